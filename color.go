@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"simplebson/preprocessing"
+)
+
+// ANSI escape sequences of the colors output is painted with
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorBlue   = "\x1b[34m"
+	colorPurple = "\x1b[35m"
+	colorCyan   = "\x1b[36m"
+)
+
+// colorOutput is set while the command being run prints in color
+var colorOutput bool
+
+// useColor reports whether a command prints in color: only to a terminal,
+// and neither with --no-color nor with NO_COLOR set to anything
+func useColor(flags preprocessing.Flags) bool {
+	if flags.Has("no-color") || flags.Has("json") || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+// paint wraps text in a color when output is in color
+func paint(color, text string) string {
+	if !colorOutput || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// printError prints an error message like fmt.Printf, in red, and records
+// it as the failure of the command being run. With --json the message is
+// only part of the envelope.
+func printError(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	trimmed := strings.TrimRight(message, "\n")
+	recordFailure(trimmed, args)
+	if jsonOutput {
+		return
+	}
+	fmt.Print(paint(colorRed, trimmed) + message[len(trimmed):])
+}
+
+// printWarning prints a warning like fmt.Printf, in yellow
+func printWarning(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	trimmed := strings.TrimRight(message, "\n")
+	fmt.Print(paint(colorYellow, trimmed) + message[len(trimmed):])
+}
+
+// paintJSON paints the field names of JSON text, compact or indented
+func paintJSON(text string) string {
+	if !colorOutput {
+		return text
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '"' {
+			b.WriteByte(text[i])
+			continue
+		}
+		end := i + 1
+		for end < len(text) && text[end] != '"' {
+			if text[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(text) {
+			b.WriteString(text[i:])
+			break
+		}
+		str := text[i : end+1]
+		next := end + 1
+		for next < len(text) && strings.IndexByte(" \t\r\n", text[next]) >= 0 {
+			next++
+		}
+		if next < len(text) && text[next] == ':' {
+			str = paint(colorCyan, str)
+		}
+		b.WriteString(str)
+		i = end
+	}
+	return b.String()
+}
+
+// paintDefinition paints the field names and types of a schema definition
+func paintDefinition(schemaDef string) string {
+	if !colorOutput {
+		return schemaDef
+	}
+	parts := strings.Fields(schemaDef)
+	for i, part := range parts {
+		if name, fieldType, typed := strings.Cut(part, ":"); typed {
+			parts[i] = paint(colorCyan, name) + ":" + paint(colorPurple, fieldType)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"simplebson/memory"
+	"simplebson/preprocessing"
+)
+
+// shellCommands lists the commands the shell completes at the start of a
+// line
+var shellCommands = []string{
+	"add", "agg", "archive", "checksum", "compact", "dbs", "delete",
+	"delete-where", "distinct", "drop", "exists", "exit", "find", "flush",
+	"get", "help", "index", "join", "keys", "list", "near", "pipeline",
+	"quit", "reindex", "schema", "search", "search-text", "sql", "stats",
+	"top", "update", "update-where", "upsert", "use", "view", "watch", "wipe",
+}
+
+// shellFlags lists the options the shell completes after --
+var shellFlags = []string{
+	"--apply", "--cursor", "--db", "--desc", "--dry-run", "--explain", "--fields", "--file",
+	"--force", "--format", "--fuzzy", "--group-by", "--help", "--ignore-case", "--interval", "--json", "--keep-going", "--left", "--limit",
+	"--n", "--no-color", "--no-pager", "--offset", "--on", "--prefix", "--quiet", "--radius", "--show-binary",
+	"--since", "--sort", "--time-field", "--to", "--until", "--upsert",
+	"--verbose", "--verify", "--wide", "--with-records", "--yes",
+}
+
+// subcommands lists the actions of the commands taking one before their
+// arguments
+var subcommands = map[string][]string{
+	"schema": {"alter", "copy", "diff", "drop", "export", "import", "infer", "json", "rename"},
+	"view":   {"create", "drop", "list"},
+	"index":  {"create", "drop", "list"},
+}
+
+// keyCommands lists the commands taking the key of a record after the
+// schema
+var keyCommands = map[string]bool{
+	"get": true, "view": true, "delete": true, "exists": true, "update": true,
+}
+
+// maxKeyCompletions limits the record keys offered at once
+const maxKeyCompletions = 100
+
+// shellCompleter returns the function completing the word under the
+// cursor in the shell: command names first, then the schema names,
+// subcommands, databases and record keys the command takes, option names
+// after --, and databases after --db. Record keys are looked up in the
+// prefix index of their schema.
+func shellCompleter(storage *memory.Storage) func(line string, pos int) (string, []string, string) {
+	return func(line string, pos int) (string, []string, string) {
+		before := line[:pos]
+		start := lastWordStart(before)
+		words, err := preprocessing.SplitWords(before[:start])
+		if err != nil {
+			return before, nil, line[pos:]
+		}
+		partial := unquotePartial(before[start:])
+
+		var completions []string
+		for _, candidate := range completeWord(storage, shellCommands, words, partial) {
+			completions = append(completions, quoteWord(candidate)+" ")
+		}
+		return before[:start], completions, line[pos:]
+	}
+}
+
+// completeWord returns the candidates starting with the partial word typed
+// after the given words, the first of which is one of commandNames
+func completeWord(storage *memory.Storage, commandNames []string, words []string, partial string) []string {
+	var candidates []string
+	if strings.HasPrefix(partial, "--") {
+		candidates = shellFlags
+	} else if len(words) > 0 && words[len(words)-1] == "--db" {
+		candidates, _ = storage.ListDBs()
+	} else {
+		candidates = completeArgument(storage, commandNames, positional(words), partial)
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, partial) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// lastWordStart returns where the word the line ends in starts, as written
+// with its quotes, or the length of the line when it ends in whitespace
+func lastWordStart(line string) int {
+	start := len(line)
+	inWord := false
+	quote := rune(0)
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			}
+		case r == ' ' || r == '\t':
+			inWord = false
+			start = len(line)
+		default:
+			if !inWord {
+				inWord = true
+				start = i
+			}
+			if r == '\'' || r == '"' {
+				quote = r
+			} else if r == '\\' {
+				escaped = true
+			}
+		}
+	}
+	return start
+}
+
+// unquotePartial returns the argument a word being typed stands for, as if
+// its open quote were closed
+func unquotePartial(word string) string {
+	for _, closing := range []string{"", "'", `"`} {
+		if words, err := preprocessing.SplitWords(word + closing); err == nil {
+			if len(words) == 0 {
+				return ""
+			}
+			return words[0]
+		}
+	}
+	return word
+}
+
+// positional returns the words of a line that are not options, leaving
+// out the values of options taking one
+func positional(words []string) []string {
+	args, _ := preprocessing.ParseFlags(words)
+	return args
+}
+
+// completeArgument returns the candidates for the argument following the
+// given words
+func completeArgument(storage *memory.Storage, commandNames []string, words []string, partial string) []string {
+	if len(words) == 0 {
+		return commandNames
+	}
+
+	command := strings.ToLower(words[0])
+	args := words[1:]
+	if actions, ok := subcommands[command]; ok && len(args) > 0 {
+		for _, action := range actions {
+			if strings.EqualFold(args[0], action) {
+				if action == "create" && command == "view" || action == "import" || len(args) > 1 {
+					return nil
+				}
+				return schemaNames(storage)
+			}
+		}
+	}
+
+	switch {
+	case (command == "use" || command == "wipe" || command == "drop") && len(args) == 0:
+		dbs, _ := storage.ListDBs()
+		return dbs
+	case command == "join" && len(args) < 2:
+		return schemaNames(storage)
+	case command == "help" && len(args) == 0:
+		return commandNames
+	case command == "help" && len(args) == 1:
+		return subcommands[strings.ToLower(args[0])]
+	case command == "completion" && len(args) == 0:
+		return completionShells
+	case len(args) == 0:
+		switch command {
+		case "dbs", "flush", "exit", "quit", "sql":
+			return nil
+		}
+		return append(append([]string(nil), subcommands[command]...), schemaNames(storage)...)
+	case len(args) == 1 && keyCommands[command]:
+		keys, err := storage.Keys(args[0], partial)
+		if err != nil {
+			return nil
+		}
+		if len(keys) > maxKeyCompletions {
+			keys = keys[:maxKeyCompletions]
+		}
+		return keys
+	}
+	return nil
+}
+
+// schemaNames returns the names of the schemas of the database in use, in
+// name order
+func schemaNames(storage *memory.Storage) []string {
+	schemas := storage.ListSchemas()
+	sort.Strings(schemas)
+	return schemas
+}
+
+// quoteWord escapes the characters of a completed word the shell would
+// split or unquote with a backslash, so it reads back as the same argument
+// and completions sharing a prefix still share it once escaped
+func quoteWord(word string) string {
+	if word == "" {
+		return "''"
+	}
+	var quoted strings.Builder
+	for _, r := range word {
+		if strings.ContainsRune(" \t'\"\\", r) {
+			quoted.WriteRune('\\')
+		}
+		quoted.WriteRune(r)
+	}
+	return quoted.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"simplebson/memory"
+)
+
+// completionShells lists the shells completion writes a script for
+var completionShells = []string{"bash", "fish", "zsh"}
+
+// cliCommands lists the commands completed at the start of a command line
+// in another shell: those of the shell, without exit and quit, and the
+// ones that cannot be run inside it
+var cliCommands = func() []string {
+	names := []string{"completion", "run", "shell"}
+	for _, name := range shellCommands {
+		if name != "exit" && name != "quit" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}()
+
+// completeCommand is the hidden command the completion scripts run to
+// complete a word: simplebson __complete <words before it...> <word>
+const completeCommand = "__complete"
+
+// Completion scripts ask simplebson for the candidates of the word being
+// completed, falling back to file names when it offers none
+const bashCompletion = `# bash completion for simplebson
+# Load with: source <(simplebson completion bash)
+_simplebson() {
+    local IFS=$'\n'
+    COMPREPLY=($(simplebson __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}" "${COMP_WORDS[COMP_CWORD]}" 2>/dev/null))
+}
+complete -o default -F _simplebson simplebson
+`
+
+const zshCompletion = `#compdef simplebson
+# zsh completion for simplebson
+# Load with: source <(simplebson completion zsh)
+_simplebson() {
+    local -a candidates
+    candidates=(${(f)"$(simplebson __complete "${(@)words[2,CURRENT-1]}" "${words[CURRENT]}" 2>/dev/null)"})
+    if (( ${#candidates} )); then
+        compadd -- $candidates
+    else
+        _files
+    fi
+}
+compdef _simplebson simplebson
+`
+
+const fishCompletion = `# fish completion for simplebson
+# Load with: simplebson completion fish | source
+function __simplebson_complete
+    set -l words (commandline -opc)
+    set -l candidates (simplebson __complete $words[2..-1] (commandline -ct) 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c simplebson -f -a '(__simplebson_complete)'
+`
+
+// runCompletion prints the completion script of a shell
+func runCompletion(args []string) int {
+	if len(args) != 1 {
+		fmt.Printf("Usage: simplebson completion <%s>\n", strings.Join(completionShells, "|"))
+		return 1
+	}
+	var script string
+	switch strings.ToLower(args[0]) {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		printError("Error: unsupported shell '%s', expected %s\n", args[0], strings.Join(completionShells, ", "))
+		return 1
+	}
+	if _, err := io.WriteString(os.Stdout, script); err != nil {
+		printError("Error writing script: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runComplete prints the candidates for the last of the given words, one
+// per line, completing the words before it as the shell does
+func runComplete(storage *memory.Storage, words []string) int {
+	if len(words) == 0 {
+		return 0
+	}
+	// Words arrive as typed, quotes included
+	typed := make([]string, len(words))
+	for i, word := range words {
+		typed[i] = unquotePartial(word)
+	}
+	partial := typed[len(typed)-1]
+	for _, candidate := range completeWord(storage, cliCommands, typed[:len(typed)-1], partial) {
+		fmt.Println(candidate)
+	}
+	return 0
+}
@@ -1,27 +1,157 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"simplebson/preprocessing"
 )
 
 // Config holds the application configuration
 type Config struct {
 	StoragePath string
 	MaxKeys     int
+
+	// DataDir is the directory holding a directory per database
+	DataDir string
+
+	// Database is the database commands use until another is selected
+	Database string
+
+	// Format is how records are printed when --format is not given, raw
+	// JSON when empty
+	Format string
+
+	// MemTableSize is the number of writes an LSM MemTable holds before it
+	// is flushed to an SSTable
+	MemTableSize int
+
+	// MemTableBytes is the approximate size in bytes at which an LSM
+	// MemTable is flushed, whichever of the two limits is reached first
+	MemTableBytes int
+
+	// CompactionThreshold enables background compaction of a schema's LSM
+	// tree once it holds more than this many SSTables, checked at most once
+	// per CompactionInterval. Zero disables the background compactor.
+	CompactionThreshold int
+	CompactionInterval  time.Duration
+
+	// FlushInterval enables async persistence when greater than zero:
+	// writes stay in memory and are saved in the background at this interval
+	FlushInterval time.Duration
+
+	// ConfirmThreshold is the number of records a bulk delete may remove
+	// without being confirmed with --yes
+	ConfirmThreshold int
+
+	// FuzzyDistance is the largest edit distance at which get --fuzzy still
+	// considers a stored key close to the one given
+	FuzzyDistance int
+
+	// Strict makes every schema reject records whose top-level fields it
+	// does not declare, as if each definition ended in strict
+	Strict bool
+
+	// HistoryPath is the file the shell keeps the commands typed at its
+	// prompt in across sessions. Empty disables the history file.
+	HistoryPath string
+
+	// Verbosity is how much diagnostic detail is written to stderr: Quiet
+	// hides warnings, Verbose adds file paths, timings and validation detail
+	Verbosity int
 }
 
-// LoadConfig creates a default configuration
-func LoadConfig() *Config {
+// LoadConfig creates the configuration: the defaults, overridden by the
+// settings of the config files, overridden in turn by the environment
+func LoadConfig() (*Config, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		wd = "."
 	}
 
-	storagePath := filepath.Join(wd, "dbs", "default", "db.bson")
+	historyPath := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyPath = filepath.Join(home, ".simplebson_history")
+	}
+
+	config := &Config{
+		DataDir:       filepath.Join(wd, "dbs"),
+		Database:      "default",
+		MaxKeys:       10000,
+		MemTableSize:  1000,
+		MemTableBytes: 4 << 20,
+
+		CompactionThreshold: 8,
+		CompactionInterval:  time.Second,
+
+		ConfirmThreshold: 10,
+		FuzzyDistance:    2,
+		HistoryPath:      historyPath,
+	}
+
+	for _, path := range configFiles(wd) {
+		err := applyConfigFile(config, path)
+		if os.IsNotExist(err) && os.Getenv("SIMPLEBSON_CONFIG") == "" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if value := os.Getenv("SIMPLEBSON_FLUSH_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil {
+			config.FlushInterval = interval
+		}
+	}
+
+	if value := os.Getenv("SIMPLEBSON_FUZZY_DISTANCE"); value != "" {
+		if distance, err := strconv.Atoi(value); err == nil && distance >= 0 {
+			config.FuzzyDistance = distance
+		}
+	}
+
+	if value := os.Getenv("SIMPLEBSON_STRICT"); value != "" {
+		config.Strict, _ = strconv.ParseBool(value)
+	}
+
+	if value := os.Getenv("SIMPLEBSON_VERBOSITY"); value != "" {
+		if verbosity, err := ParseVerbosity(value); err == nil {
+			config.Verbosity = verbosity
+		}
+	}
+
+	if value, set := os.LookupEnv("SIMPLEBSON_HISTORY"); set {
+		config.HistoryPath = value
+	}
+
+	// The database selected with use outlasts the process that selected it
+	if data, err := os.ReadFile(config.SelectedDBFile()); err == nil {
+		if name := strings.TrimSpace(string(data)); name != "" {
+			if config.Database, err = preprocessing.ParseDBName(name); err != nil {
+				return nil, fmt.Errorf("%s: %v, remove the file to select the configured database", config.SelectedDBFile(), err)
+			}
+		}
+	}
+
+	config.StoragePath = filepath.Join(config.DataDir, config.Database, "db.bson")
+	return config, nil
+}
+
+// SelectedDBFile returns the file holding the database selected with use
+func (c *Config) SelectedDBFile() string {
+	return filepath.Join(c.DataDir, ".selected")
+}
 
-	return &Config{
-		StoragePath: storagePath,
-		MaxKeys:     10000,
+// SaveSelectedDB remembers the database selected with use, which later
+// commands work on until another is selected
+func (c *Config) SaveSelectedDB(name string) error {
+	if err := os.MkdirAll(c.DataDir, 0755); err != nil {
+		return err
 	}
+	return os.WriteFile(c.SelectedDBFile(), []byte(name+"\n"), 0644)
 }
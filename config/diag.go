@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Verbosity levels, chosen with --quiet and --verbose
+const (
+	Quiet   = -1
+	Normal  = 0
+	Verbose = 1
+)
+
+// Diagnostics is where warnings and verbose detail are written, kept apart
+// from the output of commands
+var Diagnostics io.Writer = os.Stderr
+
+// ParseVerbosity parses the name of a verbosity level
+func ParseVerbosity(value string) (int, error) {
+	switch strings.ToLower(value) {
+	case "quiet":
+		return Quiet, nil
+	case "normal":
+		return Normal, nil
+	case "verbose":
+		return Verbose, nil
+	}
+	return 0, fmt.Errorf("expected quiet, normal or verbose, got '%s'", value)
+}
+
+// Warnf reports a problem that does not stop the command, such as a file
+// that could not be read, unless the verbosity is Quiet
+func (c *Config) Warnf(format string, args ...interface{}) {
+	if c.Verbosity > Quiet {
+		fmt.Fprintf(Diagnostics, "Warning: "+format+"\n", args...)
+	}
+}
+
+// Debugf reports what a command does, such as the files it reads and how
+// long it takes, when the verbosity is Verbose
+func (c *Config) Debugf(format string, args ...interface{}) {
+	if c.Verbosity >= Verbose {
+		fmt.Fprintf(Diagnostics, "Debug: "+format+"\n", args...)
+	}
+}
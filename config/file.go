@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"simplebson/preprocessing"
+)
+
+// configFiles returns the config files read, in the order they are
+// applied: the user's config file, then simplebson.yaml in the working
+// directory. SIMPLEBSON_CONFIG names the only file read instead.
+func configFiles(wd string) []string {
+	if path := os.Getenv("SIMPLEBSON_CONFIG"); path != "" {
+		return []string{path}
+	}
+
+	var files []string
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		files = append(files, filepath.Join(configHome, "simplebson", "config.yaml"))
+	}
+	return append(files, filepath.Join(wd, "simplebson.yaml"))
+}
+
+// readConfigFile reads the settings of a config file, written as YAML
+// key: value lines. Comments and blank lines are skipped, and values may
+// be quoted.
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected key: value, got '%s'", path, lineNo, line)
+		}
+		value, err := configValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		if _, duplicate := settings[key]; duplicate {
+			return nil, fmt.Errorf("%s:%d: '%s' is set twice", path, lineNo, key)
+		}
+		settings[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// configValue returns the value a setting is written with, without its
+// quotes or a trailing comment
+func configValue(text string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	switch text[0] {
+	case '"':
+		end := strings.LastIndex(text, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unclosed \" quote")
+		}
+		if rest := strings.TrimSpace(text[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected '%s' after quoted value", rest)
+		}
+		return strconv.Unquote(text[:end+1])
+	case '\'':
+		end := strings.LastIndex(text, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unclosed ' quote")
+		}
+		if rest := strings.TrimSpace(text[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected '%s' after quoted value", rest)
+		}
+		return strings.ReplaceAll(text[1:end], "''", "'"), nil
+	}
+	if comment := strings.Index(text, " #"); comment >= 0 {
+		text = strings.TrimSpace(text[:comment])
+	}
+	return text, nil
+}
+
+// applyConfigFile sets the settings of a config file on a configuration.
+// Paths in the file are relative to the directory holding it.
+func applyConfigFile(config *Config, path string) error {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	for key, value := range settings {
+		var err error
+		switch key {
+		case "storage_path":
+			config.DataDir = resolvePath(dir, value)
+		case "database":
+			config.Database, err = preprocessing.ParseDBName(value)
+		case "format":
+			config.Format = value
+		case "flush_interval":
+			config.FlushInterval, err = parseDuration(value, 0)
+		case "strict":
+			config.Strict, err = strconv.ParseBool(value)
+		case "history_file":
+			config.HistoryPath = ""
+			if value != "" {
+				config.HistoryPath = resolvePath(dir, value)
+			}
+		case "memtable_size":
+			config.MemTableSize, err = parseCount(value, 1)
+		case "memtable_bytes":
+			config.MemTableBytes, err = parseCount(value, 0)
+		case "compaction_threshold":
+			config.CompactionThreshold, err = parseCount(value, 0)
+		case "compaction_interval":
+			config.CompactionInterval, err = parseDuration(value, time.Nanosecond)
+		case "confirm_threshold":
+			config.ConfirmThreshold, err = parseCount(value, 0)
+		case "fuzzy_distance":
+			config.FuzzyDistance, err = parseCount(value, 0)
+		case "verbosity":
+			config.Verbosity, err = ParseVerbosity(value)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, key, err)
+		}
+	}
+	return nil
+}
+
+// resolvePath returns a path relative to dir, expanding a leading ~ to
+// the home directory
+func resolvePath(dir, path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// parseCount parses a number of at least min
+func parseCount(value string, min int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		if min > 0 {
+			return 0, fmt.Errorf("expected a number of at least %d, got '%s'", min, value)
+		}
+		return 0, fmt.Errorf("expected a non-negative number, got '%s'", value)
+	}
+	return n, nil
+}
+
+// parseDuration parses a Go duration such as 500ms or 5s of at least min
+func parseDuration(value string, min time.Duration) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < min {
+		if min > 0 {
+			return 0, fmt.Errorf("expected a positive duration such as 500ms or 5s, got '%s'", value)
+		}
+		return 0, fmt.Errorf("expected a duration such as 500ms or 5s, got '%s'", value)
+	}
+	return d, nil
+}
@@ -1,103 +1,522 @@
 package dbs
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// legacySchemasKey is the records entry older versions used to embed
+// schema definitions in the records file
+const legacySchemasKey = "__schemas__"
+
 // Store handles file persistence for a single database
 type Store struct {
-	filePath string
+	filePath     string
+	schemaPath   string // Schema catalog kept next to the records file
+	checksumPath string // Per-record content hashes
+	indexPath    string // Definitions of the compound indexes of each schema
+	viewPath     string // Queries defining the materialized views
+	counterPath  string // Last serial key assigned in each schema
+	versionPath  string // Version of each schema definition
+	recordPath   string // Schema version each record was written under
+	jsonPath     string // JSON Schema documents defining schemas
+	extendsPath  string // Parent schemas and own fields of extending schemas
+	metricsPath  string // Storage engine activity counters of each schema
+	journalPath  string // Record changes made since the files above were saved
+	journal      *os.File
+	coldDir      string // Compressed records of archived schemas
+	tablesDir    string // LSM SSTables, one subdirectory per schema
 }
 
 func NewStore(filePath string) *Store {
+	dir := filepath.Dir(filePath)
 	return &Store{
-		filePath: filePath,
+		filePath:     filePath,
+		schemaPath:   filepath.Join(dir, "schemas.bson"),
+		checksumPath: filepath.Join(dir, "checksums.bson"),
+		indexPath:    filepath.Join(dir, "indexes.bson"),
+		viewPath:     filepath.Join(dir, "views.bson"),
+		counterPath:  filepath.Join(dir, "counters.bson"),
+		versionPath:  filepath.Join(dir, "versions.bson"),
+		recordPath:   filepath.Join(dir, "record_versions.bson"),
+		jsonPath:     filepath.Join(dir, "jsonschemas.bson"),
+		extendsPath:  filepath.Join(dir, "extends.bson"),
+		metricsPath:  filepath.Join(dir, "metrics.bson"),
+		journalPath:  filepath.Join(dir, "journal.log"),
+		coldDir:      filepath.Join(dir, "cold"),
+		tablesDir:    filepath.Join(dir, "sstables"),
 	}
 }
 
+// Dir returns the directory holding the files of the database
+func (s *Store) Dir() string {
+	return filepath.Dir(s.filePath)
+}
+
+// TableDir returns the directory holding the LSM SSTables of a schema
+func (s *Store) TableDir(schemaName string) string {
+	return filepath.Join(s.tablesDir, schemaName)
+}
+
 func (s *Store) SaveRecords(records map[string]map[string]interface{}) error {
-	dir := filepath.Dir(s.filePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+	return writeDocument(s.filePath, records)
+}
+
+func (s *Store) LoadRecords() (map[string]map[string]interface{}, error) {
+	records := make(map[string]map[string]interface{})
+	if _, err := readDocument(s.filePath, &records); err != nil {
+		return nil, err
+	}
+	if records == nil {
+		records = make(map[string]map[string]interface{})
+	}
+
+	// Schemas live in their own catalog file, never among the records
+	delete(records, legacySchemasKey)
+
+	return records, nil
+}
+
+// SaveSchemas saves schema definitions to the schema catalog file
+func (s *Store) SaveSchemas(schemas map[string]string) error {
+	return writeDocument(s.schemaPath, schemas)
+}
+
+// LoadSchemas loads schema definitions from the schema catalog file.
+// Databases written before the catalog existed keep their schemas inside
+// the records file; those are returned instead and move to the catalog on
+// the next save.
+func (s *Store) LoadSchemas() (map[string]string, error) {
+	schemas := make(map[string]string)
+
+	found, err := readDocument(s.schemaPath, &schemas)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return schemas, nil
+	}
+
+	return s.loadLegacySchemas()
+}
+
+// loadLegacySchemas reads schema definitions embedded in the records file
+func (s *Store) loadLegacySchemas() (map[string]string, error) {
+	var records map[string]map[string]interface{}
+	if _, err := readDocument(s.filePath, &records); err != nil {
+		return nil, err
+	}
+
+	schemas := make(map[string]string)
+
+	schemaData, exists := records[legacySchemasKey]
+	if !exists {
+		return schemas, nil
+	}
+
+	// Iterate through the schema entries and convert them back to strings
+	for key, value := range schemaData {
+		if strValue, ok := value.(string); ok {
+			schemas[key] = strValue
+		}
+	}
+
+	return schemas, nil
+}
+
+// SaveChecksums saves the per-record content hashes, keyed by schema and
+// record key
+func (s *Store) SaveChecksums(checksums map[string]map[string]string) error {
+	return writeDocument(s.checksumPath, checksums)
+}
+
+// LoadChecksums loads the per-record content hashes. It returns a nil map
+// when the database has never stored checksums.
+func (s *Store) LoadChecksums() (map[string]map[string]string, error) {
+	checksums := make(map[string]map[string]string)
+
+	found, err := readDocument(s.checksumPath, &checksums)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+
+	return checksums, nil
+}
+
+// SaveIndexes saves the compound index definitions, keyed by schema. Each
+// index is stored as its comma-separated field list.
+func (s *Store) SaveIndexes(indexes map[string][]string) error {
+	return writeDocument(s.indexPath, indexes)
+}
+
+// LoadIndexes loads the compound index definitions
+func (s *Store) LoadIndexes() (map[string][]string, error) {
+	indexes := make(map[string][]string)
+	if _, err := readDocument(s.indexPath, &indexes); err != nil {
+		return nil, err
+	}
+	if indexes == nil {
+		indexes = make(map[string][]string)
+	}
+	return indexes, nil
+}
+
+// SaveViews saves the queries defining the materialized views, keyed by
+// view name
+func (s *Store) SaveViews(views map[string]string) error {
+	return writeDocument(s.viewPath, views)
+}
+
+// LoadViews loads the queries defining the materialized views
+func (s *Store) LoadViews() (map[string]string, error) {
+	views := make(map[string]string)
+	if _, err := readDocument(s.viewPath, &views); err != nil {
+		return nil, err
+	}
+	if views == nil {
+		views = make(map[string]string)
+	}
+	return views, nil
+}
+
+// SaveCounters saves the last serial key assigned in each schema
+func (s *Store) SaveCounters(counters map[string]int64) error {
+	return writeDocument(s.counterPath, counters)
+}
+
+// LoadCounters loads the last serial key assigned in each schema
+func (s *Store) LoadCounters() (map[string]int64, error) {
+	counters := make(map[string]int64)
+	if _, err := readDocument(s.counterPath, &counters); err != nil {
+		return nil, err
+	}
+	if counters == nil {
+		counters = make(map[string]int64)
+	}
+	return counters, nil
+}
+
+// SaveVersions saves the version of each schema definition
+func (s *Store) SaveVersions(versions map[string]int64) error {
+	return writeDocument(s.versionPath, versions)
+}
+
+// LoadVersions loads the version of each schema definition
+func (s *Store) LoadVersions() (map[string]int64, error) {
+	versions := make(map[string]int64)
+	if _, err := readDocument(s.versionPath, &versions); err != nil {
+		return nil, err
+	}
+	if versions == nil {
+		versions = make(map[string]int64)
+	}
+	return versions, nil
+}
+
+// SaveRecordVersions saves the schema version each record was written
+// under, keyed by schema and record key
+func (s *Store) SaveRecordVersions(versions map[string]map[string]int64) error {
+	return writeDocument(s.recordPath, versions)
+}
+
+// LoadRecordVersions loads the schema version each record was written under
+func (s *Store) LoadRecordVersions() (map[string]map[string]int64, error) {
+	versions := make(map[string]map[string]int64)
+	if _, err := readDocument(s.recordPath, &versions); err != nil {
+		return nil, err
+	}
+	if versions == nil {
+		versions = make(map[string]map[string]int64)
+	}
+	return versions, nil
+}
+
+// SaveJSONSchemas saves the JSON Schema documents defining schemas, keyed
+// by schema name
+func (s *Store) SaveJSONSchemas(documents map[string]string) error {
+	return writeDocument(s.jsonPath, documents)
+}
+
+// LoadJSONSchemas loads the JSON Schema documents defining schemas
+func (s *Store) LoadJSONSchemas() (map[string]string, error) {
+	documents := make(map[string]string)
+	if _, err := readDocument(s.jsonPath, &documents); err != nil {
+		return nil, err
+	}
+	if documents == nil {
+		documents = make(map[string]string)
+	}
+	return documents, nil
+}
+
+// SaveExtends saves the parent and own fields of every schema extending
+// another, keyed by schema name
+func (s *Store) SaveExtends(extends map[string]string) error {
+	return writeDocument(s.extendsPath, extends)
+}
+
+// LoadExtends loads the parent and own fields of every extending schema
+func (s *Store) LoadExtends() (map[string]string, error) {
+	extends := make(map[string]string)
+	if _, err := readDocument(s.extendsPath, &extends); err != nil {
+		return nil, err
+	}
+	if extends == nil {
+		extends = make(map[string]string)
+	}
+	return extends, nil
+}
+
+// SaveMetrics saves the storage engine activity counters of each schema,
+// keyed by schema name and counter name
+func (s *Store) SaveMetrics(metrics map[string]map[string]int64) error {
+	return writeDocument(s.metricsPath, metrics)
+}
+
+// LoadMetrics loads the storage engine activity counters of each schema
+func (s *Store) LoadMetrics() (map[string]map[string]int64, error) {
+	metrics := make(map[string]map[string]int64)
+	if _, err := readDocument(s.metricsPath, &metrics); err != nil {
+		return nil, err
+	}
+	if metrics == nil {
+		metrics = make(map[string]map[string]int64)
+	}
+	return metrics, nil
+}
+
+// JournalEntry records what a write changed next to a record: its checksum,
+// the schema version it was written under and the serial counter of its
+// schema. The records themselves are in the commit logs of their schemas.
+type JournalEntry struct {
+	Schema   string `bson:"schema"`
+	Key      string `bson:"key"`
+	Deleted  bool   `bson:"deleted,omitempty"`
+	Checksum string `bson:"checksum,omitempty"`
+	Version  int64  `bson:"version,omitempty"`
+	Serial   int64  `bson:"serial,omitempty"`
+}
+
+// AppendJournal records a write made since the database was last saved
+func (s *Store) AppendJournal(entry JournalEntry) error {
+	if s.journal == nil {
+		if err := os.MkdirAll(filepath.Dir(s.journalPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %v", err)
+		}
+
+		file, err := os.OpenFile(s.journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open journal: %v", err)
+		}
+		s.journal = file
+	}
+
+	// Each entry is a BSON document, which carries its own length prefix
+	data, err := bson.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %v", err)
+	}
+
+	if _, err := s.journal.Write(data); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+
+	return nil
+}
+
+// LoadJournal loads the writes made since the database was last saved, in
+// the order they were made. A torn entry at the end, left by a crash
+// mid-write, is cut off so new entries are appended after the last
+// complete one.
+func (s *Store) LoadJournal() ([]JournalEntry, error) {
+	data, err := ioutil.ReadFile(s.journalPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %v", err)
+	}
+
+	var entries []JournalEntry
+	offset := 0
+	for len(data)-offset >= 4 {
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		if length < 5 || length > len(data)-offset {
+			break
+		}
+
+		var entry JournalEntry
+		if err := bson.Unmarshal(data[offset:offset+length], &entry); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+
+		offset += length
+	}
+
+	if offset < len(data) {
+		if err := os.Truncate(s.journalPath, int64(offset)); err != nil {
+			return nil, fmt.Errorf("failed to repair journal: %v", err)
+		}
+	}
+
+	return entries, nil
+}
+
+// ClearJournal removes the journal once the writes it records are saved
+func (s *Store) ClearJournal() error {
+	if s.journal != nil {
+		err := s.journal.Close()
+		s.journal = nil
+		if err != nil {
+			return fmt.Errorf("failed to close journal: %v", err)
+		}
+	}
+
+	if err := os.Remove(s.journalPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %v", err)
 	}
 
+	return nil
+}
+
+// coldSuffix is the file extension of archived schema files
+const coldSuffix = ".bson.gz"
+
+// ArchiveSchema writes the records of a schema to a gzip compressed cold
+// file that is not read when the database is loaded
+func (s *Store) ArchiveSchema(schemaName string, records map[string]interface{}) error {
 	bsonData, err := bson.Marshal(records)
 	if err != nil {
-		return fmt.Errorf("failed to marshal records: %v", err)
+		return fmt.Errorf("failed to marshal schema '%s': %v", schemaName, err)
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(bsonData); err != nil {
+		return fmt.Errorf("failed to compress schema '%s': %v", schemaName, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress schema '%s': %v", schemaName, err)
 	}
 
-	if err := ioutil.WriteFile(s.filePath, bsonData, 0644); err != nil {
+	if err := os.MkdirAll(s.coldDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	if err := ioutil.WriteFile(s.coldPath(schemaName), compressed.Bytes(), 0644); err != nil {
 		return fmt.Errorf("failed to write file: %v", err)
 	}
 
 	return nil
 }
 
-func (s *Store) LoadRecords() (map[string]map[string]interface{}, error) {
-	if _, err := os.Stat(s.filePath); os.IsNotExist(err) {
-		return make(map[string]map[string]interface{}), nil
+// LoadArchivedSchema reads the records of an archived schema back from its
+// cold file
+func (s *Store) LoadArchivedSchema(schemaName string) (map[string]interface{}, error) {
+	file, err := os.Open(s.coldPath(schemaName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
 	}
+	defer file.Close()
 
-	data, err := ioutil.ReadFile(s.filePath)
+	reader, err := gzip.NewReader(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
+		return nil, fmt.Errorf("failed to decompress schema '%s': %v", schemaName, err)
 	}
+	defer reader.Close()
 
-	var records map[string]map[string]interface{}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress schema '%s': %v", schemaName, err)
+	}
+
+	records := make(map[string]interface{})
 	if err := bson.Unmarshal(data, &records); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal records: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal schema '%s': %v", schemaName, err)
 	}
 
 	return records, nil
 }
 
-// SaveSchemas saves schema definitions to the storage file
-func (s *Store) SaveSchemas(schemas map[string]string) error {
-	records, err := s.LoadRecords()
+// ListArchivedSchemas returns the names of all schemas with a cold file
+func (s *Store) ListArchivedSchemas() ([]string, error) {
+	files, err := ioutil.ReadDir(s.coldDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read cold storage directory: %v", err)
 	}
 
-	// For BSON, we can store the schemas directly in the structure
-	// Ensure records map exists
-	if records == nil {
-		records = make(map[string]map[string]interface{})
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), coldSuffix) {
+			names = append(names, strings.TrimSuffix(file.Name(), coldSuffix))
+		}
 	}
-	records["__schemas__"] = make(map[string]interface{})
 
-	// Iterate through the schema definitions and add them to the map
-	for key, value := range schemas {
-		records["__schemas__"][key] = value
+	return names, nil
+}
+
+// RemoveArchivedSchema deletes the cold file of a schema
+func (s *Store) RemoveArchivedSchema(schemaName string) error {
+	if err := os.Remove(s.coldPath(schemaName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove archived schema '%s': %v", schemaName, err)
 	}
+	return nil
+}
 
-	return s.SaveRecords(records)
+func (s *Store) coldPath(schemaName string) string {
+	return filepath.Join(s.coldDir, schemaName+coldSuffix)
 }
 
-// LoadSchemas loads schema definitions from the storage file
-func (s *Store) LoadSchemas() (map[string]string, error) {
-	records, err := s.LoadRecords()
+// writeDocument marshals v to BSON and writes it to path, creating the
+// parent directory when needed
+func writeDocument(path string, v interface{}) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	bsonData, err := bson.Marshal(v)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to marshal %s: %v", filepath.Base(path), err)
 	}
 
-	schemas := make(map[string]string)
+	if err := ioutil.WriteFile(path, bsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
 
-	schemaData, exists := records["__schemas__"]
-	if !exists {
-		return schemas, nil
+	return nil
+}
+
+// readDocument unmarshals the BSON file at path into v. It reports false
+// without touching v when the file does not exist.
+func readDocument(path string, v interface{}) (bool, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
 	}
 
-	// Iterate through the schema entries and convert them back to strings
-	for key, value := range schemaData {
-		if strValue, ok := value.(string); ok {
-			schemas[key] = strValue
-		}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %v", err)
 	}
 
-	return schemas, nil
-}
\ No newline at end of file
+	if err := bson.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to unmarshal %s: %v", filepath.Base(path), err)
+	}
+
+	return true, nil
+}
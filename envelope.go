@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"simplebson/config"
+	"simplebson/memory"
+	"simplebson/preprocessing"
+)
+
+// exitCodes are the exit codes of a failing command, by the kind of error
+// it ran into
+var exitCodes = map[memory.ErrorKind]int{
+	memory.ErrorOther:      1,
+	memory.ErrorNotFound:   2,
+	memory.ErrorValidation: 3,
+	memory.ErrorAmbiguous:  4,
+	memory.ErrorIO:         5,
+}
+
+// jsonOutput is set while the command being run reports its outcome as a
+// JSON envelope, with --json
+var jsonOutput bool
+
+// jsonRecords collects the records a command returns with --json, nil
+// when it returns none
+var jsonRecords []json.RawMessage
+
+// failure is the first error the command being run printed
+var failure *commandFailure
+
+// commandFailure is an error message printed by a command, and the kind of
+// error it reports
+type commandFailure struct {
+	kind    memory.ErrorKind
+	message string
+}
+
+// envelope is what a command prints with --json: whether it succeeded, the
+// kind of error it ran into, the text it printed and the records it
+// returned
+type envelope struct {
+	OK      bool              `json:"ok"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Data    []json.RawMessage `json:"data"`
+}
+
+// recordFailure records an error message as the failure of the command
+// being run, unless it already printed one. Its kind is the kind of the
+// first error among the values the message was formatted with.
+func recordFailure(message string, args []interface{}) {
+	if failure != nil {
+		return
+	}
+	failure = &commandFailure{kind: memory.ErrorOther, message: message}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			failure.kind = memory.KindOf(err)
+			break
+		}
+	}
+}
+
+// run executes a single command and returns the process exit code, which
+// tells apart the kinds of error a failing command ran into. With --json
+// the outcome is printed as an envelope instead of as text.
+func run(cfg *config.Config, storage *memory.Storage, command string, args []string) int {
+	// The commands of a script or shell session fail on their own
+	defer func(outer *commandFailure) { failure = outer }(failure)
+	failure = nil
+	_, flags := preprocessing.ParseFlags(args)
+	if !flags.Has("json") {
+		return exitCode(runCommand(cfg, storage, command, args))
+	}
+	if command == "shell" || command == "run" || command == "watch" {
+		printError("Error parsing flags: %v\n", memory.Errorf(memory.ErrorValidation, "--json cannot be used with %s", command))
+		return exitCode(1)
+	}
+
+	jsonOutput, jsonRecords = true, nil
+	code, output := captureStdout(func() int {
+		return runCommand(cfg, storage, command, args)
+	})
+	jsonOutput = false
+
+	result := envelope{OK: code == 0, Code: "ok", Message: strings.TrimRight(output, "\n"), Data: jsonRecords}
+	if code != 0 {
+		result.Code = memory.ErrorOther.String()
+		if failure != nil {
+			result.Code = failure.kind.String()
+			result.Message = failure.message
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(result); err != nil {
+		return 1
+	}
+	return exitCode(code)
+}
+
+// invalidArgs marks an error in the arguments, flags or filters of a
+// command, which fails it as a validation error
+func invalidArgs(err error) error {
+	return memory.Errorf(memory.ErrorValidation, "%w", err)
+}
+
+// exitCode returns the exit code of a command that returned code: the one
+// of the kind of error it printed when it failed
+func exitCode(code int) int {
+	if code == 0 || failure == nil {
+		return code
+	}
+	return exitCodes[failure.kind]
+}
+
+// captureStdout runs a command with its standard output redirected, and
+// returns its exit code and what it printed
+func captureStdout(command func() int) (int, string) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return command(), ""
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- string(data)
+	}()
+
+	code := command()
+	os.Stdout = stdout
+	w.Close()
+	output := <-printed
+	r.Close()
+	return code, output
+}
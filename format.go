@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+
+	"simplebson/memory"
+	"simplebson/preprocessing"
+)
+
+// timestampColumns are the fields every record holds, shown after the
+// declared ones in a table or CSV
+var timestampColumns = []string{"created_at", "updated_at"}
+
+// maxCellWidth is the width a table cell is truncated to unless --wide is
+// passed
+const maxCellWidth = 40
+
+// recordWriter prints the records a command returns in the format chosen
+// with --format. A CSV prints its header with the first record, or on
+// Flush when there was none, while a table is held back until Flush to
+// size its columns.
+type recordWriter struct {
+	out     io.Writer
+	format  string
+	columns []string
+	redact  []string
+	written int
+	wide    bool            // Print table cells in full
+	numeric map[string]bool // Whether a declared column holds numbers
+	report  memory.Progress // Told the number of records written, when set
+	total   int             // Number of records expected by report, zero when not known
+
+	table *tableRenderer
+	csv   *csv.Writer
+}
+
+// newRecordWriter returns the writer printing the records of a schema.
+// The columns of a table or CSV are the given fields when set, otherwise
+// the fields the schema declares followed by the timestamps.
+func newRecordWriter(out io.Writer, format string, schemaDef string, fields []string) *recordWriter {
+	w := &recordWriter{out: out, format: format, columns: fields, numeric: make(map[string]bool)}
+	for _, field := range memory.DeclaredFields(schemaDef) {
+		w.numeric[field.Name] = field.Numeric
+	}
+	if len(w.columns) == 0 && schemaDef != "" {
+		for _, field := range memory.DeclaredFields(schemaDef) {
+			w.columns = append(w.columns, field.Name)
+		}
+		w.columns = append(w.columns, timestampColumns...)
+	}
+
+	switch format {
+	case "table":
+		w.table = &tableRenderer{}
+	case "csv":
+		w.csv = csv.NewWriter(out)
+	}
+	return w
+}
+
+// recordPrinter returns the writer get, list, find and sql print the
+// records of a schema to out with, which hides the content of bytes fields
+// unless --show-binary is passed and truncates long table cells unless
+// --wide is
+func recordPrinter(out io.Writer, storage *memory.Storage, schema string, fields []string, format string, flags preprocessing.Flags) *recordWriter {
+	schemaDef, err := storage.GetSchema(schema)
+	if err != nil {
+		schemaDef = ""
+	}
+	w := newRecordWriter(out, format, schemaDef, fields)
+	if !flags.Has("show-binary") {
+		w.redact = memory.BinaryFields(schemaDef)
+	}
+	w.wide = flags.Has("wide")
+	if jsonOutput && jsonRecords == nil {
+		// Commands returning records report them as data, even none
+		jsonRecords = []json.RawMessage{}
+	}
+	return w
+}
+
+// reportProgress makes the writer report the records it wrote as progress
+// when stdout is not a terminal, such as when exporting a schema to a file.
+// Total is the number of records expected, zero when not known.
+func (w *recordWriter) reportProgress(storage *memory.Storage, total int) {
+	if jsonOutput || isTerminal(os.Stdout) {
+		return
+	}
+	w.report, w.total = storage.Report, total
+}
+
+// Write prints a record as a query streams it
+func (w *recordWriter) Write(record interface{}) error {
+	record = memory.RedactBinary(record, w.redact)
+	w.written++
+	if w.report != nil {
+		w.report("Exporting records", w.written, w.total)
+	}
+	if jsonOutput {
+		jsonRecords = append(jsonRecords, json.RawMessage(recordText(record)))
+		return nil
+	}
+
+	switch w.format {
+	case "json":
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, []byte(recordText(record)), "", "  "); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(w.out, paintJSON(pretty.String()))
+		return err
+	case "yaml":
+		fields, err := decodeFields(record)
+		if err != nil {
+			return err
+		}
+		var doc strings.Builder
+		if w.written > 1 {
+			doc.WriteString("---\n")
+		}
+		writeYAML(&doc, fields, 0)
+		_, err = io.WriteString(w.out, doc.String())
+		return err
+	case "table":
+		fields, err := decodeFields(record)
+		if err != nil {
+			return err
+		}
+		if len(w.columns) == 0 {
+			w.columns = sortedKeys(fields)
+		}
+		values := make([]interface{}, len(w.columns))
+		for i, column := range w.columns {
+			values[i] = lookupPath(fields, column)
+		}
+		w.table.add(values)
+		return nil
+	case "csv":
+		fields, err := decodeFields(record)
+		if err != nil {
+			return err
+		}
+		if w.written == 1 {
+			if len(w.columns) == 0 {
+				w.columns = sortedKeys(fields)
+			}
+			if err := w.csv.Write(w.columns); err != nil {
+				return err
+			}
+		}
+		row := make([]string, len(w.columns))
+		for i, column := range w.columns {
+			row[i] = cellText(lookupPath(fields, column))
+		}
+		return w.csv.Write(row)
+	}
+
+	_, err := fmt.Fprintln(w.out, paintJSON(recordText(record)))
+	return err
+}
+
+// Flush prints what the writer holds back, and the header of a table or
+// CSV that received no record
+func (w *recordWriter) Flush() error {
+	if w.report != nil && w.written > 0 {
+		// Ends the progress of an export, which may stop short of its total
+		w.report("Exporting records", w.written, w.written)
+	}
+	switch {
+	case jsonOutput:
+		return nil
+	case w.table != nil:
+		width := maxCellWidth
+		if w.wide {
+			width = 0
+		}
+		_, err := io.WriteString(w.out, w.table.render(w.columns, w.numeric, width))
+		return err
+	case w.csv != nil:
+		if w.written == 0 && len(w.columns) > 0 {
+			if err := w.csv.Write(w.columns); err != nil {
+				return err
+			}
+		}
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return nil
+}
+
+// tableRenderer lays records out in aligned columns
+type tableRenderer struct {
+	rows [][]interface{}
+}
+
+// add holds a row of values until the table is rendered
+func (t *tableRenderer) add(values []interface{}) {
+	t.rows = append(t.rows, values)
+}
+
+// render returns the table with the given column names as its header,
+// underlined, and a row per record. Columns are as wide as their widest
+// cell, and cells wider than maxWidth are cut short with an ellipsis when
+// maxWidth is above zero. Columns of declared numeric fields, and
+// undeclared columns holding only numbers, are aligned to the right.
+func (t *tableRenderer) render(columns []string, numeric map[string]bool, maxWidth int) string {
+	if len(columns) == 0 {
+		return ""
+	}
+
+	cells := make([][]string, 0, len(t.rows)+2)
+	cells = append(cells, make([]string, len(columns)), make([]string, len(columns)))
+	widths := make([]int, len(columns))
+	right := make([]bool, len(columns))
+	for i, column := range columns {
+		cells[0][i] = truncateCell(column, maxWidth)
+		widths[i] = runewidth.StringWidth(cells[0][i])
+		if isNumeric, declared := numeric[column]; declared {
+			right[i] = isNumeric
+		} else {
+			right[i] = len(t.rows) > 0
+			for _, row := range t.rows {
+				if _, isNumber := row[i].(json.Number); !isNumber && row[i] != nil {
+					right[i] = false
+				}
+			}
+		}
+	}
+	for _, row := range t.rows {
+		line := make([]string, len(columns))
+		for i, value := range row {
+			line[i] = truncateCell(cellText(value), maxWidth)
+			if width := runewidth.StringWidth(line[i]); width > widths[i] {
+				widths[i] = width
+			}
+		}
+		cells = append(cells, line)
+	}
+	for i := range columns {
+		cells[1][i] = strings.Repeat("-", widths[i])
+	}
+
+	var b strings.Builder
+	for row, line := range cells {
+		var text strings.Builder
+		for i, cell := range line {
+			if i > 0 {
+				text.WriteString("  ")
+			}
+			padding := strings.Repeat(" ", widths[i]-runewidth.StringWidth(cell))
+			// The header names the fields
+			if row == 0 {
+				cell = paint(colorCyan, cell)
+			}
+			if right[i] {
+				text.WriteString(padding + cell)
+			} else {
+				text.WriteString(cell + padding)
+			}
+		}
+		b.WriteString(strings.TrimRight(text.String(), " ") + "\n")
+	}
+	return b.String()
+}
+
+// truncateCell keeps the text of a table cell on one line and cuts it
+// short with an ellipsis when it is wider than maxWidth, unless maxWidth
+// is zero
+func truncateCell(text string, maxWidth int) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	if maxWidth <= 0 || runewidth.StringWidth(text) <= maxWidth {
+		return text
+	}
+	return runewidth.Truncate(text, maxWidth, "…")
+}
+
+// recordText returns the JSON text of a record, which queries hand over
+// as the string it is stored as
+func recordText(record interface{}) string {
+	if text, ok := record.(string); ok {
+		return text
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Sprint(record)
+	}
+	return string(data)
+}
+
+// decodeFields decodes a record, keeping numbers as written
+func decodeFields(record interface{}) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(recordText(record)))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("failed to decode record: %v", err)
+	}
+	return fields, nil
+}
+
+// lookupPath returns the value of a field, or of a dotted path into nested
+// objects such as address.city, and nil when the record lacks it
+func lookupPath(fields map[string]interface{}, path string) interface{} {
+	if value, exists := fields[path]; exists {
+		return value
+	}
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		return nil
+	}
+	inner, ok := fields[head].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookupPath(inner, rest)
+}
+
+// cellText returns how a value reads in a table or CSV cell: strings as
+// they are, nothing for null or a missing field, and other values as JSON
+func cellText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// sortedKeys returns the keys of a decoded object in name order
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeYAML writes a decoded value as a YAML block indented by the given
+// number of spaces, ending in a newline
+func writeYAML(b *strings.Builder, value interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			b.WriteString(pad + "{}\n")
+			return
+		}
+		for _, key := range sortedKeys(v) {
+			b.WriteString(pad + paint(colorCyan, yamlScalar(key)) + ":")
+			if isYAMLBlock(v[key]) {
+				b.WriteString("\n")
+				writeYAML(b, v[key], indent+2)
+			} else {
+				b.WriteString(" " + yamlInline(v[key]) + "\n")
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range v {
+			if !isYAMLBlock(item) {
+				b.WriteString(pad + "- " + yamlInline(item) + "\n")
+				continue
+			}
+			// The first line of a nested block follows the dash
+			var nested strings.Builder
+			writeYAML(&nested, item, indent+2)
+			b.WriteString(pad + "- " + strings.TrimPrefix(nested.String(), pad+"  "))
+		}
+	default:
+		b.WriteString(pad + yamlInline(value) + "\n")
+	}
+}
+
+// isYAMLBlock reports whether a value is written as a block of lines
+// rather than after its key or dash
+func isYAMLBlock(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
+
+// yamlInline writes a value that fits on the line of its key or dash
+func yamlInline(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlScalar(v)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return yamlScalar(fmt.Sprint(value))
+}
+
+// yamlScalar writes a string plainly when YAML reads it back as the same
+// string, and double-quoted otherwise
+func yamlScalar(text string) string {
+	if text == "" || strings.TrimSpace(text) != text || strings.ContainsAny(text, ":#{}[],&*!|>'\"%@`\n\t\\") ||
+		strings.ContainsAny(text[:1], "-?") {
+		return strconv.Quote(text)
+	}
+	switch strings.ToLower(text) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~", "y", "n":
+		return strconv.Quote(text)
+	}
+	if _, err := strconv.ParseFloat(text, 64); err == nil {
+		return strconv.Quote(text)
+	}
+	return text
+}
@@ -2,4 +2,11 @@ module simplebson
 
 go 1.25
 
-require go.mongodb.org/mongo-driver v1.17.6
+require (
+	github.com/mattn/go-runewidth v0.0.3
+	github.com/peterh/liner v1.2.2
+	go.mongodb.org/mongo-driver v1.17.6
+	golang.org/x/term v0.35.0
+)
+
+require golang.org/x/sys v0.36.0 // indirect
@@ -0,0 +1,794 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandHelp documents a command, or a subcommand such as schema alter,
+// for the command list, help <command> and <command> --help
+type commandHelp struct {
+	name     string   // The command, followed by its subcommand if any
+	aliases  []string // Other names running the same command
+	summary  string   // One line shown in the command list
+	usage    []string // Forms of the command line, without simplebson
+	about    string   // What the command does, in more detail
+	args     []helpItem
+	flags    []helpItem
+	examples []string
+}
+
+// helpItem describes an argument or a flag
+type helpItem struct {
+	name string
+	text string
+}
+
+// Flags taken by several commands
+var (
+	fieldsFlag     = helpItem{"--fields <field,...>", "Only print the given fields, or nested ones like address.city"}
+	formatFlag     = helpItem{"--format <format>", "Print records as json, table, csv or yaml"}
+	wideFlag       = helpItem{"--wide", "Print table cells in full instead of cutting them at 40 characters"}
+	showBinaryFlag = helpItem{"--show-binary", "Print bytes fields as base64 instead of their size"}
+	explainFlag    = helpItem{"--explain", "Print the access path and records examined after the records"}
+	sortFlag       = helpItem{"--sort <field[:desc]>", "Order records by a field instead of by key"}
+	limitFlag      = helpItem{"--limit <n>", "Return at most n records"}
+	offsetFlag     = helpItem{"--offset <n>", "Skip the first n records"}
+	cursorFlag     = helpItem{"--cursor <token>", "Resume after the page that printed this Next cursor"}
+	sinceFlag      = helpItem{"--since <time>", "Only records created at or after a time or duration ago"}
+	untilFlag      = helpItem{"--until <time>", "Only records created at or before a time or duration ago"}
+	timeFieldFlag  = helpItem{"--time-field <field>", "Timestamp --since and --until compare, created_at by default"}
+	prefixFlag     = helpItem{"--prefix", "Accept the prefix of a single key"}
+	fuzzyFlag      = helpItem{"--fuzzy", "Accept a key a few typos away from a stored one"}
+	ignoreCaseFlag = helpItem{"--ignore-case", "Match the key regardless of case"}
+	fileFlag       = helpItem{"--file <path>", "Read the record data from a file, as - reads it from stdin"}
+	upsertForce    = helpItem{"--force", "Also change fields declared immutable"}
+	noPagerFlag    = helpItem{"--no-pager", "Print straight to the terminal instead of through $PAGER"}
+)
+
+// globalFlags are the flags every command takes
+var globalFlags = []helpItem{
+	{"--db <database>", "Run the command against another database"},
+	{"--verbose", "Also print file paths, timings and validation detail to stderr"},
+	{"--quiet", "Hide warnings such as files that could not be read"},
+	{"--no-color", "Print without colors, as when NO_COLOR is set or output is not a terminal"},
+	{"--json", "Print the outcome as a JSON object with ok, code, message and data"},
+	{"--help", "Print the help of the command"},
+}
+
+// Arguments taken by several commands
+var (
+	schemaArg = helpItem{"<schema>", "Name of the schema"}
+	keyArg    = helpItem{"<key>", "Key of the record"}
+	filterArg = helpItem{"[filter...]", "Filters every record must match: field=value, expressions such as \"age > 30\", or a query document"}
+)
+
+// commands documents every command, in the order the command list shows
+// them
+var commands = []commandHelp{
+	{
+		name:    "schema",
+		summary: "Create or view a schema, or list all schemas",
+		usage: []string{
+			"schema <schema> <field_definitions>",
+			"schema <schema> extends <parent> [field_definitions]",
+			"schema <schema>",
+			"schema",
+		},
+		about: "Defines a schema by its fields, written name:type with optional constraints, or prints the definition of an existing one. " +
+			"Without arguments the schemas of the database are listed. A definition ending in strict rejects undeclared fields, " +
+			"and one ending in ignorecase matches keys regardless of case.",
+		args: []helpItem{
+			schemaArg,
+			{"<field_definitions>", "Fields such as name:string age:int email:string:required:unique"},
+			{"<parent>", "Schema whose fields the new schema inherits"},
+		},
+		examples: []string{
+			"schema User name:string age:int email:string",
+			"schema Account name:string! email:string:required:unique active:bool=true",
+			"schema Member email:string:key name:string",
+			"schema Order id:int amount:decimal:immutable",
+			"schema Login id:uuid \"user_id:ref(User,cascade)\"",
+			"schema Ticket title:string \"status:enum(open,closed,pending)=open\"",
+			"schema Post title:string \"tags:[]string=[]\" \"scores:[]int\"",
+			"schema Customer name:string address:Address",
+			"schema Event name:string day:date starts:datetime",
+			"schema Visit page:string \"at:datetime=now()\" \"token:string=uuid()\"",
+			"schema Issue id:serial title:string",
+			"schema Contact name:string email:string strict",
+			"schema Lead name:string email:string ignorecase",
+			"schema AdminUser extends User role:string",
+			"schema Person \"age:int(min=0,max=150)\" \"email:string(pattern=^.+@.+$)\"",
+			"schema Card holder:string \"number:string!:validator(luhn)\"",
+		},
+	},
+	{
+		name:    "schema alter",
+		summary: "Add, rename or drop a field",
+		usage: []string{
+			"schema alter <schema> add-field <field_definition>",
+			"schema alter <schema> rename-field <old_name> <new_name>",
+			"schema alter <schema> drop-field <field>",
+		},
+		about: "Changes a field of a schema and rewrites the records it holds to match.",
+		args: []helpItem{
+			schemaArg,
+			{"<field_definition>", "The field to add, written as in schema"},
+			{"<old_name> <new_name>", "The field to rename and its new name"},
+			{"<field>", "The field to drop"},
+		},
+		examples: []string{
+			"schema alter User add-field phone:string",
+			"schema alter User rename-field phone mobile",
+			"schema alter User drop-field mobile",
+		},
+	},
+	{
+		name:    "schema drop",
+		summary: "Remove a schema",
+		usage:   []string{"schema drop <schema> [--with-records]"},
+		about:   "Removes the definition of a schema. A schema still holding records is only removed with --with-records.",
+		args:    []helpItem{schemaArg},
+		flags: []helpItem{
+			{"--with-records", "Also remove the records the schema holds"},
+		},
+		examples: []string{"schema drop Session --with-records"},
+	},
+	{
+		name:     "schema rename",
+		summary:  "Rename a schema and move its records",
+		usage:    []string{"schema rename <old_name> <new_name>"},
+		args:     []helpItem{{"<old_name>", "The schema to rename"}, {"<new_name>", "Its new name"}},
+		examples: []string{"schema rename Customer Client"},
+	},
+	{
+		name:     "schema export",
+		summary:  "Print schemas as JSON documents",
+		usage:    []string{"schema export [schema...]"},
+		about:    "Prints the definitions of the given schemas, or of all of them, in a file schema import reads back.",
+		args:     []helpItem{{"[schema...]", "The schemas to export, all by default"}},
+		examples: []string{"schema export > schemas.json", "schema export User Order"},
+	},
+	{
+		name:     "schema import",
+		summary:  "Apply schemas exported as JSON",
+		usage:    []string{"schema import <file|->"},
+		args:     []helpItem{{"<file|->", "File written by schema export, or - to read stdin"}},
+		examples: []string{"schema import schemas.json"},
+	},
+	{
+		name:     "schema json",
+		summary:  "Define a schema by a JSON Schema document",
+		usage:    []string{"schema json <schema> <file|->"},
+		args:     []helpItem{schemaArg, {"<file|->", "The JSON Schema document, or - to read stdin"}},
+		examples: []string{"schema json Person person.schema.json"},
+	},
+	{
+		name:    "schema infer",
+		summary: "Propose a definition from sample records",
+		usage:   []string{"schema infer <schema> [file|-] [--apply]"},
+		about:   "Proposes a definition fitting the records of a file, or the records the schema holds when no file is given.",
+		args:    []helpItem{schemaArg, {"[file|-]", "Sample records as JSON, or - to read stdin"}},
+		flags: []helpItem{
+			{"--apply", "Create the schema that was inferred"},
+		},
+		examples: []string{"schema infer User sample.json", "schema infer Visit --apply"},
+	},
+	{
+		name:     "schema diff",
+		summary:  "Compare a schema with another database or export",
+		usage:    []string{"schema diff <schema> <database|file|->"},
+		args:     []helpItem{schemaArg, {"<database|file|->", "Database holding the other definition, or a file written by schema export"}},
+		examples: []string{"schema diff User production", "schema diff User schemas.json"},
+	},
+	{
+		name:    "schema copy",
+		summary: "Copy a schema to another database",
+		usage:   []string{"schema copy <schema> --to <database> [--with-records]"},
+		args:    []helpItem{schemaArg},
+		flags: []helpItem{
+			{"--to <database>", "The database the schema is copied to"},
+			{"--with-records", "Also copy the records the schema holds"},
+		},
+		examples: []string{"schema copy User --to staging --with-records"},
+	},
+	{
+		name:    "add",
+		summary: "Add one or more records",
+		usage: []string{
+			"add <schema> <record_data> [record_data...]",
+			"add <schema> -",
+			"add <schema> --file <path>",
+		},
+		about: "Adds records to a schema. Several records are saved in a single write, and none is added when one fails validation.",
+		args: []helpItem{
+			schemaArg,
+			{"<record_data>", "A record as a JSON object"},
+		},
+		flags: []helpItem{
+			fileFlag,
+			{"--upsert", "Update the records whose key already exists, as upsert does"},
+			{"--force", "With --upsert, also change fields declared immutable"},
+		},
+		examples: []string{
+			"add User '{\"name\":\"Alice\", \"age\":30, \"email\":\"alice@example.com\"}'",
+			"add User --file users.json",
+			"echo '{\"name\":\"Bob\"}' | simplebson add User -",
+		},
+	},
+	{
+		name:    "upsert",
+		summary: "Add records or update existing ones",
+		usage:   []string{"upsert <schema> <record_data> [record_data...]"},
+		about:   "Adds records to a schema, replacing the fields of those whose key already exists.",
+		args: []helpItem{
+			schemaArg,
+			{"<record_data>", "A record as a JSON object"},
+		},
+		flags:    []helpItem{fileFlag, upsertForce},
+		examples: []string{"upsert User '{\"name\":\"Alice\", \"age\":31}'"},
+	},
+	{
+		name:    "get",
+		aliases: []string{"view"},
+		summary: "Get a record",
+		usage:   []string{"get <schema> <key> [--prefix|--fuzzy|--ignore-case]"},
+		about:   "Prints the record stored under a key.",
+		args:    []helpItem{schemaArg, keyArg},
+		flags: []helpItem{
+			prefixFlag, fuzzyFlag, ignoreCaseFlag,
+			{"--verify", "Warn when the record fails its checksum"},
+			fieldsFlag, formatFlag, wideFlag, showBinaryFlag, explainFlag,
+		},
+		examples: []string{
+			"get User Alice",
+			"get User Ali --prefix",
+			"get User Alcie --fuzzy",
+			"get User alice --ignore-case",
+			"get Attachment note.txt --show-binary",
+		},
+	},
+	{
+		name:    "exists",
+		summary: "Print true and exit 0 if a record exists",
+		usage:   []string{"exists <schema> <key>"},
+		about:   "Prints true and exits with 0 when the record exists, or prints false and exits with 1.",
+		args:    []helpItem{schemaArg, keyArg},
+		flags:   []helpItem{prefixFlag, fuzzyFlag, ignoreCaseFlag},
+		examples: []string{
+			"exists User Alice && echo found",
+		},
+	},
+	{
+		name:     "keys",
+		summary:  "List the keys starting with a prefix",
+		usage:    []string{"keys <schema> [prefix]"},
+		about:    "Lists the keys of a schema in key order, only those starting with the prefix when one is given.",
+		args:     []helpItem{schemaArg, {"[prefix]", "The start of the keys to list"}},
+		examples: []string{"keys User Al"},
+	},
+	{
+		name:    "update",
+		summary: "Change fields of a record",
+		usage:   []string{"update <schema> <key> <update_data>"},
+		about:   "Changes the given fields of a record, keeping the others.",
+		args: []helpItem{
+			schemaArg, keyArg,
+			{"<update_data>", "The fields to change, as a JSON object"},
+		},
+		flags: []helpItem{fileFlag, upsertForce},
+		examples: []string{
+			"update User Alice '{\"age\":31}'",
+			"update Order 1001 '{\"amount\":90}' --force",
+		},
+	},
+	{
+		name:    "update-where",
+		summary: "Change fields of all matching records",
+		usage:   []string{"update-where <schema> <filter> <update_data>"},
+		about:   "Changes the same fields of every record matching a filter, in one batch.",
+		args: []helpItem{
+			schemaArg,
+			{"<filter>", "Filter the records to change must match"},
+			{"<update_data>", "The fields to change, as a JSON object"},
+		},
+		flags: []helpItem{
+			fileFlag,
+			{"--dry-run", "Only count the records that would change"},
+			upsertForce,
+		},
+		examples: []string{"update-where User \"age < 18\" '{\"minor\":true}' --dry-run"},
+	},
+	{
+		name:     "delete",
+		summary:  "Delete a record",
+		usage:    []string{"delete <schema> <key>"},
+		args:     []helpItem{schemaArg, keyArg},
+		flags:    []helpItem{prefixFlag, fuzzyFlag, ignoreCaseFlag},
+		examples: []string{"delete User Alice"},
+	},
+	{
+		name:    "delete-where",
+		summary: "Delete all matching records",
+		usage:   []string{"delete-where <schema> <filter> [--yes]"},
+		about:   "Deletes every record matching a filter. Deleting more than the confirm_threshold setting, 10 by default, needs --yes.",
+		args: []helpItem{
+			schemaArg,
+			{"<filter>", "Filter the records to delete must match"},
+		},
+		flags: []helpItem{
+			{"--yes", "Confirm deleting more than 10 records"},
+		},
+		examples: []string{"delete-where Session active=false --yes"},
+	},
+	{
+		name:    "list",
+		summary: "List all records of a schema",
+		usage:   []string{"list <schema>"},
+		args:    []helpItem{schemaArg},
+		flags: []helpItem{
+			fieldsFlag, formatFlag, wideFlag, sortFlag, limitFlag, offsetFlag, cursorFlag,
+			sinceFlag, untilFlag, timeFieldFlag, showBinaryFlag, explainFlag, noPagerFlag,
+		},
+		examples: []string{
+			"list User",
+			"list User --fields name,email",
+			"list User --format table",
+			"list User --sort age:desc",
+			"list User --limit 10 --offset 20",
+			"list User --limit 10 --cursor <token>",
+			"list User --since 24h --time-field updated_at",
+		},
+	},
+	{
+		name:    "find",
+		summary: "Find records matching filters",
+		usage:   []string{"find <schema> [filter...]"},
+		about:   "Prints the records matching every filter, using an index when one covers the filters.",
+		args:    []helpItem{schemaArg, filterArg},
+		flags: []helpItem{
+			fieldsFlag, formatFlag, wideFlag, sortFlag, limitFlag, offsetFlag, cursorFlag,
+			sinceFlag, untilFlag, timeFieldFlag, showBinaryFlag, explainFlag, noPagerFlag,
+		},
+		examples: []string{
+			"find User age=30",
+			"find User \"age > 30 && email != null\"",
+			"find Post \"tags contains golang\"",
+			"find User \"email IS NULL\" \"phone NOT EXISTS\"",
+			"find Customer address.city=Lagos --fields name,address.city",
+			"find User '{\"age\": {\"$gt\": 30}, \"name\": {\"$regex\": \"^Al\"}}'",
+			"find User age>30 --format csv > users.csv",
+			"find Orders customer=alice --explain",
+		},
+	},
+	{
+		name:    "sql",
+		summary: "Query records with a subset of SQL",
+		usage:   []string{"sql \"SELECT <* | field, ...> FROM <schema> [WHERE ...] [ORDER BY field [ASC|DESC]] [LIMIT n] [OFFSET n]\""},
+		args: []helpItem{
+			{"<query>", "A SELECT statement over a single schema"},
+		},
+		flags:    []helpItem{formatFlag, wideFlag, showBinaryFlag, explainFlag},
+		examples: []string{"sql \"SELECT name, age FROM User WHERE age > 30 ORDER BY age LIMIT 10\""},
+	},
+	{
+		name:    "agg",
+		summary: "Sum/avg/min/max of a numeric field",
+		usage:   []string{"agg <schema> <sum|avg|min|max> <field> [filter...]"},
+		about:   "Computes an aggregate over a numeric field of the records matching the filters.",
+		args: []helpItem{
+			schemaArg,
+			{"<sum|avg|min|max>", "The aggregate to compute"},
+			{"<field>", "A field declared int, float or decimal"},
+			filterArg,
+		},
+		flags: []helpItem{
+			{"--group-by <field>", "Aggregate each value of a field separately"},
+		},
+		examples: []string{
+			"agg User avg age \"email != null\"",
+			"agg Orders sum amount --group-by customer",
+		},
+	},
+	{
+		name:    "top",
+		summary: "Records with the smallest or largest values",
+		usage:   []string{"top <schema> <field> [filter...] [--n 10] [--desc]"},
+		args:    []helpItem{schemaArg, {"<field>", "The field records are ranked by"}, filterArg},
+		flags: []helpItem{
+			{"--n <n>", "Number of records to return, 10 by default"},
+			{"--desc", "Return the largest values instead of the smallest"},
+		},
+		examples: []string{"top Orders amount --n 5 --desc"},
+	},
+	{
+		name:    "distinct",
+		summary: "List the unique values of a field",
+		usage:   []string{"distinct <schema> <field> [filter...] [--count]"},
+		args:    []helpItem{schemaArg, {"<field>", "The field whose values are listed"}, filterArg},
+		flags: []helpItem{
+			{"--count", "Also print how many records hold each value"},
+		},
+		examples: []string{"distinct User age --count"},
+	},
+	{
+		name:    "pipeline",
+		summary: "Run an aggregation pipeline",
+		usage:   []string{"pipeline <schema> <stages>"},
+		about:   "Runs a JSON array of $match, $project, $group, $sort, $skip and $limit stages in one pass over the records.",
+		args:    []helpItem{schemaArg, {"<stages>", "The stages, as a JSON array"}},
+		examples: []string{
+			"pipeline Orders '[{\"$group\": {\"_id\": \"$customer\", \"total\": {\"$sum\": \"$amount\"}}}, {\"$sort\": {\"total\": -1}}]'",
+		},
+	},
+	{
+		name:    "join",
+		summary: "Combine records of two schemas",
+		usage:   []string{"join <left> <right> --on <left.field=right.field> [--left]"},
+		about:   "Pairs every record of the left schema with the records of the right one whose join field holds the same value.",
+		args: []helpItem{
+			{"<left>", "The schema whose records are paired"},
+			{"<right>", "The schema searched for partners"},
+		},
+		flags: []helpItem{
+			{"--on <l.field=r.field>", "Fields whose values must be equal"},
+			{"--left", "Also return left records without a match"},
+		},
+		examples: []string{"join User Orders --on User.id=Orders.user_id --left"},
+	},
+	{
+		name:    "search",
+		summary: "Find records by regular expression",
+		usage:   []string{"search <schema> <regexp> [--field f]"},
+		about:   "Prints the records with a field value matching a Go regular expression, after the fields that matched.",
+		args:    []helpItem{schemaArg, {"<regexp>", "A Go regular expression"}},
+		flags: []helpItem{
+			{"--field <field>", "Only search this field"},
+		},
+		examples: []string{"search User '@example\\.com$' --field email"},
+	},
+	{
+		name:     "search-text",
+		summary:  "Full-text search in text fields",
+		usage:    []string{"search-text <schema> <words>"},
+		about:    "Prints the records containing any of the words in their text fields, best matches first, after their score.",
+		args:     []helpItem{schemaArg, {"<words>", "The words to search for"}},
+		examples: []string{"search-text Post \"quick brown\""},
+	},
+	{
+		name:    "near",
+		summary: "Find records near a point",
+		usage:   []string{"near <schema> <lat> <lon> --radius <distance> [--field f]"},
+		about:   "Prints the records whose geo field lies within a distance of a point, closest first, after their distance.",
+		args: []helpItem{
+			schemaArg,
+			{"<lat> <lon>", "Latitude and longitude of the point"},
+		},
+		flags: []helpItem{
+			{"--radius <distance>", "Search radius such as 5km, 300m or 2mi"},
+			{"--field <field>", "The geo field to compare, needed when the schema has several"},
+		},
+		examples: []string{"near Shop 52.52 13.40 --radius 2km"},
+	},
+	{
+		name:    "index",
+		summary: "Manage compound indexes",
+		usage:   []string{"index <create|drop|list> ..."},
+		about:   "Creates, drops and lists the compound indexes of a schema.",
+	},
+	{
+		name:     "index create",
+		summary:  "Create a compound index",
+		usage:    []string{"index create <schema> <field,...>"},
+		about:    "Creates an index over an ordered tuple of fields, which find uses for filters comparing a prefix of them with ==.",
+		args:     []helpItem{schemaArg, {"<field,...>", "The indexed fields, most significant first"}},
+		examples: []string{"index create Orders customer,date"},
+	},
+	{
+		name:     "index drop",
+		summary:  "Drop a compound index",
+		usage:    []string{"index drop <schema> <field,...>"},
+		args:     []helpItem{schemaArg, {"<field,...>", "The fields of the index"}},
+		examples: []string{"index drop Orders customer,date"},
+	},
+	{
+		name:     "index list",
+		summary:  "List the indexes of a schema",
+		usage:    []string{"index list <schema>"},
+		args:     []helpItem{schemaArg},
+		examples: []string{"index list Orders"},
+	},
+	{
+		name:    "view",
+		summary: "View a record, or manage materialized views",
+		usage:   []string{"view <schema> <key>", "view <create|drop|list> ..."},
+		about:   "With a schema and a key, view is another name for get.",
+		args:    []helpItem{schemaArg, keyArg},
+	},
+	{
+		name:     "view create",
+		summary:  "Create a materialized view",
+		usage:    []string{"view create <name> \"FROM <schema> [WHERE <condition>]\""},
+		about:    "Creates a schema holding the records of another that match a condition, kept in sync as the source changes.",
+		args:     []helpItem{{"<name>", "Name of the view"}, {"<query>", "The source schema and condition, written as in sql"}},
+		examples: []string{"view create ActiveUsers \"FROM User WHERE active = true\""},
+	},
+	{
+		name:     "view drop",
+		summary:  "Drop a materialized view",
+		usage:    []string{"view drop <name>"},
+		about:    "Removes a view and its records, leaving its source untouched.",
+		args:     []helpItem{{"<name>", "Name of the view"}},
+		examples: []string{"view drop ActiveUsers"},
+	},
+	{
+		name:     "view list",
+		summary:  "List materialized views",
+		usage:    []string{"view list"},
+		examples: []string{"view list"},
+	},
+	{
+		name:    "watch",
+		summary: "Print changes to a schema as they are saved",
+		usage:   []string{"watch <schema> [--interval 500ms]"},
+		about: "Keeps running and prints a line for every record added, updated or deleted in a schema, " +
+			"by this or another process, until interrupted with Ctrl-C. The files of the database are checked " +
+			"every interval, and read again when they changed.",
+		args: []helpItem{schemaArg},
+		flags: []helpItem{
+			{"--interval <duration>", "How often the database files are checked, 500ms by default"},
+			showBinaryFlag,
+		},
+		examples: []string{"watch User", "watch Orders --interval 2s --db staging"},
+	},
+	{
+		name:     "checksum",
+		summary:  "Verify record checksums",
+		usage:    []string{"checksum <schema>"},
+		about:    "Checks every record of a schema against the checksum saved with it.",
+		args:     []helpItem{schemaArg},
+		examples: []string{"checksum User"},
+	},
+	{
+		name:     "archive",
+		summary:  "Move a schema to cold storage",
+		usage:    []string{"archive <schema>"},
+		about:    "Moves the records of a rarely used schema to a compressed file, loaded again when the schema is next used.",
+		args:     []helpItem{schemaArg},
+		examples: []string{"archive AuditLog"},
+	},
+	{
+		name:     "stats",
+		summary:  "Show storage engine statistics",
+		usage:    []string{"stats [schema...]"},
+		about:    "Prints record counts and read and write amplification of the given schemas, or of all of them.",
+		args:     []helpItem{{"[schema...]", "The schemas to report on, all by default"}},
+		examples: []string{"stats", "stats User"},
+	},
+	{
+		name:     "use",
+		summary:  "Switch the database later commands use",
+		usage:    []string{"use <database>"},
+		about:    "Selects the database later commands use, in this and later invocations, until another is selected.",
+		args:     []helpItem{{"<database>", "Name of the database, created when first written to"}},
+		examples: []string{"use my_database"},
+	},
+	{
+		name:     "dbs",
+		summary:  "List all available databases",
+		usage:    []string{"dbs"},
+		about:    "Lists the databases, marking the one in use.",
+		examples: []string{"dbs"},
+	},
+	{
+		name:     "shell",
+		summary:  "Run commands at an interactive prompt",
+		usage:    []string{"shell"},
+		about:    "Runs commands typed at a prompt against a database loaded once, with tab completion and history.",
+		examples: []string{"shell"},
+	},
+	{
+		name:    "run",
+		summary: "Run the commands in a script, saving once",
+		usage:   []string{"run <script|-> [--keep-going]"},
+		about:   "Runs the commands of a script, one per line, and saves the database once at the end. The script stops at the first command that fails.",
+		args:    []helpItem{{"<script|->", "The script to run, or - to read stdin"}},
+		flags: []helpItem{
+			{"--keep-going", "Run the rest of the script after a command fails"},
+		},
+		examples: []string{"run setup.sbs --keep-going"},
+	},
+	{
+		name:     "flush",
+		summary:  "Write pending changes to disk",
+		usage:    []string{"flush"},
+		about:    "Saves the changes async mode holds in memory.",
+		examples: []string{"flush"},
+	},
+	{
+		name:     "reindex",
+		summary:  "Rebuild the indexes of schemas",
+		usage:    []string{"reindex [schema...]"},
+		about:    "Rebuilds the secondary indexes of the given schemas, or of all of them, from their records, reporting a value held twice in a unique field.",
+		args:     []helpItem{{"[schema...]", "The schemas to reindex, all by default"}},
+		examples: []string{"reindex", "reindex User"},
+	},
+	{
+		name:     "compact",
+		summary:  "Merge the SSTables of schemas",
+		usage:    []string{"compact [schema...]"},
+		about:    "Merges the SSTables of the given schemas, or of all of them, into one each, dropping overwritten values and deleted records.",
+		args:     []helpItem{{"[schema...]", "The schemas to compact, all by default"}},
+		examples: []string{"compact", "compact User"},
+	},
+	{
+		name:    "wipe",
+		aliases: []string{"drop"},
+		summary: "Wipe a database after confirming it",
+		usage:   []string{"wipe [database] [--force]"},
+		about:   "Removes every schema and record of a database after listing them and asking for confirmation.",
+		args:    []helpItem{{"[database]", "The database to wipe, the one in use by default"}},
+		flags: []helpItem{
+			{"--force", "Wipe without confirming, also when stdin is not a terminal"},
+		},
+		examples: []string{"wipe", "wipe staging --force"},
+	},
+	{
+		name:    "completion",
+		summary: "Print a shell completion script",
+		usage:   []string{"completion <bash|zsh|fish>"},
+		about: "Prints a script completing commands, subcommands, options, schema names, databases and record keys " +
+			"in bash, zsh or fish. The script asks simplebson for the candidates of the word being completed, " +
+			"and falls back to file names when there are none.",
+		args: []helpItem{{"<bash|zsh|fish>", "The shell the script is for"}},
+		examples: []string{
+			"source <(simplebson completion bash)",
+			"source <(simplebson completion zsh)",
+			"simplebson completion fish | source",
+			"simplebson completion bash > /etc/bash_completion.d/simplebson",
+		},
+	},
+	{
+		name:     "help",
+		summary:  "Show the help of a command",
+		usage:    []string{"help [command] [subcommand]"},
+		args:     []helpItem{{"[command]", "The command to describe, all of them by default"}},
+		examples: []string{"help get", "help schema alter"},
+	},
+}
+
+// lookupHelp returns the help of a command, or of its subcommand when the
+// first argument names one
+func lookupHelp(command string, args []string) (commandHelp, bool) {
+	if len(args) > 0 {
+		if help, found := findHelp(command + " " + strings.ToLower(args[0])); found {
+			return help, true
+		}
+	}
+	return findHelp(command)
+}
+
+// findHelp returns the help of a command by its name, or else by an alias
+func findHelp(name string) (commandHelp, bool) {
+	for _, help := range commands {
+		if help.name == name {
+			return help, true
+		}
+	}
+	for _, help := range commands {
+		for _, alias := range help.aliases {
+			if alias == name {
+				return help, true
+			}
+		}
+	}
+	return commandHelp{}, false
+}
+
+// runHelp prints the help of the command given, or the usage of every
+// command without one
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return 0
+	}
+	help, found := lookupHelp(strings.ToLower(args[0]), args[1:])
+	if !found {
+		printError("Error: unknown command '%s'\n", args[0])
+		fmt.Println("Run 'simplebson help' to list the commands")
+		return 1
+	}
+	printCommandHelp(help)
+	return 0
+}
+
+// printCommandHelp prints the forms, arguments, flags and examples of a
+// command, and the subcommands it has
+func printCommandHelp(help commandHelp) {
+	fmt.Println("Usage:")
+	for _, usage := range help.usage {
+		fmt.Println("  simplebson " + usage)
+	}
+	fmt.Println()
+	about := help.about
+	if about == "" {
+		about = help.summary
+	}
+	fmt.Println(wrapText(about, 80))
+	if len(help.aliases) > 0 {
+		fmt.Printf("Also run as: %s\n", strings.Join(help.aliases, ", "))
+	}
+
+	var subcommands []helpItem
+	for _, sub := range commands {
+		if strings.HasPrefix(sub.name, help.name+" ") {
+			subcommands = append(subcommands, helpItem{"simplebson " + sub.name, sub.summary})
+		}
+	}
+	printHelpItems("Subcommands:", subcommands)
+	printHelpItems("Arguments:", help.args)
+	printHelpItems("Options:", help.flags)
+	printHelpItems("Options of every command:", globalFlags)
+
+	if len(help.examples) > 0 {
+		fmt.Println()
+		fmt.Println("Examples:")
+		for _, example := range help.examples {
+			if !strings.Contains(example, "simplebson ") {
+				example = "simplebson " + example
+			}
+			fmt.Println("  " + example)
+		}
+	}
+}
+
+// wrapText breaks text into lines no longer than width where it can
+func wrapText(text string, width int) string {
+	var b strings.Builder
+	lineLen := 0
+	for _, word := range strings.Fields(text) {
+		if lineLen > 0 && lineLen+1+len(word) > width {
+			b.WriteString("\n")
+			lineLen = 0
+		} else if lineLen > 0 {
+			b.WriteString(" ")
+			lineLen++
+		}
+		b.WriteString(word)
+		lineLen += len(word)
+	}
+	return b.String()
+}
+
+// printHelpItems prints a titled list of names with their descriptions
+// aligned after the longest name
+func printHelpItems(title string, items []helpItem) {
+	if len(items) == 0 {
+		return
+	}
+	width := 0
+	for _, item := range items {
+		if len(item.name) > width {
+			width = len(item.name)
+		}
+	}
+	fmt.Println()
+	fmt.Println(title)
+	for _, item := range items {
+		fmt.Printf("  %-*s  %s\n", width, item.name, item.text)
+	}
+}
+
+// printUsage prints the commands with their summary and the options every
+// command takes, leaving the details to help <command>
+func printUsage() {
+	fmt.Println("Usage: simplebson <command> [arguments] [options]")
+	list := make([]helpItem, len(commands))
+	for i, help := range commands {
+		list[i] = helpItem{help.name, help.summary}
+	}
+	printHelpItems("Commands:", list)
+	printHelpItems("Options of every command:", globalFlags)
+	fmt.Println()
+	fmt.Println("Run 'simplebson help <command>' or 'simplebson <command> --help' for the arguments, options and examples of a command.")
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"simplebson/config"
 	"simplebson/memory"
@@ -18,166 +25,1376 @@ func main() {
 
 	command := strings.ToLower(os.Args[1])
 
-	config := config.LoadConfig()
+	config, err := config.LoadConfig()
+	if err != nil {
+		printError("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Initialize LSM-enhanced preprocessor
-	// This creates an instance that could leverage LSM tree optimizations
-	_ = preprocessing.NewLSMPreprocessor(1000) // Size can be configured
+	// Loading the database already reports what it reads
+	_, flags := preprocessing.ParseFlags(os.Args[2:])
+	if verbosity, err := parseVerbosity(flags, config.Verbosity); err == nil {
+		config.Verbosity = verbosity
+	}
 
 	storage := memory.NewStorage(config)
+	storage.SetProgress(newProgressReporter(config).Report)
+
+	// Make sure pending writes reach disk when the process is interrupted
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := storage.Close(); err != nil {
+			printError("Error saving database: %v\n", err)
+		}
+		os.Exit(1)
+	}()
+
+	var exitCode int
+	if command == completeCommand {
+		// The words to complete are passed on as typed, options included
+		exitCode = runComplete(storage, os.Args[2:])
+	} else {
+		exitCode = run(config, storage, command, os.Args[2:])
+	}
+
+	if err := storage.Close(); err != nil {
+		printError("Error saving database: %v\n", err)
+		exitCode = exitCodes[memory.KindOf(err)]
+	}
+	os.Exit(exitCode)
+}
+
+// parseVerbosity returns the verbosity a command runs with: Verbose with
+// --verbose, Quiet with --quiet, and the configured one otherwise
+func parseVerbosity(flags preprocessing.Flags, configured int) (int, error) {
+	switch {
+	case flags.Has("verbose") && flags.Has("quiet"):
+		return 0, fmt.Errorf("--verbose and --quiet cannot be combined")
+	case flags.Has("verbose"):
+		return config.Verbose, nil
+	case flags.Has("quiet"):
+		return config.Quiet, nil
+	}
+	return configured, nil
+}
+
+// runCommand executes a single command against the storage and returns 1
+// when it fails
+func runCommand(cfg *config.Config, storage *memory.Storage, command string, args []string) int {
+	args, flags := preprocessing.ParseFlags(args)
+	colorOutput = useColor(flags)
+
+	if flags.Has("help") {
+		if help, found := lookupHelp(command, args); found {
+			printCommandHelp(help)
+			return 0
+		}
+	}
+
+	// --verbose and --quiet apply to this command only
+	verbosity, err := parseVerbosity(flags, cfg.Verbosity)
+	if err != nil {
+		printError("Error parsing flags: %v\n", invalidArgs(err))
+		return 1
+	}
+	defer func(previous int) { cfg.Verbosity = previous }(cfg.Verbosity)
+	cfg.Verbosity = verbosity
+	start := time.Now()
+	defer func() { cfg.Debugf("%s finished in %v", command, time.Since(start)) }()
+
+	// --db runs the command against another database, switching back
+	// afterwards so the shell stays on the one selected with use
+	if flags.Has("db") && command != "use" {
+		dbName, err := preprocessing.ParseDBName(flags.Get("db"))
+		if err != nil {
+			printError("Error parsing --db: %v\n", invalidArgs(err))
+			return 1
+		}
+		if previous := storage.CurrentDB(); previous != dbName {
+			storage.UseDB(dbName)
+			defer storage.UseDB(previous)
+		}
+	}
+
+	args, err = recordArgs(command, args, flags)
+	if err != nil {
+		printError("Error reading record data: %v\n", err)
+		return 1
+	}
 
-	args := os.Args[2:]
 	parsedArgs, err := preprocessing.ParseCommand(command, args)
 	if err != nil {
-		fmt.Printf("Error parsing command: %v\n", err)
-		os.Exit(1)
+		printError("Error parsing command: %v\n", invalidArgs(err))
+		return 1
+	}
+	fields := preprocessing.ParseFieldList(flags.Get("fields"))
+	format := cfg.Format
+	if flags.Has("format") {
+		format = flags.Get("format")
+	}
+	if format, err = preprocessing.ParseFormat(format); err != nil {
+		printError("Error parsing format: %v\n", invalidArgs(err))
+		return 1
+	}
+	opts := memory.QueryOptions{Fields: fields}
+	if flags.Has("sort") {
+		opts.SortField, opts.SortDescending, err = preprocessing.ParseSortSpec(flags.Get("sort"))
+		if err != nil {
+			printError("Error parsing --sort: %v\n", invalidArgs(err))
+			return 1
+		}
+	}
+	if opts.Limit, err = flags.Count("limit"); err != nil {
+		printError("Error parsing flags: %v\n", invalidArgs(err))
+		return 1
+	}
+	if opts.Offset, err = flags.Count("offset"); err != nil {
+		printError("Error parsing flags: %v\n", invalidArgs(err))
+		return 1
+	}
+	if flags.Has("cursor") {
+		if flags.Has("offset") {
+			printError("Error parsing flags: %v\n", memory.Errorf(memory.ErrorValidation, "--cursor and --offset cannot be combined"))
+			return 1
+		}
+		if opts.After, err = memory.ParseCursor(flags.Get("cursor")); err != nil {
+			printError("Error parsing flags: %v\n", invalidArgs(err))
+			return 1
+		}
+	}
+	if flags.Has("since") || flags.Has("until") {
+		timeField := "created_at"
+		if flags.Has("time-field") {
+			timeField = flags.Get("time-field")
+		}
+		opts.Filter, err = preprocessing.TimeWindow(timeField, flags.Get("since"), flags.Get("until"), time.Now())
+		if err != nil {
+			printError("Error parsing flags: %v\n", invalidArgs(err))
+			return 1
+		}
 	}
 	switch command {
-	case "add":
+	case "add", "upsert":
 		if len(parsedArgs) < 2 {
-			fmt.Println("Usage: simplebson add <schema> <record_data>")
-			os.Exit(1)
+			fmt.Printf("Usage: simplebson %s <schema> <record_data> [record_data...]\n", command)
+			return 1
 		}
 		schema := parsedArgs[0]
-		recordData := parsedArgs[1]
-		err := storage.AddRecord(schema, recordData)
-		if err != nil {
-			fmt.Printf("Error adding record: %v\n", err)
-			os.Exit(1)
+		records := parsedArgs[1:]
+		upsert := command == "upsert" || flags.Has("upsert")
+
+		// Several records are added as one batch so the database is
+		// written once instead of once per record
+		storage.Begin()
+		added, updated := 0, 0
+		var generated []string
+		var addErr error
+		for i, recordData := range records {
+			storage.Report("Adding records", i+1, len(records))
+			key, inserted := "", true
+			if upsert {
+				key, inserted, addErr = storage.UpsertRecord(schema, recordData, flags.Has("force"))
+			} else {
+				key, addErr = storage.AddRecord(schema, recordData)
+			}
+			if addErr != nil {
+				break
+			}
+			if key != "" {
+				generated = append(generated, key)
+			}
+			if inserted {
+				added++
+			} else {
+				updated++
+			}
+		}
+		if err := storage.Flush(); err != nil {
+			printError("Error saving records: %v\n", err)
+			return 1
+		}
+		if addErr != nil {
+			// The records before the failing one are saved all the same
+			if saved := added + updated; saved > 0 {
+				fmt.Printf("Saved %d of %d records\n", saved, len(records))
+				for _, key := range generated {
+					fmt.Printf("Generated key: %s\n", paint(colorBlue, key))
+				}
+			}
+			printError("Error adding record: %v\n", addErr)
+			return 1
+		}
+		switch {
+		case updated == 0 && added == 1:
+			fmt.Println("Record added successfully")
+		case updated == 0:
+			fmt.Printf("%d records added successfully\n", added)
+		case added == 0 && updated == 1:
+			fmt.Println("Record updated successfully")
+		default:
+			fmt.Printf("%d records added and %d updated successfully\n", added, updated)
+		}
+		for _, key := range generated {
+			fmt.Printf("Generated key: %s\n", paint(colorBlue, key))
 		}
-		fmt.Println("Record added successfully")
 
 	case "get", "view":
+		if command == "view" && preprocessing.IsViewAction(parsedArgs[0]) {
+			return runView(storage, parsedArgs)
+		}
 		if len(parsedArgs) < 2 {
 			fmt.Println("Usage: simplebson get <schema> <key>")
-			os.Exit(1)
+			return 1
 		}
 		schema := parsedArgs[0]
 		key := parsedArgs[1]
-		record, err := storage.GetRecord(schema, key)
+		match := keyMatch(flags)
+		record, plan, err := storage.ExplainGet(schema, key, match, fields...)
 		if err != nil {
-			fmt.Printf("Error retrieving record: %v\n", err)
-			os.Exit(1)
+			printError("Error retrieving record: %v\n", err)
+			return 1
+		}
+		if flags.Has("verify") {
+			if err := storage.VerifyRecord(schema, key, match); err != nil {
+				printWarning("Warning: %v\n", err)
+			}
+		}
+		out := recordPrinter(os.Stdout, storage, schema, fields, format, flags)
+		err = out.Write(record)
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			printError("Error printing record: %v\n", err)
+			return 1
+		}
+		if flags.Has("explain") {
+			fmt.Printf("Plan: %s\n", plan)
+		}
+
+	case "exists":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson exists <schema> <key>")
+			return 1
+		}
+		found, err := storage.Exists(parsedArgs[0], parsedArgs[1], keyMatch(flags))
+		if err != nil {
+			printError("Error checking record: %v\n", err)
+			return 1
+		}
+		fmt.Println(found)
+		if !found {
+			return 1
+		}
+
+	case "near":
+		if len(parsedArgs) < 3 || !flags.Has("radius") {
+			fmt.Println("Usage: simplebson near <schema> <lat> <lon> --radius <distance> [--field f]")
+			return 1
+		}
+		lat, errLat := strconv.ParseFloat(parsedArgs[1], 64)
+		lon, errLon := strconv.ParseFloat(parsedArgs[2], 64)
+		if errLat != nil || errLon != nil {
+			printError("Error parsing point: %v\n", memory.Errorf(memory.ErrorValidation, "expected numeric latitude and longitude, got '%s' '%s'", parsedArgs[1], parsedArgs[2]))
+			return 1
+		}
+		radius, err := preprocessing.ParseDistance(flags.Get("radius"))
+		if err != nil {
+			printError("Error parsing flags: %v\n", invalidArgs(err))
+			return 1
+		}
+		matches, err := storage.Near(parsedArgs[0], flags.Get("field"), lat, lon, radius)
+		if err != nil {
+			printError("Error searching records: %v\n", err)
+			return 1
+		}
+		for _, match := range matches {
+			fmt.Printf("%.3fkm: %v\n", match.Distance, match.Record)
+		}
+
+	case "keys":
+		if len(parsedArgs) < 1 {
+			fmt.Println("Usage: simplebson keys <schema> [prefix]")
+			return 1
+		}
+		prefix := ""
+		if len(parsedArgs) > 1 {
+			prefix = parsedArgs[1]
+		}
+		keys, err := storage.Keys(parsedArgs[0], prefix)
+		if err != nil {
+			printError("Error listing keys: %v\n", err)
+			return 1
+		}
+		for _, key := range keys {
+			fmt.Println(paint(colorBlue, key))
 		}
-		fmt.Println(record)
 
 	case "delete":
 		if len(parsedArgs) < 2 {
 			fmt.Println("Usage: simplebson delete <schema> <key>")
-			os.Exit(1)
+			return 1
 		}
 		schema := parsedArgs[0]
 		key := parsedArgs[1]
-		err := storage.DeleteRecord(schema, key)
+		err := storage.DeleteRecord(schema, key, keyMatch(flags))
 		if err != nil {
-			fmt.Printf("Error deleting record: %v\n", err)
-			os.Exit(1)
+			printError("Error deleting record: %v\n", err)
+			return 1
 		}
 		fmt.Println("Record deleted successfully")
 
+	case "update":
+		if len(parsedArgs) < 3 {
+			fmt.Println("Usage: simplebson update <schema> <key> <update_data>")
+			return 1
+		}
+		schema := parsedArgs[0]
+		key := parsedArgs[1]
+		updateData := parsedArgs[2]
+		if err := storage.UpdateRecord(schema, key, updateData, flags.Has("force")); err != nil {
+			printError("Error updating record: %v\n", err)
+			return 1
+		}
+		fmt.Println("Record updated successfully")
+
+	case "update-where":
+		if len(parsedArgs) < 3 {
+			fmt.Println("Usage: simplebson update-where <schema> <filter> <update_data> [--dry-run]")
+			return 1
+		}
+		schema := parsedArgs[0]
+		filter, err := preprocessing.ParseFilter(parsedArgs[1:2])
+		if err != nil {
+			printError("Error parsing filters: %v\n", invalidArgs(err))
+			return 1
+		}
+		updateData := parsedArgs[2]
+		dryRun := flags.Has("dry-run")
+		count, err := storage.UpdateWhere(schema, filter, updateData, dryRun, flags.Has("force"))
+		if err != nil {
+			printError("Error updating records: %v\n", err)
+			return 1
+		}
+		if dryRun {
+			fmt.Printf("%d records would be updated\n", count)
+		} else {
+			fmt.Printf("%d records updated successfully\n", count)
+		}
+
+	case "delete-where":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson delete-where <schema> <filter> [--yes]")
+			return 1
+		}
+		schema := parsedArgs[0]
+		filter, err := preprocessing.ParseFilter(parsedArgs[1:2])
+		if err != nil {
+			printError("Error parsing filters: %v\n", invalidArgs(err))
+			return 1
+		}
+		if !flags.Has("yes") {
+			count, err := storage.DeleteWhere(schema, filter, true)
+			if err != nil {
+				printError("Error deleting records: %v\n", err)
+				return 1
+			}
+			if count > cfg.ConfirmThreshold {
+				printError("Error deleting records: %d records match, pass --yes to delete more than %d\n", count, cfg.ConfirmThreshold)
+				return 1
+			}
+		}
+		count, err := storage.DeleteWhere(schema, filter, false)
+		if err != nil {
+			printError("Error deleting records: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%d records deleted successfully\n", count)
+
+	case "checksum":
+		if len(parsedArgs) < 1 {
+			fmt.Println("Usage: simplebson checksum <schema>")
+			return 1
+		}
+		schema := parsedArgs[0]
+		checked, problems, err := storage.VerifyChecksums(schema)
+		if err != nil {
+			printError("Error verifying checksums: %v\n", err)
+			return 1
+		}
+		if len(problems) == 0 {
+			fmt.Printf("All %d records in schema '%s' match their checksums\n", checked, schema)
+			break
+		}
+		fmt.Printf("Found %d problem(s) in schema '%s':\n", len(problems), schema)
+		for _, problem := range problems {
+			fmt.Printf("  %s: %s\n", problem.Key, problem.Reason)
+		}
+		return 1
+
 	case "list":
 		if len(parsedArgs) < 1 {
 			fmt.Println("Usage: simplebson list <schema>")
-			os.Exit(1)
+			return 1
 		}
 		schema := parsedArgs[0]
-		records, err := storage.ListRecords(schema)
+		page := newPager(flags)
+		out := recordPrinter(page, storage, schema, fields, format, flags)
+		out.reportProgress(storage, listTotal(storage, schema, opts))
+		plan, err := storage.Stream(schema, opts, out.Write)
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			page.Close()
+			printError("Error listing records: %v\n", err)
+			return 1
+		}
+		if flags.Has("explain") {
+			fmt.Fprintf(page, "Plan: %s\n", plan)
+		}
+		if plan.Next != nil {
+			fmt.Fprintf(page, "Next cursor: %s\n", plan.Next.Token())
+		}
+		page.Close()
+
+	case "find":
+		if len(parsedArgs) < 1 {
+			fmt.Println("Usage: simplebson find <schema> [filter...]")
+			return 1
+		}
+		schema := parsedArgs[0]
+		filter, err := preprocessing.ParseFilter(parsedArgs[1:])
+		if err != nil {
+			printError("Error parsing filters: %v\n", invalidArgs(err))
+			return 1
+		}
+		opts.Filter = preprocessing.All(filter, opts.Filter)
+		page := newPager(flags)
+		out := recordPrinter(page, storage, schema, fields, format, flags)
+		out.reportProgress(storage, 0)
+		plan, err := storage.Stream(schema, opts, out.Write)
+		if err == nil {
+			err = out.Flush()
+		}
 		if err != nil {
-			fmt.Printf("Error listing records: %v\n", err)
-			os.Exit(1)
+			page.Close()
+			printError("Error finding records: %v\n", err)
+			return 1
+		}
+		if flags.Has("explain") {
+			fmt.Fprintf(page, "Plan: %s\n", plan)
+		}
+		if plan.Next != nil {
+			fmt.Fprintf(page, "Next cursor: %s\n", plan.Next.Token())
+		}
+		page.Close()
+
+	case "agg":
+		if len(parsedArgs) < 3 {
+			fmt.Println("Usage: simplebson agg <schema> <sum|avg|min|max> <field> [filter...]")
+			return 1
+		}
+		schema := parsedArgs[0]
+		function := strings.ToLower(parsedArgs[1])
+		field := parsedArgs[2]
+		filter, err := preprocessing.ParseFilter(parsedArgs[3:])
+		if err != nil {
+			printError("Error parsing filters: %v\n", invalidArgs(err))
+			return 1
+		}
+		if flags.Has("group-by") {
+			groups, err := storage.AggregateGroups(schema, function, field, flags.Get("group-by"), filter)
+			if err != nil {
+				printError("Error aggregating records: %v\n", err)
+				return 1
+			}
+			for _, group := range groups {
+				fmt.Printf("%s: %s\n", group.Group, group.Result)
+			}
+			break
+		}
+		result, err := storage.Aggregate(schema, function, field, filter)
+		if err != nil {
+			printError("Error aggregating records: %v\n", err)
+			return 1
+		}
+		fmt.Println(result)
+
+	case "top":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson top <schema> <field> [filter...] [--n 10] [--desc]")
+			return 1
+		}
+		schema, field := parsedArgs[0], parsedArgs[1]
+		filter, err := preprocessing.ParseFilter(parsedArgs[2:])
+		if err != nil {
+			printError("Error parsing filters: %v\n", invalidArgs(err))
+			return 1
+		}
+		n := 10
+		if flags.Has("n") {
+			if n, err = flags.Count("n"); err != nil {
+				printError("Error parsing flags: %v\n", invalidArgs(err))
+				return 1
+			}
+		}
+		records, err := storage.Top(schema, field, n, flags.Has("desc"), filter)
+		if err != nil {
+			printError("Error finding top records: %v\n", err)
+			return 1
 		}
 		for _, record := range records {
 			fmt.Println(record)
 		}
 
+	case "pipeline":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson pipeline <schema> '[{\"$match\": ...}, {\"$group\": ...}, ...]'")
+			return 1
+		}
+		stages, err := preprocessing.ParsePipeline(strings.Join(parsedArgs[1:], " "))
+		if err != nil {
+			printError("Error parsing pipeline: %v\n", invalidArgs(err))
+			return 1
+		}
+		records, err := storage.Pipeline(parsedArgs[0], stages)
+		if err != nil {
+			printError("Error running pipeline: %v\n", err)
+			return 1
+		}
+		for _, record := range records {
+			fmt.Println(record)
+		}
+
+	case "distinct":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson distinct <schema> <field> [filter...] [--count]")
+			return 1
+		}
+		schema := parsedArgs[0]
+		field := parsedArgs[1]
+		filter, err := preprocessing.ParseFilter(parsedArgs[2:])
+		if err != nil {
+			printError("Error parsing filters: %v\n", invalidArgs(err))
+			return 1
+		}
+		values, err := storage.Distinct(schema, field, filter)
+		if err != nil {
+			printError("Error listing distinct values: %v\n", err)
+			return 1
+		}
+		for _, value := range values {
+			if flags.Has("count") {
+				fmt.Printf("%s: %d\n", value.Value, value.Count)
+			} else {
+				fmt.Println(value.Value)
+			}
+		}
+
+	case "sql":
+		if len(parsedArgs) < 1 {
+			fmt.Println("Usage: simplebson sql \"SELECT <fields> FROM <schema> [WHERE ...] [ORDER BY ...] [LIMIT n]\"")
+			return 1
+		}
+		query, err := preprocessing.ParseSQL(strings.Join(parsedArgs, " "))
+		if err != nil {
+			printError("Error parsing query: %v\n", invalidArgs(err))
+			return 1
+		}
+		out := recordPrinter(os.Stdout, storage, query.Schema, query.Fields, format, flags)
+		plan, err := storage.Stream(query.Schema, memory.QueryOptions{
+			Filter:         query.Filter,
+			Fields:         query.Fields,
+			SortField:      query.SortField,
+			SortDescending: query.SortDescending,
+			Limit:          query.Limit,
+			Offset:         query.Offset,
+		}, out.Write)
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			printError("Error running query: %v\n", err)
+			return 1
+		}
+		if flags.Has("explain") {
+			fmt.Printf("Plan: %s\n", plan)
+		}
+
+	case "join":
+		if len(parsedArgs) < 2 || !flags.Has("on") {
+			fmt.Println("Usage: simplebson join <left_schema> <right_schema> --on left.field=right.field [--left]")
+			return 1
+		}
+		leftSchema, rightSchema := parsedArgs[0], parsedArgs[1]
+		leftField, rightField, err := preprocessing.ParseJoinOn(flags.Get("on"), leftSchema, rightSchema)
+		if err != nil {
+			printError("Error parsing join condition: %v\n", invalidArgs(err))
+			return 1
+		}
+		records, err := storage.Join(leftSchema, rightSchema, leftField, rightField, flags.Has("left"))
+		if err != nil {
+			printError("Error joining records: %v\n", err)
+			return 1
+		}
+		for _, record := range records {
+			fmt.Println(record)
+		}
+
+	case "search":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson search <schema> <regexp> [--field f]")
+			return 1
+		}
+		schema := parsedArgs[0]
+		pattern := parsedArgs[1]
+		matches, err := storage.Search(schema, pattern, flags.Get("field"))
+		if err != nil {
+			printError("Error searching records: %v\n", err)
+			return 1
+		}
+		for _, match := range matches {
+			fmt.Printf("%s: %v\n", strings.Join(match.Fields, ","), match.Record)
+		}
+
+	case "search-text":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson search-text <schema> <words>")
+			return 1
+		}
+		schema := parsedArgs[0]
+		query := strings.Join(parsedArgs[1:], " ")
+		matches, err := storage.SearchText(schema, query)
+		if err != nil {
+			printError("Error searching records: %v\n", err)
+			return 1
+		}
+		for _, match := range matches {
+			fmt.Printf("%d: %v\n", match.Score, match.Record)
+		}
+
+	case "index":
+		if len(parsedArgs) < 2 {
+			fmt.Println("Usage: simplebson index <create|drop> <schema> <field,...> | index list <schema>")
+			return 1
+		}
+		action := strings.ToLower(parsedArgs[0])
+		schema := parsedArgs[1]
+		switch action {
+		case "create", "drop":
+			if len(parsedArgs) < 3 {
+				fmt.Printf("Usage: simplebson index %s <schema> <field,...>\n", action)
+				return 1
+			}
+			indexFields := preprocessing.ParseFieldList(strings.Join(parsedArgs[2:], ","))
+			if action == "create" {
+				err = storage.CreateIndex(schema, indexFields)
+			} else {
+				err = storage.DropIndex(schema, indexFields)
+			}
+			if err != nil {
+				printError("Error updating index: %v\n", err)
+				return 1
+			}
+			if action == "create" {
+				fmt.Printf("Index (%s) created on schema '%s'\n", strings.Join(indexFields, ","), schema)
+			} else {
+				fmt.Printf("Index (%s) dropped from schema '%s'\n", strings.Join(indexFields, ","), schema)
+			}
+		case "list":
+			indexes, err := storage.ListIndexes(schema)
+			if err != nil {
+				printError("Error listing indexes: %v\n", err)
+				return 1
+			}
+			if len(indexes) == 0 {
+				fmt.Printf("No indexes on schema '%s'\n", schema)
+			}
+			for _, index := range indexes {
+				fmt.Printf("  (%s)\n", strings.Join(index, ","))
+			}
+		}
+
 	case "schema":
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "alter") {
+			return runSchemaAlter(storage, parsedArgs[1:])
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "drop") {
+			schema := parsedArgs[1]
+			if err := storage.DropSchema(schema, flags.Has("with-records")); err != nil {
+				printError("Error dropping schema: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Schema '%s' dropped\n", schema)
+			return 0
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "rename") {
+			if err := storage.RenameSchema(parsedArgs[1], parsedArgs[2]); err != nil {
+				printError("Error renaming schema: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Schema '%s' renamed to '%s'\n", parsedArgs[1], parsedArgs[2])
+			return 0
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "export") {
+			docs, err := storage.ExportSchemas(parsedArgs[1:]...)
+			if err != nil {
+				printError("Error exporting schemas: %v\n", err)
+				return 1
+			}
+			data, err := json.MarshalIndent(docs, "", "  ")
+			if err != nil {
+				printError("Error exporting schemas: %v\n", err)
+				return 1
+			}
+			fmt.Println(string(data))
+			return 0
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "import") {
+			return runSchemaImport(storage, parsedArgs[1])
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "json") {
+			return runSchemaJSON(storage, parsedArgs[1], parsedArgs[2])
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "copy") {
+			schema, target := parsedArgs[1], flags.Get("to")
+			if target == "" {
+				fmt.Println("Usage: simplebson schema copy <schema> --to <database> [--with-records]")
+				return 1
+			}
+			copied, err := storage.CopySchema(schema, target, flags.Has("with-records"))
+			if err != nil {
+				printError("Error copying schema: %v\n", err)
+				return 1
+			}
+			if flags.Has("with-records") {
+				fmt.Printf("Schema '%s' copied to database '%s' with %d record(s)\n", schema, target, copied)
+			} else {
+				fmt.Printf("Schema '%s' copied to database '%s'\n", schema, target)
+			}
+			return 0
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "diff") {
+			return runSchemaDiff(storage, parsedArgs[1], parsedArgs[2])
+		}
+		if len(parsedArgs) > 0 && strings.EqualFold(parsedArgs[0], "infer") {
+			return runSchemaInfer(storage, parsedArgs[1:], flags.Has("apply"))
+		}
 		if len(parsedArgs) < 1 {
 			schemas := storage.ListSchemas()
 			if len(schemas) == 0 {
 				fmt.Println("No schemas defined")
 			} else {
+				archived := make(map[string]bool)
+				for _, schema := range storage.ArchivedSchemas() {
+					archived[schema] = true
+				}
+				views := make(map[string]string)
+				for _, view := range storage.Views() {
+					views[view.Name] = view.Source
+				}
+				parents := storage.Parents()
+
 				fmt.Println("Defined schemas:")
 				for _, schema := range schemas {
-					fmt.Printf("  %s\n", schema)
+					switch {
+					case archived[schema] && views[schema] != "":
+						fmt.Printf("  %s (view of %s, archived)\n", schema, views[schema])
+					case archived[schema]:
+						fmt.Printf("  %s (archived)\n", schema)
+					case views[schema] != "":
+						fmt.Printf("  %s (view of %s)\n", schema, views[schema])
+					case parents[schema] != "":
+						fmt.Printf("  %s (extends %s)\n", schema, parents[schema])
+					default:
+						fmt.Printf("  %s\n", schema)
+					}
 				}
 			}
 		} else if len(parsedArgs) == 1 {
 			schema := parsedArgs[0]
 			schemaDef, err := storage.GetSchema(schema)
 			if err != nil {
-				fmt.Printf("Error getting schema: %v\n", err)
-				os.Exit(1)
+				printError("Error getting schema: %v\n", err)
+				return 1
+			}
+			version, err := storage.SchemaVersion(schema)
+			if err != nil {
+				printError("Error getting schema: %v\n", err)
+				return 1
+			}
+			document, err := storage.JSONSchema(schema)
+			if err != nil {
+				printError("Error getting schema: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Schema '%s' (version %d): %s\n", schema, version, paintDefinition(schemaDef))
+			if document != "" {
+				fmt.Printf("  Defined by JSON Schema: %s\n", document)
 			}
-			fmt.Printf("Schema '%s': %s\n", schema, schemaDef)
+			if parent := storage.Parents()[schema]; parent != "" {
+				fmt.Printf("  Extends: %s\n", parent)
+			}
+			for _, enum := range memory.EnumFields(schemaDef) {
+				fmt.Printf("  %s: one of %s\n", enum.Name, strings.Join(enum.Values, ", "))
+			}
+		} else if len(parsedArgs) >= 3 && strings.EqualFold(parsedArgs[1], "extends") {
+			schema := parsedArgs[0]
+			if err := storage.ExtendSchema(schema, parsedArgs[2], strings.Join(parsedArgs[3:], " ")); err != nil {
+				printError("Error creating schema: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Schema '%s' created successfully\n", schema)
 		} else {
 			schema := parsedArgs[0]
 			fieldsStr := strings.Join(parsedArgs[1:], " ")
 			err := storage.CreateSchema(schema, fieldsStr)
 			if err != nil {
-				fmt.Printf("Error creating schema: %v\n", err)
-				os.Exit(1)
+				printError("Error creating schema: %v\n", err)
+				return 1
 			}
 			fmt.Printf("Schema '%s' created successfully\n", schema)
 		}
 
+	case "archive":
+		if len(parsedArgs) < 1 {
+			fmt.Println("Usage: simplebson archive <schema>")
+			return 1
+		}
+		schema := parsedArgs[0]
+		if err := storage.ArchiveSchema(schema); err != nil {
+			printError("Error archiving schema: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Schema '%s' archived to cold storage\n", schema)
+
+	case "stats":
+		stats, err := storage.Stats(parsedArgs...)
+		if err != nil {
+			printError("Error getting stats: %v\n", err)
+			return 1
+		}
+		if len(stats) == 0 {
+			fmt.Println("No schemas defined")
+		}
+		for _, stat := range stats {
+			printStats(stat)
+		}
+
 	case "use":
 		if len(parsedArgs) < 1 {
 			fmt.Println("Usage: simplebson use <database_name>")
-			os.Exit(1)
+			return 1
+		}
+		dbName, err := preprocessing.ParseDBName(parsedArgs[0])
+		if err != nil {
+			printError("Error switching database: %v\n", err)
+			return 1
 		}
-		dbName := parsedArgs[0]
 		storage.UseDB(dbName)
+		if err := cfg.SaveSelectedDB(dbName); err != nil {
+			printError("Error saving database selection: %v\n", err)
+			return 1
+		}
 		fmt.Printf("Switched to database '%s'\n", dbName)
 
 	case "dbs":
 		dbs, err := storage.ListDBs()
 		if err != nil {
-			fmt.Printf("Error listing databases: %v\n", err)
-			os.Exit(1)
+			printError("Error listing databases: %v\n", err)
+			return 1
 		}
 		if len(dbs) == 0 {
 			fmt.Println("No databases found")
 		} else {
 			fmt.Println("Available databases:")
+			current := storage.CurrentDB()
 			for _, db := range dbs {
-				fmt.Printf("  %s\n", db)
+				if db == current {
+					fmt.Printf("  %s (in use)\n", db)
+				} else {
+					fmt.Printf("  %s\n", db)
+				}
 			}
 		}
 
-	case "wipe", "drop":
-		err := storage.WipeDatabase()
-		if err != nil {
-			fmt.Printf("Error wiping database: %v\n", err)
-			os.Exit(1)
+	case "shell":
+		return runShell(cfg, storage, os.Stdin, isTerminal(os.Stdin))
+
+	case "run":
+		if len(parsedArgs) < 1 {
+			fmt.Println("Usage: simplebson run <script|-> [--keep-going]")
+			return 1
+		}
+		return runScript(cfg, storage, parsedArgs[0], flags.Has("keep-going"))
+
+	case "flush":
+		if err := storage.Flush(); err != nil {
+			printError("Error flushing database: %v\n", err)
+			return 1
+		}
+		fmt.Println("Database flushed successfully")
+
+	case "reindex":
+		if err := storage.Reindex(parsedArgs...); err != nil {
+			printError("Error rebuilding indexes: %v\n", err)
+			return 1
+		}
+		fmt.Println("Indexes rebuilt successfully")
+
+	case "compact":
+		if err := storage.Compact(parsedArgs...); err != nil {
+			printError("Error compacting database: %v\n", err)
+			return 1
 		}
-		fmt.Println("Database wiped successfully")
+		fmt.Println("Database compacted successfully")
+
+	case "wipe", "drop":
+		return runWipe(storage, parsedArgs, flags.Has("force") || flags.Has("yes"))
+
+	case "help":
+		return runHelp(parsedArgs)
+
+	case "completion":
+		return runCompletion(parsedArgs)
+
+	case "watch":
+		return runWatch(cfg, storage, parsedArgs[0], flags)
 
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		printUsage()
-		os.Exit(1)
+		return 1
+	}
+
+	return 0
+}
+
+// listTotal returns the number of records list prints when it prints every
+// record of a schema, and zero when a page or time window makes that
+// unknown
+func listTotal(storage *memory.Storage, schema string, opts memory.QueryOptions) int {
+	if opts.Filter != nil || opts.Limit > 0 || opts.Offset > 0 || opts.After != nil {
+		return 0
+	}
+	stats, err := storage.Stats(schema)
+	if err != nil || len(stats) == 0 {
+		return 0
+	}
+	return stats[0].LiveRecords
+}
+
+// keyMatch returns how get and delete match their key, as set by the
+// --prefix, --fuzzy and --ignore-case flags
+func keyMatch(flags preprocessing.Flags) memory.KeyMatch {
+	match := memory.MatchExact
+	if flags.Has("prefix") {
+		match |= memory.MatchPrefix
+	}
+	if flags.Has("fuzzy") {
+		match |= memory.MatchFuzzy
+	}
+	if flags.Has("ignore-case") {
+		match |= memory.MatchIgnoreCase
+	}
+	return match
+}
+
+// runWipe clears the database in use, or the one named, after listing
+// the schemas and records it holds. Unless force is set the wipe must be
+// confirmed at the terminal; without one it is refused.
+func runWipe(storage *memory.Storage, args []string, force bool) int {
+	if len(args) > 0 {
+		dbName, err := preprocessing.ParseDBName(args[0])
+		if err != nil {
+			printError("Error wiping database: %v\n", err)
+			return 1
+		}
+		dbs, err := storage.ListDBs()
+		if err != nil {
+			printError("Error wiping database: %v\n", err)
+			return 1
+		}
+		found := false
+		for _, db := range dbs {
+			found = found || db == dbName
+		}
+		if !found {
+			printError("Error wiping database: %v\n", memory.Errorf(memory.ErrorNotFound, "database '%s' does not exist", dbName))
+			return 1
+		}
+		if previous := storage.CurrentDB(); previous != dbName {
+			storage.UseDB(dbName)
+			defer storage.UseDB(previous)
+		}
+	}
+
+	dbName := storage.CurrentDB()
+	stats, err := storage.Stats()
+	if err != nil {
+		printError("Error wiping database: %v\n", err)
+		return 1
+	}
+	if len(stats) == 0 {
+		fmt.Printf("Database '%s' is already empty\n", dbName)
+		return 0
+	}
+
+	records := 0
+	for _, stat := range stats {
+		records += stat.LiveRecords
+	}
+	fmt.Printf("Database '%s' holds %d schema(s) and %d record(s):\n", dbName, len(stats), records)
+	for _, stat := range stats {
+		if !stat.Loaded {
+			fmt.Printf("  %s (archived)\n", stat.Schema)
+		} else {
+			fmt.Printf("  %s: %d record(s)\n", stat.Schema, stat.LiveRecords)
+		}
+	}
+
+	if !force {
+		if !isTerminal(os.Stdin) || jsonOutput {
+			printError("Error wiping database: pass --force to wipe without confirmation\n")
+			return 1
+		}
+		fmt.Printf("Wipe database '%s'? This cannot be undone [y/N]: ", dbName)
+		if answer := strings.ToLower(readAnswer()); answer != "y" && answer != "yes" {
+			fmt.Println("Wipe cancelled")
+			return 1
+		}
+	}
+
+	if err := storage.WipeDatabase(); err != nil {
+		printError("Error wiping database: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Database '%s' wiped successfully\n", dbName)
+	return 0
+}
+
+// readAnswer reads a line typed at the terminal, one byte at a time so
+// nothing after it is taken from standard input
+func readAnswer() string {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 0 || err != nil || buf[0] == '\n' {
+			return strings.TrimSpace(string(line))
+		}
+		line = append(line, buf[0])
 	}
 }
 
-func printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  simplebson schema <schema_name> <field_definitions>  - Create or view schema")
-	fmt.Println("  simplebson add <schema> <record_data>               - Add a record")
-	fmt.Println("  simplebson get <schema> <key>                      - Get a record")
-	fmt.Println("  simplebson view <schema> <key>                     - View a record")
-	fmt.Println("  simplebson delete <schema> <key>                   - Delete a record")
-	fmt.Println("  simplebson list <schema>                           - List all records of a schema")
-	fmt.Println("  simplebson use <database_name>                     - Switch to a different database")
-	fmt.Println("  simplebson dbs                                     - List all available databases")
-	fmt.Println("  simplebson wipe/drop                                - Wipe entire database")
-	fmt.Println("")
-	fmt.Println("Examples:")
-	fmt.Println("  simplebson schema User name:string age:int email:string")
-	fmt.Println("  simplebson add User '{\"name\":\"Alice\", \"age\":30, \"email\":\"alice@example.com\"}'")
-	fmt.Println("  simplebson get User Alice")
-	fmt.Println("  simplebson list User")
-	fmt.Println("  simplebson delete User Alice")
-	fmt.Println("  simplebson use my_database")
-	fmt.Println("  simplebson dbs")
-	fmt.Println("  simplebson wipe")
+// runView creates, drops or lists materialized views
+func runView(storage *memory.Storage, args []string) int {
+	switch strings.ToLower(args[0]) {
+	case "create":
+		if err := storage.CreateView(args[1], strings.Join(args[2:], " ")); err != nil {
+			printError("Error creating view: %v\n", err)
+			return 1
+		}
+		fmt.Printf("View '%s' created successfully\n", args[1])
+	case "drop":
+		if err := storage.DropView(args[1]); err != nil {
+			printError("Error dropping view: %v\n", err)
+			return 1
+		}
+		fmt.Printf("View '%s' dropped\n", args[1])
+	case "list":
+		views := storage.Views()
+		if len(views) == 0 {
+			fmt.Println("No views defined")
+		}
+		for _, view := range views {
+			fmt.Printf("  %s: %s\n", view.Name, view.Query)
+		}
+	}
+	return 0
+}
+
+// runSchemaAlter runs schema alter <schema> add-field, rename-field or
+// drop-field
+func runSchemaAlter(storage *memory.Storage, args []string) int {
+	schema := args[0]
+	var err error
+	switch strings.ToLower(args[1]) {
+	case "add-field":
+		err = storage.AddField(schema, args[2])
+	case "rename-field":
+		err = storage.RenameField(schema, args[2], args[3])
+	case "drop-field":
+		err = storage.DropField(schema, args[2])
+	}
+	if err != nil {
+		printError("Error altering schema: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Schema '%s' altered successfully\n", schema)
+	return 0
+}
+
+// readInput reads a file, or standard input when the file is -
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// recordArgs returns the arguments of a command writing records with the
+// record data written as - replaced by the records on standard input, and
+// the records in the file of --file added. add and upsert take any number
+// of records from either; update and update-where take a single object as
+// their update data.
+func recordArgs(command string, args []string, flags preprocessing.Flags) ([]string, error) {
+	first := 0
+	switch command {
+	case "add", "upsert":
+		first = 1
+	case "update", "update-where":
+		first = 2
+	default:
+		return args, nil
+	}
+
+	var expanded []string
+	for i, arg := range args {
+		if i < first || arg != "-" {
+			expanded = append(expanded, arg)
+			continue
+		}
+		records, err := readRecords("-")
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, records...)
+	}
+	if flags.Has("file") {
+		records, err := readRecords(flags.Get("file"))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, records...)
+	}
+
+	if first == 2 && len(expanded) > 3 {
+		return nil, fmt.Errorf("%s takes a single JSON object as its update data, got %d", command, len(expanded)-2)
+	}
+	return expanded, nil
+}
+
+// readRecords reads the JSON records in a file, or on standard input when
+// the file is -. Records may follow each other, one per line or not, and
+// the elements of an array are taken as records of their own.
+func readRecords(path string) ([]string, error) {
+	source := path
+	if path == "-" {
+		source = "standard input"
+	}
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []string
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid JSON in %s: %v", source, err)
+		}
+		var elements []json.RawMessage
+		if err := json.Unmarshal(value, &elements); err != nil {
+			records = append(records, string(value))
+			continue
+		}
+		for _, element := range elements {
+			records = append(records, string(element))
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no records in %s", source)
+	}
+	return records, nil
+}
+
+// runSchemaJSON defines a schema by the JSON Schema document in a file, or
+// on standard input when the file is -
+func runSchemaJSON(storage *memory.Storage, schema, path string) int {
+	data, err := readInput(path)
+	if err != nil {
+		printError("Error reading JSON Schema: %v\n", err)
+		return 1
+	}
+	if err := storage.DefineJSONSchema(schema, data); err != nil {
+		printError("Error creating schema: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Schema '%s' created successfully from JSON Schema\n", schema)
+	return 0
+}
+
+// runSchemaInfer proposes a definition for a schema from the sample records
+// in a file, or on standard input when the file is -, or from the records
+// it already holds when no file is given, and creates it with apply set
+func runSchemaInfer(storage *memory.Storage, args []string, apply bool) int {
+	schema := args[0]
+	var inference memory.Inference
+	if len(args) > 1 {
+		data, err := readInput(args[1])
+		if err != nil {
+			printError("Error reading samples: %v\n", err)
+			return 1
+		}
+		samples, err := memory.ParseSamples(bytes.NewReader(data))
+		if err != nil {
+			printError("Error inferring schema: %v\n", err)
+			return 1
+		}
+		inference = memory.InferSchema(samples)
+	} else {
+		var err error
+		if inference, err = storage.InferSchema(schema); err != nil {
+			printError("Error inferring schema: %v\n", err)
+			return 1
+		}
+	}
+
+	if inference.Definition == "" {
+		printError("Error inferring schema: no field of the %d record(s) has a single type\n", inference.Samples)
+		return 1
+	}
+	fmt.Printf("Inferred from %d record(s):\n", inference.Samples)
+	fmt.Printf("simplebson schema %s %s\n", schema, inference.Definition)
+	if len(inference.Skipped) > 0 {
+		fmt.Printf("Left out, as their values have different types or their names cannot be declared: %s\n", strings.Join(inference.Skipped, ", "))
+	}
+	if !apply {
+		return 0
+	}
+
+	if err := storage.CreateSchema(schema, inference.Definition); err != nil {
+		printError("Error creating schema: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Schema '%s' created successfully\n", schema)
+	return 0
+}
+
+// runSchemaDiff prints how a schema would change to match its definition
+// in another database, or in a file written by schema export. It returns 1
+// when the definitions differ, like diff.
+func runSchemaDiff(storage *memory.Storage, schema, other string) int {
+	schemaDef, err := storage.GetSchema(schema)
+	if err != nil {
+		printError("Error comparing schema: %v\n", err)
+		return 1
+	}
+
+	var otherDef, source string
+	if info, statErr := os.Stat(other); other == "-" || statErr == nil && !info.IsDir() {
+		source = fmt.Sprintf("file '%s'", other)
+		if other == "-" {
+			source = "standard input"
+		}
+		otherDef, err = exportedDefinition(schema, other)
+	} else {
+		source = fmt.Sprintf("database '%s'", other)
+		otherDef, err = storage.DBSchema(other, schema)
+	}
+	if err != nil {
+		printError("Error comparing schema: %v\n", err)
+		return 1
+	}
+
+	diff := memory.DiffSchemas(schemaDef, otherDef)
+	if diff.Empty() {
+		fmt.Printf("Schema '%s' is the same in %s\n", schema, source)
+		return 0
+	}
+	fmt.Printf("Schema '%s' compared with %s:\n", schema, source)
+	for _, change := range diff.Fields {
+		switch change.Kind {
+		case "added":
+			fmt.Printf("  + %s:%s\n", change.Field, change.To)
+		case "removed":
+			fmt.Printf("  - %s:%s\n", change.Field, change.From)
+		default:
+			fmt.Printf("  ~ %s: %s -> %s (%s)\n", change.Field, change.From, change.To, change.Kind)
+		}
+	}
+	if diff.FromStrict != diff.ToStrict {
+		fmt.Printf("  ~ strict: %t -> %t\n", diff.FromStrict, diff.ToStrict)
+	}
+	if diff.FromIgnoreCase != diff.ToIgnoreCase {
+		fmt.Printf("  ~ ignorecase: %t -> %t\n", diff.FromIgnoreCase, diff.ToIgnoreCase)
+	}
+	return 1
+}
+
+// exportedDefinition returns the definition of a schema in a file written
+// by schema export, or on standard input when the file is -
+func exportedDefinition(schema, path string) (string, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return "", err
+	}
+	docs, err := memory.ParseSchemaDocuments(data)
+	if err != nil {
+		return "", err
+	}
+	for _, doc := range docs {
+		if doc.Name == schema {
+			return doc.SchemaDefinition()
+		}
+	}
+	return "", fmt.Errorf("schema '%s' is not in file '%s'", schema, path)
+}
+
+// runSchemaImport applies the schema documents in a file, or on standard
+// input when the file is -
+func runSchemaImport(storage *memory.Storage, path string) int {
+	data, err := readInput(path)
+	if err != nil {
+		printError("Error reading schemas: %v\n", err)
+		return 1
+	}
+
+	docs, err := memory.ParseSchemaDocuments(data)
+	if err != nil {
+		printError("Error importing schemas: %v\n", err)
+		return 1
+	}
+	imported, err := storage.ImportSchemas(docs)
+	if err != nil {
+		printError("Error importing schemas: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%d schema(s) imported\n", imported)
+	return 0
+}
+
+// printStats prints the storage engine statistics of one schema
+func printStats(stat memory.SchemaStats) {
+	fmt.Printf("Schema '%s':\n", stat.Schema)
+	if !stat.Loaded {
+		fmt.Println("  Archived in cold storage (not loaded)")
+		return
+	}
+
+	m := stat.Metrics
+	fmt.Printf("  Records:             %d live, %d dead\n", stat.LiveRecords, stat.DeadEntries)
+	fmt.Printf("  SSTables:            %d across %d level(s)\n", m.SSTables, m.Levels)
+	fmt.Printf("  Reads:               %d (%d MemTable hits, %.2f SSTable probes per read)\n", m.Reads, m.MemTableHits, m.ProbesPerRead())
+	fmt.Printf("  Writes:              %d (%d bytes)\n", m.Writes, m.BytesWritten)
+	fmt.Printf("  Flushes:             %d (%d bytes)\n", m.Flushes, m.FlushBytes)
+	fmt.Printf("  Compactions:         %d (%d bytes rewritten)\n", m.Compactions, m.CompactionBytes)
+	fmt.Printf("  Write amplification: %.2fx\n", m.WriteAmplification())
 }
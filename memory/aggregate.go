@@ -0,0 +1,242 @@
+package memory
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"simplebson/preprocessing"
+)
+
+// aggregateFunctions lists the functions Aggregate supports
+var aggregateFunctions = map[string]bool{
+	"sum": true,
+	"avg": true,
+	"min": true,
+	"max": true,
+}
+
+// AggregateResult is the outcome of an aggregate function over one field
+type AggregateResult struct {
+	Function string
+	Value    float64
+	Count    int      // Number of values that were aggregated
+	Integer  bool     // The field is declared int, so sums, minimums and maximums are whole
+	Decimal  *big.Rat // Exact result over a decimal field, nil for other fields
+	Scale    int      // Digits after the decimal point of the most precise decimal value
+}
+
+// String formats the result as an int or float depending on the field type,
+// or for decimal fields with as many digits after the decimal point as the
+// most precise value aggregated. Aggregates other than sum over no values
+// are null.
+func (r AggregateResult) String() string {
+	if r.Count == 0 && r.Function != "sum" {
+		return "null"
+	}
+	if r.Decimal != nil {
+		return r.Decimal.FloatString(r.Scale)
+	}
+	if r.Integer && r.Function != "avg" {
+		return strconv.FormatInt(int64(r.Value), 10)
+	}
+	return strconv.FormatFloat(r.Value, 'f', -1, 64)
+}
+
+// AggregateGroup is the result of an aggregate over the records sharing one
+// value of the group-by field
+type AggregateGroup struct {
+	Group  string // Value of the group-by field, "null" for records without one
+	Result AggregateResult
+}
+
+// Aggregate computes sum, avg, min or max over a numeric field of the
+// records matching the filter. The field must be declared int, float or
+// decimal in the schema; decimal fields are aggregated exactly. Records
+// without a value for the field are skipped.
+func (s *Storage) Aggregate(schemaName, function, field string, filter preprocessing.Filter) (AggregateResult, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return AggregateResult{}, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !aggregateFunctions[function] {
+		return AggregateResult{}, fmt.Errorf("unknown aggregate function '%s', expected sum, avg, min or max", function)
+	}
+
+	fieldType, err := s.numericFieldType(schemaName, field)
+	if err != nil {
+		return AggregateResult{}, err
+	}
+
+	matches, err := s.matchRecords(schemaName, filter)
+	if err != nil {
+		return AggregateResult{}, err
+	}
+
+	return aggregate(matches, function, field, fieldType)
+}
+
+// AggregateGroups computes an aggregate like Aggregate separately for each
+// value of the groupBy field, returning one result per group ordered by the
+// group value
+func (s *Storage) AggregateGroups(schemaName, function, field, groupBy string, filter preprocessing.Filter) ([]AggregateGroup, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !aggregateFunctions[function] {
+		return nil, fmt.Errorf("unknown aggregate function '%s', expected sum, avg, min or max", function)
+	}
+
+	fieldType, err := s.numericFieldType(schemaName, field)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := s.matchRecords(schemaName, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	groups, grouped := groupMatches(matches, groupBy, parseSchemaFields(schemaDef)[groupBy])
+
+	results := make([]AggregateGroup, 0, len(groups))
+	for _, group := range groups {
+		result, err := aggregate(grouped[group], function, field, fieldType)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, AggregateGroup{Group: group, Result: result})
+	}
+
+	return results, nil
+}
+
+// numericFieldType returns the declared type of a field, which must be int,
+// float or decimal. A dotted path into a field typed with a schema has the
+// type the schema declares, and one into an object field is taken as float.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) numericFieldType(schemaName, field string) (string, error) {
+	schemaDef, exists := s.getDBState(s.currentDB).schemas[schemaName]
+	if !exists {
+		return "", Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+
+	types := parseSchemaFields(schemaDef)
+	fieldType, declared := types[field]
+	if !declared {
+		// A path into an object field has no declared type of its own; its
+		// values are checked to be numbers as they are aggregated
+		if root, path, nested := strings.Cut(field, "."); nested {
+			if _, isSchema := s.getDBState(s.currentDB).schemas[types[root]]; isSchema {
+				return s.numericFieldType(types[root], path)
+			}
+			if isObjectType(types[root]) {
+				return "float", nil
+			}
+		}
+		return "", fmt.Errorf("field '%s' is not defined in schema '%s'", field, schemaName)
+	}
+	if !isNumericType(fieldType) {
+		return "", fmt.Errorf("field '%s' has type %s, expected int, float or decimal", field, fieldType)
+	}
+	return fieldType, nil
+}
+
+// aggregate applies an aggregate function to a field of the matches
+func aggregate(matches []queryMatch, function, field, fieldType string) (AggregateResult, error) {
+	if fieldType == "decimal" {
+		return aggregateDecimal(matches, function, field)
+	}
+
+	result := AggregateResult{
+		Function: function,
+		Integer:  fieldType == "int" || fieldType == "integer" || fieldType == "serial",
+	}
+
+	for _, match := range matches {
+		value, exists := preprocessing.LookupField(match.fields, field)
+		if !exists || value == nil {
+			continue
+		}
+		number, ok := toNumber(value, true)
+		if !ok {
+			return AggregateResult{}, fmt.Errorf("record '%s' has non-numeric value %v in field '%s'", match.key, value, field)
+		}
+
+		switch {
+		case result.Count == 0 && (function == "min" || function == "max"):
+			result.Value = number
+		case function == "min":
+			if number < result.Value {
+				result.Value = number
+			}
+		case function == "max":
+			if number > result.Value {
+				result.Value = number
+			}
+		default:
+			result.Value += number
+		}
+		result.Count++
+	}
+
+	if function == "avg" && result.Count > 0 {
+		result.Value /= float64(result.Count)
+	}
+
+	return result, nil
+}
+
+// aggregateDecimal applies an aggregate function to a decimal field of the
+// matches without rounding. An average is rounded to the digits the values
+// are written with.
+func aggregateDecimal(matches []queryMatch, function, field string) (AggregateResult, error) {
+	result := AggregateResult{Function: function, Decimal: new(big.Rat)}
+
+	for _, match := range matches {
+		value, exists := preprocessing.LookupField(match.fields, field)
+		if !exists || value == nil {
+			continue
+		}
+		text, ok := canonicalDecimal(value)
+		if !ok {
+			return AggregateResult{}, fmt.Errorf("record '%s' has non-decimal value %v in field '%s'", match.key, value, field)
+		}
+		number, _ := preprocessing.ParseDecimal(text)
+		if scale := preprocessing.DecimalScale(text); scale > result.Scale {
+			result.Scale = scale
+		}
+
+		switch {
+		case result.Count == 0 && (function == "min" || function == "max"):
+			result.Decimal = number
+		case function == "min":
+			if number.Cmp(result.Decimal) < 0 {
+				result.Decimal = number
+			}
+		case function == "max":
+			if number.Cmp(result.Decimal) > 0 {
+				result.Decimal = number
+			}
+		default:
+			result.Decimal.Add(result.Decimal, number)
+		}
+		result.Count++
+	}
+
+	if function == "avg" && result.Count > 0 {
+		result.Decimal.Quo(result.Decimal, new(big.Rat).SetInt64(int64(result.Count)))
+	}
+	result.Value, _ = result.Decimal.Float64()
+
+	return result, nil
+}
@@ -0,0 +1,368 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"simplebson/preprocessing"
+)
+
+// AddField adds a field definition, such as phone:string or
+// active:bool=true, to a schema. Existing records without the field get
+// its default value.
+func (s *Storage) AddField(schemaName, fieldDef string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	defs := parseFieldDefs(fieldDef)
+	if len(defs) != 1 || len(strings.Fields(fieldDef)) != 1 {
+		return fmt.Errorf("expected a single field definition, got '%s'", fieldDef)
+	}
+	field := defs[0].name
+
+	schemaDef, err := s.alterableSchema(schemaName)
+	if err != nil {
+		return err
+	}
+	if hasField(schemaDef, field) {
+		return fmt.Errorf("schema '%s' already has a field '%s'", schemaName, field)
+	}
+
+	return s.alterSchema(schemaName, schemaDef+" "+fieldDef, func(key string, record map[string]interface{}) error {
+		_, err := applyDefaults(fieldDef, record)
+		return err
+	})
+}
+
+// RenameField renames a field of a schema along with the values existing
+// records hold for it
+func (s *Storage) RenameField(schemaName, oldName, newName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schemaDef, err := s.alterableSchema(schemaName)
+	if err != nil {
+		return err
+	}
+	if !hasField(schemaDef, oldName) {
+		return fmt.Errorf("schema '%s' has no field '%s'", schemaName, oldName)
+	}
+	if newName == "" || strings.ContainsAny(newName, ":=! \t") {
+		return fmt.Errorf("invalid field name '%s'", newName)
+	}
+	if hasField(schemaDef, newName) {
+		return fmt.Errorf("schema '%s' already has a field '%s'", schemaName, newName)
+	}
+
+	parts := strings.Fields(schemaDef)
+	for i, part := range parts {
+		if name, rest, _ := strings.Cut(part, ":"); name == oldName {
+			parts[i] = newName + ":" + rest
+		}
+	}
+
+	if view, used := s.viewFilterUsing(schemaName, oldName); used {
+		return fmt.Errorf("field '%s' is used by the filter of view '%s'", oldName, view)
+	}
+
+	return s.alterSchema(schemaName, strings.Join(parts, " "), func(key string, record map[string]interface{}) error {
+		value, exists := record[oldName]
+		if !exists {
+			return nil
+		}
+		if _, taken := record[newName]; taken {
+			return fmt.Errorf("field '%s' is already set", newName)
+		}
+		record[newName] = value
+		delete(record, oldName)
+		return nil
+	}, renameIndexField(oldName, newName))
+}
+
+// DropField removes a field from a schema and the values existing records
+// hold for it. The key field of a schema cannot be dropped.
+func (s *Storage) DropField(schemaName, field string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schemaDef, err := s.alterableSchema(schemaName)
+	if err != nil {
+		return err
+	}
+	if !hasField(schemaDef, field) {
+		return fmt.Errorf("schema '%s' has no field '%s'", schemaName, field)
+	}
+	if keyField(schemaDef) == field {
+		return fmt.Errorf("field '%s' is the key of schema '%s' and cannot be dropped", field, schemaName)
+	}
+
+	if view, used := s.viewFilterUsing(schemaName, field); used {
+		return fmt.Errorf("field '%s' is used by the filter of view '%s'", field, view)
+	}
+
+	var parts []string
+	for _, part := range strings.Fields(schemaDef) {
+		if name, _, _ := strings.Cut(part, ":"); name != field {
+			parts = append(parts, part)
+		}
+	}
+
+	return s.alterSchema(schemaName, strings.Join(parts, " "), func(key string, record map[string]interface{}) error {
+		delete(record, field)
+		return nil
+	}, dropIndexField(field))
+}
+
+// hasField reports whether a schema definition declares a field
+func hasField(schemaDef, field string) bool {
+	for _, def := range parseFieldDefs(schemaDef) {
+		if def.name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// renameIndexField returns a rewrite of compound index definitions that
+// renames a field in them
+func renameIndexField(oldName, newName string) func([]string) []string {
+	return func(indexDefs []string) []string {
+		renamed := make([]string, 0, len(indexDefs))
+		for _, name := range indexDefs {
+			fields := strings.Split(name, ",")
+			for i, field := range fields {
+				if field == oldName {
+					fields[i] = newName
+				}
+			}
+			renamed = append(renamed, indexName(fields))
+		}
+		return renamed
+	}
+}
+
+// dropIndexField returns a rewrite of compound index definitions that
+// drops the indexes covering a field
+func dropIndexField(field string) func([]string) []string {
+	return func(indexDefs []string) []string {
+		var kept []string
+		for _, name := range indexDefs {
+			covers := false
+			for _, indexed := range strings.Split(name, ",") {
+				covers = covers || indexed == field
+			}
+			if !covers {
+				kept = append(kept, name)
+			}
+		}
+		return kept
+	}
+}
+
+// alterableSchema returns the definition of a schema that can be altered:
+// it exists, is not a view or defined by a JSON Schema document, neither
+// extends nor is extended by another schema, and is not embedded in
+// another schema, whose records would no longer match it
+// NOTE: This function should be called from within a locked context
+func (s *Storage) alterableSchema(schemaName string) (string, error) {
+	schemaDef, exists := s.getDBState(s.currentDB).schemas[schemaName]
+	if !exists {
+		return "", Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if err := s.checkWritable(schemaName); err != nil {
+		return "", err
+	}
+	if _, defined := s.getDBState(s.currentDB).documents[schemaName]; defined {
+		return "", fmt.Errorf("schema '%s' is defined by a JSON Schema document, attach a changed document instead", schemaName)
+	}
+	if parent, _ := parseExtends(s.getDBState(s.currentDB).extends[schemaName]); parent != "" {
+		return "", fmt.Errorf("schema '%s' extends '%s', redefine it with schema %s extends %s instead", schemaName, parent, schemaName, parent)
+	}
+	if children := s.childSchemas(schemaName); len(children) > 0 {
+		return "", fmt.Errorf("schema '%s' is extended by '%s', redefine it with schema instead so the schemas extending it follow", schemaName, children[0])
+	}
+	if other, field, embedded := s.embeddingField(schemaName); embedded {
+		return "", fmt.Errorf("schema '%s' is embedded in field '%s' of schema '%s' and cannot be altered", schemaName, field, other)
+	}
+	return schemaDef, nil
+}
+
+// recordChange is a record rewritten by a schema change, with the data it
+// held before
+type recordChange struct {
+	key      string
+	previous string
+	data     string
+}
+
+// alterSchema gives an alterable schema a new definition and rewrites its
+// records with the given function in one operation. Every rewritten record
+// is validated against the new definition before any is stored, and the
+// schema and its records are restored when the stored records break a
+// unique or key constraint. The schema moves to its next version. The
+// optional index rewrite is applied to the
+// compound index definitions of the schema.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) alterSchema(schemaName, schemaDef string, rewrite func(key string, record map[string]interface{}) error, rewriteIndexes ...func([]string) []string) error {
+	dbState := s.getDBState(s.currentDB)
+
+	previousDef := dbState.schemas[schemaName]
+	if err := validateSchemaDef(schemaDef, dbState.schemas); err != nil {
+		return err
+	}
+	if err := s.ensureLoaded(schemaName); err != nil {
+		return err
+	}
+
+	previousIndexes := dbState.indexDefs[schemaName]
+	previousVersion := dbState.versions[schemaName]
+	restore := func() {
+		dbState.schemas[schemaName] = previousDef
+		dbState.versions[schemaName] = previousVersion
+		if previousIndexes != nil {
+			dbState.indexDefs[schemaName] = previousIndexes
+		} else {
+			delete(dbState.indexDefs, schemaName)
+		}
+	}
+
+	dbState.schemas[schemaName] = schemaDef
+	dbState.versions[schemaName] = s.schemaVersion(schemaName) + 1
+	for _, rewriteIndex := range rewriteIndexes {
+		if indexes := rewriteIndex(append([]string(nil), previousIndexes...)); len(indexes) > 0 {
+			dbState.indexDefs[schemaName] = indexes
+		} else {
+			delete(dbState.indexDefs, schemaName)
+		}
+	}
+
+	var changes []recordChange
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		previous, ok := it.Value().(string)
+		if !ok {
+			continue
+		}
+		record, err := decodeRecord(previous)
+		if err != nil {
+			continue
+		}
+
+		err = rewrite(it.Key(), record)
+		if err == nil {
+			normalizeFields(schemaDef, record, dbState.schemas)
+			err = validateObject(record, schemaDef, dbState.schemas)
+		}
+		if err == nil {
+			err = s.checkRefs(schemaName, record)
+		}
+		if err != nil {
+			restore()
+			return fmt.Errorf("record '%s': %v", it.Key(), err)
+		}
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			restore()
+			return fmt.Errorf("failed to serialize record '%s': %v", it.Key(), err)
+		}
+		if string(data) != previous {
+			changes = append(changes, recordChange{key: it.Key(), previous: previous, data: string(data)})
+		}
+	}
+
+	// Records the change rewrites are written under the new version, the
+	// others keep the version they were written under
+	restoreVersions := s.saveRecordVersions(schemaName)
+	for _, change := range changes {
+		s.writeRecord(schemaName, change.key, change.data)
+	}
+	s.alterViews(schemaName, schemaDef)
+
+	// The rewritten records must satisfy unique and key constraints
+	err := s.indexSchema(schemaName)
+	if err == nil {
+		err = s.checkKeys(schemaName)
+	}
+	if err != nil {
+		restore()
+		for _, change := range changes {
+			s.putRecord(schemaName, change.key, change.previous)
+		}
+		restoreVersions()
+		s.alterViews(schemaName, previousDef)
+		s.indexSchema(schemaName)
+		return err
+	}
+
+	return s.saveToPersistent()
+}
+
+// alterViews gives the views following a schema, directly or through
+// other views, its new definition and version
+// NOTE: This function should be called from within a locked context
+func (s *Storage) alterViews(schemaName, schemaDef string) {
+	dbState := s.getDBState(s.currentDB)
+	for name, view := range dbState.views {
+		if view.source != schemaName {
+			continue
+		}
+		dbState.schemas[name] = schemaDef
+		dbState.versions[name] = dbState.versions[schemaName]
+		s.indexSchema(name)
+		s.alterViews(name, schemaDef)
+	}
+}
+
+// viewFilterUsing returns a view following a schema, directly or through
+// other views, whose filter looks at the given field
+// NOTE: This function should be called from within a locked context
+func (s *Storage) viewFilterUsing(schemaName, field string) (string, bool) {
+	dbState := s.getDBState(s.currentDB)
+	for name, view := range dbState.views {
+		if view.source != schemaName {
+			continue
+		}
+		if view.filter != nil && preprocessing.FilterUses(view.filter, field) {
+			return name, true
+		}
+		if other, used := s.viewFilterUsing(name, field); used {
+			return other, true
+		}
+	}
+	return "", false
+}
+
+// embeddingField returns a schema and field holding objects, or arrays of
+// objects, of the given schema. Views, which repeat the fields of their
+// source, are left out.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) embeddingField(schemaName string) (string, string, bool) {
+	dbState := s.getDBState(s.currentDB)
+	schemaNames := make([]string, 0, len(dbState.schemas))
+	for name := range dbState.schemas {
+		if _, isView := dbState.views[name]; !isView {
+			schemaNames = append(schemaNames, name)
+		}
+	}
+	sort.Strings(schemaNames)
+
+	for _, other := range schemaNames {
+		for _, def := range parseFieldDefs(dbState.schemas[other]) {
+			fieldType := def.fieldType
+			for {
+				elem, ok := elementType(fieldType)
+				if !ok {
+					break
+				}
+				fieldType = elem
+			}
+			if fieldType == schemaName {
+				return other, def.name, true
+			}
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"fmt"
+)
+
+// ArchiveSchema moves the records of a schema into a compressed cold file.
+// Archived schemas are not loaded at startup; their records are read back
+// transparently the first time the schema is accessed, and move back to
+// SSTables once they are modified.
+func (s *Storage) ArchiveSchema(schemaName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.schemas[schemaName]; !exists {
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if dbState.archived[schemaName] {
+		return fmt.Errorf("schema '%s' is already archived", schemaName)
+	}
+
+	records := s.lookupTable(schemaName).Items()
+
+	store := s.getOrCreateStore(s.currentDB)
+	if err := store.ArchiveSchema(schemaName, records); err != nil {
+		return err
+	}
+
+	// The snapshot in the cold file supersedes any SSTables of the schema
+	if err := s.table(schemaName).Drop(); err != nil {
+		return err
+	}
+
+	dbState.archived[schemaName] = true
+	delete(dbState.records, schemaName)
+	delete(dbState.indexes, schemaName)
+	delete(dbState.compound, schemaName)
+	delete(dbState.textIndex, schemaName)
+	delete(dbState.folded, schemaName)
+	delete(dbState.geo, schemaName)
+	delete(dbState.arrays, schemaName)
+
+	return s.saveToPersistent()
+}
+
+// unarchive moves the records of an archived schema that was modified back
+// to SSTables and removes its cold file. A schema whose records cannot be
+// written stays archived, so its cold file is kept.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) unarchive(schemaName string) {
+	dbState := s.getDBState(s.currentDB)
+
+	if !dbState.archived[schemaName] {
+		return
+	}
+	table, loaded := dbState.records[schemaName]
+	if !loaded {
+		return
+	}
+
+	if err := table.Persist(); err != nil {
+		s.config.Warnf("cannot move archived schema '%s' back to SSTables: %v", schemaName, err)
+		return
+	}
+	store := s.getOrCreateStore(s.currentDB)
+	if err := store.RemoveArchivedSchema(schemaName); err != nil {
+		s.config.Warnf("cannot remove the cold file of schema '%s': %v", schemaName, err)
+		return
+	}
+	delete(dbState.archived, schemaName)
+}
+
+// ArchivedSchemas returns the names of the schemas kept in cold storage
+func (s *Storage) ArchivedSchemas() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+	names := make([]string, 0, len(dbState.archived))
+	for name := range dbState.archived {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// loadArchived rehydrates an archived schema before a read-only operation,
+// which cannot load it while only holding the read lock
+func (s *Storage) loadArchived(schemaName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.ensureLoaded(schemaName)
+}
+
+// ensureLoaded reads the records of an archived schema from its cold file
+// if they are not in memory yet
+// NOTE: This function should be called from within a locked context
+func (s *Storage) ensureLoaded(schemaName string) error {
+	dbState := s.getDBState(s.currentDB)
+
+	if !dbState.archived[schemaName] {
+		return nil
+	}
+	if _, loaded := dbState.records[schemaName]; loaded {
+		return nil
+	}
+
+	store := s.getOrCreateStore(s.currentDB)
+	records, err := store.LoadArchivedSchema(schemaName)
+	if err != nil {
+		return fmt.Errorf("failed to load archived schema '%s': %v", schemaName, err)
+	}
+
+	dbState.records[schemaName] = s.openTable(schemaName, records)
+	s.indexFoldedKeys(schemaName)
+	s.indexSchema(schemaName)
+
+	return nil
+}
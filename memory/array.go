@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+)
+
+// elementType returns the type of the elements of a typed array such as
+// []string, or false when the type is not a typed array
+func elementType(fieldType string) (string, bool) {
+	if !strings.HasPrefix(fieldType, "[]") {
+		return "", false
+	}
+	return fieldType[len("[]"):], true
+}
+
+// isArrayType reports whether a schema field type holds arrays
+func isArrayType(fieldType string) bool {
+	_, typed := elementType(fieldType)
+	return typed || fieldType == "array" || fieldType == "list"
+}
+
+// arrayFields returns the fields a schema declares as array, list or typed
+// array, in name order
+func arrayFields(schemaDef string) []string {
+	var fields []string
+	for field, fieldType := range parseSchemaFields(schemaDef) {
+		if isArrayType(fieldType) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// updateArrayIndexes adds the elements of the array fields of a record to
+// the element indexes of its schema, or removes them again
+// NOTE: This function should be called from within a locked context
+func (s *Storage) updateArrayIndexes(schemaName, key string, fields map[string]interface{}, add bool) {
+	dbState := s.getDBState(s.currentDB)
+
+	for _, field := range arrayFields(dbState.schemas[schemaName]) {
+		elements, ok := fields[field].([]interface{})
+		if !ok {
+			continue
+		}
+
+		if dbState.arrays[schemaName] == nil {
+			dbState.arrays[schemaName] = make(map[string]fieldIndex)
+		}
+		index := dbState.arrays[schemaName][field]
+		if index == nil {
+			index = make(fieldIndex)
+			dbState.arrays[schemaName][field] = index
+		}
+
+		for _, element := range elements {
+			if element == nil {
+				continue
+			}
+			indexed := indexValue(element)
+			if add {
+				if index[indexed] == nil {
+					index[indexed] = make(map[string]bool)
+				}
+				index[indexed][key] = true
+				continue
+			}
+
+			delete(index[indexed], key)
+			if len(index[indexed]) == 0 {
+				delete(index, indexed)
+			}
+		}
+	}
+}
+
+// arrayLookup finds the records whose array field holds a value, using the
+// element index of the first indexed field among the memberships. It
+// returns false when none of the fields is indexed.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) arrayLookup(schemaName string, memberships map[string][]string) ([]string, []string, bool) {
+	dbState := s.getDBState(s.currentDB)
+
+	for _, field := range arrayFields(dbState.schemas[schemaName]) {
+		forms, exists := memberships[field]
+		if !exists {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var keys []string
+		for _, form := range forms {
+			for key := range dbState.arrays[schemaName][field][form] {
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+		s.sortKeys(schemaName, keys)
+		return keys, []string{field}, true
+	}
+
+	return nil, nil, false
+}
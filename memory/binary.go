@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// decodeBytes decodes the base64 text a bytes field is written with, in
+// the standard or URL-safe alphabet, with or without padding
+func decodeBytes(text string) ([]byte, bool) {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := encoding.DecodeString(text); err == nil {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// canonicalBytes returns the standard, padded base64 a bytes field stores
+// for a value
+func canonicalBytes(value interface{}) (string, bool) {
+	text, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+	data, ok := decodeBytes(text)
+	if !ok {
+		return "", false
+	}
+	return base64.StdEncoding.EncodeToString(data), true
+}
+
+// BinaryFields returns the fields a schema definition declares as bytes
+func BinaryFields(schemaDef string) []string {
+	var fields []string
+	for _, def := range parseFieldDefs(schemaDef) {
+		if def.fieldType == "bytes" {
+			fields = append(fields, def.name)
+		}
+	}
+	return fields
+}
+
+// RedactBinary returns a record with the values of the given bytes fields
+// replaced by a note of their size, such as "[binary 1024 bytes]". Records
+// holding none of the fields are returned unchanged.
+func RedactBinary(record interface{}, fields []string) interface{} {
+	if len(fields) == 0 {
+		return record
+	}
+	decoded, err := decodeRecord(record)
+	if err != nil {
+		return record
+	}
+
+	redacted := false
+	for _, field := range fields {
+		text, ok := decoded[field].(string)
+		if !ok {
+			continue
+		}
+		data, _ := decodeBytes(text)
+		decoded[field] = fmt.Sprintf("[binary %d bytes]", len(data))
+		redacted = true
+	}
+	if !redacted {
+		return record
+	}
+
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		return record
+	}
+	return string(data)
+}
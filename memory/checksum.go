@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// ChecksumProblem describes a record whose stored content no longer
+// matches its recorded hash
+type ChecksumProblem struct {
+	Key    string
+	Reason string
+}
+
+// recordChecksum returns the hex encoded SHA-256 hash of a stored record
+func recordChecksum(record interface{}) string {
+	data, ok := record.(string)
+	if !ok {
+		data = fmt.Sprintf("%v", record)
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateChecksum records the hash of a record's current content
+// NOTE: This function should be called from within a locked context
+func (s *Storage) updateChecksum(schemaName, key string, record interface{}) {
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.checksums[schemaName]; !exists {
+		dbState.checksums[schemaName] = make(map[string]string)
+	}
+	dbState.checksums[schemaName][key] = recordChecksum(record)
+}
+
+// rebuildChecksums computes hashes for every record of the current database
+// NOTE: This function should be called from within a locked context
+func (s *Storage) rebuildChecksums() {
+	dbState := s.getDBState(s.currentDB)
+	dbState.checksums = make(map[string]map[string]string)
+
+	for schemaName, table := range dbState.records {
+		for key, record := range table.Items() {
+			s.updateChecksum(schemaName, key, record)
+		}
+	}
+}
+
+// verifyChecksum compares a record against its stored hash
+// NOTE: This function should be called from within a locked context
+func (s *Storage) verifyChecksum(schemaName, key string) error {
+	dbState := s.getDBState(s.currentDB)
+
+	expected, exists := dbState.checksums[schemaName][key]
+	if !exists {
+		return fmt.Errorf("record '%s' in schema '%s' has no stored checksum", key, schemaName)
+	}
+
+	record, err := s.lookupTable(schemaName).Get(key)
+	if err != nil {
+		return err
+	}
+
+	if actual := recordChecksum(record); actual != expected {
+		return fmt.Errorf("record '%s' in schema '%s' does not match its stored checksum", key, schemaName)
+	}
+
+	return nil
+}
+
+// VerifyRecord checks a single record, looked up like by ExplainGet,
+// against its stored checksum
+func (s *Storage) VerifyRecord(schemaName string, key string, match KeyMatch) error {
+	if err := s.loadArchived(schemaName); err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	fullKey, err := s.resolveKey(schemaName, key, match)
+	if err != nil {
+		return err
+	}
+
+	return s.verifyChecksum(schemaName, fullKey)
+}
+
+// VerifyChecksums checks every record of a schema against its stored
+// checksum and returns the number of records checked along with any problems
+func (s *Storage) VerifyChecksums(schemaName string) (int, []ChecksumProblem, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return 0, nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.schemas[schemaName]; !exists {
+		return 0, nil, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+
+	records := s.lookupTable(schemaName).Items()
+
+	var problems []ChecksumProblem
+	for key, record := range records {
+		expected, exists := dbState.checksums[schemaName][key]
+		if !exists {
+			problems = append(problems, ChecksumProblem{Key: key, Reason: "missing checksum"})
+		} else if recordChecksum(record) != expected {
+			problems = append(problems, ChecksumProblem{Key: key, Reason: "checksum mismatch"})
+		}
+	}
+
+	// Hashes left behind by records removed outside of the CLI
+	for key := range dbState.checksums[schemaName] {
+		if _, exists := records[key]; !exists {
+			problems = append(problems, ChecksumProblem{Key: key, Reason: "record missing"})
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Key < problems[j].Key
+	})
+
+	return len(records), problems, nil
+}
@@ -0,0 +1,248 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"simplebson/preprocessing"
+)
+
+// compoundSeparator separates the values of an entry in a compound index
+const compoundSeparator = "\x00"
+
+// compoundIndex is a secondary index over an ordered tuple of fields. Its
+// entries are the indexed values of a record followed by the record key,
+// kept sorted so every prefix of the fields can be looked up with a binary
+// search.
+type compoundIndex struct {
+	fields  []string
+	entries []string
+}
+
+// indexName returns the name of an index over the given fields
+func indexName(fields []string) string {
+	return strings.Join(fields, ",")
+}
+
+// CreateIndex adds a compound index over the given fields of a schema. It
+// is used by find queries that compare a prefix of the fields with ==.
+func (s *Storage) CreateIndex(schemaName string, fields []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.schemas[schemaName]; !exists {
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("an index needs at least one field")
+	}
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		if seen[field] {
+			return fmt.Errorf("field '%s' appears twice in the index", field)
+		}
+		seen[field] = true
+	}
+
+	name := indexName(fields)
+	for _, existing := range dbState.indexDefs[schemaName] {
+		if existing == name {
+			return fmt.Errorf("index (%s) already exists on schema '%s'", name, schemaName)
+		}
+	}
+
+	if err := s.ensureLoaded(schemaName); err != nil {
+		return err
+	}
+
+	dbState.indexDefs[schemaName] = append(dbState.indexDefs[schemaName], name)
+	s.indexSchema(schemaName)
+
+	return s.saveToPersistent()
+}
+
+// DropIndex removes the compound index over the given fields of a schema
+func (s *Storage) DropIndex(schemaName string, fields []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	name := indexName(fields)
+	defs := dbState.indexDefs[schemaName]
+	for i, existing := range defs {
+		if existing != name {
+			continue
+		}
+
+		dbState.indexDefs[schemaName] = append(defs[:i:i], defs[i+1:]...)
+		if len(dbState.indexDefs[schemaName]) == 0 {
+			delete(dbState.indexDefs, schemaName)
+		}
+		delete(dbState.compound[schemaName], name)
+
+		return s.saveToPersistent()
+	}
+
+	return Errorf(ErrorNotFound, "index (%s) does not exist on schema '%s'", name, schemaName)
+}
+
+// ListIndexes returns the field lists of the compound indexes of a schema,
+// in the order they were created
+func (s *Storage) ListIndexes(schemaName string) ([][]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.schemas[schemaName]; !exists {
+		return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+
+	indexes := make([][]string, 0, len(dbState.indexDefs[schemaName]))
+	for _, name := range dbState.indexDefs[schemaName] {
+		indexes = append(indexes, strings.Split(name, ","))
+	}
+	return indexes, nil
+}
+
+// compoundIndexes returns the compound indexes of a schema, creating the
+// empty structures of newly defined ones
+// NOTE: This function should be called from within a locked context
+func (s *Storage) compoundIndexes(schemaName string) []*compoundIndex {
+	dbState := s.getDBState(s.currentDB)
+
+	defs := dbState.indexDefs[schemaName]
+	if len(defs) == 0 {
+		return nil
+	}
+	if dbState.compound[schemaName] == nil {
+		dbState.compound[schemaName] = make(map[string]*compoundIndex)
+	}
+
+	indexes := make([]*compoundIndex, 0, len(defs))
+	for _, name := range defs {
+		index := dbState.compound[schemaName][name]
+		if index == nil {
+			index = &compoundIndex{fields: strings.Split(name, ",")}
+			dbState.compound[schemaName][name] = index
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+// entry returns the index entry of a record, or false when the record does
+// not have every indexed field
+func (index *compoundIndex) entry(key string, fields map[string]interface{}) (string, bool) {
+	values := make([]string, 0, len(index.fields)+1)
+	for _, field := range index.fields {
+		value, exists := preprocessing.LookupField(fields, field)
+		if !exists || value == nil {
+			return "", false
+		}
+		values = append(values, indexValue(value))
+	}
+	return strings.Join(append(values, key), compoundSeparator), true
+}
+
+// update adds the entry of a record to the index, or removes it
+func (index *compoundIndex) update(key string, fields map[string]interface{}, add bool) {
+	entry, ok := index.entry(key, fields)
+	if !ok {
+		return
+	}
+
+	i := sort.SearchStrings(index.entries, entry)
+	found := i < len(index.entries) && index.entries[i] == entry
+	switch {
+	case add && !found:
+		index.entries = append(index.entries, "")
+		copy(index.entries[i+1:], index.entries[i:])
+		index.entries[i] = entry
+	case !add && found:
+		index.entries = append(index.entries[:i], index.entries[i+1:]...)
+	}
+}
+
+// lookup returns the keys of the records whose first fields take one of
+// the given forms each
+func (index *compoundIndex) lookup(prefix [][]string) []string {
+	var keys []string
+
+	var visit func(depth int, path string)
+	visit = func(depth int, path string) {
+		if depth < len(prefix) {
+			for _, form := range prefix[depth] {
+				visit(depth+1, path+form+compoundSeparator)
+			}
+			return
+		}
+
+		for i := sort.SearchStrings(index.entries, path); i < len(index.entries); i++ {
+			entry := index.entries[i]
+			if !strings.HasPrefix(entry, path) {
+				break
+			}
+			keys = append(keys, entry[strings.LastIndex(entry, compoundSeparator)+1:])
+		}
+	}
+	visit(0, "")
+
+	return keys
+}
+
+// indexLookup uses the index covering the longest prefix of equality
+// conditions of a filter to find the candidate records of a query, and
+// returns them with the fields of the index used. Without a usable
+// equality, an element index of an array field the filter requires to
+// contain a value is used. It returns false when no index applies.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) indexLookup(schemaName string, filter preprocessing.Filter) ([]string, []string, bool) {
+	equalities := preprocessing.Equalities(filter)
+
+	var best *compoundIndex
+	var bestPrefix [][]string
+	for _, index := range s.compoundIndexes(schemaName) {
+		var prefix [][]string
+		for _, field := range index.fields {
+			forms, exists := equalities[field]
+			if !exists {
+				break
+			}
+			prefix = append(prefix, forms)
+		}
+		if len(prefix) > len(bestPrefix) {
+			best, bestPrefix = index, prefix
+		}
+	}
+
+	if best != nil {
+		keys := best.lookup(bestPrefix)
+		s.sortKeys(schemaName, keys)
+		return keys, best.fields, true
+	}
+
+	// A unique field is an index over that single field
+	dbState := s.getDBState(s.currentDB)
+	for _, field := range s.indexedFields(schemaName) {
+		forms, exists := equalities[field]
+		if !exists {
+			continue
+		}
+
+		var keys []string
+		for _, form := range forms {
+			for key := range dbState.indexes[schemaName][field][form] {
+				keys = append(keys, key)
+			}
+		}
+		s.sortKeys(schemaName, keys)
+		return keys, []string{field}, true
+	}
+
+	return s.arrayLookup(schemaName, preprocessing.Memberships(filter))
+}
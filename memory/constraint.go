@@ -0,0 +1,209 @@
+package memory
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"simplebson/preprocessing"
+)
+
+// fieldConstraints limits the values of a field beyond its type, written
+// after the type as in age:int(min=0,max=150) or
+// email:string(pattern=^.+@.+$)
+type fieldConstraints struct {
+	min, max *big.Rat // Bounds of numbers, nil when unbounded
+	minText  string   // Bounds as written, for messages
+	maxText  string
+	minLen   int    // Least number of characters or elements
+	maxLen   int    // Largest number of characters or elements, -1 when unbounded
+	pattern  string // Regular expression strings must match, empty when unset
+}
+
+// splitOutside splits text at every separator that is not inside
+// parentheses, so the constraints of a type may hold colons
+func splitOutside(text string, sep rune) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range text {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case r == sep && depth == 0:
+			parts = append(parts, text[start:i])
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// cutOutside cuts text around the first separator that is not inside
+// parentheses
+func cutOutside(text string, sep rune) (string, string, bool) {
+	parts := splitOutside(text, sep)
+	if len(parts) == 1 {
+		return text, "", false
+	}
+	return parts[0], text[len(parts[0])+utf8.RuneLen(sep):], true
+}
+
+// splitConstraints separates a field type such as int(min=0) into its base
+// type and the text of its constraints. Enums, references and typed arrays
+// hold parentheses of their own and have no constraints.
+func splitConstraints(fieldType string) (string, string, bool) {
+	open := strings.Index(fieldType, "(")
+	if open <= 0 || !strings.HasSuffix(fieldType, ")") {
+		return fieldType, "", false
+	}
+	base := fieldType[:open]
+	if base == "enum" || base == "ref" || strings.HasPrefix(base, "[]") {
+		return fieldType, "", false
+	}
+	return base, fieldType[open+1 : len(fieldType)-1], true
+}
+
+// parseConstraints parses the constraints written for a field of the
+// given type, a comma separated list of min, max, minlen, maxlen and
+// pattern settings. A pattern takes the rest of the list, so it may hold
+// commas and comes last.
+func parseConstraints(fieldType, text string) (*fieldConstraints, error) {
+	c := &fieldConstraints{maxLen: -1}
+	for text != "" {
+		setting := text
+		if !strings.HasPrefix(text, "pattern=") {
+			setting, text, _ = strings.Cut(text, ",")
+		} else {
+			text = ""
+		}
+
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("expected name=value, got '%s'", setting)
+		}
+		if err := checkConstraintType(name, fieldType); err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "min", "max":
+			bound, ok := preprocessing.ParseDecimal(value)
+			if !ok {
+				return nil, fmt.Errorf("%s expects a number, got '%s'", name, value)
+			}
+			if name == "min" {
+				c.min, c.minText = bound, value
+			} else {
+				c.max, c.maxText = bound, value
+			}
+		case "minlen", "maxlen":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s expects a whole number of 0 or more, got '%s'", name, value)
+			}
+			if name == "minlen" {
+				c.minLen = n
+			} else {
+				c.maxLen = n
+			}
+		case "pattern":
+			if _, err := compilePattern(value); err != nil {
+				return nil, fmt.Errorf("invalid pattern: %v", err)
+			}
+			c.pattern = value
+		}
+	}
+
+	if c.min != nil && c.max != nil && c.min.Cmp(c.max) > 0 {
+		return nil, fmt.Errorf("min %s is larger than max %s", c.minText, c.maxText)
+	}
+	if c.maxLen >= 0 && c.minLen > c.maxLen {
+		return nil, fmt.Errorf("minlen %d is larger than maxlen %d", c.minLen, c.maxLen)
+	}
+	return c, nil
+}
+
+// checkConstraintType reports a constraint that does not apply to fields
+// of the given type
+func checkConstraintType(name, fieldType string) error {
+	var types []string
+	switch name {
+	case "min", "max":
+		types = []string{"int", "integer", "serial", "float", "double", "decimal"}
+	case "minlen", "maxlen":
+		types = []string{"string", "text", "array", "list"}
+	case "pattern":
+		types = []string{"string", "text"}
+	default:
+		return fmt.Errorf("unknown constraint '%s', expected min, max, minlen, maxlen or pattern", name)
+	}
+	for _, t := range types {
+		if t == fieldType {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not apply to %s fields", name, fieldType)
+}
+
+// patterns caches the compiled constraint patterns by their text
+var patterns sync.Map
+
+// compilePattern compiles the pattern of a constraint once
+func compilePattern(text string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(text); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(text)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(text, re)
+	return re, nil
+}
+
+// check reports how a value of the field's type breaks the constraints
+func (c *fieldConstraints) check(value interface{}) error {
+	var number *big.Rat
+	switch v := value.(type) {
+	case float64:
+		number = new(big.Rat).SetFloat64(v)
+	case string:
+		number, _ = decimalValue(v)
+	}
+	if number != nil {
+		if c.min != nil && number.Cmp(c.min) < 0 {
+			return fmt.Errorf("must be at least %s, got %v", c.minText, value)
+		}
+		if c.max != nil && number.Cmp(c.max) > 0 {
+			return fmt.Errorf("must be at most %s, got %v", c.maxText, value)
+		}
+	}
+
+	if c.minLen > 0 || c.maxLen >= 0 {
+		length, unit := 0, "characters"
+		switch v := value.(type) {
+		case string:
+			length = utf8.RuneCountInString(v)
+		case []interface{}:
+			length, unit = len(v), "elements"
+		}
+		if length < c.minLen {
+			return fmt.Errorf("must have at least %d %s, got %d", c.minLen, unit, length)
+		}
+		if c.maxLen >= 0 && length > c.maxLen {
+			return fmt.Errorf("must have at most %d %s, got %d", c.maxLen, unit, length)
+		}
+	}
+
+	if text, ok := value.(string); ok && c.pattern != "" {
+		if re, err := compilePattern(c.pattern); err == nil && !re.MatchString(text) {
+			return fmt.Errorf("must match pattern %s, got %q", c.pattern, text)
+		}
+	}
+	return nil
+}
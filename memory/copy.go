@@ -0,0 +1,172 @@
+package memory
+
+import "fmt"
+
+// CopySchema copies a schema to another database managed by the storage,
+// creating the database if needed: its definition or JSON Schema document
+// and its compound indexes, and with withRecords its records, kept under
+// their keys. A schema the other database already has is redefined, and
+// its records with the keys of copied ones are replaced. Copied records
+// are validated in the other database, and nothing is copied when one
+// does not fit or breaks a unique or key constraint there. A schema
+// extending another is copied with the definition it inherits, as a
+// schema of its own. It returns the number of records copied.
+func (s *Storage) CopySchema(name, targetDB string, withRecords bool) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	source := s.currentDB
+	if targetDB == source {
+		return 0, fmt.Errorf("schema '%s' is already in database '%s'", name, targetDB)
+	}
+
+	dbState := s.getDBState(source)
+	schemaDef, exists := dbState.schemas[name]
+	if !exists {
+		return 0, Errorf(ErrorNotFound, "schema '%s' does not exist", name)
+	}
+	if view, isView := dbState.views[name]; isView {
+		return 0, fmt.Errorf("'%s' is a view of '%s', copy its source and create the view there", name, view.source)
+	}
+
+	document := dbState.documents[name]
+	indexDefs := append([]string(nil), dbState.indexDefs[name]...)
+	var records map[string]interface{}
+	if withRecords {
+		if err := s.ensureLoaded(name); err != nil {
+			return 0, err
+		}
+		records = s.lookupTable(name).Items()
+	}
+	counter := dbState.counters[name]
+
+	if err := s.saveToPersistent(); err != nil {
+		return 0, err
+	}
+	s.currentDB = targetDB
+	defer func() { s.currentDB = source }()
+	s.loadFromPersistent()
+
+	if err := s.copyInto(name, schemaDef, document, indexDefs, records, counter); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// copyInto defines a copied schema in the current database and writes its
+// copied records, restoring the schema and records it replaced when a
+// record does not fit
+// NOTE: This function should be called from within a locked context
+func (s *Storage) copyInto(name, schemaDef, document string, indexDefs []string, records map[string]interface{}, counter int64) error {
+	dbState := s.getDBState(s.currentDB)
+
+	previousDef, existed := dbState.schemas[name]
+	previousDocument := dbState.documents[name]
+	previousExtends := dbState.extends[name]
+	previousVersion := dbState.versions[name]
+	previousIndexes := dbState.indexDefs[name]
+	previousCounter, hadCounter := dbState.counters[name]
+	if err := s.ensureLoaded(name); err != nil {
+		return err
+	}
+
+	if err := s.defineSchema(name, schemaDef, document, ""); err != nil {
+		return err
+	}
+	restoreVersions := s.saveRecordVersions(name)
+	restore := func(changes []recordChange) {
+		for _, change := range changes {
+			if change.previous != "" {
+				s.putRecord(name, change.key, change.previous)
+			} else {
+				s.removeRecord(name, change.key)
+			}
+		}
+		restoreVersions()
+		if existed {
+			dbState.schemas[name] = previousDef
+			dbState.versions[name] = previousVersion
+			if previousDocument != "" {
+				dbState.documents[name] = previousDocument
+			} else {
+				delete(dbState.documents, name)
+			}
+			if previousExtends != "" {
+				dbState.extends[name] = previousExtends
+			}
+			if previousIndexes != nil {
+				dbState.indexDefs[name] = previousIndexes
+			} else {
+				delete(dbState.indexDefs, name)
+			}
+			if hadCounter {
+				dbState.counters[name] = previousCounter
+			} else {
+				delete(dbState.counters, name)
+			}
+			s.indexSchema(name)
+			s.inheritChildren(name)
+		} else {
+			s.forgetSchema(name)
+		}
+		s.saveToPersistent()
+	}
+
+	merged := append([]string(nil), previousIndexes...)
+	for _, index := range indexDefs {
+		found := false
+		for _, existing := range merged {
+			if existing == index {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, index)
+		}
+	}
+	if len(merged) > 0 {
+		dbState.indexDefs[name] = merged
+	}
+	if counter > dbState.counters[name] {
+		dbState.counters[name] = counter
+	}
+
+	var changes []recordChange
+	for key, record := range records {
+		data, ok := record.(string)
+		if !ok {
+			continue
+		}
+		fields, err := decodeRecord(data)
+		if err == nil {
+			err = s.validateRecordAgainstSchema(name, data)
+		}
+		if err == nil {
+			err = s.checkRefs(name, fields)
+		}
+		if err != nil {
+			restore(nil)
+			return fmt.Errorf("record '%s' does not fit in database '%s': %v", key, s.currentDB, err)
+		}
+
+		previous, _ := s.lookupTable(name).Get(key)
+		previousData, _ := previous.(string)
+		changes = append(changes, recordChange{key: key, previous: previousData, data: data})
+	}
+
+	// Copied records are written under the version of the schema here
+	for _, change := range changes {
+		s.writeRecord(name, change.key, change.data)
+	}
+	err := s.indexSchema(name)
+	if err == nil {
+		err = s.checkKeys(name)
+	}
+	if err != nil {
+		restore(changes)
+		return fmt.Errorf("records do not fit in database '%s': %v", s.currentDB, err)
+	}
+
+	return s.saveToPersistent()
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"simplebson/preprocessing"
+)
+
+// Cursor marks the position of the last record of a page, so the next page
+// can start right after it. A position is a record key, together with the
+// value of the sort field when the query is sorted, so records added or
+// removed between pages neither shift the following pages nor make them
+// skip or repeat records.
+type Cursor struct {
+	Schema     string      `json:"s"`
+	Key        string      `json:"k"`
+	SortField  string      `json:"f,omitempty"`
+	Descending bool        `json:"d,omitempty"`
+	Value      interface{} `json:"v"`
+	HasValue   bool        `json:"h,omitempty"` // Whether the record had a value for the sort field
+}
+
+// Token encodes the cursor as an opaque string
+func (c *Cursor) Token() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor token
+func ParseCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor '%s'", token)
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Schema == "" {
+		return nil, fmt.Errorf("invalid cursor '%s'", token)
+	}
+	return &c, nil
+}
+
+// newCursor returns the cursor positioned at a match of a query
+func newCursor(schemaName string, match queryMatch, opts QueryOptions) *Cursor {
+	c := &Cursor{Schema: schemaName, Key: match.key, SortField: opts.SortField, Descending: opts.SortDescending}
+	if opts.SortField != "" {
+		value, exists := preprocessing.LookupField(match.fields, opts.SortField)
+		c.Value, c.HasValue = value, exists && value != nil
+	}
+	return c
+}
+
+// check reports an error when the cursor was not issued for a query on
+// the same schema in the same order
+func (c *Cursor) check(schemaName string, opts QueryOptions) error {
+	if c.Schema != schemaName {
+		return fmt.Errorf("cursor belongs to schema '%s'", c.Schema)
+	}
+	if c.SortField != opts.SortField || c.Descending != opts.SortDescending {
+		return fmt.Errorf("cursor was issued for a different sort order")
+	}
+	return nil
+}
+
+// after reports whether a match comes after the cursor in the order
+// sortMatches puts records in: by sort field value, records without one
+// last, and by key, in the order compareKeys gives, among equal values
+func (c *Cursor) after(match queryMatch, numeric bool, compareKeys func(a, b string) int) bool {
+	if c.SortField != "" {
+		value, exists := preprocessing.LookupField(match.fields, c.SortField)
+		hasValue := exists && value != nil
+		if hasValue != c.HasValue {
+			return c.HasValue
+		}
+		if hasValue {
+			order := compareFieldValues(value, c.Value, numeric)
+			if c.Descending {
+				order = -order
+			}
+			if order != 0 {
+				return order > 0
+			}
+		}
+	}
+	return compareKeys(match.key, c.Key) > 0
+}
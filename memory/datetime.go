@@ -0,0 +1,30 @@
+package memory
+
+import (
+	"time"
+
+	"simplebson/preprocessing"
+)
+
+// isTimeType reports whether a schema field type holds dates or times
+func isTimeType(fieldType string) bool {
+	switch fieldType {
+	case "date", "datetime", "timestamp":
+		return true
+	}
+	return false
+}
+
+// canonicalTime returns the form a date or time is stored in: 2006-01-02
+// for date fields and an RFC 3339 timestamp in UTC, to the second, for
+// datetime and timestamp fields. Both sort in chronological order as text.
+func canonicalTime(fieldType, text string) (string, bool) {
+	t, ok := preprocessing.ParseTime(text)
+	if !ok {
+		return "", false
+	}
+	if fieldType == "date" {
+		return t.Format("2006-01-02"), true
+	}
+	return t.UTC().Format(time.RFC3339), true
+}
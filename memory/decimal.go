@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"simplebson/preprocessing"
+)
+
+// canonicalDecimal returns the string a decimal field stores for a value:
+// the number as written, without a plus sign or leading zeros, keeping
+// the digits after the decimal point so 19.90 stays 19.90. JSON numbers
+// are taken in their shortest form.
+func canonicalDecimal(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case string:
+		number, ok := preprocessing.ParseDecimal(v)
+		if !ok {
+			return "", false
+		}
+
+		negative := strings.HasPrefix(v, "-") && number.Sign() != 0
+		whole, fraction, hasPoint := strings.Cut(strings.TrimLeft(v, "+-"), ".")
+		if whole = strings.TrimLeft(whole, "0"); whole == "" {
+			whole = "0"
+		}
+
+		canonical := whole
+		if hasPoint && fraction != "" {
+			canonical += "." + fraction
+		}
+		if negative {
+			canonical = "-" + canonical
+		}
+		return canonical, true
+	}
+	return "", false
+}
+
+// decimalValue returns the exact value of a decimal string
+func decimalValue(value interface{}) (*big.Rat, bool) {
+	text, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+	return preprocessing.ParseDecimal(text)
+}
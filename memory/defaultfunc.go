@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultFuncs lists the functions a default can call, as written, with
+// the field types each one fills
+var defaultFuncs = map[string][]string{
+	"now()":  {"datetime", "timestamp", "date", "string", "text", "int", "integer"},
+	"uuid()": {"uuid", "string", "text"},
+}
+
+// defaultCallPattern matches a default written as a function call
+var defaultCallPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\(\)$`)
+
+// isDefaultFunc reports whether a default is written as a function call,
+// which is evaluated for every new record instead of taken as a value
+func isDefaultFunc(text string) bool {
+	return defaultCallPattern.MatchString(text)
+}
+
+// checkDefaultFunc reports a default function that does not exist or does
+// not fill fields of the given type
+func checkDefaultFunc(fieldType, text string) error {
+	types, exists := defaultFuncs[text]
+	if !exists {
+		return fmt.Errorf("unknown function %s, expected now() or uuid(); quote the default as \"%s\" to use the text", text, text)
+	}
+	for _, t := range types {
+		if t == fieldType {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not fill %s fields, only %s", text, fieldType, strings.Join(types, ", "))
+}
+
+// evalDefaultFunc computes the value a default function gives a field of
+// the given type in a record written at the given time
+func evalDefaultFunc(fieldType, text string, now time.Time) (interface{}, error) {
+	switch text {
+	case "now()":
+		now = now.UTC()
+		switch fieldType {
+		case "date":
+			return now.Format("2006-01-02"), nil
+		case "int", "integer":
+			return float64(now.Unix()), nil
+		}
+		return now.Format(time.RFC3339), nil
+	case "uuid()":
+		return newUUID()
+	}
+	return nil, fmt.Errorf("unknown default function %s", text)
+}
@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"simplebson/preprocessing"
+)
+
+// DeleteWhere removes every record matching the filter, along with the
+// records their cascading references remove, and saves the result in one
+// batch. Nothing is removed when a record with a blocking reference refers
+// to one of them, or with dryRun set. It returns the number of matching
+// records.
+func (s *Storage) DeleteWhere(schemaName string, filter preprocessing.Filter, dryRun bool) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.checkWritable(schemaName); err != nil {
+		return 0, err
+	}
+	if err := s.ensureLoaded(schemaName); err != nil {
+		return 0, err
+	}
+
+	matches, err := s.matchRecords(schemaName, filter)
+	if err != nil {
+		return 0, err
+	}
+	if len(matches) == 0 {
+		return 0, nil
+	}
+
+	deletion := s.newRefDeletion()
+	for _, match := range matches {
+		if err := deletion.add(schemaName, match.key); err != nil {
+			return 0, err
+		}
+	}
+	if dryRun {
+		return len(matches), nil
+	}
+	deletion.apply()
+
+	return len(matches), s.saveToPersistent()
+}
@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FieldChange is a field that differs between two definitions of a schema
+type FieldChange struct {
+	Field string
+	Kind  string // added, removed, retyped or changed
+	From  string // Definition of the field, without its name, before
+	To    string // Definition of the field, without its name, after
+}
+
+// SchemaDiff lists how a schema definition differs from another
+type SchemaDiff struct {
+	Fields         []FieldChange // Sorted by field name
+	FromStrict     bool
+	ToStrict       bool
+	FromIgnoreCase bool
+	ToIgnoreCase   bool
+}
+
+// Empty reports whether both definitions declare the same fields alike
+// and take the same options
+func (d SchemaDiff) Empty() bool {
+	return len(d.Fields) == 0 && d.FromStrict == d.ToStrict && d.FromIgnoreCase == d.ToIgnoreCase
+}
+
+// DiffSchemas compares two schema definitions field by field. A field is
+// added or removed when only one of them declares it, retyped when they
+// declare it with different types and changed when only its constraints,
+// default, modifiers or validators differ. The order of fields is ignored.
+func DiffSchemas(from, to string) SchemaDiff {
+	fromParts, fromTypes := fieldParts(from)
+	toParts, toTypes := fieldParts(to)
+
+	names := make([]string, 0, len(fromParts)+len(toParts))
+	for name := range fromParts {
+		names = append(names, name)
+	}
+	for name := range toParts {
+		if _, seen := fromParts[name]; !seen {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diff := SchemaDiff{
+		FromStrict:     isStrict(from),
+		ToStrict:       isStrict(to),
+		FromIgnoreCase: isIgnoreCase(from),
+		ToIgnoreCase:   isIgnoreCase(to),
+	}
+	for _, name := range names {
+		before, inFrom := fromParts[name]
+		after, inTo := toParts[name]
+		change := FieldChange{Field: name, From: before, To: after}
+		switch {
+		case !inFrom:
+			change.Kind = "added"
+		case !inTo:
+			change.Kind = "removed"
+		case fromTypes[name] != toTypes[name]:
+			change.Kind = "retyped"
+		case before != after:
+			change.Kind = "changed"
+		default:
+			continue
+		}
+		diff.Fields = append(diff.Fields, change)
+	}
+	return diff
+}
+
+// fieldParts returns the definition of every field of a schema without its
+// name, and its type, by field name
+func fieldParts(schemaDef string) (map[string]string, map[string]string) {
+	parts := make(map[string]string)
+	for _, part := range strings.Fields(schemaDef) {
+		segments := splitOutside(part, ':')
+		if len(segments) < 2 {
+			continue
+		}
+		parts[segments[0]] = part[len(segments[0])+1:]
+	}
+
+	types := make(map[string]string)
+	for _, def := range parseFieldDefs(schemaDef) {
+		types[def.name] = def.fieldType
+	}
+	return parts, types
+}
+
+// DBSchema returns the definition of a schema in another database, read
+// from its files without switching to it
+func (s *Storage) DBSchema(dbName, name string) (string, error) {
+	if info, err := os.Stat(filepath.Join(s.config.DataDir, dbName)); err != nil || !info.IsDir() {
+		return "", Errorf(ErrorNotFound, "database '%s' does not exist", dbName)
+	}
+
+	s.mutex.Lock()
+	store := s.getOrCreateStore(dbName)
+	s.mutex.Unlock()
+
+	schemas, err := store.LoadSchemas()
+	if err != nil {
+		return "", err
+	}
+	schemaDef, exists := schemas[name]
+	if !exists {
+		return "", Errorf(ErrorNotFound, "schema '%s' does not exist in database '%s'", name, dbName)
+	}
+	return schemaDef, nil
+}
@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"simplebson/preprocessing"
+)
+
+// DistinctValue is one value of a field together with the number of
+// records holding it
+type DistinctValue struct {
+	Value string // The value as typed on the command line, "null" when missing
+	Count int
+}
+
+// Distinct returns the unique values of a field across the records
+// matching the filter, ordered by value. Records without the field are
+// counted under a final "null" value.
+func (s *Storage) Distinct(schemaName, field string, filter preprocessing.Filter) ([]DistinctValue, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	matches, err := s.matchRecords(schemaName, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	groups, grouped := groupMatches(matches, field, parseSchemaFields(schemaDef)[field])
+
+	values := make([]DistinctValue, 0, len(groups))
+	for _, group := range groups {
+		values = append(values, DistinctValue{Value: group, Count: len(grouped[group])})
+	}
+
+	return values, nil
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DropSchema removes a schema definition. A schema that still holds
+// records is only dropped with withRecords set, which removes its records
+// and their index entries as well. Views, schemas that are the source of
+// a view or extended by another schema and schemas other schemas refer to
+// or embed cannot be dropped.
+func (s *Storage) DropSchema(name string, withRecords bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.schemas[name]; !exists {
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", name)
+	}
+	if _, isView := dbState.views[name]; isView {
+		return fmt.Errorf("'%s' is a view, use view drop to remove it", name)
+	}
+	for other, view := range dbState.views {
+		if view.source == name {
+			return fmt.Errorf("schema '%s' is the source of view '%s', drop that first", name, other)
+		}
+	}
+	if children := s.childSchemas(name); len(children) > 0 {
+		return fmt.Errorf("schema '%s' is extended by '%s', drop that first", name, children[0])
+	}
+	if other, field, used := s.dependentField(name); used {
+		return fmt.Errorf("schema '%s' is used by field '%s' of schema '%s'", name, field, other)
+	}
+
+	if !withRecords {
+		if err := s.ensureLoaded(name); err != nil {
+			return err
+		}
+		count := 0
+		it := s.lookupTable(name).Scan("", "")
+		for it.Next() {
+			count++
+		}
+		if count > 0 {
+			return fmt.Errorf("schema '%s' still holds %d record(s), pass --with-records to drop them too", name, count)
+		}
+	}
+
+	if err := s.forgetSchema(name); err != nil {
+		return err
+	}
+
+	return s.saveToPersistent()
+}
+
+// dependentField returns a field of another schema that refers to records
+// of the given schema or holds objects of it, directly or as array
+// elements. Views, which repeat the fields of their source, are left out.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) dependentField(schemaName string) (string, string, bool) {
+	dbState := s.getDBState(s.currentDB)
+	schemaNames := make([]string, 0, len(dbState.schemas))
+	for name := range dbState.schemas {
+		if _, isView := dbState.views[name]; !isView && name != schemaName {
+			schemaNames = append(schemaNames, name)
+		}
+	}
+	sort.Strings(schemaNames)
+
+	for _, other := range schemaNames {
+		for _, def := range parseFieldDefs(dbState.schemas[other]) {
+			fieldType := def.fieldType
+			for {
+				elem, ok := elementType(fieldType)
+				if !ok {
+					break
+				}
+				fieldType = elem
+			}
+			if target, _, isRef := parseRef(fieldType); isRef {
+				fieldType = target
+			}
+			if fieldType == schemaName {
+				return other, def.name, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// forgetSchema removes a schema or view along with its records, indexes
+// and the other state kept for it
+// NOTE: This function should be called from within a locked context
+func (s *Storage) forgetSchema(name string) error {
+	dbState := s.getDBState(s.currentDB)
+
+	if table, exists := dbState.records[name]; exists {
+		if err := table.Drop(); err != nil {
+			return err
+		}
+	}
+
+	delete(dbState.views, name)
+	delete(dbState.schemas, name)
+	delete(dbState.records, name)
+	delete(dbState.checksums, name)
+	delete(dbState.archived, name)
+	delete(dbState.indexes, name)
+	delete(dbState.indexDefs, name)
+	delete(dbState.compound, name)
+	delete(dbState.textIndex, name)
+	delete(dbState.folded, name)
+	delete(dbState.geo, name)
+	delete(dbState.arrays, name)
+	delete(dbState.counters, name)
+	delete(dbState.versions, name)
+	delete(dbState.recordVersions, name)
+	delete(dbState.documents, name)
+	delete(dbState.extends, name)
+	delete(dbState.metrics, name)
+	return nil
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrorKind classifies the errors of the storage, so callers can tell a
+// missing record from an invalid one without reading the message
+type ErrorKind int
+
+const (
+	ErrorOther      ErrorKind = iota // Any other error
+	ErrorNotFound                    // A schema, record, view, index or database does not exist
+	ErrorValidation                  // A record does not satisfy its schema, or a command does not parse
+	ErrorAmbiguous                   // A key matches several records
+	ErrorIO                          // A file could not be read or written
+)
+
+// String returns the name of an error kind, as printed in --json output
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorNotFound:
+		return "not_found"
+	case ErrorValidation:
+		return "validation_failed"
+	case ErrorAmbiguous:
+		return "ambiguous_key"
+	case ErrorIO:
+		return "io_error"
+	}
+	return "error"
+}
+
+// kindError is an error of a known kind
+type kindError struct {
+	kind ErrorKind
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Errorf formats an error of the given kind like fmt.Errorf
+func Errorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// KindOf returns the kind of an error: the one it was created with, an IO
+// error for a failed file operation, and ErrorOther otherwise
+func KindOf(err error) ErrorKind {
+	var kerr *kindError
+	if errors.As(err, &kerr) {
+		return kerr.kind
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return ErrorIO
+	}
+	return ErrorOther
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Access paths a lookup can take, from the most to the least selective
+const (
+	PathExactKey       = "exact key"
+	PathFoldedKey      = "case-insensitive key index"
+	PathKeyPrefix      = "key prefix"
+	PathFuzzyKey       = "fuzzy key"
+	PathSecondaryIndex = "secondary index"
+	PathFullScan       = "full scan"
+)
+
+// QueryPlan describes how a lookup found its records: the access path, the
+// index fields when a secondary index was used, and how many stored
+// records were read compared to how many were returned. Next is set when
+// Limit left matching records out.
+type QueryPlan struct {
+	Path     string
+	Index    []string
+	Examined int
+	Returned int
+	Next     *Cursor // Position of the last record returned, to resume from
+}
+
+// String formats the plan as a single line
+func (p QueryPlan) String() string {
+	path := p.Path
+	if len(p.Index) > 0 {
+		path = fmt.Sprintf("%s (%s)", path, strings.Join(p.Index, ","))
+	}
+	return fmt.Sprintf("%s, %d records examined, %d returned", path, p.Examined, p.Returned)
+}
+
+// ExplainGet retrieves a record like GetRecord, matching the key as the
+// KeyMatch asks, and also reports how the key was matched
+func (s *Storage) ExplainGet(schemaName string, key string, match KeyMatch, fields ...string) (interface{}, QueryPlan, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, QueryPlan{}, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	plan := QueryPlan{Path: PathExactKey, Examined: 1}
+	fullKey, err := s.resolveKey(schemaName, key, match)
+	if err != nil {
+		return nil, plan, err
+	}
+	if fullKey != key {
+		switch {
+		case match&MatchIgnoreCase != 0 && strings.EqualFold(fullKey, key):
+			plan.Path = PathFoldedKey
+		case match&MatchPrefix != 0 && strings.HasPrefix(fullKey, key):
+			plan.Path = PathKeyPrefix
+		default:
+			// Every key is compared with the one given
+			plan.Path = PathFuzzyKey
+			plan.Examined = len(s.keysWithPrefix(schemaName, ""))
+		}
+	}
+
+	record, err := s.lookupTable(schemaName).Get(fullKey)
+	if err != nil {
+		return nil, plan, err
+	}
+	plan.Returned = 1
+
+	projected, err := projectRecord(record, fields)
+	return projected, plan, err
+}
+
+// Explain runs a query like Query and also reports the plan it used
+func (s *Storage) Explain(schemaName string, opts QueryOptions) ([]interface{}, QueryPlan, error) {
+	records := make([]interface{}, 0)
+	plan, err := s.Stream(schemaName, opts, func(record interface{}) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, plan, err
+	}
+	return records, plan, nil
+}
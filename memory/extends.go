@@ -0,0 +1,182 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExtendSchema creates or redefines a schema that inherits every field of
+// a parent schema, with its constraints, defaults, modifiers and options,
+// and declares fields of its own, as in AdminUser extending User with
+// role:string. The schema follows the parent: when the parent is redefined
+// the schema inherits the new definition, which its records must fit.
+func (s *Storage) ExtendSchema(name, parent, fields string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+	parentDef, exists := dbState.schemas[parent]
+	if !exists {
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", parent)
+	}
+	if _, isView := dbState.views[parent]; isView {
+		return fmt.Errorf("'%s' is a view and cannot be extended", parent)
+	}
+	if dbState.documents[parent] != "" {
+		return fmt.Errorf("schema '%s' is defined by a JSON Schema document and cannot be extended", parent)
+	}
+	for ancestor := parent; ancestor != ""; ancestor, _ = parseExtends(dbState.extends[ancestor]) {
+		if ancestor == name {
+			return fmt.Errorf("schema '%s' cannot extend '%s', which is or extends it", name, parent)
+		}
+	}
+
+	schemaDef, err := inheritDefinition(parentDef, fields)
+	if err != nil {
+		return fmt.Errorf("schema '%s' cannot extend '%s': %v", name, parent, err)
+	}
+	return s.defineSchema(name, schemaDef, "", strings.TrimSpace(parent+" "+fields))
+}
+
+// Parents returns the schema every extending schema extends, by name
+func (s *Storage) Parents() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	parents := make(map[string]string)
+	for name, extends := range s.getDBState(s.currentDB).extends {
+		parents[name], _ = parseExtends(extends)
+	}
+	return parents
+}
+
+// parseExtends splits what an extending schema is stored with into the
+// schema it extends and the fields it declares itself
+func parseExtends(extends string) (string, string) {
+	parent, fields, _ := strings.Cut(strings.TrimSpace(extends), " ")
+	return parent, strings.TrimSpace(fields)
+}
+
+// inheritDefinition returns the definition of a schema extending a parent
+// with the given definition: the fields of the parent, then its own
+// fields, then the options of both. A field declared by both is rejected.
+func inheritDefinition(parentDef, fields string) (string, error) {
+	inherited := make(map[string]bool)
+	for _, def := range parseFieldDefs(parentDef) {
+		inherited[def.name] = true
+	}
+	for _, def := range parseFieldDefs(fields) {
+		if inherited[def.name] {
+			return "", fmt.Errorf("field '%s' is already declared by the parent", def.name)
+		}
+	}
+
+	var parts, options []string
+	for _, part := range append(strings.Fields(parentDef), strings.Fields(fields)...) {
+		switch part {
+		case strictWord, ignoreCaseWord:
+			found := false
+			for _, option := range options {
+				found = found || option == part
+			}
+			if !found {
+				options = append(options, part)
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(append(parts, options...), " "), nil
+}
+
+// childSchemas returns the schemas extending a schema directly, by name
+// NOTE: This function should be called from within a locked context
+func (s *Storage) childSchemas(name string) []string {
+	var children []string
+	for child, extends := range s.getDBState(s.currentDB).extends {
+		if parent, _ := parseExtends(extends); parent == name {
+			children = append(children, child)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// inheritChildren gives the schemas extending a schema, and the schemas
+// extending those, the definition they inherit from it now. Their records
+// must fit it like those of a redefined schema; otherwise every child is
+// left as it was.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) inheritChildren(name string) error {
+	var undo []func()
+	if err := s.inheritInto(name, &undo); err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		return err
+	}
+	return nil
+}
+
+// inheritInto redefines the schemas extending a schema, recursively, and
+// adds a function restoring each one it changed to undo
+// NOTE: This function should be called from within a locked context
+func (s *Storage) inheritInto(name string, undo *[]func()) error {
+	dbState := s.getDBState(s.currentDB)
+
+	for _, child := range s.childSchemas(name) {
+		_, fields := parseExtends(dbState.extends[child])
+		schemaDef, err := inheritDefinition(dbState.schemas[name], fields)
+		if err == nil {
+			err = validateSchemaDef(schemaDef, dbState.schemas)
+		}
+		if err != nil {
+			return fmt.Errorf("schema '%s' extends '%s': %v", child, name, err)
+		}
+		previous := dbState.schemas[child]
+		if schemaDef == previous {
+			continue
+		}
+		if err := s.ensureLoaded(child); err != nil {
+			return err
+		}
+
+		version, versioned := dbState.versions[child]
+		dbState.schemas[child] = schemaDef
+		dbState.versions[child] = s.schemaVersion(child) + 1
+		folded, err := s.foldStoredFields(child)
+		*undo = append(*undo, func() {
+			for _, change := range folded {
+				s.putRecord(child, change.key, change.previous)
+			}
+			dbState.schemas[child] = previous
+			if versioned {
+				dbState.versions[child] = version
+			} else {
+				delete(dbState.versions, child)
+			}
+			s.indexSchema(child)
+		})
+
+		if err == nil {
+			err = s.indexSchema(child)
+		}
+		if err == nil {
+			err = s.checkRequired(child)
+		}
+		if err == nil {
+			err = s.checkKeys(child)
+		}
+		if err == nil {
+			err = s.checkStrict(child)
+		}
+		if err != nil {
+			return fmt.Errorf("schema '%s' extends '%s': %v", child, name, err)
+		}
+		if err := s.inheritInto(child, undo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
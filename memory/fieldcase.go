@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ignoreCaseWord is the word a schema definition holds to match the names
+// of record fields regardless of case, as in "email:string ignorecase"
+const ignoreCaseWord = "ignorecase"
+
+// isIgnoreCase reports whether a schema definition matches field names
+// regardless of case
+func isIgnoreCase(schemaDef string) bool {
+	for _, part := range strings.Fields(schemaDef) {
+		if part == ignoreCaseWord {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredNames maps the lower case form of every field a schema declares,
+// and of the fields every record holds, to the name it is declared under
+func declaredNames(schemaDef string) map[string]string {
+	names := map[string]string{
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	}
+	for _, def := range parseFieldDefs(schemaDef) {
+		names[strings.ToLower(def.name)] = def.name
+	}
+	return names
+}
+
+// foldFieldNames renames the top-level fields of a record that match a
+// declared field of an ignorecase schema in another case to the declared
+// name, and reports whether any was renamed. A record holding the same
+// field in two cases is rejected. Other schemas leave records as they are.
+func foldFieldNames(schemaDef string, record map[string]interface{}) (bool, error) {
+	if !isIgnoreCase(schemaDef) {
+		return false, nil
+	}
+	names := declaredNames(schemaDef)
+
+	fields := make([]string, 0, len(record))
+	for field := range record {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	renames := make(map[string]string)
+	spelled := make(map[string]string)
+	for _, field := range fields {
+		name, declared := names[strings.ToLower(field)]
+		if !declared {
+			continue
+		}
+		if other, seen := spelled[name]; seen {
+			return false, fmt.Errorf("fields '%s' and '%s' both set field '%s'", other, field, name)
+		}
+		spelled[name] = field
+		if field != name {
+			renames[field] = name
+		}
+	}
+
+	for field, name := range renames {
+		record[name] = record[field]
+		delete(record, field)
+	}
+	return len(renames) > 0, nil
+}
+
+// canonicalField returns the name a field, or the first part of a dotted
+// path into it, is declared under in an ignorecase schema. Other names,
+// and any name in other schemas, are returned as they are.
+func canonicalField(schemaDef, path string) string {
+	if !isIgnoreCase(schemaDef) || path == "" {
+		return path
+	}
+	head, rest, nested := strings.Cut(path, ".")
+	name, declared := declaredNames(schemaDef)[strings.ToLower(head)]
+	if !declared {
+		return path
+	}
+	if nested {
+		return name + "." + rest
+	}
+	return name
+}
+
+// foldStoredFields renames the fields of the stored records of an
+// ignorecase schema to their declared names, so records written before
+// the schema ignored case are found by their fields. It returns the
+// records it rewrote, with their previous data.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) foldStoredFields(schemaName string) ([]recordChange, error) {
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	if !isIgnoreCase(schemaDef) {
+		return nil, nil
+	}
+
+	var changes []recordChange
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		previous, ok := it.Value().(string)
+		if !ok {
+			continue
+		}
+		record, err := decodeRecord(previous)
+		if err != nil {
+			continue
+		}
+		folded, err := foldFieldNames(schemaDef, record)
+		if err != nil {
+			return nil, fmt.Errorf("record '%s': %v", it.Key(), err)
+		}
+		if !folded {
+			continue
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize record '%s': %v", it.Key(), err)
+		}
+		changes = append(changes, recordChange{key: it.Key(), previous: previous, data: string(data)})
+	}
+
+	for _, change := range changes {
+		s.putRecord(schemaName, change.key, change.data)
+	}
+	return changes, nil
+}
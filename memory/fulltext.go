@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TextMatch is a record found by a full-text search with its relevance
+// score, the number of times the search terms occur in its text fields
+type TextMatch struct {
+	Key    string
+	Record interface{}
+	Score  int
+}
+
+// SearchText looks the words of the query up in the full-text index of a
+// schema, which covers every field declared as text. Records containing
+// any of the words are returned, ranked by how often the words occur in
+// them; records with the same score are in key order.
+func (s *Storage) SearchText(schemaName, query string) ([]TextMatch, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	schemaDef, exists := dbState.schemas[schemaName]
+	if !exists {
+		return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if len(textFields(schemaDef)) == 0 {
+		return nil, fmt.Errorf("schema '%s' has no text fields", schemaName)
+	}
+
+	scores := make(map[string]int)
+	for _, term := range tokenizeText(query) {
+		for key, frequency := range dbState.textIndex[schemaName][term] {
+			scores[key] += frequency
+		}
+	}
+
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	matches := make([]TextMatch, 0, len(keys))
+	for _, key := range keys {
+		record, err := s.lookupTable(schemaName).Get(key)
+		if err != nil {
+			continue
+		}
+		matches = append(matches, TextMatch{Key: key, Record: record, Score: scores[key]})
+	}
+
+	return matches, nil
+}
+
+// tokenizeText splits text into lowercase words of letters and digits
+func tokenizeText(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// textFields returns the fields a schema declares as text, in name order
+func textFields(schemaDef string) []string {
+	var fields []string
+	for field, fieldType := range parseSchemaFields(schemaDef) {
+		if fieldType == "text" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// updateTextIndex adds the words in the text fields of a record to the
+// full-text index of its schema, or removes them again
+// NOTE: This function should be called from within a locked context
+func (s *Storage) updateTextIndex(schemaName, key string, fields map[string]interface{}, add bool) {
+	dbState := s.getDBState(s.currentDB)
+
+	fieldNames := textFields(dbState.schemas[schemaName])
+	if len(fieldNames) == 0 {
+		return
+	}
+
+	index, exists := dbState.textIndex[schemaName]
+	if !exists {
+		index = make(map[string]map[string]int)
+		dbState.textIndex[schemaName] = index
+	}
+
+	for _, field := range fieldNames {
+		text, ok := fields[field].(string)
+		if !ok {
+			continue
+		}
+		for _, term := range tokenizeText(text) {
+			if add {
+				if index[term] == nil {
+					index[term] = make(map[string]int)
+				}
+				index[term][key]++
+				continue
+			}
+
+			if index[term][key]--; index[term][key] <= 0 {
+				delete(index[term], key)
+			}
+			if len(index[term]) == 0 {
+				delete(index, term)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package memory
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// GeoMatch is a record found by a proximity search with its distance in
+// kilometres from the search point
+type GeoMatch struct {
+	Key      string
+	Record   interface{}
+	Distance float64
+}
+
+// geoIndex is a geohash index over one geo field. Its entries are sorted
+// "geohash\x00key" strings, so the records in a geohash cell are the ones
+// whose entries start with the cell's hash.
+type geoIndex struct {
+	entries []string
+}
+
+const (
+	geohashAlphabet  = "0123456789bcdefghjkmnpqrstuvwxyz"
+	geohashPrecision = 12
+	earthRadiusKm    = 6371.0088
+	kmPerDegreeLat   = 110.574
+	kmPerDegreeLon   = 111.320
+)
+
+// Near returns the records of a schema whose geo field lies within
+// radiusKm kilometres of a point, closest first. With an empty field name
+// the schema's only geo field is used. Candidates are read from the
+// geohash cells around the point and checked by great-circle distance.
+func (s *Storage) Near(schemaName, field string, lat, lon, radiusKm float64) ([]GeoMatch, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	schemaDef, exists := s.getDBState(s.currentDB).schemas[schemaName]
+	if !exists {
+		return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, fmt.Errorf("invalid point %v,%v", lat, lon)
+	}
+
+	fields := geoFields(schemaDef)
+	switch {
+	case len(fields) == 0:
+		return nil, fmt.Errorf("schema '%s' has no geo fields", schemaName)
+	case field == "" && len(fields) > 1:
+		return nil, fmt.Errorf("schema '%s' has several geo fields, choose one of %s", schemaName, strings.Join(fields, ", "))
+	case field == "":
+		field = fields[0]
+	case parseSchemaFields(schemaDef)[field] != "geo":
+		return nil, fmt.Errorf("field '%s' is not a geo field of schema '%s'", field, schemaName)
+	}
+
+	var matches []GeoMatch
+	index := s.getDBState(s.currentDB).geo[schemaName][field]
+	for _, key := range index.near(lat, lon, radiusKm) {
+		record, err := s.lookupTable(schemaName).Get(key)
+		if err != nil {
+			continue
+		}
+		fields, err := decodeRecord(record)
+		if err != nil {
+			continue
+		}
+		pointLat, pointLon, ok := geoPoint(fields[field])
+		if !ok {
+			continue
+		}
+		if distance := haversine(lat, lon, pointLat, pointLon); distance <= radiusKm {
+			matches = append(matches, GeoMatch{Key: key, Record: record, Distance: distance})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Key < matches[j].Key
+	})
+	return matches, nil
+}
+
+// geoFields returns the fields a schema declares as geo, in name order
+func geoFields(schemaDef string) []string {
+	var fields []string
+	for field, fieldType := range parseSchemaFields(schemaDef) {
+		if fieldType == "geo" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// geoPoint reads a geo field value, written either as {"lat": .., "lon": ..}
+// or as a [lat, lon] pair
+func geoPoint(value interface{}) (float64, float64, bool) {
+	var lat, lon interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) != 2 {
+			return 0, 0, false
+		}
+		lat, lon = v["lat"], v["lon"]
+	case []interface{}:
+		if len(v) != 2 {
+			return 0, 0, false
+		}
+		lat, lon = v[0], v[1]
+	default:
+		return 0, 0, false
+	}
+
+	latValue, okLat := lat.(float64)
+	lonValue, okLon := lon.(float64)
+	if !okLat || !okLon || latValue < -90 || latValue > 90 || lonValue < -180 || lonValue > 180 {
+		return 0, 0, false
+	}
+	return latValue, lonValue, true
+}
+
+// updateGeoIndexes adds a record to the geohash indexes of its schema, or
+// removes it
+// NOTE: This function should be called from within a locked context
+func (s *Storage) updateGeoIndexes(schemaName, key string, fields map[string]interface{}, add bool) {
+	dbState := s.getDBState(s.currentDB)
+
+	for _, field := range geoFields(dbState.schemas[schemaName]) {
+		lat, lon, ok := geoPoint(fields[field])
+		if !ok {
+			continue
+		}
+
+		if dbState.geo[schemaName] == nil {
+			dbState.geo[schemaName] = make(map[string]*geoIndex)
+		}
+		index := dbState.geo[schemaName][field]
+		if index == nil {
+			index = &geoIndex{}
+			dbState.geo[schemaName][field] = index
+		}
+		index.update(geohash(lat, lon, geohashPrecision)+"\x00"+key, add)
+	}
+}
+
+// update inserts an entry into the index, or removes it
+func (idx *geoIndex) update(entry string, add bool) {
+	i := sort.SearchStrings(idx.entries, entry)
+	found := i < len(idx.entries) && idx.entries[i] == entry
+	switch {
+	case add && !found:
+		idx.entries = append(idx.entries, "")
+		copy(idx.entries[i+1:], idx.entries[i:])
+		idx.entries[i] = entry
+	case !add && found:
+		idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+	}
+}
+
+// near returns the keys of the records in the geohash cells that may hold
+// points within radiusKm of a point: the cell containing the point and its
+// eight neighbours, at the finest precision whose cells are at least as
+// large as the radius. A nil index yields no keys.
+func (idx *geoIndex) near(lat, lon, radiusKm float64) []string {
+	if idx == nil {
+		return nil
+	}
+
+	// Meridians converge towards the poles, so cells are measured at the
+	// latitude furthest from the equator the search circle reaches
+	extent := math.Abs(lat) + radiusKm/kmPerDegreeLat
+	precision := 0
+	if extent < 90 {
+		for p := geohashPrecision; p > 0; p-- {
+			latDeg, lonDeg := geohashCellSize(p)
+			width := lonDeg * kmPerDegreeLon * math.Cos(extent*math.Pi/180)
+			if latDeg*kmPerDegreeLat >= radiusKm && width >= radiusKm {
+				precision = p
+				break
+			}
+		}
+	}
+
+	cells := map[string]bool{"": true}
+	if precision > 0 {
+		cells = make(map[string]bool)
+		latDeg, lonDeg := geohashCellSize(precision)
+		for dLat := -1; dLat <= 1; dLat++ {
+			for dLon := -1; dLon <= 1; dLon++ {
+				cellLat := lat + float64(dLat)*latDeg
+				if cellLat < -90 || cellLat > 90 {
+					continue
+				}
+				cellLon := math.Mod(lon+float64(dLon)*lonDeg+540, 360) - 180
+				cells[geohash(cellLat, cellLon, precision)] = true
+			}
+		}
+	}
+
+	var keys []string
+	for cell := range cells {
+		i := sort.SearchStrings(idx.entries, cell)
+		for ; i < len(idx.entries) && strings.HasPrefix(idx.entries[i], cell); i++ {
+			entry := idx.entries[i]
+			keys = append(keys, entry[strings.IndexByte(entry, 0)+1:])
+		}
+	}
+	return keys
+}
+
+// geohash encodes a point as a geohash of the given number of characters
+func geohash(lat, lon float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	var hash strings.Builder
+	bit, ch, even := 0, 0, true
+	for hash.Len() < precision {
+		rng, value := &latRange, lat
+		if even {
+			rng, value = &lonRange, lon
+		}
+		mid := (rng[0] + rng[1]) / 2
+		if value >= mid {
+			ch |= 1 << (4 - bit)
+			rng[0] = mid
+		} else {
+			rng[1] = mid
+		}
+		even = !even
+
+		if bit++; bit == 5 {
+			hash.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// geohashCellSize returns the height and width in degrees of the cells of
+// a geohash precision
+func geohashCellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lonBits))
+}
+
+// haversine returns the great-circle distance in kilometres between two
+// points
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+
+	"simplebson/preprocessing"
+)
+
+// fieldIndex is a secondary index over one field, mapping every value of
+// the field to the keys of the records holding it
+type fieldIndex map[string]map[string]bool
+
+// indexValue returns the form a field value is indexed under
+func indexValue(value interface{}) string {
+	return preprocessing.FormatValue(value)
+}
+
+// sortKeys puts the keys of records of a schema found through an index in
+// the order the schema keeps its records in
+// NOTE: This function should be called from within a locked context
+func (s *Storage) sortKeys(schemaName string, keys []string) {
+	table := s.lookupTable(schemaName)
+	sort.Slice(keys, func(i, j int) bool {
+		return table.Compare(keys[i], keys[j]) < 0
+	})
+}
+
+// indexedFields returns the fields of a schema that have a secondary index
+// NOTE: This function should be called from within a locked context
+func (s *Storage) indexedFields(schemaName string) []string {
+	return uniqueFields(s.getDBState(s.currentDB).schemas[schemaName])
+}
+
+// hasIndexes reports whether a schema has a secondary, full-text, geohash
+// or array element index
+// NOTE: This function should be called from within a locked context
+func (s *Storage) hasIndexes(schemaName string) bool {
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	return len(s.indexedFields(schemaName)) > 0 || len(textFields(schemaDef)) > 0 ||
+		len(geoFields(schemaDef)) > 0 || len(arrayFields(schemaDef)) > 0 ||
+		len(s.getDBState(s.currentDB).indexDefs[schemaName]) > 0
+}
+
+// rebuildIndexes builds the secondary, compound, full-text, geohash and
+// array element indexes of every loaded schema in the current database
+// NOTE: This function should be called from within a locked context
+func (s *Storage) rebuildIndexes() {
+	dbState := s.getDBState(s.currentDB)
+	dbState.indexes = make(map[string]map[string]fieldIndex)
+	dbState.compound = make(map[string]map[string]*compoundIndex)
+	dbState.textIndex = make(map[string]map[string]map[string]int)
+	dbState.geo = make(map[string]map[string]*geoIndex)
+	dbState.arrays = make(map[string]map[string]fieldIndex)
+
+	for schemaName := range dbState.records {
+		// Unique violations in stored data are reported when writing
+		s.indexSchema(schemaName)
+	}
+}
+
+// indexSchema builds the secondary, compound, full-text, geohash and array
+// element indexes of a single schema. It reports the first value that
+// occurs more than once in a field declared unique.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) indexSchema(schemaName string) error {
+	dbState := s.getDBState(s.currentDB)
+	delete(dbState.indexes, schemaName)
+	delete(dbState.compound, schemaName)
+	delete(dbState.textIndex, schemaName)
+	delete(dbState.geo, schemaName)
+	delete(dbState.arrays, schemaName)
+
+	if !s.hasIndexes(schemaName) {
+		return nil
+	}
+
+	var violation error
+	table := s.lookupTable(schemaName)
+	total, _ := table.Size()
+	done := 0
+	it := table.Scan("", "")
+	for it.Next() {
+		done++
+		s.Report("Indexing "+schemaName, done, total)
+		fields, err := decodeRecord(it.Value())
+		if err != nil {
+			continue
+		}
+		if violation == nil {
+			violation = s.checkUnique(schemaName, it.Key(), fields)
+		}
+		s.indexRecord(schemaName, it.Key(), fields, true)
+	}
+
+	return violation
+}
+
+// indexRecord adds a record to the indexes of its schema, or removes it
+// NOTE: This function should be called from within a locked context
+func (s *Storage) indexRecord(schemaName, key string, fields map[string]interface{}, add bool) {
+	dbState := s.getDBState(s.currentDB)
+
+	for _, field := range s.indexedFields(schemaName) {
+		value, exists := fields[field]
+		if !exists || value == nil {
+			continue
+		}
+
+		if dbState.indexes[schemaName] == nil {
+			dbState.indexes[schemaName] = make(map[string]fieldIndex)
+		}
+		index := dbState.indexes[schemaName][field]
+		if index == nil {
+			index = make(fieldIndex)
+			dbState.indexes[schemaName][field] = index
+		}
+
+		indexed := indexValue(value)
+		if add {
+			if index[indexed] == nil {
+				index[indexed] = make(map[string]bool)
+			}
+			index[indexed][key] = true
+			continue
+		}
+
+		delete(index[indexed], key)
+		if len(index[indexed]) == 0 {
+			delete(index, indexed)
+		}
+	}
+
+	for _, index := range s.compoundIndexes(schemaName) {
+		index.update(key, fields, add)
+	}
+
+	s.updateTextIndex(schemaName, key, fields, add)
+	s.updateGeoIndexes(schemaName, key, fields, add)
+	s.updateArrayIndexes(schemaName, key, fields, add)
+}
+
+// unindexRecord removes the current version of a record from the indexes
+// of its schema before it is replaced or deleted
+// NOTE: This function should be called from within a locked context
+func (s *Storage) unindexRecord(schemaName, key string) {
+	if !s.hasIndexes(schemaName) {
+		return
+	}
+
+	old, err := s.lookupTable(schemaName).Get(key)
+	if err != nil {
+		return
+	}
+	if fields, err := decodeRecord(old); err == nil {
+		s.indexRecord(schemaName, key, fields, false)
+	}
+}
+
+// checkUnique reports whether storing the record under key would give a
+// unique field a value another record already holds
+// NOTE: This function should be called from within a locked context
+func (s *Storage) checkUnique(schemaName, key string, fields map[string]interface{}) error {
+	dbState := s.getDBState(s.currentDB)
+
+	for _, field := range uniqueFields(dbState.schemas[schemaName]) {
+		value, exists := fields[field]
+		if !exists || value == nil {
+			continue
+		}
+
+		for other := range dbState.indexes[schemaName][field][indexValue(value)] {
+			if other != key {
+				return fmt.Errorf("value %s of unique field '%s' is already used by record '%s'", indexValue(value), field, other)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Inference is a schema definition proposed from sample records
+type Inference struct {
+	Definition string   // Field definitions, as schema takes them
+	Samples    int      // Number of records inspected
+	Skipped    []string // Fields left out as their values have different types, or their names hold : or spaces
+}
+
+// ParseSamples reads sample records from JSON: a single object, an array
+// of objects, or objects one after the other as in JSON Lines
+func ParseSamples(r io.Reader) ([]map[string]interface{}, error) {
+	var samples []map[string]interface{}
+	decoder := json.NewDecoder(r)
+	for {
+		var value interface{}
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+
+		values, isList := value.([]interface{})
+		if !isList {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			object, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("expected JSON objects, got %s", jsonTypeOf(v))
+			}
+			samples = append(samples, object)
+		}
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no records found")
+	}
+	return samples, nil
+}
+
+// InferSchema proposes a definition for records like the samples. Fields
+// get the narrowest type all of their values fit, and fields every sample
+// holds a value for are marked required.
+func InferSchema(samples []map[string]interface{}) Inference {
+	types := make(map[string]string)
+	counts := make(map[string]int)
+	mixed := make(map[string]bool)
+	for _, sample := range samples {
+		for name, value := range sample {
+			if value == nil {
+				continue
+			}
+			counts[name]++
+			if mixed[name] {
+				continue
+			}
+			fieldType := inferType(value)
+			if previous, seen := types[name]; seen {
+				fieldType = widenType(previous, fieldType)
+			}
+			if fieldType == "" {
+				mixed[name] = true
+			}
+			types[name] = fieldType
+		}
+	}
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inference := Inference{Samples: len(samples)}
+	var fields []string
+	for _, name := range names {
+		switch name {
+		case "created_at", "updated_at":
+			continue
+		}
+		if mixed[name] || strings.ContainsAny(name, ":=!,()[] \t\n") {
+			inference.Skipped = append(inference.Skipped, name)
+			continue
+		}
+		fieldType := types[name]
+		if fieldType == "[]" {
+			fieldType = "array"
+		}
+		field := name + ":" + fieldType
+		if counts[name] == len(samples) {
+			field += "!"
+		}
+		fields = append(fields, field)
+	}
+	inference.Definition = strings.Join(fields, " ")
+	return inference
+}
+
+// InferSchema proposes a definition for the stored records of a schema,
+// for schemas whose records hold fields the definition does not declare.
+// Declared fields keep their definitions, and the schema its strict and
+// ignorecase words; the other fields are inferred.
+func (s *Storage) InferSchema(schemaName string) (Inference, error) {
+	schemaDef, err := s.GetSchema(schemaName)
+	if err != nil {
+		return Inference{}, err
+	}
+
+	var samples []map[string]interface{}
+	err = s.Iterate(schemaName, func(key string, record interface{}) error {
+		if fields, err := decodeRecord(record); err == nil {
+			samples = append(samples, fields)
+		}
+		return nil
+	})
+	if err != nil {
+		return Inference{}, err
+	}
+	if len(samples) == 0 {
+		return Inference{}, fmt.Errorf("schema '%s' holds no records to infer from", schemaName)
+	}
+
+	declared := make(map[string]bool)
+	for _, def := range parseFieldDefs(schemaDef) {
+		declared[def.name] = true
+	}
+	for _, sample := range samples {
+		for name := range sample {
+			if declared[name] {
+				delete(sample, name)
+			}
+		}
+	}
+
+	inference := InferSchema(samples)
+	var fields []string
+	for _, part := range strings.Fields(schemaDef) {
+		if part != strictWord && part != ignoreCaseWord {
+			fields = append(fields, part)
+		}
+	}
+	if inference.Definition != "" {
+		fields = append(fields, inference.Definition)
+	}
+	if isStrict(schemaDef) {
+		fields = append(fields, strictWord)
+	}
+	if isIgnoreCase(schemaDef) {
+		fields = append(fields, ignoreCaseWord)
+	}
+	inference.Definition = strings.Join(fields, " ")
+	return inference, nil
+}
+
+// inferType returns the narrowest field type a value fits
+func inferType(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return "bool"
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return "int"
+		}
+		return "float"
+	case string:
+		if uuidPattern.MatchString(v) {
+			return "uuid"
+		}
+		if _, err := time.Parse("2006-01-02", v); err == nil {
+			return "date"
+		}
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			return "datetime"
+		}
+		return "string"
+	case []interface{}:
+		elemType := ""
+		for _, elem := range v {
+			if elem == nil {
+				return "array"
+			}
+			next := inferType(elem)
+			if elemType != "" {
+				next = widenType(elemType, next)
+			}
+			if next == "" || strings.HasPrefix(next, "[]") || next == "array" {
+				return "array"
+			}
+			elemType = next
+		}
+		// An empty array says nothing about its elements yet
+		return "[]" + elemType
+	case map[string]interface{}:
+		if _, _, ok := geoPoint(v); ok {
+			return "geo"
+		}
+		return "object"
+	}
+	return ""
+}
+
+// widenType returns the narrowest field type values of both types fit,
+// or an empty string when there is none
+func widenType(a, b string) string {
+	if a == b {
+		return a
+	}
+	pair := map[string]bool{a: true, b: true}
+	switch {
+	case a == "[]" && (strings.HasPrefix(b, "[]") || b == "array"):
+		return b
+	case b == "[]" && (strings.HasPrefix(a, "[]") || a == "array"):
+		return a
+	case pair["int"] && pair["float"]:
+		return "float"
+	case pair["date"] && pair["datetime"]:
+		return "datetime"
+	case pair["string"] && (pair["uuid"] || pair["date"] || pair["datetime"]):
+		return "string"
+	case pair["uuid"] && (pair["date"] || pair["datetime"]):
+		return "string"
+	case pair["array"] && (strings.HasPrefix(a, "[]") || strings.HasPrefix(b, "[]")):
+		return "array"
+	case strings.HasPrefix(a, "[]") && strings.HasPrefix(b, "[]"):
+		if elem := widenType(a[2:], b[2:]); elem != "" {
+			return "[]" + elem
+		}
+		return "array"
+	case pair["geo"] && pair["object"]:
+		return "object"
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"simplebson/preprocessing"
+)
+
+// Join combines the records of two schemas whose join fields hold the same
+// value. Every combined record holds the fields of both sides under their
+// schema names. Records are in key order of the left schema, then of the
+// right one. With leftJoin set, left records without a partner are returned
+// as well, with null for the right side; otherwise only pairs are returned.
+func (s *Storage) Join(leftSchema, rightSchema, leftField, rightField string, leftJoin bool) ([]interface{}, error) {
+	if leftSchema == rightSchema {
+		return nil, fmt.Errorf("cannot join schema '%s' with itself", leftSchema)
+	}
+	for _, schemaName := range []string{leftSchema, rightSchema} {
+		if err := s.loadArchived(schemaName); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	leftMatches, err := s.matchRecords(leftSchema, nil)
+	if err != nil {
+		return nil, err
+	}
+	rightMatches, err := s.matchRecords(rightSchema, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hash the right side by join value; records without one never match
+	partners := make(map[string][]queryMatch)
+	for _, match := range rightMatches {
+		if value, ok := preprocessing.LookupField(match.fields, rightField); ok && value != nil {
+			joinValue := preprocessing.FormatValue(value)
+			partners[joinValue] = append(partners[joinValue], match)
+		}
+	}
+
+	records := make([]interface{}, 0, len(leftMatches))
+	for _, left := range leftMatches {
+		var matched []queryMatch
+		if value, ok := preprocessing.LookupField(left.fields, leftField); ok && value != nil {
+			matched = partners[preprocessing.FormatValue(value)]
+		}
+
+		if len(matched) == 0 {
+			if !leftJoin {
+				continue
+			}
+			record, err := joinRecord(leftSchema, rightSchema, left.fields, nil)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+			continue
+		}
+
+		for _, right := range matched {
+			record, err := joinRecord(leftSchema, rightSchema, left.fields, right.fields)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// joinRecord encodes a combined record holding the fields of both sides
+// under their schema names
+func joinRecord(leftSchema, rightSchema string, left, right map[string]interface{}) (string, error) {
+	combined := map[string]interface{}{leftSchema: left, rightSchema: nil}
+	if right != nil {
+		combined[rightSchema] = right
+	}
+
+	data, err := json.Marshal(combined)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal joined record: %v", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,65 @@
+package memory
+
+import "simplebson/dbs"
+
+// journalRecord notes what a deferred write changed next to a record, so
+// its checksum, version and serial counter are not lost with the pending
+// changes when the process dies before they are flushed
+// NOTE: This function should be called from within a locked context
+func (s *Storage) journalRecord(schemaName, key string, deleted bool) {
+	if !s.deferred() {
+		return
+	}
+	dbState := s.getDBState(s.currentDB)
+
+	entry := dbs.JournalEntry{
+		Schema:  schemaName,
+		Key:     key,
+		Deleted: deleted,
+		Serial:  dbState.counters[schemaName],
+	}
+	if !deleted {
+		entry.Checksum = dbState.checksums[schemaName][key]
+		entry.Version = dbState.recordVersions[schemaName][key]
+	}
+
+	if err := s.getOrCreateStore(s.currentDB).AppendJournal(entry); err != nil {
+		s.config.Warnf("cannot journal record '%s' of schema '%s': %v", key, schemaName, err)
+	}
+}
+
+// replayJournal applies the writes journaled since the database was last
+// saved to the checksums, record versions and serial counters loaded from
+// its files
+// NOTE: This function should be called from within a locked context
+func (s *Storage) replayJournal(entries []dbs.JournalEntry) {
+	dbState := s.getDBState(s.currentDB)
+
+	for _, entry := range entries {
+		if entry.Deleted {
+			delete(dbState.checksums[entry.Schema], entry.Key)
+			delete(dbState.recordVersions[entry.Schema], entry.Key)
+		} else {
+			if _, exists := dbState.checksums[entry.Schema]; !exists {
+				dbState.checksums[entry.Schema] = make(map[string]string)
+			}
+			dbState.checksums[entry.Schema][entry.Key] = entry.Checksum
+
+			if entry.Version > 0 {
+				if _, exists := dbState.recordVersions[entry.Schema]; !exists {
+					dbState.recordVersions[entry.Schema] = make(map[string]int64)
+				}
+				dbState.recordVersions[entry.Schema][entry.Key] = entry.Version
+			}
+		}
+
+		if entry.Serial > dbState.counters[entry.Schema] {
+			dbState.counters[entry.Schema] = entry.Serial
+		}
+	}
+
+	// The replayed changes are written by the next save
+	if len(entries) > 0 {
+		dbState.dirty = true
+	}
+}
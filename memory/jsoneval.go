@@ -0,0 +1,653 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// jsonSchema is a parsed JSON Schema document. It evaluates the keywords
+// of drafts 4 to 2020-12 that constrain values; annotations such as title
+// and default are ignored, and references must point into the document.
+type jsonSchema struct {
+	root interface{}
+}
+
+// unsupportedKeywords are the keywords a document may not use, as values
+// could pass that the document means to reject
+var unsupportedKeywords = []string{"$dynamicRef", "$recursiveRef", "unevaluatedProperties", "unevaluatedItems"}
+
+// maxRefDepth bounds how many references are followed within one value,
+// so a reference to an enclosing schema cannot loop
+const maxRefDepth = 64
+
+// jsonSchemas caches the parsed JSON Schema documents by their text
+var jsonSchemas sync.Map
+
+// compileJSONSchema parses and checks a JSON Schema document once
+func compileJSONSchema(text string) (*jsonSchema, error) {
+	if js, ok := jsonSchemas.Load(text); ok {
+		return js.(*jsonSchema), nil
+	}
+
+	var root interface{}
+	if err := json.Unmarshal([]byte(text), &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, ok := root.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("a JSON Schema document must be an object")
+	}
+	js := &jsonSchema{root: root}
+	if err := js.check(root, "#"); err != nil {
+		return nil, err
+	}
+
+	jsonSchemas.Store(text, js)
+	return js, nil
+}
+
+// check reports keywords of a schema and the schemas within it that are
+// unsupported or malformed
+func (js *jsonSchema) check(node interface{}, at string) error {
+	if _, ok := node.(bool); ok {
+		return nil
+	}
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: a schema must be an object or a boolean", at)
+	}
+
+	for _, keyword := range unsupportedKeywords {
+		if _, used := schema[keyword]; used {
+			return fmt.Errorf("%s: keyword '%s' is not supported", at, keyword)
+		}
+	}
+	if ref, used := schema["$ref"]; used {
+		text, ok := ref.(string)
+		if !ok {
+			return fmt.Errorf("%s: $ref must be a string", at)
+		}
+		if _, err := js.resolve(text); err != nil {
+			return fmt.Errorf("%s: %v", at, err)
+		}
+	}
+	if t, used := schema["type"]; used {
+		if err := checkTypeKeyword(t); err != nil {
+			return fmt.Errorf("%s: %v", at, err)
+		}
+	}
+	if pattern, used := schema["pattern"]; used {
+		text, ok := pattern.(string)
+		if !ok {
+			return fmt.Errorf("%s: pattern must be a string", at)
+		}
+		if _, err := compilePattern(text); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %v", at, err)
+		}
+	}
+	for name := range objectKeyword(schema, "patternProperties") {
+		if _, err := compilePattern(name); err != nil {
+			return fmt.Errorf("%s: invalid pattern property: %v", at, err)
+		}
+	}
+	for _, keyword := range []string{"minimum", "maximum", "multipleOf", "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties", "minContains", "maxContains"} {
+		if value, used := schema[keyword]; used {
+			if _, ok := value.(float64); !ok {
+				return fmt.Errorf("%s: %s must be a number", at, keyword)
+			}
+		}
+	}
+	if value, used := schema["required"]; used {
+		if _, ok := stringList(value); !ok {
+			return fmt.Errorf("%s: required must be an array of strings", at)
+		}
+	}
+
+	// Subschemas
+	for _, keyword := range []string{"additionalProperties", "propertyNames", "additionalItems", "contains", "not", "if", "then", "else"} {
+		if sub, used := schema[keyword]; used {
+			if err := js.check(sub, at+"/"+keyword); err != nil {
+				return err
+			}
+		}
+	}
+	if items, used := schema["items"]; used {
+		if list, ok := items.([]interface{}); ok {
+			for i, sub := range list {
+				if err := js.check(sub, fmt.Sprintf("%s/items/%d", at, i)); err != nil {
+					return err
+				}
+			}
+		} else if err := js.check(items, at+"/items"); err != nil {
+			return err
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf", "prefixItems"} {
+		if value, used := schema[keyword]; used {
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return fmt.Errorf("%s: %s must be a non-empty array of schemas", at, keyword)
+			}
+			for i, sub := range list {
+				if err := js.check(sub, fmt.Sprintf("%s/%s/%d", at, keyword, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"properties", "patternProperties", "dependentSchemas", "$defs", "definitions", "dependencies"} {
+		for name, sub := range objectKeyword(schema, keyword) {
+			if _, isList := sub.([]interface{}); isList && keyword == "dependencies" {
+				continue
+			}
+			if err := js.check(sub, at+"/"+keyword+"/"+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkTypeKeyword reports a type keyword naming no JSON Schema type
+func checkTypeKeyword(value interface{}) error {
+	names, ok := stringList(value)
+	if text, isText := value.(string); isText {
+		names, ok = []string{text}, true
+	}
+	if !ok {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	for _, name := range names {
+		switch name {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("unknown type '%s'", name)
+		}
+	}
+	return nil
+}
+
+// resolve returns the schema a reference within the document points at,
+// written as # or a JSON pointer such as #/$defs/address
+func (js *jsonSchema) resolve(ref string) (interface{}, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("$ref '%s' does not point into the document, only local references are supported", ref)
+	}
+
+	node := js.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch v := node.(type) {
+		case map[string]interface{}:
+			next, exists := v[token]
+			if !exists {
+				return nil, fmt.Errorf("$ref '%s' points at nothing", ref)
+			}
+			node = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("$ref '%s' points at nothing", ref)
+			}
+			node = v[i]
+		default:
+			return nil, fmt.Errorf("$ref '%s' points at nothing", ref)
+		}
+	}
+	return node, nil
+}
+
+// validate reports the first way a decoded JSON value breaks the document
+func (js *jsonSchema) validate(value interface{}) error {
+	return js.eval(js.root, value, "", 0)
+}
+
+// eval reports the first way a value at a dotted path breaks a schema
+func (js *jsonSchema) eval(node interface{}, value interface{}, path string, depth int) error {
+	if allowed, ok := node.(bool); ok {
+		if !allowed {
+			return fmt.Errorf("%s is not allowed", subject(path))
+		}
+		return nil
+	}
+	schema, _ := node.(map[string]interface{})
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			return fmt.Errorf("%s nests $ref more than %d levels deep", subject(path), maxRefDepth)
+		}
+		target, err := js.resolve(ref)
+		if err != nil {
+			return err
+		}
+		if err := js.eval(target, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if t, used := schema["type"]; used {
+		names, _ := stringList(t)
+		if text, isText := t.(string); isText {
+			names = []string{text}
+		}
+		matched := false
+		for _, name := range names {
+			if hasJSONType(value, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s must be of type %s, got %s", subject(path), strings.Join(names, " or "), jsonTypeOf(value))
+		}
+	}
+	if options, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range options {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %s, got %s", subject(path), compactJSON(options), compactJSON(value))
+		}
+	}
+	if constant, used := schema["const"]; used && !reflect.DeepEqual(constant, value) {
+		return fmt.Errorf("%s must be %s, got %s", subject(path), compactJSON(constant), compactJSON(value))
+	}
+
+	var err error
+	switch v := value.(type) {
+	case float64:
+		err = evalNumber(schema, v, path)
+	case string:
+		err = evalString(schema, v, path)
+	case []interface{}:
+		err = js.evalArray(schema, v, path, depth)
+	case map[string]interface{}:
+		err = js.evalObject(schema, v, path, depth)
+	}
+	if err != nil {
+		return err
+	}
+
+	return js.evalCombinators(schema, value, path, depth)
+}
+
+// evalNumber checks the numeric keywords of a schema
+func evalNumber(schema map[string]interface{}, v float64, path string) error {
+	exclusiveMin, _ := schema["exclusiveMinimum"].(bool)
+	exclusiveMax, _ := schema["exclusiveMaximum"].(bool)
+	if min, ok := schema["minimum"].(float64); ok && (v < min || exclusiveMin && v == min) {
+		if exclusiveMin {
+			return fmt.Errorf("%s must be more than %v, got %v", subject(path), min, v)
+		}
+		return fmt.Errorf("%s must be at least %v, got %v", subject(path), min, v)
+	}
+	if max, ok := schema["maximum"].(float64); ok && (v > max || exclusiveMax && v == max) {
+		if exclusiveMax {
+			return fmt.Errorf("%s must be less than %v, got %v", subject(path), max, v)
+		}
+		return fmt.Errorf("%s must be at most %v, got %v", subject(path), max, v)
+	}
+	if min, ok := schema["exclusiveMinimum"].(float64); ok && v <= min {
+		return fmt.Errorf("%s must be more than %v, got %v", subject(path), min, v)
+	}
+	if max, ok := schema["exclusiveMaximum"].(float64); ok && v >= max {
+		return fmt.Errorf("%s must be less than %v, got %v", subject(path), max, v)
+	}
+	if step, ok := schema["multipleOf"].(float64); ok && step > 0 {
+		quotient := v / step
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			return fmt.Errorf("%s must be a multiple of %v, got %v", subject(path), step, v)
+		}
+	}
+	return nil
+}
+
+// evalString checks the string keywords of a schema
+func evalString(schema map[string]interface{}, v string, path string) error {
+	length := utf8.RuneCountInString(v)
+	if min, ok := schema["minLength"].(float64); ok && float64(length) < min {
+		return fmt.Errorf("%s must have at least %v characters, got %d", subject(path), min, length)
+	}
+	if max, ok := schema["maxLength"].(float64); ok && float64(length) > max {
+		return fmt.Errorf("%s must have at most %v characters, got %d", subject(path), max, length)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re, err := compilePattern(pattern); err == nil && !re.MatchString(v) {
+			return fmt.Errorf("%s must match pattern %s, got %q", subject(path), pattern, v)
+		}
+	}
+	if format, ok := schema["format"].(string); ok {
+		if err := checkFormat(format, v); err != nil {
+			return fmt.Errorf("%s must be in format %s, got %q", subject(path), format, v)
+		}
+	}
+	return nil
+}
+
+// checkFormat checks the formats of strings that are asserted; other
+// formats are taken as annotations
+func checkFormat(format, v string) error {
+	var err error
+	switch format {
+	case "date":
+		_, err = time.Parse("2006-01-02", v)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, v)
+	case "email":
+		err = validateEmail(v)
+	case "uri":
+		err = validateURL(v)
+	case "ipv4":
+		if ip := net.ParseIP(v); ip == nil || ip.To4() == nil || strings.Contains(v, ":") {
+			err = fmt.Errorf("not an IPv4 address")
+		}
+	case "ipv6":
+		if ip := net.ParseIP(v); ip == nil || !strings.Contains(v, ":") {
+			err = fmt.Errorf("not an IPv6 address")
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(v) {
+			err = fmt.Errorf("not a UUID")
+		}
+	case "regex":
+		_, err = regexp.Compile(v)
+	}
+	return err
+}
+
+// evalArray checks the array keywords of a schema and its elements
+func (js *jsonSchema) evalArray(schema map[string]interface{}, v []interface{}, path string, depth int) error {
+	if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+		return fmt.Errorf("%s must have at least %v elements, got %d", subject(path), min, len(v))
+	}
+	if max, ok := schema["maxItems"].(float64); ok && float64(len(v)) > max {
+		return fmt.Errorf("%s must have at most %v elements, got %d", subject(path), max, len(v))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if reflect.DeepEqual(v[i], v[j]) {
+					return fmt.Errorf("%s must hold unique elements, %s appears twice", subject(path), compactJSON(v[i]))
+				}
+			}
+		}
+	}
+
+	// Elements are checked against the schema of their position, then the
+	// schema of the remaining elements
+	prefix, _ := schema["prefixItems"].([]interface{})
+	rest, hasRest := schema["items"]
+	if tuple, ok := rest.([]interface{}); ok {
+		prefix = tuple
+		rest, hasRest = schema["additionalItems"]
+	}
+	for i, element := range v {
+		elementPath := joinPath(path, strconv.Itoa(i))
+		if i < len(prefix) {
+			if err := js.eval(prefix[i], element, elementPath, depth); err != nil {
+				return err
+			}
+		} else if hasRest {
+			if err := js.eval(rest, element, elementPath, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if contains, used := schema["contains"]; used {
+		count := 0
+		for i, element := range v {
+			if js.eval(contains, element, joinPath(path, strconv.Itoa(i)), depth) == nil {
+				count++
+			}
+		}
+		min := 1.0
+		if n, ok := schema["minContains"].(float64); ok {
+			min = n
+		}
+		if float64(count) < min {
+			return fmt.Errorf("%s must hold at least %v elements matching contains, got %d", subject(path), min, count)
+		}
+		if max, ok := schema["maxContains"].(float64); ok && float64(count) > max {
+			return fmt.Errorf("%s must hold at most %v elements matching contains, got %d", subject(path), max, count)
+		}
+	}
+	return nil
+}
+
+// evalObject checks the object keywords of a schema and its properties
+func (js *jsonSchema) evalObject(schema map[string]interface{}, v map[string]interface{}, path string, depth int) error {
+	if min, ok := schema["minProperties"].(float64); ok && float64(len(v)) < min {
+		return fmt.Errorf("%s must have at least %v fields, got %d", subject(path), min, len(v))
+	}
+	if max, ok := schema["maxProperties"].(float64); ok && float64(len(v)) > max {
+		return fmt.Errorf("%s must have at most %v fields, got %d", subject(path), max, len(v))
+	}
+	required, _ := stringList(schema["required"])
+	for _, name := range required {
+		if _, exists := v[name]; !exists {
+			return fmt.Errorf("required field '%s' is missing", joinPath(path, name))
+		}
+	}
+	for name, dependency := range objectKeyword(schema, "dependentRequired") {
+		if err := checkDependentRequired(v, name, dependency, path); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	properties := objectKeyword(schema, "properties")
+	patternProperties := objectKeyword(schema, "patternProperties")
+	additional, hasAdditional := schema["additionalProperties"]
+	for _, name := range names {
+		fieldPath := joinPath(path, name)
+		if nameSchema, used := schema["propertyNames"]; used {
+			if js.eval(nameSchema, name, "", depth) != nil {
+				return fmt.Errorf("field name '%s' is not allowed by propertyNames", fieldPath)
+			}
+		}
+
+		declared := false
+		if sub, exists := properties[name]; exists {
+			declared = true
+			if err := js.eval(sub, v[name], fieldPath, depth); err != nil {
+				return err
+			}
+		}
+		for pattern, sub := range patternProperties {
+			if re, err := compilePattern(pattern); err == nil && re.MatchString(name) {
+				declared = true
+				if err := js.eval(sub, v[name], fieldPath, depth); err != nil {
+					return err
+				}
+			}
+		}
+		if !declared && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				return fmt.Errorf("field '%s' is not declared in the JSON Schema", fieldPath)
+			}
+			if err := js.eval(additional, v[name], fieldPath, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, keyword := range []string{"dependentSchemas", "dependencies"} {
+		for name, dependency := range objectKeyword(schema, keyword) {
+			if _, exists := v[name]; !exists {
+				continue
+			}
+			if _, isList := dependency.([]interface{}); isList {
+				if err := checkDependentRequired(v, name, dependency, path); err != nil {
+					return err
+				}
+			} else if err := js.eval(dependency, v, path, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDependentRequired reports a field missing although a field that
+// requires it is present
+func checkDependentRequired(v map[string]interface{}, name string, dependency interface{}, path string) error {
+	if _, exists := v[name]; !exists {
+		return nil
+	}
+	needed, _ := stringList(dependency)
+	for _, other := range needed {
+		if _, exists := v[other]; !exists {
+			return fmt.Errorf("field '%s' is required when '%s' is present", joinPath(path, other), joinPath(path, name))
+		}
+	}
+	return nil
+}
+
+// evalCombinators checks the allOf, anyOf, oneOf, not and if/then/else
+// keywords of a schema
+func (js *jsonSchema) evalCombinators(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	if list, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range list {
+			if err := js.eval(sub, value, path, depth); err != nil {
+				return err
+			}
+		}
+	}
+	if list, ok := schema["anyOf"].([]interface{}); ok {
+		var firstErr error
+		for _, sub := range list {
+			err := js.eval(sub, value, path, depth)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s matches none of the anyOf schemas, the first fails as %v", subject(path), firstErr)
+		}
+	}
+	if list, ok := schema["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range list {
+			if js.eval(sub, value, path, depth) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s must match exactly one of the oneOf schemas, matches %d", subject(path), matched)
+		}
+	}
+	if sub, used := schema["not"]; used && js.eval(sub, value, path, depth) == nil {
+		return fmt.Errorf("%s must not match the schema under not", subject(path))
+	}
+	if condition, used := schema["if"]; used {
+		branch := "else"
+		if js.eval(condition, value, path, depth) == nil {
+			branch = "then"
+		}
+		if sub, used := schema[branch]; used {
+			if err := js.eval(sub, value, path, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasJSONType reports whether a decoded JSON value is of a JSON Schema type
+func hasJSONType(value interface{}, name string) bool {
+	if name == "integer" {
+		v, ok := value.(float64)
+		return ok && v == math.Trunc(v)
+	}
+	return jsonTypeOf(value) == name
+}
+
+// jsonTypeOf returns the JSON Schema type of a decoded JSON value
+func jsonTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// subject names the value at a dotted path in messages
+func subject(path string) string {
+	if path == "" {
+		return "record"
+	}
+	return fmt.Sprintf("field '%s'", path)
+}
+
+// joinPath appends a field name or array index to a dotted path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// objectKeyword returns the object a keyword of a schema holds
+func objectKeyword(schema map[string]interface{}, keyword string) map[string]interface{} {
+	object, _ := schema[keyword].(map[string]interface{})
+	return object
+}
+
+// stringList returns the strings of a JSON array holding only strings
+func stringList(value interface{}) ([]string, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	texts := make([]string, 0, len(list))
+	for _, item := range list {
+		text, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		texts = append(texts, text)
+	}
+	return texts, true
+}
+
+// compactJSON writes a decoded JSON value back as JSON for messages
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefineJSONSchema creates or redefines a schema from a JSON Schema
+// document instead of a definition. Records are validated against the
+// document, and the top-level properties holding a single type also make
+// up the definition of the schema, so filters, sorting and aggregates
+// treat them by type. Existing records must match the document.
+func (s *Storage) DefineJSONSchema(name string, document []byte) error {
+	text := compactDocument(document)
+	js, err := compileJSONSchema(text)
+	if err != nil {
+		return fmt.Errorf("invalid JSON Schema: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.defineSchema(name, deriveDefinition(js.root), text, "")
+}
+
+// compactDocument removes the insignificant whitespace of a JSON Schema
+// document, so documents differing only in layout are stored alike.
+// Invalid JSON is returned as it is, for compileJSONSchema to report.
+func compactDocument(document []byte) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, document); err != nil {
+		return string(document)
+	}
+	return compact.String()
+}
+
+// JSONSchema returns the JSON Schema document a schema is defined by, or
+// an empty string when it has a definition of its own
+func (s *Storage) JSONSchema(name string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+	if _, exists := dbState.schemas[name]; !exists {
+		return "", Errorf(ErrorNotFound, "schema '%s' does not exist", name)
+	}
+	return dbState.documents[name], nil
+}
+
+// deriveDefinition writes the top-level properties of a JSON Schema
+// document that hold a single, non-null type as a schema definition.
+// Required properties are marked required; other properties are left to
+// the document.
+func deriveDefinition(root interface{}) string {
+	schema, _ := root.(map[string]interface{})
+	properties := objectKeyword(schema, "properties")
+	required, _ := stringList(schema["required"])
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []string
+	for _, name := range names {
+		switch name {
+		case "created_at", "updated_at":
+			continue
+		}
+		if name == "" || strings.ContainsAny(name, ":=!,()[] \t\n") {
+			continue
+		}
+		property, _ := properties[name].(map[string]interface{})
+		jsonType, _ := property["type"].(string)
+		fieldType := map[string]string{
+			"string":  "string",
+			"integer": "int",
+			"number":  "float",
+			"boolean": "bool",
+			"array":   "array",
+			"object":  "object",
+		}[jsonType]
+		if fieldType == "" {
+			continue
+		}
+
+		field := name + ":" + fieldType
+		for _, requiredName := range required {
+			if requiredName == name {
+				field += "!"
+				break
+			}
+		}
+		fields = append(fields, field)
+	}
+	return strings.Join(fields, " ")
+}
+
+// validateDocument checks a record against the JSON Schema document of
+// its schema, if it has one. The fields the database sets on every record
+// are left out.
+func validateDocument(document string, record map[string]interface{}) error {
+	if document == "" {
+		return nil
+	}
+	js, err := compileJSONSchema(document)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{}, len(record))
+	for name, value := range record {
+		switch name {
+		case "created_at", "updated_at":
+			continue
+		}
+		fields[name] = value
+	}
+	return js.validate(fields)
+}
+
+// checkDocument reports the first stored record of a schema that does not
+// match its JSON Schema document, so a document cannot be attached while
+// records breaking it exist
+// NOTE: This function should be called from within a locked context
+func (s *Storage) checkDocument(schemaName string) error {
+	document := s.getDBState(s.currentDB).documents[schemaName]
+	if document == "" {
+		return nil
+	}
+
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		record, err := decodeRecord(it.Value())
+		if err != nil {
+			continue
+		}
+		if err := validateDocument(document, record); err != nil {
+			return fmt.Errorf("record '%s' does not match the JSON Schema: %v", it.Key(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package memory
+
+import "strconv"
+
+// generateKey fills in the key of a new record when the field it would be
+// taken from is declared uuid or serial and left out, and returns the
+// generated key. The key is taken from the field the schema declares key,
+// or else from the first of id, name and key the record holds, so a
+// generated field only becomes the key when no field before it is present.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) generateKey(schemaName string, record map[string]interface{}) (string, error) {
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	types := parseSchemaFields(schemaDef)
+	candidates := []string{"id", "name", "key"}
+	if field := keyField(schemaDef); field != "" {
+		candidates = []string{field}
+	}
+	for _, field := range candidates {
+		if value, exists := record[field]; exists {
+			if types[field] == "serial" {
+				s.advanceSerial(schemaName, value)
+			}
+			return "", nil
+		}
+
+		switch types[field] {
+		case "uuid":
+			key, err := newUUID()
+			if err != nil {
+				return "", err
+			}
+			record[field] = key
+			return key, nil
+		case "serial":
+			serial := s.nextSerial(schemaName)
+			record[field] = serial
+			// Formatted the way the key is extracted from the record
+			return formatKey(serial), nil
+		}
+	}
+	return "", nil
+}
+
+// nextSerial assigns the next serial key of a schema. The counter is saved
+// with the database, so keys keep counting up across runs and are not
+// reused after their records are deleted.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) nextSerial(schemaName string) int64 {
+	dbState := s.getDBState(s.currentDB)
+	dbState.counters[schemaName]++
+	return dbState.counters[schemaName]
+}
+
+// advanceSerial moves the serial counter of a schema past a key a record
+// brings itself, so later generated keys cannot collide with it
+// NOTE: This function should be called from within a locked context
+func (s *Storage) advanceSerial(schemaName string, value interface{}) {
+	// The key holds the number the way formatKey writes it
+	number, err := strconv.ParseInt(formatKey(value), 10, 64)
+	if err != nil {
+		return
+	}
+	dbState := s.getDBState(s.currentDB)
+	if number > dbState.counters[schemaName] {
+		dbState.counters[schemaName] = number
+	}
+}
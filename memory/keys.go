@@ -0,0 +1,273 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KeyMatch selects how a key given by the user is matched against the keys
+// of stored records. Modes other than MatchExact may be combined.
+type KeyMatch int
+
+const (
+	// MatchExact only accepts the key of a stored record as given
+	MatchExact KeyMatch = 0
+	// MatchPrefix also accepts the prefix of exactly one stored key
+	MatchPrefix KeyMatch = 1 << (iota - 1)
+	// MatchFuzzy also accepts a key within the configured edit distance of
+	// a stored key, as long as one stored key is closer than all others
+	MatchFuzzy
+	// MatchIgnoreCase also accepts a key differing from exactly one stored
+	// key only in case, and makes fuzzy matching ignore case
+	MatchIgnoreCase
+)
+
+// Keys returns the keys of a schema that start with the prefix, in key
+// order. An empty prefix returns every key.
+func (s *Storage) Keys(schemaName, prefix string) ([]string, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, exists := s.getDBState(s.currentDB).schemas[schemaName]; !exists {
+		return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+
+	return s.keysWithPrefix(schemaName, prefix), nil
+}
+
+// Exists reports whether a record matches the key, matched as the KeyMatch
+// asks. A key matching several records is an error rather than false.
+func (s *Storage) Exists(schemaName, key string, match KeyMatch) (bool, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return false, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, exists := s.getDBState(s.currentDB).schemas[schemaName]; !exists {
+		return false, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+
+	_, found, err := s.findKey(schemaName, key, match)
+	return found, err
+}
+
+// keysWithPrefix returns the keys of a schema starting with the prefix, in
+// key order. Keys in byte order are scanned from the prefix onwards up to
+// the first key that no longer starts with it; in another order, such as
+// that of numeric keys, they need not follow each other and every key is
+// checked.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) keysWithPrefix(schemaName, prefix string) []string {
+	keys := make([]string, 0)
+	table := s.lookupTable(schemaName)
+	contiguous := table.Lexicographic()
+	start := ""
+	if contiguous {
+		start = prefix
+	}
+	it := table.Scan(start, "")
+	for it.Next() {
+		if !strings.HasPrefix(it.Key(), prefix) {
+			if contiguous {
+				break
+			}
+			continue
+		}
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+// resolveKey maps a key given by the user to the key of an existing record,
+// failing when no record matches
+// NOTE: This function should be called from within a locked context
+func (s *Storage) resolveKey(schemaName string, key string, match KeyMatch) (string, error) {
+	if _, exists := s.getDBState(s.currentDB).schemas[schemaName]; !exists {
+		return "", Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+
+	fullKey, found, err := s.findKey(schemaName, key, match)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", Errorf(ErrorNotFound, "record with key '%s' does not exist in schema '%s'", key, schemaName)
+	}
+	return fullKey, nil
+}
+
+// findKey looks up the stored key a key given by the user matches. A stored
+// key equal to the given one always wins; the modes of the KeyMatch are
+// tried next. It reports false when no record matches, and an error when
+// several records match equally well.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) findKey(schemaName string, key string, match KeyMatch) (string, bool, error) {
+	if _, err := s.lookupTable(schemaName).Get(key); err == nil {
+		return key, true, nil
+	}
+
+	if match&MatchIgnoreCase != 0 {
+		candidates := s.foldedKeys(schemaName, key)
+		if len(candidates) == 1 {
+			return candidates[0], true, nil
+		}
+		if len(candidates) > 1 {
+			return "", false, Errorf(ErrorAmbiguous, "multiple records match key '%s' ignoring case in schema '%s': %v", key, schemaName, candidates)
+		}
+	}
+
+	if match&MatchPrefix != 0 && key != "" {
+		candidates := s.keysWithPrefix(schemaName, key)
+		if len(candidates) == 1 {
+			return candidates[0], true, nil
+		}
+		if len(candidates) > 1 {
+			return "", false, Errorf(ErrorAmbiguous, "multiple records match prefix '%s' in schema '%s': %v", key, schemaName, candidates)
+		}
+	}
+
+	if match&MatchFuzzy != 0 {
+		candidates := s.closeKeys(schemaName, key, s.config.FuzzyDistance, match&MatchIgnoreCase != 0)
+		if len(candidates) == 1 || len(candidates) > 1 && candidates[0].distance < candidates[1].distance {
+			return candidates[0].key, true, nil
+		}
+		if len(candidates) > 1 {
+			listed := make([]string, len(candidates))
+			for i, candidate := range candidates {
+				listed[i] = fmt.Sprintf("%s (distance %d)", candidate.key, candidate.distance)
+			}
+			return "", false, Errorf(ErrorAmbiguous, "multiple records are close to key '%s' in schema '%s': %s", key, schemaName, strings.Join(listed, ", "))
+		}
+	}
+
+	return "", false, nil
+}
+
+// keyCandidate is a stored key close to a key given by the user
+type keyCandidate struct {
+	key      string
+	distance int
+}
+
+// closeKeys returns the keys of a schema within maxDistance edits of the
+// key, closest first and in key order among equally close ones. With
+// ignoreCase set, keys are compared in lower case.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) closeKeys(schemaName, key string, maxDistance int, ignoreCase bool) []keyCandidate {
+	if ignoreCase {
+		key = strings.ToLower(key)
+	}
+
+	var candidates []keyCandidate
+	for _, stored := range s.keysWithPrefix(schemaName, "") {
+		compared := stored
+		if ignoreCase {
+			compared = strings.ToLower(stored)
+		}
+		if distance := editDistance(key, compared); distance <= maxDistance {
+			candidates = append(candidates, keyCandidate{key: stored, distance: distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	return candidates
+}
+
+// editDistance returns the Levenshtein distance between a and b, the
+// number of single character insertions, deletions and substitutions
+// needed to turn one into the other, with a swap of two adjacent
+// characters counted as a single edit since it is the most common typo
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(ra)][len(rb)]
+}
+
+// foldedKeys returns the stored keys equal to the key ignoring case, in
+// key order
+// NOTE: This function should be called from within a locked context
+func (s *Storage) foldedKeys(schemaName, key string) []string {
+	matches := s.getDBState(s.currentDB).folded[schemaName][strings.ToLower(key)]
+	keys := make([]string, 0, len(matches))
+	for stored := range matches {
+		keys = append(keys, stored)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rebuildFoldedKeys builds the case-insensitive key index of every loaded
+// schema in the current database
+// NOTE: This function should be called from within a locked context
+func (s *Storage) rebuildFoldedKeys() {
+	dbState := s.getDBState(s.currentDB)
+	dbState.folded = make(map[string]map[string]map[string]bool)
+
+	for schemaName := range dbState.records {
+		s.indexFoldedKeys(schemaName)
+	}
+}
+
+// indexFoldedKeys builds the case-insensitive key index of a single schema
+// NOTE: This function should be called from within a locked context
+func (s *Storage) indexFoldedKeys(schemaName string) {
+	delete(s.getDBState(s.currentDB).folded, schemaName)
+	for _, key := range s.lookupTable(schemaName).Keys() {
+		s.updateFoldedKey(schemaName, key, true)
+	}
+}
+
+// updateFoldedKey adds a key to the case-insensitive key index of its
+// schema, or removes it
+// NOTE: This function should be called from within a locked context
+func (s *Storage) updateFoldedKey(schemaName, key string, add bool) {
+	dbState := s.getDBState(s.currentDB)
+
+	index, exists := dbState.folded[schemaName]
+	if !exists {
+		index = make(map[string]map[string]bool)
+		dbState.folded[schemaName] = index
+	}
+
+	folded := strings.ToLower(key)
+	if add {
+		if index[folded] == nil {
+			index[folded] = make(map[string]bool)
+		}
+		index[folded][key] = true
+		return
+	}
+
+	delete(index[folded], key)
+	if len(index[folded]) == 0 {
+		delete(index, folded)
+	}
+}
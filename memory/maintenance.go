@@ -0,0 +1,94 @@
+package memory
+
+import "sort"
+
+// Progress is told how many of the total items of a long operation, such
+// as the records of a schema being indexed, are done. Total is zero when
+// it is not known in advance.
+type Progress func(label string, done, total int)
+
+// SetProgress sets the function long operations report how far they got
+// to, or none when nil
+func (s *Storage) SetProgress(progress Progress) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.progress = progress
+}
+
+// Report tells the progress function how far an operation got, for those
+// the caller runs record by record such as adding a batch of records
+func (s *Storage) Report(label string, done, total int) {
+	if s.progress != nil {
+		s.progress(label, done, total)
+	}
+}
+
+// Reindex rebuilds the indexes of the given schemas, or of every schema
+// when none is given, from their records. It reports the first value held
+// twice in a field declared unique.
+func (s *Storage) Reindex(schemaNames ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schemaNames, err := s.schemaList(schemaNames)
+	if err != nil {
+		return err
+	}
+	for _, name := range schemaNames {
+		if err := s.ensureLoaded(name); err != nil {
+			return err
+		}
+		if err := s.indexSchema(name); err != nil {
+			return Errorf(ErrorValidation, "schema '%s': %v", name, err)
+		}
+	}
+	s.rebuildFoldedKeys()
+	return nil
+}
+
+// Compact merges the SSTables of the given schemas, or of every schema
+// when none is given, into a single one each, dropping overwritten values
+// and deleted records
+func (s *Storage) Compact(schemaNames ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schemaNames, err := s.schemaList(schemaNames)
+	if err != nil {
+		return err
+	}
+	for i, name := range schemaNames {
+		// Archived schemas have no SSTables until they are used again
+		if table, loaded := s.getDBState(s.currentDB).records[name]; loaded {
+			if err := table.Flush(); err != nil {
+				return Errorf(ErrorIO, "schema '%s': %v", name, err)
+			}
+			if err := table.Compact(); err != nil {
+				return Errorf(ErrorIO, "schema '%s': %v", name, err)
+			}
+		}
+		s.Report("Compacting", i+1, len(schemaNames))
+	}
+	return nil
+}
+
+// schemaList returns the given schema names in name order, or every schema
+// of the current database when none is given, failing on one that does
+// not exist
+// NOTE: This function should be called from within a locked context
+func (s *Storage) schemaList(schemaNames []string) ([]string, error) {
+	dbState := s.getDBState(s.currentDB)
+	if len(schemaNames) == 0 {
+		for name := range dbState.schemas {
+			schemaNames = append(schemaNames, name)
+		}
+	}
+	for _, name := range schemaNames {
+		if _, exists := dbState.schemas[name]; !exists {
+			return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", name)
+		}
+	}
+	sort.Strings(schemaNames)
+	return schemaNames, nil
+}
@@ -0,0 +1,72 @@
+package memory
+
+// normalizeFields rewrites the date, time, decimal, bytes and uuid fields
+// of a record into their canonical form, including those in typed arrays
+// and in objects typed with a schema, and reports whether any changed.
+// Values that cannot be parsed are left for validation to reject.
+func normalizeFields(schemaDef string, record map[string]interface{}, schemas map[string]string) bool {
+	changed := false
+	for field, fieldType := range parseSchemaFields(schemaDef) {
+		value, exists := record[field]
+		if !exists {
+			continue
+		}
+		if normalized, ok := normalizeValue(value, fieldType, schemas); ok {
+			record[field] = normalized
+			changed = true
+		}
+	}
+	return changed
+}
+
+// normalizeValue returns the canonical form of a value of the given type,
+// or false when it is already canonical or has no canonical form
+func normalizeValue(value interface{}, fieldType string, schemas map[string]string) (interface{}, bool) {
+	if isTimeType(fieldType) {
+		text, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		canonical, ok := canonicalTime(fieldType, text)
+		return canonical, ok && canonical != text
+	}
+
+	if fieldType == "decimal" {
+		canonical, ok := canonicalDecimal(value)
+		return canonical, ok && canonical != value
+	}
+
+	if fieldType == "bytes" {
+		canonical, ok := canonicalBytes(value)
+		return canonical, ok && canonical != value
+	}
+
+	if fieldType == "uuid" {
+		canonical, ok := canonicalUUID(value)
+		return canonical, ok && canonical != value
+	}
+
+	if elemType, ok := elementType(fieldType); ok {
+		elements, ok := value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		changed := false
+		for i, element := range elements {
+			if normalized, ok := normalizeValue(element, elemType, schemas); ok {
+				elements[i] = normalized
+				changed = true
+			}
+		}
+		return elements, changed
+	}
+
+	if schemaDef, ok := schemas[fieldType]; ok {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		return object, normalizeFields(schemaDef, object, schemas)
+	}
+	return nil, false
+}
@@ -0,0 +1,372 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"simplebson/preprocessing"
+)
+
+// Pipeline runs an aggregation pipeline over the records of a schema and
+// returns the documents its last stage produces, encoded as JSON. The
+// $match stages the pipeline starts with select the records to read, using
+// an index when they can. The remaining stages handle the records one at a
+// time as they are read, in a single pass: only $group and $sort hold
+// documents until the scan ends, and a $limit that is satisfied stops it.
+//
+// Stages before the first $group compare fields by their schema types;
+// later stages see group documents, whose fields are untyped.
+func (s *Storage) Pipeline(schemaName string, stages []preprocessing.PipelineStage) ([]interface{}, error) {
+	s.mutex.RLock()
+	schemaDef, exists := s.getDBState(s.currentDB).schemas[schemaName]
+	s.mutex.RUnlock()
+	if !exists {
+		return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	types := fieldTypes(schemaDef)
+
+	var source preprocessing.Filter
+	for len(stages) > 0 && stages[0].Kind == "match" {
+		source = preprocessing.All(source, stages[0].Filter)
+		stages = stages[1:]
+	}
+
+	sink := &pipelineSink{}
+	first, err := buildPipeline(stages, types, sink)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.Stream(schemaName, QueryOptions{Filter: source}, func(record interface{}) error {
+		fields, err := decodeRecord(record)
+		if err != nil {
+			return err
+		}
+		more, err := first.push(fields)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	if err := first.finish(); err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, 0, len(sink.documents))
+	for _, document := range sink.documents {
+		data, err := json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal pipeline result: %v", err)
+		}
+		results = append(results, string(data))
+	}
+	return results, nil
+}
+
+// pipelineStep is a running pipeline stage. Documents are pushed through
+// the steps in order; push reports false once the step, or a later one,
+// needs no more documents. finish is called when the input ends, so steps
+// holding documents can hand them on.
+type pipelineStep interface {
+	push(document map[string]interface{}) (bool, error)
+	finish() error
+}
+
+// buildPipeline chains the steps running the stages, ending in the sink
+func buildPipeline(stages []preprocessing.PipelineStage, types map[string]string, sink pipelineStep) (pipelineStep, error) {
+	// Steps are chained back to front, so first note which stages still
+	// see records rather than group documents
+	typed := make([]bool, len(stages))
+	grouped := false
+	for i, stage := range stages {
+		typed[i] = !grouped
+		if stage.Kind == "group" {
+			grouped = true
+		}
+	}
+
+	next := sink
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage := stages[i]
+		switch stage.Kind {
+		case "match":
+			filter := stage.Filter
+			if typed[i] && filter != nil {
+				filter = preprocessing.WithFieldTypes(filter, types)
+			}
+			next = &matchStep{filter: filter, next: next}
+		case "project":
+			next = &projectStep{fields: stage.Fields, next: next}
+		case "group":
+			numeric := make(map[string]bool, len(stage.Accumulators))
+			for _, accumulator := range stage.Accumulators {
+				numeric[accumulator.Field] = typed[i] && isNumericType(types[accumulator.Field])
+			}
+			next = &groupStep{stage: stage, numeric: numeric, groups: make(map[string]*groupState), next: next}
+		case "sort":
+			next = &sortStep{keys: stage.Sort, next: next}
+		case "skip":
+			next = &skipStep{count: stage.Count, next: next}
+		case "limit":
+			next = &limitStep{count: stage.Count, next: next}
+		default:
+			return nil, fmt.Errorf("unknown pipeline stage '%s'", stage.Kind)
+		}
+	}
+	return next, nil
+}
+
+// pipelineSink collects the documents leaving the last stage
+type pipelineSink struct {
+	documents []map[string]interface{}
+}
+
+func (s *pipelineSink) push(document map[string]interface{}) (bool, error) {
+	s.documents = append(s.documents, document)
+	return true, nil
+}
+
+func (s *pipelineSink) finish() error {
+	return nil
+}
+
+// matchStep passes on the documents matching a filter
+type matchStep struct {
+	filter preprocessing.Filter
+	next   pipelineStep
+}
+
+func (m *matchStep) push(document map[string]interface{}) (bool, error) {
+	if m.filter != nil && !m.filter.Match(document) {
+		return true, nil
+	}
+	return m.next.push(document)
+}
+
+func (m *matchStep) finish() error {
+	return m.next.finish()
+}
+
+// projectStep passes on only some fields of every document
+type projectStep struct {
+	fields []string
+	next   pipelineStep
+}
+
+func (p *projectStep) push(document map[string]interface{}) (bool, error) {
+	return p.next.push(projectMap(document, p.fields))
+}
+
+func (p *projectStep) finish() error {
+	return p.next.finish()
+}
+
+// skipStep drops the first documents
+type skipStep struct {
+	count   int
+	skipped int
+	next    pipelineStep
+}
+
+func (s *skipStep) push(document map[string]interface{}) (bool, error) {
+	if s.skipped < s.count {
+		s.skipped++
+		return true, nil
+	}
+	return s.next.push(document)
+}
+
+func (s *skipStep) finish() error {
+	return s.next.finish()
+}
+
+// limitStep passes on the first documents and then asks for no more
+type limitStep struct {
+	count  int
+	passed int
+	next   pipelineStep
+}
+
+func (l *limitStep) push(document map[string]interface{}) (bool, error) {
+	if l.passed >= l.count {
+		return false, nil
+	}
+	l.passed++
+	more, err := l.next.push(document)
+	return more && l.passed < l.count, err
+}
+
+func (l *limitStep) finish() error {
+	return l.next.finish()
+}
+
+// sortStep holds every document and passes them on in order once the
+// input ends. Documents without a sort field come last, and documents
+// that compare equal keep their order.
+type sortStep struct {
+	keys      []preprocessing.SortKey
+	documents []map[string]interface{}
+	next      pipelineStep
+}
+
+func (s *sortStep) push(document map[string]interface{}) (bool, error) {
+	s.documents = append(s.documents, document)
+	return true, nil
+}
+
+func (s *sortStep) finish() error {
+	sort.SliceStable(s.documents, func(i, j int) bool {
+		for _, key := range s.keys {
+			a, hasA := preprocessing.LookupField(s.documents[i], key.Field)
+			b, hasB := preprocessing.LookupField(s.documents[j], key.Field)
+			hasA = hasA && a != nil
+			hasB = hasB && b != nil
+			if !hasA || !hasB {
+				if hasA != hasB {
+					return hasA
+				}
+				continue
+			}
+
+			order := compareFieldValues(a, b, false)
+			if order == 0 {
+				continue
+			}
+			if key.Descending {
+				return order > 0
+			}
+			return order < 0
+		}
+		return false
+	})
+
+	for _, document := range s.documents {
+		more, err := s.next.push(document)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return s.next.finish()
+}
+
+// groupState holds the running accumulators of one group
+type groupState struct {
+	id     interface{}
+	counts []int
+	values []float64
+}
+
+// groupStep accumulates the documents of every group and passes on one
+// document per group, ordered by group value, once the input ends
+type groupStep struct {
+	stage   preprocessing.PipelineStage
+	numeric map[string]bool // Accumulated fields declared numeric, whose strings are parsed
+	groups  map[string]*groupState
+	next    pipelineStep
+}
+
+func (g *groupStep) push(document map[string]interface{}) (bool, error) {
+	var id interface{}
+	if g.stage.GroupBy != "" {
+		id, _ = preprocessing.LookupField(document, g.stage.GroupBy)
+	}
+	name := preprocessing.FormatValue(id)
+
+	group, exists := g.groups[name]
+	if !exists {
+		accumulators := len(g.stage.Accumulators)
+		group = &groupState{id: id, counts: make([]int, accumulators), values: make([]float64, accumulators)}
+		g.groups[name] = group
+	}
+
+	for i, accumulator := range g.stage.Accumulators {
+		if accumulator.Function == "count" {
+			group.counts[i]++
+			continue
+		}
+
+		value, exists := preprocessing.LookupField(document, accumulator.Field)
+		if !exists || value == nil {
+			continue
+		}
+		number, ok := toNumber(value, g.numeric[accumulator.Field])
+		if !ok {
+			return false, fmt.Errorf("non-numeric value %v in field '%s'", value, accumulator.Field)
+		}
+
+		switch {
+		case group.counts[i] == 0 && (accumulator.Function == "min" || accumulator.Function == "max"):
+			group.values[i] = number
+		case accumulator.Function == "min":
+			if number < group.values[i] {
+				group.values[i] = number
+			}
+		case accumulator.Function == "max":
+			if number > group.values[i] {
+				group.values[i] = number
+			}
+		default:
+			group.values[i] += number
+		}
+		group.counts[i]++
+	}
+	return true, nil
+}
+
+func (g *groupStep) finish() error {
+	groups := make([]*groupState, 0, len(g.groups))
+	for _, group := range g.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].id == nil || groups[j].id == nil {
+			return groups[j].id == nil && groups[i].id != nil
+		}
+		return compareFieldValues(groups[i].id, groups[j].id, false) < 0
+	})
+
+	for _, group := range groups {
+		document := map[string]interface{}{"_id": group.id}
+		for i, accumulator := range g.stage.Accumulators {
+			document[accumulator.Name] = group.result(i, accumulator.Function)
+		}
+		more, err := g.next.push(document)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return g.next.finish()
+}
+
+// result returns the value of an accumulator of the group. min, max and
+// avg are null when no document had a value.
+func (group *groupState) result(i int, function string) interface{} {
+	switch function {
+	case "count":
+		return group.counts[i]
+	case "sum":
+		return group.values[i]
+	case "avg":
+		if group.counts[i] == 0 {
+			return nil
+		}
+		return group.values[i] / float64(group.counts[i])
+	}
+	if group.counts[i] == 0 {
+		return nil
+	}
+	return group.values[i]
+}
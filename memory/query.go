@@ -0,0 +1,283 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"simplebson/preprocessing"
+)
+
+// QueryOptions controls which records a query returns and how they are
+// shaped
+type QueryOptions struct {
+	Filter         preprocessing.Filter // Records to return, all when nil
+	Fields         []string             // Fields to keep in each record, all when empty
+	SortField      string               // Field to order records by, key order when empty
+	SortDescending bool
+	Limit          int     // Maximum number of records to return, no limit when 0
+	Offset         int     // Number of matching records to skip
+	After          *Cursor // Only return records after this position, from a previous page
+}
+
+// queryMatch is a record selected by a query together with its decoded
+// fields
+type queryMatch struct {
+	key    string
+	record interface{}
+	fields map[string]interface{}
+}
+
+// Query returns the records of a schema that match the options. Records
+// are in key order unless a sort field is given. The page selected by
+// Offset and Limit is cut before the records are projected.
+func (s *Storage) Query(schemaName string, opts QueryOptions) ([]interface{}, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	matches, err := s.matchRecords(schemaName, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	records, _, err := s.shapeMatches(schemaName, matches, opts)
+	return records, err
+}
+
+// shapeMatches sorts, pages and projects matched records as the options
+// ask. It also returns the cursor after the last record when the limit
+// left matches out.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) shapeMatches(schemaName string, matches []queryMatch, opts QueryOptions) ([]interface{}, *Cursor, error) {
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	opts = canonicalOptions(schemaDef, opts)
+	fieldType := parseSchemaFields(schemaDef)[opts.SortField]
+	if opts.SortField != "" {
+		sortMatches(matches, opts.SortField, fieldType, opts.SortDescending)
+	}
+
+	if opts.After != nil {
+		if err := opts.After.check(schemaName, opts); err != nil {
+			return nil, nil, err
+		}
+		start := 0
+		compareKeys := s.lookupTable(schemaName).Compare
+		for start < len(matches) && !opts.After.after(matches[start], isNumericType(fieldType), compareKeys) {
+			start++
+		}
+		matches = matches[start:]
+	}
+
+	page := paginate(matches, opts.Offset, opts.Limit)
+	var next *Cursor
+	if opts.Limit > 0 && len(page) > 0 && opts.Offset+len(page) < len(matches) {
+		next = newCursor(schemaName, page[len(page)-1], opts)
+	}
+
+	records := make([]interface{}, 0, len(page))
+	for _, match := range page {
+		if len(opts.Fields) == 0 {
+			records = append(records, match.record)
+			continue
+		}
+		projected, err := projectFields(match.fields, opts.Fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, projected)
+	}
+
+	return records, next, nil
+}
+
+// matchRecords returns the records of a schema that match the filter, in
+// key order. Fields are compared according to their schema types. A nil
+// filter matches every record.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) matchRecords(schemaName string, filter preprocessing.Filter) ([]queryMatch, error) {
+	matches, _, err := s.planRecords(schemaName, filter)
+	return matches, err
+}
+
+// planRecords matches records like matchRecords and also returns the plan
+// that found them
+// NOTE: This function should be called from within a locked context
+func (s *Storage) planRecords(schemaName string, filter preprocessing.Filter) ([]queryMatch, QueryPlan, error) {
+	dbState := s.getDBState(s.currentDB)
+
+	schemaDef, exists := dbState.schemas[schemaName]
+	if !exists {
+		return nil, QueryPlan{}, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	filter = schemaFilter(schemaDef, filter)
+
+	// An index narrows the records down to candidates, which are checked
+	// against the whole filter like scanned records
+	if filter != nil {
+		if keys, index, ok := s.indexLookup(schemaName, filter); ok {
+			plan := QueryPlan{Path: PathSecondaryIndex, Index: index}
+			matches := make([]queryMatch, 0, len(keys))
+			for _, key := range keys {
+				record, err := s.lookupTable(schemaName).Get(key)
+				if err != nil {
+					continue
+				}
+				plan.Examined++
+				match, ok, err := matchRecord(key, record, filter, s.recordVersion(schemaName, key))
+				if err != nil {
+					return nil, plan, err
+				}
+				if ok {
+					matches = append(matches, match)
+				}
+			}
+			plan.Returned = len(matches)
+			return matches, plan, nil
+		}
+	}
+
+	plan := QueryPlan{Path: PathFullScan}
+	matches := make([]queryMatch, 0)
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		plan.Examined++
+		match, ok, err := matchRecord(it.Key(), it.Value(), filter, s.recordVersion(schemaName, it.Key()))
+		if err != nil {
+			return nil, plan, err
+		}
+		if ok {
+			matches = append(matches, match)
+		}
+	}
+
+	plan.Returned = len(matches)
+	return matches, plan, nil
+}
+
+// matchRecord decodes a record and reports whether it matches the filter,
+// given the schema version the record was written under
+func matchRecord(key string, record interface{}, filter preprocessing.Filter, version int64) (queryMatch, bool, error) {
+	fields, err := decodeRecord(record)
+	if err != nil {
+		return queryMatch{}, false, fmt.Errorf("failed to decode record '%s': %v", key, err)
+	}
+	if filter != nil && !matchVersioned(filter, fields, version) {
+		return queryMatch{}, false, nil
+	}
+	return queryMatch{key: key, record: record, fields: fields}, true, nil
+}
+
+// schemaFilter returns a copy of a filter that compares the fields of a
+// schema according to their types, and looks up the fields of an
+// ignorecase schema under their declared names. A nil filter stays nil.
+func schemaFilter(schemaDef string, filter preprocessing.Filter) preprocessing.Filter {
+	if filter == nil {
+		return nil
+	}
+	if isIgnoreCase(schemaDef) {
+		filter = preprocessing.WithFieldNames(filter, func(path string) string {
+			return canonicalField(schemaDef, path)
+		})
+	}
+	return preprocessing.WithFieldTypes(filter, fieldTypes(schemaDef))
+}
+
+// canonicalOptions returns query options whose sort field and projected
+// fields are written under their declared names in an ignorecase schema
+func canonicalOptions(schemaDef string, opts QueryOptions) QueryOptions {
+	if !isIgnoreCase(schemaDef) {
+		return opts
+	}
+	opts.SortField = canonicalField(schemaDef, opts.SortField)
+	fields := make([]string, len(opts.Fields))
+	for i, name := range opts.Fields {
+		fields[i] = canonicalField(schemaDef, name)
+	}
+	opts.Fields = fields
+	return opts
+}
+
+// fieldTypes returns the type of every field of a schema, including the
+// timestamps added to every record and the schema version filters see
+func fieldTypes(schemaDef string) map[string]string {
+	types := parseSchemaFields(schemaDef)
+	for _, field := range []string{"created_at", "updated_at"} {
+		if _, declared := types[field]; !declared {
+			types[field] = "datetime"
+		}
+	}
+	if _, declared := types[versionField]; !declared {
+		types[versionField] = "int"
+	}
+	return types
+}
+
+// paginate returns the matches left after skipping offset of them, at most
+// limit of them when limit is not 0
+func paginate(matches []queryMatch, offset, limit int) []queryMatch {
+	if offset >= len(matches) {
+		return matches[:0]
+	}
+	matches = matches[offset:]
+	if limit > 0 && limit < len(matches) {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// decodeRecord parses a stored record into its fields
+func decodeRecord(record interface{}) (map[string]interface{}, error) {
+	data, ok := record.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected record type %T", record)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON format: %v", err)
+	}
+
+	return fields, nil
+}
+
+// projectRecord reduces a stored record to the given fields. With no fields
+// the record is returned unchanged.
+func projectRecord(record interface{}, names []string) (interface{}, error) {
+	if len(names) == 0 {
+		return record, nil
+	}
+
+	fields, err := decodeRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	return projectFields(fields, names)
+}
+
+// projectFields encodes only the named fields of a decoded record. Fields
+// the record does not have are left out, and dotted paths into nested
+// objects are encoded nested in the same shape.
+func projectFields(fields map[string]interface{}, names []string) (string, error) {
+	data, err := json.Marshal(projectMap(fields, names))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal projected record: %v", err)
+	}
+	return string(data), nil
+}
+
+// projectMap returns only the named fields of a decoded record, nesting
+// dotted paths as projectFields does
+func projectMap(fields map[string]interface{}, names []string) map[string]interface{} {
+	projected := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if value, exists := fields[name]; exists {
+			projected[name] = value
+		} else if value, exists := preprocessing.LookupField(fields, name); exists {
+			preprocessing.SetField(projected, name, value)
+		}
+	}
+	return projected
+}
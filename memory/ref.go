@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Policies of a reference, deciding what deleting the referenced record
+// does to the records referring to it
+const (
+	refBlock   = "block"   // The delete fails while referring records exist
+	refCascade = "cascade" // Referring records are deleted along with it
+)
+
+// parseRef returns the schema a reference type such as ref(User) or
+// ref(User,cascade) refers to and its delete policy, block by default, or
+// false when the type is not a valid reference
+func parseRef(fieldType string) (string, string, bool) {
+	if !strings.HasPrefix(fieldType, "ref(") || !strings.HasSuffix(fieldType, ")") {
+		return "", "", false
+	}
+
+	target, policy, hasPolicy := strings.Cut(fieldType[len("ref("):len(fieldType)-1], ",")
+	if target = strings.TrimSpace(target); target == "" {
+		return "", "", false
+	}
+	if !hasPolicy {
+		return target, refBlock, true
+	}
+	switch policy = strings.TrimSpace(policy); policy {
+	case refBlock, refCascade:
+		return target, policy, true
+	}
+	return "", "", false
+}
+
+// refKey returns the key of the record a reference field value points to
+func refKey(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, v != ""
+	case float64, int64:
+		return formatKey(v), true
+	}
+	return "", false
+}
+
+// checkRefs reports the first reference field of a record pointing to a
+// record that does not exist
+// NOTE: This function should be called from within a locked context
+func (s *Storage) checkRefs(schemaName string, record map[string]interface{}) error {
+	dbState := s.getDBState(s.currentDB)
+	for _, def := range parseFieldDefs(dbState.schemas[schemaName]) {
+		target, _, ok := parseRef(def.fieldType)
+		if !ok || record[def.name] == nil {
+			continue
+		}
+		key, _ := refKey(record[def.name])
+		if _, exists := dbState.schemas[target]; !exists {
+			return fmt.Errorf("field '%s' refers to schema '%s', which does not exist", def.name, target)
+		}
+		if err := s.ensureLoaded(target); err != nil {
+			return err
+		}
+		if _, err := s.lookupTable(target).Get(key); err != nil {
+			return fmt.Errorf("field '%s' refers to %s record '%s', which does not exist", def.name, target, key)
+		}
+	}
+	return nil
+}
+
+// recordRef identifies a record by schema and key
+type recordRef struct {
+	schema string
+	key    string
+}
+
+// refDeletion collects the records a delete removes: the records asked
+// for and, through cascading references, the records referring to them.
+// The records referring to each schema are looked up once per deletion.
+type refDeletion struct {
+	storage  *Storage
+	seen     map[recordRef]bool
+	records  []recordRef
+	referers map[string]map[string][]string // Referring keys by schema and field, then referenced key
+}
+
+// newRefDeletion starts collecting the records of a delete
+func (s *Storage) newRefDeletion() *refDeletion {
+	return &refDeletion{storage: s, seen: make(map[recordRef]bool), referers: make(map[string]map[string][]string)}
+}
+
+// add adds a record to the delete along with the records cascading from
+// it, failing when a record with a blocking reference refers to one of
+// them
+// NOTE: This function should be called from within a locked context
+func (d *refDeletion) add(schemaName, key string) error {
+	ref := recordRef{schema: schemaName, key: key}
+	if d.seen[ref] {
+		return nil
+	}
+	d.seen[ref] = true
+	d.records = append(d.records, ref)
+
+	dbState := d.storage.getDBState(d.storage.currentDB)
+	schemaNames := make([]string, 0, len(dbState.schemas))
+	for name := range dbState.schemas {
+		// Views follow their source and are never referred from directly
+		if _, isView := dbState.views[name]; !isView {
+			schemaNames = append(schemaNames, name)
+		}
+	}
+	sort.Strings(schemaNames)
+
+	for _, other := range schemaNames {
+		for _, def := range parseFieldDefs(dbState.schemas[other]) {
+			target, policy, ok := parseRef(def.fieldType)
+			if !ok || target != schemaName {
+				continue
+			}
+			referers, err := d.referersOf(other, def.name)
+			if err != nil {
+				return err
+			}
+			for _, referer := range referers[key] {
+				if d.seen[recordRef{schema: other, key: referer}] {
+					continue
+				}
+				if policy == refBlock {
+					return fmt.Errorf("%s record '%s' is referred to by %s record '%s' through field '%s'", schemaName, key, other, referer, def.name)
+				}
+				if err := d.add(other, referer); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// referersOf returns the keys of the records of a schema by the key their
+// reference field refers to
+func (d *refDeletion) referersOf(schemaName, field string) (map[string][]string, error) {
+	name := schemaName + "\x00" + field
+	if referers, exists := d.referers[name]; exists {
+		return referers, nil
+	}
+	if err := d.storage.ensureLoaded(schemaName); err != nil {
+		return nil, err
+	}
+
+	referers := make(map[string][]string)
+	it := d.storage.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		record, err := decodeRecord(it.Value())
+		if err != nil {
+			continue
+		}
+		if key, ok := refKey(record[field]); ok {
+			referers[key] = append(referers[key], it.Key())
+		}
+	}
+	d.referers[name] = referers
+	return referers, nil
+}
+
+// apply removes every collected record
+// NOTE: This function should be called from within a locked context
+func (d *refDeletion) apply() {
+	for _, ref := range d.records {
+		d.storage.removeRecord(ref.schema, ref.key)
+	}
+}
@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+
+	"simplebson/preprocessing"
+)
+
+// RenameSchema renames a schema or view in one operation. Its records move
+// to the new name with their keys, checksums, versions and indexes, and
+// its compound index definitions, serial counter and version go along with
+// them. Field types of other schemas naming it, as in ref(Old) or []Old,
+// and the queries of views following it and the schemas extending it are
+// rewritten to the new name.
+func (s *Storage) RenameSchema(oldName, newName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	schemaDef, exists := dbState.schemas[oldName]
+	if !exists {
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", oldName)
+	}
+	if newName == "" || strings.ContainsAny(newName, ":=!,()[] \t\n") {
+		return fmt.Errorf("invalid schema name '%s'", newName)
+	}
+	if _, taken := dbState.schemas[newName]; taken {
+		return fmt.Errorf("schema '%s' already exists", newName)
+	}
+	if err := s.ensureLoaded(oldName); err != nil {
+		return err
+	}
+
+	// Queries are rewritten first, as they are the only step that can fail
+	queries := make(map[string]string)
+	for name, view := range dbState.views {
+		if view.source != oldName {
+			continue
+		}
+		query, err := preprocessing.RetargetViewQuery(view.query, newName)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite the query of view '%s': %v", name, err)
+		}
+		queries[name] = query
+	}
+
+	records := s.lookupTable(oldName).Items()
+	view := dbState.views[oldName]
+	indexDefs := dbState.indexDefs[oldName]
+	counter, hasCounter := dbState.counters[oldName]
+	version, hasVersion := dbState.versions[oldName]
+	recordVersions := dbState.recordVersions[oldName]
+	document := dbState.documents[oldName]
+	extends := dbState.extends[oldName]
+
+	if err := s.forgetSchema(oldName); err != nil {
+		return err
+	}
+
+	dbState.schemas[newName] = schemaDef
+	if view != nil {
+		dbState.views[newName] = view
+	}
+	if indexDefs != nil {
+		dbState.indexDefs[newName] = indexDefs
+	}
+	if hasCounter {
+		dbState.counters[newName] = counter
+	}
+	if hasVersion {
+		dbState.versions[newName] = version
+	}
+	if document != "" {
+		dbState.documents[newName] = document
+	}
+	if extends != "" {
+		dbState.extends[newName] = extends
+	}
+
+	s.table(newName)
+	s.indexSchema(newName)
+	for key, record := range records {
+		if data, ok := record.(string); ok {
+			s.putRecord(newName, key, data)
+		}
+	}
+	if recordVersions != nil {
+		dbState.recordVersions[newName] = recordVersions
+	}
+
+	for name, query := range queries {
+		dbState.views[name].source = newName
+		dbState.views[name].query = query
+	}
+	for name, def := range dbState.schemas {
+		dbState.schemas[name] = renameSchemaRefs(def, oldName, newName)
+	}
+	for name, extends := range dbState.extends {
+		if parent, fields := parseExtends(extends); parent == oldName {
+			dbState.extends[name] = strings.TrimSpace(newName + " " + fields)
+		}
+	}
+
+	return s.saveToPersistent()
+}
+
+// renameSchemaRefs returns a schema definition with the field types naming
+// a schema, directly, as a reference or as array elements, renamed
+func renameSchemaRefs(schemaDef, oldName, newName string) string {
+	parts := strings.Fields(schemaDef)
+	for i, part := range parts {
+		segments := splitOutside(part, ':')
+		if len(segments) < 2 {
+			continue
+		}
+		fieldType, defaultText, hasDefault := cutOutside(segments[1], '=')
+		required := strings.HasSuffix(fieldType, "!")
+
+		segments[1] = renameSchemaType(strings.TrimSuffix(fieldType, "!"), oldName, newName)
+		if required {
+			segments[1] += "!"
+		}
+		if hasDefault {
+			segments[1] += "=" + defaultText
+		}
+		parts[i] = strings.Join(segments, ":")
+	}
+	return strings.Join(parts, " ")
+}
+
+// renameSchemaType returns a field type with the schema it names renamed
+func renameSchemaType(fieldType, oldName, newName string) string {
+	if elem, ok := elementType(fieldType); ok {
+		return "[]" + renameSchemaType(elem, oldName, newName)
+	}
+	if target, _, ok := parseRef(fieldType); ok && target == oldName {
+		_, policy, hasPolicy := strings.Cut(fieldType[len("ref("):len(fieldType)-1], ",")
+		if hasPolicy {
+			return "ref(" + newName + "," + policy + ")"
+		}
+		return "ref(" + newName + ")"
+	}
+	if fieldType == oldName {
+		return newName
+	}
+	return fieldType
+}
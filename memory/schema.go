@@ -0,0 +1,504 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"simplebson/preprocessing"
+)
+
+// fieldDef is one field of a schema definition, written as
+// name:type[(constraints)][=default][:modifier...]. A type ending in !
+// marks the field required, like the required modifier.
+type fieldDef struct {
+	name         string
+	fieldType    string
+	unique       bool              // No two records may hold the same value
+	key          bool              // Records are stored under the value of this field
+	required     bool              // Every record must hold a value other than null
+	immutable    bool              // Updates cannot change a value once it is set
+	hasDefault   bool              // New records without the field get defaultValue
+	defaultValue interface{}       // Decoded like a JSON value of the field's type
+	defaultFunc  string            // Function computing the default of each new record, such as now()
+	constraints  *fieldConstraints // Limits on values beyond the type, nil when none
+	validators   []string          // Names of the validators values must pass
+}
+
+// parseFieldDefs parses the field definitions of a schema in the order
+// they are written. Parts without a type are skipped.
+func parseFieldDefs(schemaDef string) []fieldDef {
+	var defs []fieldDef
+	for _, part := range strings.Fields(schemaDef) {
+		segments := splitOutside(part, ':')
+		if len(segments) < 2 {
+			continue
+		}
+
+		fieldType, defaultText, hasDefault := cutOutside(strings.TrimSpace(segments[1]), '=')
+		def := fieldDef{
+			name:      strings.TrimSpace(segments[0]),
+			fieldType: strings.TrimSuffix(fieldType, "!"),
+			required:  strings.HasSuffix(fieldType, "!"),
+		}
+		if base, text, ok := splitConstraints(def.fieldType); ok {
+			def.fieldType = base
+			def.constraints, _ = parseConstraints(base, text)
+		}
+		if hasDefault && isDefaultFunc(defaultText) {
+			if checkDefaultFunc(def.fieldType, defaultText) == nil {
+				def.hasDefault, def.defaultFunc = true, defaultText
+			}
+		} else if hasDefault {
+			if value, err := parseDefault(def.fieldType, defaultText); err == nil {
+				def.hasDefault, def.defaultValue = true, value
+			}
+		}
+		for _, modifier := range segments[2:] {
+			switch strings.TrimSpace(modifier) {
+			case "unique":
+				def.unique = true
+			case "required":
+				def.required = true
+			case "key":
+				def.key = true
+			case "immutable":
+				def.immutable = true
+			default:
+				if name, ok := parseValidatorModifier(strings.TrimSpace(modifier)); ok {
+					def.validators = append(def.validators, name)
+				}
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// validateSchemaDef reports field definitions with modifiers,
+// constraints or validators that are not supported, default values that do
+// not fit the field's type and schemas declaring more than one key field
+func validateSchemaDef(schemaDef string, schemas map[string]string) error {
+	keyField := ""
+	for _, part := range strings.Fields(schemaDef) {
+		segments := splitOutside(part, ':')
+		if len(segments) < 2 {
+			continue
+		}
+		fieldType, defaultText, hasDefault := cutOutside(segments[1], '=')
+		fieldType = strings.TrimSuffix(fieldType, "!")
+
+		var constraints *fieldConstraints
+		if base, text, ok := splitConstraints(fieldType); ok {
+			var err error
+			if constraints, err = parseConstraints(base, text); err != nil {
+				return fmt.Errorf("invalid constraints for field '%s': %v", segments[0], err)
+			}
+			fieldType = base
+		}
+		if err := checkFieldType(fieldType); err != nil {
+			return fmt.Errorf("invalid type for field '%s': %v", segments[0], err)
+		}
+		var validatorNames []string
+		for _, modifier := range segments[2:] {
+			switch strings.TrimSpace(modifier) {
+			case "unique", "required", "immutable":
+			case "key":
+				if keyField != "" {
+					return fmt.Errorf("fields '%s' and '%s' are both declared key, a schema has one key field", keyField, segments[0])
+				}
+				keyField = segments[0]
+			default:
+				name, ok := parseValidatorModifier(strings.TrimSpace(modifier))
+				if !ok {
+					return fmt.Errorf("unknown modifier '%s' for field '%s'", modifier, segments[0])
+				}
+				if _, exists := lookupValidator(name); !exists {
+					return fmt.Errorf("unknown validator '%s' for field '%s', expected one of %s", name, segments[0], strings.Join(ValidatorNames(), ", "))
+				}
+				validatorNames = append(validatorNames, name)
+			}
+		}
+		if hasDefault && isDefaultFunc(defaultText) {
+			if err := checkDefaultFunc(fieldType, defaultText); err != nil {
+				return fmt.Errorf("invalid default '%s' for field '%s': %v", defaultText, segments[0], err)
+			}
+		} else if hasDefault {
+			value, err := parseDefault(fieldType, defaultText)
+			if err == nil {
+				err = validateFieldType(value, fieldType, schemas)
+			}
+			if err == nil && constraints != nil {
+				err = constraints.check(value)
+			}
+			if err == nil && value != nil {
+				err = runValidators(validatorNames, value)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid default '%s' for field '%s': %v", defaultText, segments[0], err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkFieldType reports enum, reference and typed array types that are
+// malformed
+func checkFieldType(fieldType string) error {
+	if elemType, ok := elementType(fieldType); ok {
+		if elemType == "" {
+			return fmt.Errorf("'%s' has no element type, expected []type", fieldType)
+		}
+		return checkFieldType(elemType)
+	}
+	if strings.HasPrefix(fieldType, "ref") {
+		if _, _, ok := parseRef(fieldType); !ok {
+			return fmt.Errorf("'%s' is not a valid reference, expected ref(Schema) or ref(Schema,cascade)", fieldType)
+		}
+	}
+	if strings.HasPrefix(fieldType, "enum") {
+		if _, ok := parseEnum(fieldType); !ok {
+			return fmt.Errorf("'%s' is not a valid enum, expected enum(value,...)", fieldType)
+		}
+	} else if strings.Contains(fieldType, "(") && !strings.HasPrefix(fieldType, "ref") {
+		if !strings.HasSuffix(fieldType, ")") {
+			return fmt.Errorf("'%s' is not closed with ), constraints cannot contain spaces", fieldType)
+		}
+		return fmt.Errorf("'%s' cannot have constraints, only the type of a field can", fieldType)
+	}
+	return nil
+}
+
+// parseDefault decodes the default value written for a field of the given
+// type. Numbers and booleans are parsed from their text, strings are taken
+// as written unless quoted as JSON, and other types take a JSON value,
+// falling back to the text itself.
+func parseDefault(fieldType, text string) (interface{}, error) {
+	switch fieldType {
+	case "string", "text":
+		if strings.HasPrefix(text, `"`) {
+			var value string
+			if err := json.Unmarshal([]byte(text), &value); err != nil {
+				return nil, fmt.Errorf("invalid quoted string")
+			}
+			return value, nil
+		}
+		return text, nil
+	case "int", "integer":
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		return float64(n), nil
+	case "float", "double":
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number")
+		}
+		return n, nil
+	case "bool", "boolean":
+		if text != "true" && text != "false" {
+			return nil, fmt.Errorf("expected true or false")
+		}
+		return text == "true", nil
+	case "decimal", "bytes":
+		// Kept as written, so digits after a decimal point survive and
+		// base64 is not read as a number
+		return text, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return text, nil
+	}
+	return value, nil
+}
+
+// applyDefaults fills the fields of a new record that are absent and have
+// a default, and reports whether any was filled. Default functions are
+// evaluated for the record. A field explicitly set to null keeps its null.
+func applyDefaults(schemaDef string, record map[string]interface{}) (bool, error) {
+	filled := false
+	now := time.Now()
+	for _, def := range parseFieldDefs(schemaDef) {
+		if !def.hasDefault {
+			continue
+		}
+		if _, exists := record[def.name]; exists {
+			continue
+		}
+
+		value := def.defaultValue
+		if def.defaultFunc != "" {
+			var err error
+			if value, err = evalDefaultFunc(def.fieldType, def.defaultFunc, now); err != nil {
+				return filled, fmt.Errorf("default of field '%s': %v", def.name, err)
+			}
+		}
+		record[def.name] = value
+		filled = true
+	}
+	return filled, nil
+}
+
+// EnumField is a field of a schema restricted to a set of values
+type EnumField struct {
+	Name   string
+	Values []string
+}
+
+// EnumFields returns the enum fields of a schema definition, in the order
+// they are defined
+func EnumFields(schemaDef string) []EnumField {
+	var fields []EnumField
+	for _, def := range parseFieldDefs(schemaDef) {
+		if values, ok := parseEnum(def.fieldType); ok {
+			fields = append(fields, EnumField{Name: def.name, Values: values})
+		}
+	}
+	return fields
+}
+
+// Field is a field a schema declares, with its type as written
+type Field struct {
+	Name    string
+	Type    string
+	Numeric bool // The field holds numbers
+}
+
+// DeclaredFields returns the fields of a schema definition, in the order
+// they are defined
+func DeclaredFields(schemaDef string) []Field {
+	var fields []Field
+	for _, def := range parseFieldDefs(schemaDef) {
+		fields = append(fields, Field{Name: def.name, Type: def.fieldType, Numeric: isNumericType(def.fieldType)})
+	}
+	return fields
+}
+
+// parseEnum returns the values an enum type such as enum(open,closed)
+// allows, or false when the type is not a valid enum
+func parseEnum(fieldType string) ([]string, bool) {
+	if !strings.HasPrefix(fieldType, "enum(") || !strings.HasSuffix(fieldType, ")") {
+		return nil, false
+	}
+
+	var values []string
+	for _, value := range strings.Split(fieldType[len("enum("):len(fieldType)-1], ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			return nil, false
+		}
+		values = append(values, value)
+	}
+	return values, true
+}
+
+// requiredFields returns the fields a schema declares required
+func requiredFields(schemaDef string) []string {
+	var fields []string
+	for _, def := range parseFieldDefs(schemaDef) {
+		if def.required {
+			fields = append(fields, def.name)
+		}
+	}
+	return fields
+}
+
+// missingRequired returns the first required field a record lacks or
+// holds null in, or an empty string when it has them all
+func missingRequired(schemaDef string, record map[string]interface{}) string {
+	for _, field := range requiredFields(schemaDef) {
+		if value, exists := record[field]; !exists || value == nil {
+			return field
+		}
+	}
+	return ""
+}
+
+// strictWord is the word a schema definition holds to reject records with
+// fields it does not declare, as in "name:string age:int strict"
+const strictWord = "strict"
+
+// isStrict reports whether a schema definition rejects undeclared fields
+func isStrict(schemaDef string) bool {
+	for _, part := range strings.Fields(schemaDef) {
+		if part == strictWord {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownField returns the first field, in name order, of a record that
+// its schema definition does not declare, or an empty string when there is
+// none. The timestamps every record holds are known.
+func unknownField(schemaDef string, record map[string]interface{}) string {
+	declared := parseSchemaFields(schemaDef)
+	unknown := ""
+	for field := range record {
+		switch field {
+		case "created_at", "updated_at":
+			continue
+		}
+		if _, ok := declared[field]; !ok && (unknown == "" || field < unknown) {
+			unknown = field
+		}
+	}
+	return unknown
+}
+
+// checkStrict reports the first stored record of a strict schema holding a
+// field the schema does not declare, so a schema cannot be made strict
+// while such records exist
+// NOTE: This function should be called from within a locked context
+func (s *Storage) checkStrict(schemaName string) error {
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	if !isStrict(schemaDef) {
+		return nil
+	}
+
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		record, err := decodeRecord(it.Value())
+		if err != nil {
+			continue
+		}
+		if field := unknownField(schemaDef, record); field != "" {
+			return fmt.Errorf("record '%s' has field '%s', which the strict schema does not declare", it.Key(), field)
+		}
+	}
+	return nil
+}
+
+// checkRequired reports the first stored record of a schema that lacks a
+// required field, so a field cannot be declared required while records
+// without it exist
+// NOTE: This function should be called from within a locked context
+func (s *Storage) checkRequired(schemaName string) error {
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	if len(requiredFields(schemaDef)) == 0 {
+		return nil
+	}
+
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		record, err := decodeRecord(it.Value())
+		if err != nil {
+			continue
+		}
+		if field := missingRequired(schemaDef, record); field != "" {
+			return fmt.Errorf("record '%s' has no value for required field '%s'", it.Key(), field)
+		}
+	}
+	return nil
+}
+
+// keyField returns the field a schema declares as its key, or an empty
+// string when records are keyed by the first of id, name and key they hold
+func keyField(schemaDef string) string {
+	for _, def := range parseFieldDefs(schemaDef) {
+		if def.key {
+			return def.name
+		}
+	}
+	return ""
+}
+
+// keyComparator returns the order the records of a schema are kept in: by
+// value when its key field, or else its id field, holds numbers, so 9
+// comes before 10, and byte by byte otherwise
+func keyComparator(schemaDef string) preprocessing.Comparator {
+	field := keyField(schemaDef)
+	if field == "" {
+		field = "id"
+	}
+	for _, def := range parseFieldDefs(schemaDef) {
+		if def.name == field && isNumericType(def.fieldType) {
+			return preprocessing.NumericComparator
+		}
+	}
+	return preprocessing.LexicographicComparator
+}
+
+// formatKey returns the key a value of a key field stores a record under.
+// Whole numbers are written out in full, so the key of serial 1000000 is
+// "1000000" rather than the "1e+06" of %v.
+func formatKey(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// recordKey returns the key a record of a schema with a declared key field
+// is stored under
+func recordKey(field string, record map[string]interface{}) (string, error) {
+	switch value := record[field].(type) {
+	case string:
+		if value == "" {
+			return "", fmt.Errorf("key field '%s' is empty", field)
+		}
+		return value, nil
+	case float64, int64, bool:
+		return formatKey(value), nil
+	case nil:
+		return "", fmt.Errorf("record has no value for key field '%s'", field)
+	default:
+		return "", fmt.Errorf("key field '%s' must hold a string, number or boolean, got %T", field, value)
+	}
+}
+
+// checkKeys reports the first stored record of a schema that is not stored
+// under the value of its key field, so a key field cannot be declared on a
+// schema whose records were keyed otherwise
+// NOTE: This function should be called from within a locked context
+func (s *Storage) checkKeys(schemaName string) error {
+	field := keyField(s.getDBState(s.currentDB).schemas[schemaName])
+	if field == "" {
+		return nil
+	}
+
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		record, err := decodeRecord(it.Value())
+		if err != nil {
+			continue
+		}
+		if key, err := recordKey(field, record); err != nil || key != it.Key() {
+			return fmt.Errorf("record '%s' is not stored under the value of key field '%s'", it.Key(), field)
+		}
+	}
+	return nil
+}
+
+// immutableFields returns the fields a schema declares immutable
+func immutableFields(schemaDef string) []string {
+	var fields []string
+	for _, def := range parseFieldDefs(schemaDef) {
+		if def.immutable {
+			fields = append(fields, def.name)
+		}
+	}
+	return fields
+}
+
+// uniqueFields returns the fields a schema declares unique
+func uniqueFields(schemaDef string) []string {
+	var fields []string
+	for _, def := range parseFieldDefs(schemaDef) {
+		if def.unique {
+			fields = append(fields, def.name)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,256 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaDocument describes a schema in the form schema export writes and
+// schema import reads. A view has the query defining it instead of a
+// definition, as it takes the definition of its source, and a schema
+// defined by a JSON Schema document has that document.
+type SchemaDocument struct {
+	Name       string          `json:"name"`
+	Definition string          `json:"definition,omitempty"`
+	JSONSchema json.RawMessage `json:"jsonSchema,omitempty"`
+	View       string          `json:"view,omitempty"`
+	Indexes    [][]string      `json:"indexes,omitempty"` // Compound indexes, by their fields
+}
+
+// ExportSchemas returns the documents describing the named schemas, or
+// every schema when no names are given, sorted by name
+func (s *Storage) ExportSchemas(names ...string) ([]SchemaDocument, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if len(names) == 0 {
+		for name := range dbState.schemas {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	docs := make([]SchemaDocument, 0, len(names))
+	for _, name := range names {
+		schemaDef, exists := dbState.schemas[name]
+		if !exists {
+			return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", name)
+		}
+
+		doc := SchemaDocument{Name: name}
+		if view, isView := dbState.views[name]; isView {
+			doc.View = view.query
+		} else if document := dbState.documents[name]; document != "" {
+			doc.JSONSchema = json.RawMessage(document)
+		} else {
+			doc.Definition = schemaDef
+		}
+		for _, index := range dbState.indexDefs[name] {
+			doc.Indexes = append(doc.Indexes, strings.Split(index, ","))
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// ParseSchemaDocuments reads the JSON array of schema documents written by
+// schema export
+func ParseSchemaDocuments(data []byte) ([]SchemaDocument, error) {
+	var docs []SchemaDocument
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, fmt.Errorf("invalid schema documents: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, doc := range docs {
+		switch {
+		case doc.Name == "":
+			return nil, fmt.Errorf("schema document %d has no name", i+1)
+		case seen[doc.Name]:
+			return nil, fmt.Errorf("schema '%s' appears twice", doc.Name)
+		case countSet(doc.Definition != "", len(doc.JSONSchema) > 0, doc.View != "") != 1:
+			return nil, fmt.Errorf("schema '%s' needs one of a definition, a JSON Schema or a view query", doc.Name)
+		}
+		seen[doc.Name] = true
+	}
+	return docs, nil
+}
+
+// SchemaDefinition returns the definition a schema document gives its
+// schema, derived from its JSON Schema when it has one. Views have none.
+func (doc SchemaDocument) SchemaDefinition() (string, error) {
+	if len(doc.JSONSchema) > 0 {
+		js, err := compileJSONSchema(compactDocument(doc.JSONSchema))
+		if err != nil {
+			return "", fmt.Errorf("invalid JSON Schema: %v", err)
+		}
+		return deriveDefinition(js.root), nil
+	}
+	if doc.View != "" {
+		return "", fmt.Errorf("'%s' is a view, defined by the query '%s'", doc.Name, doc.View)
+	}
+	return doc.Definition, nil
+}
+
+// countSet returns how many of the given conditions hold
+func countSet(conditions ...bool) int {
+	count := 0
+	for _, set := range conditions {
+		if set {
+			count++
+		}
+	}
+	return count
+}
+
+// ImportSchemas applies schema documents in one batch: schemas are created
+// or redefined, then views are created from their queries, then compound
+// indexes are created. Definitions are checked before anything is
+// applied; schemas, views and indexes that already exist as described are
+// left as they are. It returns the number of documents applied.
+func (s *Storage) ImportSchemas(docs []SchemaDocument) (int, error) {
+	if err := s.checkSchemaDocuments(docs); err != nil {
+		return 0, err
+	}
+
+	s.Begin()
+	applied, err := s.applySchemaDocuments(docs)
+	if flushErr := s.Flush(); err == nil {
+		err = flushErr
+	}
+	return applied, err
+}
+
+// checkSchemaDocuments checks the definitions of schema documents against
+// each other and the schemas already defined, and that views being
+// imported do not redefine existing ones
+func (s *Storage) checkSchemaDocuments(docs []SchemaDocument) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	schemas := make(map[string]string, len(dbState.schemas)+len(docs))
+	for name, schemaDef := range dbState.schemas {
+		schemas[name] = schemaDef
+	}
+	definitions := make(map[string]string)
+	for _, doc := range docs {
+		if doc.View != "" {
+			continue
+		}
+		definition, err := doc.SchemaDefinition()
+		if err != nil {
+			return fmt.Errorf("schema '%s': %v", doc.Name, err)
+		}
+		if _, isView := dbState.views[doc.Name]; isView {
+			return fmt.Errorf("'%s' is a view and cannot be given a definition", doc.Name)
+		}
+		schemas[doc.Name] = definition
+		definitions[doc.Name] = definition
+	}
+
+	for _, doc := range docs {
+		if definition, defined := definitions[doc.Name]; defined {
+			if err := validateSchemaDef(definition, schemas); err != nil {
+				return fmt.Errorf("schema '%s': %v", doc.Name, err)
+			}
+			continue
+		}
+
+		if view, isView := dbState.views[doc.Name]; isView {
+			if view.query != doc.View {
+				return fmt.Errorf("view '%s' already exists with query '%s'", doc.Name, view.query)
+			}
+		} else if _, exists := dbState.schemas[doc.Name]; exists {
+			return fmt.Errorf("schema '%s' already exists and is not a view", doc.Name)
+		}
+		if _, err := newView(doc.View); err != nil {
+			return fmt.Errorf("view '%s': invalid view query: %v", doc.Name, err)
+		}
+	}
+	return nil
+}
+
+// applySchemaDocuments creates the schemas, views and indexes described
+// by checked schema documents
+func (s *Storage) applySchemaDocuments(docs []SchemaDocument) (int, error) {
+	applied := 0
+	for _, doc := range docs {
+		var err error
+		switch {
+		case len(doc.JSONSchema) > 0:
+			err = s.DefineJSONSchema(doc.Name, doc.JSONSchema)
+		case doc.Definition != "":
+			err = s.CreateSchema(doc.Name, doc.Definition)
+		default:
+			continue
+		}
+		if err != nil {
+			return applied, fmt.Errorf("schema '%s': %v", doc.Name, err)
+		}
+		applied++
+	}
+
+	// Views may read from views further down the list, so they are created
+	// in rounds until no more can be
+	var pending []SchemaDocument
+	for _, doc := range docs {
+		if doc.View != "" {
+			pending = append(pending, doc)
+		}
+	}
+	for len(pending) > 0 {
+		var waiting []SchemaDocument
+		var lastErr error
+		for _, doc := range pending {
+			if s.viewExists(doc.Name) {
+				applied++
+				continue
+			}
+			if err := s.CreateView(doc.Name, doc.View); err != nil {
+				waiting = append(waiting, doc)
+				lastErr = fmt.Errorf("view '%s': %v", doc.Name, err)
+				continue
+			}
+			applied++
+		}
+		if len(waiting) == len(pending) {
+			return applied, lastErr
+		}
+		pending = waiting
+	}
+
+	for _, doc := range docs {
+		existing, err := s.ListIndexes(doc.Name)
+		if err != nil {
+			return applied, err
+		}
+		created := make(map[string]bool, len(existing))
+		for _, fields := range existing {
+			created[indexName(fields)] = true
+		}
+		for _, fields := range doc.Indexes {
+			if created[indexName(fields)] {
+				continue
+			}
+			if err := s.CreateIndex(doc.Name, fields); err != nil {
+				return applied, fmt.Errorf("schema '%s': %v", doc.Name, err)
+			}
+		}
+	}
+	return applied, nil
+}
+
+// viewExists reports whether a view of the given name is defined
+func (s *Storage) viewExists(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, exists := s.getDBState(s.currentDB).views[name]
+	return exists
+}
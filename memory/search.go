@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"simplebson/preprocessing"
+)
+
+// SearchMatch is a record found by Search together with the fields whose
+// values matched
+type SearchMatch struct {
+	Key    string
+	Record interface{}
+	Fields []string
+}
+
+// Search returns the records of a schema with a field value matching the
+// regular expression, in key order. Only the given field is searched when
+// one is given, otherwise every field is. Values are matched in the form
+// they are typed on the command line, so numbers and booleans can be
+// searched as well.
+func (s *Storage) Search(schemaName, pattern, field string) ([]SearchMatch, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %v", err)
+	}
+
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	matches, err := s.matchRecords(schemaName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchMatch, 0)
+	for _, match := range matches {
+		var names []string
+		if field != "" {
+			names = []string{field}
+		} else {
+			for name := range match.fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+
+		var matched []string
+		for _, name := range names {
+			value, exists := match.fields[name]
+			if exists && re.MatchString(preprocessing.FormatValue(value)) {
+				matched = append(matched, name)
+			}
+		}
+
+		if len(matched) > 0 {
+			results = append(results, SearchMatch{Key: match.key, Record: match.record, Fields: matched})
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"simplebson/preprocessing"
+)
+
+// sortMatches orders query matches by a field. Fields declared as int,
+// float or decimal in the schema compare numerically, as do untyped fields
+// holding numbers on both sides; everything else compares as text. Records
+// without the field come last in either direction, and records that
+// compare equal keep their key order.
+func sortMatches(matches []queryMatch, field, fieldType string, descending bool) {
+	numeric := isNumericType(fieldType)
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, hasA := preprocessing.LookupField(matches[i].fields, field)
+		b, hasB := preprocessing.LookupField(matches[j].fields, field)
+		hasA = hasA && a != nil
+		hasB = hasB && b != nil
+		if !hasA || !hasB {
+			return hasA && !hasB
+		}
+
+		order := compareFieldValues(a, b, numeric)
+		if descending {
+			return order > 0
+		}
+		return order < 0
+	})
+}
+
+// groupMatches splits query matches by the value of a field. The group
+// names are returned ordered by value, comparing numerically for numeric
+// field types, with the "null" group of records without the field last.
+func groupMatches(matches []queryMatch, field, fieldType string) ([]string, map[string][]queryMatch) {
+	// Keep a sample value of each group so groups are ordered by the
+	// field's type rather than by their text
+	grouped := make(map[string][]queryMatch)
+	samples := make(map[string]interface{})
+	for _, match := range matches {
+		value, _ := preprocessing.LookupField(match.fields, field)
+		group := preprocessing.FormatValue(value)
+		grouped[group] = append(grouped[group], match)
+		samples[group] = value
+	}
+
+	numeric := isNumericType(fieldType)
+	groups := make([]string, 0, len(grouped))
+	for group := range grouped {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := samples[groups[i]], samples[groups[j]]
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if order := compareFieldValues(a, b, numeric); order != 0 {
+			return order < 0
+		}
+		return groups[i] < groups[j]
+	})
+
+	return groups, grouped
+}
+
+// isNumericType reports whether a schema field type holds numbers
+func isNumericType(fieldType string) bool {
+	switch fieldType {
+	case "int", "integer", "serial", "float", "double", "decimal":
+		return true
+	}
+	return false
+}
+
+// isObjectType reports whether a schema field type holds nested objects
+func isObjectType(fieldType string) bool {
+	return fieldType == "object" || fieldType == "json"
+}
+
+// compareFieldValues orders two field values, returning a negative number
+// when a sorts first, zero when they are equal and a positive number when b
+// sorts first
+func compareFieldValues(a, b interface{}, numeric bool) int {
+	// Decimal strings compare exactly rather than as floats
+	if numeric {
+		if x, ok := decimalValue(a); ok {
+			if y, ok := decimalValue(b); ok {
+				return x.Cmp(y)
+			}
+		}
+	}
+
+	x, okA := toNumber(a, numeric)
+	y, okB := toNumber(b, numeric)
+	if okA && okB {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(preprocessing.FormatValue(a), preprocessing.FormatValue(b))
+}
+
+// toNumber returns the numeric value of a field. Strings are only parsed
+// for fields the schema declares numeric.
+func toNumber(value interface{}, numeric bool) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		if numeric {
+			if number, err := strconv.ParseFloat(v, 64); err == nil {
+				return number, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"sort"
+
+	"simplebson/preprocessing"
+)
+
+// SchemaStats describes the storage engine state of one schema
+type SchemaStats struct {
+	Schema      string
+	LiveRecords int
+	DeadEntries int // Tombstones and overwritten values awaiting compaction
+	Archived    bool
+	Loaded      bool // False for archived schemas still in cold storage
+	Metrics     preprocessing.LSMMetrics
+}
+
+// Stats returns engine statistics for the given schemas, or for every
+// schema of the current database when none are given. Archived schemas are
+// reported without loading them.
+func (s *Storage) Stats(schemaNames ...string) ([]SchemaStats, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if len(schemaNames) == 0 {
+		for name := range dbState.schemas {
+			schemaNames = append(schemaNames, name)
+		}
+	}
+	sort.Strings(schemaNames)
+
+	stats := make([]SchemaStats, 0, len(schemaNames))
+	for _, name := range schemaNames {
+		if _, exists := dbState.schemas[name]; !exists {
+			return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", name)
+		}
+
+		table, loaded := dbState.records[name]
+		if !loaded {
+			stats = append(stats, SchemaStats{Schema: name, Archived: dbState.archived[name]})
+			continue
+		}
+
+		live, dead := table.Size()
+		stats = append(stats, SchemaStats{
+			Schema:      name,
+			LiveRecords: live,
+			DeadEntries: dead,
+			Archived:    dbState.archived[name],
+			Loaded:      true,
+			Metrics:     table.Metrics(),
+		})
+	}
+
+	return stats, nil
+}
@@ -12,22 +12,47 @@ import (
 
 	"simplebson/config"
 	"simplebson/dbs"
+	"simplebson/preprocessing"
 )
 
 // DatabaseState holds the data for a single database
 type DatabaseState struct {
-	records     map[string]map[string]interface{} // Maps schemas to records
-	schemas     map[string]string                 // Schema definitions
-	partialKeys map[string]map[string][]string    // For partial key lookups
+	records        map[string]*preprocessing.LSMTree     // Maps schemas to the LSM trees holding their records
+	schemas        map[string]string                     // Schema definitions
+	checksums      map[string]map[string]string          // Content hash of every record
+	archived       map[string]bool                       // Schemas whose records live in cold storage
+	indexes        map[string]map[string]fieldIndex      // Secondary indexes by schema and field
+	indexDefs      map[string][]string                   // Compound index definitions by schema, as comma-separated field lists
+	compound       map[string]map[string]*compoundIndex  // Compound indexes by schema and definition
+	textIndex      map[string]map[string]map[string]int  // Full-text index: term frequency of every record, by schema and term
+	folded         map[string]map[string]map[string]bool // Keys by schema and lowercased key, for case-insensitive lookups
+	geo            map[string]map[string]*geoIndex       // Geohash indexes by schema and geo field
+	arrays         map[string]map[string]fieldIndex      // Element indexes by schema and array field
+	views          map[string]*materializedView          // Materialized views by name
+	counters       map[string]int64                      // Last serial key assigned in each schema
+	versions       map[string]int64                      // Version of each schema definition, counted up as it changes
+	recordVersions map[string]map[string]int64           // Schema version every record was written under, by schema and key
+	documents      map[string]string                     // JSON Schema documents of the schemas defined by one
+	extends        map[string]string                     // Parent and own fields of the schemas extending another, as "Parent field:type ..."
+	metrics        map[string]map[string]int64           // Engine activity counters saved by earlier runs, by schema
+	legacyRecords  bool                                  // Set while a records file written by an older version holds records
+	dirty          bool                                  // Set when changes are waiting for a batch flush
 }
 
 // Storage manages records in memory with BSON persistence
 type Storage struct {
-	config    *config.Config
-	stores    map[string]*dbs.Store // Maps database names to stores
-	dbStates  map[string]*DatabaseState // Maps database names to their data state
-	currentDB string                    // The currently selected database
-	mutex     sync.RWMutex
+	config     *config.Config
+	stores     map[string]*dbs.Store     // Maps database names to stores
+	dbStates   map[string]*DatabaseState // Maps database names to their data state
+	currentDB  string                    // The currently selected database
+	batchDepth int                       // Nesting depth of open Begin calls
+	async      bool                      // Persist from a background goroutine instead of on every write
+	stopFlush  chan struct{}             // Closed to stop the background flusher
+	flushDone  chan struct{}             // Closed once the background flusher has exited
+	progress   Progress                  // Told how far long operations got, when set
+	closeOnce  sync.Once                 // Runs the shutdown of Close once
+	closeErr   error                     // What the shutdown returned
+	mutex      sync.RWMutex
 }
 
 // NewStorage creates a new storage instance with persistence
@@ -36,19 +61,38 @@ func NewStorage(config *config.Config) *Storage {
 		config:    config,
 		stores:    make(map[string]*dbs.Store),
 		dbStates:  make(map[string]*DatabaseState),
-		currentDB: "default", // Default database
+		currentDB: config.Database, // Default database
 	}
 
 	// Initialize default database state
-	s.dbStates["default"] = &DatabaseState{
-		records:     make(map[string]map[string]interface{}),
-		schemas:     make(map[string]string),
-		partialKeys: make(map[string]map[string][]string),
+	s.dbStates[s.currentDB] = &DatabaseState{
+		records:        make(map[string]*preprocessing.LSMTree),
+		schemas:        make(map[string]string),
+		checksums:      make(map[string]map[string]string),
+		archived:       make(map[string]bool),
+		indexes:        make(map[string]map[string]fieldIndex),
+		indexDefs:      make(map[string][]string),
+		compound:       make(map[string]map[string]*compoundIndex),
+		textIndex:      make(map[string]map[string]map[string]int),
+		folded:         make(map[string]map[string]map[string]bool),
+		geo:            make(map[string]map[string]*geoIndex),
+		arrays:         make(map[string]map[string]fieldIndex),
+		views:          make(map[string]*materializedView),
+		counters:       make(map[string]int64),
+		versions:       make(map[string]int64),
+		recordVersions: make(map[string]map[string]int64),
+		documents:      make(map[string]string),
+		extends:        make(map[string]string),
+		metrics:        make(map[string]map[string]int64),
 	}
 
 	// Load existing data from persistent storage for default database
 	s.loadFromPersistent()
 
+	if config.FlushInterval > 0 {
+		s.startBackgroundFlush(config.FlushInterval)
+	}
+
 	return s
 }
 
@@ -59,9 +103,10 @@ func (s *Storage) getOrCreateStore(dbName string) *dbs.Store {
 	}
 
 	// If the store doesn't exist, create a new one
-	dbPath := filepath.Join("dbs", dbName)
+	dbPath := filepath.Join(s.config.DataDir, dbName)
 	if err := os.MkdirAll(dbPath, 0755); err != nil {
-		// Handle error, maybe log it or return an error
+		// Saving the database reports the failure again when it happens
+		s.config.Warnf("cannot create directory of database '%s': %v", dbName, err)
 	}
 	storagePath := filepath.Join(dbPath, "db.bson")
 	newStore := dbs.NewStore(storagePath)
@@ -77,9 +122,24 @@ func (s *Storage) getDBState(dbName string) *DatabaseState {
 
 	// Create new database state
 	dbState := &DatabaseState{
-		records:     make(map[string]map[string]interface{}),
-		schemas:     make(map[string]string),
-		partialKeys: make(map[string]map[string][]string),
+		records:        make(map[string]*preprocessing.LSMTree),
+		schemas:        make(map[string]string),
+		checksums:      make(map[string]map[string]string),
+		archived:       make(map[string]bool),
+		indexes:        make(map[string]map[string]fieldIndex),
+		indexDefs:      make(map[string][]string),
+		compound:       make(map[string]map[string]*compoundIndex),
+		textIndex:      make(map[string]map[string]map[string]int),
+		folded:         make(map[string]map[string]map[string]bool),
+		geo:            make(map[string]map[string]*geoIndex),
+		arrays:         make(map[string]map[string]fieldIndex),
+		views:          make(map[string]*materializedView),
+		counters:       make(map[string]int64),
+		versions:       make(map[string]int64),
+		recordVersions: make(map[string]map[string]int64),
+		documents:      make(map[string]string),
+		extends:        make(map[string]string),
+		metrics:        make(map[string]map[string]int64),
 	}
 	s.dbStates[dbName] = dbState
 	return dbState
@@ -87,64 +147,482 @@ func (s *Storage) getDBState(dbName string) *DatabaseState {
 
 // loadFromPersistent loads data from the BSON file for the current database
 func (s *Storage) loadFromPersistent() {
+	start := time.Now()
 	store := s.getOrCreateStore(s.currentDB)
 	dbState := s.getDBState(s.currentDB)
-	
+	s.config.Debugf("loading database '%s' from %s", s.currentDB, store.Dir())
+
+	// Unreadable files are loaded as empty, so the rest of the database
+	// stays usable
+	warnLoad := func(what string, err error) {
+		s.config.Warnf("cannot read %s of database '%s', loading none: %v", what, s.currentDB, err)
+	}
+
 	records, err := store.LoadRecords()
 	if err != nil {
-		dbState.records = make(map[string]map[string]interface{})
-	} else {
-		dbState.records = records
+		warnLoad("records", err)
+		records = make(map[string]map[string]interface{})
 	}
 
-	schemas, err := store.LoadSchemas()
-	if err != nil {
+	schemas, schemasErr := store.LoadSchemas()
+	if schemasErr != nil {
+		warnLoad("schemas", schemasErr)
 		dbState.schemas = make(map[string]string)
 	} else {
 		dbState.schemas = schemas
 	}
 
-	s.rebuildPartialKeyIndex()
-}
+	// Archived schemas stay on disk until they are first accessed
+	dbState.archived = make(map[string]bool)
+	if archived, err := store.ListArchivedSchemas(); err == nil {
+		for _, schemaName := range archived {
+			dbState.archived[schemaName] = true
+		}
+	} else {
+		warnLoad("archived schemas", err)
+	}
 
-// rebuildPartialKeyIndex builds partial key lookup table for current database
-func (s *Storage) rebuildPartialKeyIndex() {
-	dbState := s.getDBState(s.currentDB)
-	dbState.partialKeys = make(map[string]map[string][]string)
+	// Trees opened below start from the counters of earlier runs
+	metrics, err := store.LoadMetrics()
+	if err != nil {
+		warnLoad("statistics", err)
+		metrics = make(map[string]map[string]int64)
+	}
+	dbState.metrics = metrics
 
-	for schemaName, schemaRecords := range dbState.records {
-		if schemaName == "__schemas__" {
-			continue
+	// Every schema gets a tree holding its SSTables. Records files written
+	// by older versions, which kept every record there, are moved into the
+	// SSTables and emptied.
+	for _, table := range dbState.records {
+		table.Close()
+	}
+	dbState.records = make(map[string]*preprocessing.LSMTree)
+	dbState.legacyRecords = false
+	for schemaName := range schemas {
+		if _, exists := records[schemaName]; !exists {
+			records[schemaName] = nil
+		}
+	}
+	for schemaName, schemaRecords := range records {
+		if !dbState.archived[schemaName] {
+			dbState.records[schemaName] = s.openTable(schemaName, schemaRecords)
+			dbState.legacyRecords = dbState.legacyRecords || len(schemaRecords) > 0
 		}
+	}
+	if dbState.legacyRecords && schemasErr == nil {
+		if err := s.retireRecordsFile(s.currentDB); err != nil {
+			s.config.Warnf("cannot move the records of database '%s' to SSTables, keeping the records file: %v", s.currentDB, err)
+		}
+	}
 
-		dbState.partialKeys[schemaName] = make(map[string][]string)
+	indexDefs, err := store.LoadIndexes()
+	if err != nil {
+		warnLoad("indexes", err)
+		indexDefs = make(map[string][]string)
+	}
+	dbState.indexDefs = indexDefs
 
-		for fullKey := range schemaRecords {
-			partialKey := getPartialKey(fullKey)
-			if _, exists := dbState.partialKeys[schemaName][partialKey]; !exists {
-				dbState.partialKeys[schemaName][partialKey] = []string{}
-			}
-			dbState.partialKeys[schemaName][partialKey] = append(dbState.partialKeys[schemaName][partialKey], fullKey)
-		}
+	viewDefs, err := store.LoadViews()
+	if err != nil {
+		warnLoad("views", err)
+		viewDefs = make(map[string]string)
+	}
+	dbState.views = loadViews(viewDefs)
+
+	counters, err := store.LoadCounters()
+	if err != nil {
+		warnLoad("counters", err)
+		counters = make(map[string]int64)
+	}
+	dbState.counters = counters
+
+	versions, err := store.LoadVersions()
+	if err != nil {
+		warnLoad("versions", err)
+		versions = make(map[string]int64)
+	}
+	dbState.versions = versions
+
+	recordVersions, err := store.LoadRecordVersions()
+	if err != nil {
+		warnLoad("record versions", err)
+		recordVersions = make(map[string]map[string]int64)
 	}
+	dbState.recordVersions = recordVersions
+
+	documents, err := store.LoadJSONSchemas()
+	if err != nil {
+		warnLoad("JSON Schemas", err)
+		documents = make(map[string]string)
+	}
+	dbState.documents = documents
+
+	extends, err := store.LoadExtends()
+	if err != nil {
+		warnLoad("extends", err)
+		extends = make(map[string]string)
+	}
+	dbState.extends = extends
+
+	checksums, err := store.LoadChecksums()
+	if err != nil {
+		warnLoad("checksums", err)
+	}
+	if err != nil || checksums == nil {
+		// Databases without stored checksums get a baseline computed from
+		// their current contents
+		s.rebuildChecksums()
+	} else {
+		dbState.checksums = checksums
+	}
+
+	// Writes deferred to a flush that never came, because the process died,
+	// are in the commit logs; the journal holds what they changed next to
+	// the records
+	journal, err := store.LoadJournal()
+	if err != nil {
+		warnLoad("journal", err)
+	}
+	s.replayJournal(journal)
+
+	s.rebuildIndexes()
+	s.rebuildFoldedKeys()
+	s.config.Debugf("loaded database '%s': %d schemas in %v", s.currentDB, len(dbState.schemas), time.Since(start))
 }
 
-// saveToPersistent writes data to the BSON file for the current database
-func (s *Storage) saveToPersistent() error {
+// openTable opens the LSM tree holding the records of one schema in the
+// current database, its keys in the order keyComparator picks for the
+// schema. Its SSTables and commit log hold the records; the given records,
+// read from a cold file or a records file of an older version, form a base
+// layer beneath them that is kept in memory until it is persisted.
+func (s *Storage) openTable(schemaName string, records map[string]interface{}) *preprocessing.LSMTree {
 	store := s.getOrCreateStore(s.currentDB)
+	compare := keyComparator(s.getDBState(s.currentDB).schemas[schemaName])
+
+	table, err := preprocessing.OpenLSMTree(store.TableDir(schemaName), s.config.MemTableSize, compare)
+	if err != nil {
+		// Unreadable SSTables are left alone on disk for recovery
+		s.config.Warnf("cannot read SSTables of schema '%s', changes to it will not be saved: %v", schemaName, err)
+		table = preprocessing.NewLSMTree(s.config.MemTableSize, compare)
+	}
+	table.SetMaxBytes(s.config.MemTableBytes)
+	table.AddCounters(s.getDBState(s.currentDB).metrics[schemaName])
+	if len(records) > 0 {
+		table.Seed(records)
+	}
+	if s.config.CompactionThreshold > 0 {
+		table.StartCompactor(s.config.CompactionThreshold, s.config.CompactionInterval)
+	}
+	return table
+}
+
+// lookupTable returns the LSM tree of a schema in the current database for
+// reading. Unlike table it never adds a tree, so it is safe under the read
+// lock: a schema whose tree was never opened holds no records yet and gets
+// an empty tree that is not kept.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) lookupTable(schemaName string) *preprocessing.LSMTree {
 	dbState := s.getDBState(s.currentDB)
-	
-	if err := store.SaveRecords(dbState.records); err != nil {
+	if table, exists := dbState.records[schemaName]; exists {
+		return table
+	}
+	return preprocessing.NewLSMTree(s.config.MemTableSize, keyComparator(dbState.schemas[schemaName]))
+}
+
+// table returns the LSM tree of a schema in the current database, creating
+// an empty one if the schema has none yet, for writing to it. Readers use
+// lookupTable.
+// NOTE: This function should be called from within a write-locked context
+func (s *Storage) table(schemaName string) *preprocessing.LSMTree {
+	dbState := s.getDBState(s.currentDB)
+
+	table, exists := dbState.records[schemaName]
+	if !exists {
+		table = s.openTable(schemaName, nil)
+		dbState.records[schemaName] = table
+	}
+	return table
+}
+
+// saveToPersistent writes data to the BSON file for the current database.
+// While a batch is open, or in async mode, the write is deferred until the
+// next flush.
+func (s *Storage) saveToPersistent() error {
+	if s.deferred() {
+		s.getDBState(s.currentDB).dirty = true
+		return nil
+	}
+
+	return s.persistDB(s.currentDB)
+}
+
+// deferred reports whether writes are persisted by a later flush instead of
+// by the operation making them
+// NOTE: This function should be called from within a locked context
+func (s *Storage) deferred() bool {
+	return s.batchDepth > 0 || s.async
+}
+
+// persistDB writes the state of the given database to its BSON file,
+// reporting a failure as an IO error
+func (s *Storage) persistDB(dbName string) error {
+	if err := s.writeDB(dbName); err != nil {
+		return &kindError{kind: ErrorIO, err: err}
+	}
+	return nil
+}
+
+// writeDB writes the state of the given database to its files
+func (s *Storage) writeDB(dbName string) error {
+	start := time.Now()
+	store := s.getOrCreateStore(dbName)
+	dbState := s.getDBState(dbName)
+
+	// Records are durable in the SSTables and commit log of their schema
+	// as soon as they are written, so a save only writes the files kept
+	// next to them
+	if dbState.legacyRecords {
+		if err := s.retireRecordsFile(dbName); err != nil {
+			return err
+		}
+	}
+
+	if err := store.SaveSchemas(dbState.schemas); err != nil {
+		return err
+	}
+
+	if err := store.SaveChecksums(dbState.checksums); err != nil {
 		return err
 	}
 
+	if err := store.SaveIndexes(dbState.indexDefs); err != nil {
+		return err
+	}
+
+	if err := store.SaveViews(viewQueries(dbState.views)); err != nil {
+		return err
+	}
+
+	if err := store.SaveCounters(dbState.counters); err != nil {
+		return err
+	}
+
+	if err := store.SaveVersions(dbState.versions); err != nil {
+		return err
+	}
+
+	if err := store.SaveRecordVersions(dbState.recordVersions); err != nil {
+		return err
+	}
+
+	if err := store.SaveJSONSchemas(dbState.documents); err != nil {
+		return err
+	}
+
+	if err := store.SaveExtends(dbState.extends); err != nil {
+		return err
+	}
+
+	if err := store.ClearJournal(); err != nil {
+		return err
+	}
+
+	if err := s.saveMetrics(dbName); err != nil {
+		return err
+	}
+
+	// Cold files of schemas that were modified since are now stale
+	archived, err := store.ListArchivedSchemas()
+	if err != nil {
+		return err
+	}
+	for _, schemaName := range archived {
+		if dbState.archived[schemaName] {
+			continue
+		}
+		if err := store.RemoveArchivedSchema(schemaName); err != nil {
+			return err
+		}
+	}
+
+	dbState.dirty = false
+	s.config.Debugf("saved database '%s' to %s in %v", dbName, store.Dir(), time.Since(start))
+	return nil
+}
+
+// retireRecordsFile moves the records a records file written by an older
+// version holds into the SSTables of their schemas, then empties the file.
+// The schema catalog is written first, as the file may also hold the
+// schemas of versions older still.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) retireRecordsFile(dbName string) error {
+	dbState := s.getDBState(dbName)
+	store := s.getOrCreateStore(dbName)
+
+	for schemaName, table := range dbState.records {
+		if dbState.archived[schemaName] {
+			continue
+		}
+		if err := table.Persist(); err != nil {
+			return err
+		}
+	}
+
 	if err := store.SaveSchemas(dbState.schemas); err != nil {
 		return err
 	}
+	if err := store.SaveRecords(make(map[string]map[string]interface{})); err != nil {
+		return err
+	}
+
+	dbState.legacyRecords = false
+	return nil
+}
+
+// saveMetrics writes the activity counters of every schema of a database,
+// so statistics add up across runs. Archived schemas that were not loaded
+// keep the counters they were saved with.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) saveMetrics(dbName string) error {
+	dbState := s.getDBState(dbName)
+
+	metrics := make(map[string]map[string]int64, len(dbState.schemas))
+	for schemaName := range dbState.schemas {
+		if table, loaded := dbState.records[schemaName]; loaded {
+			metrics[schemaName] = table.Metrics().Counters()
+		} else if saved, exists := dbState.metrics[schemaName]; exists {
+			metrics[schemaName] = saved
+		}
+	}
+
+	return s.getOrCreateStore(dbName).SaveMetrics(metrics)
+}
+
+// Begin opens a write batch. Mutations made until the matching Flush are
+// kept in memory and persisted together in a single write per database.
+// Batches may be nested; only the outermost Flush writes to disk.
+func (s *Storage) Begin() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.batchDepth++
+}
+
+// Flush closes the current write batch and persists every database that
+// was modified while it was open. Outside of a batch it persists any
+// pending changes, which is how async mode is flushed on demand.
+func (s *Storage) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.batchDepth > 0 {
+		s.batchDepth--
+	}
+	if s.batchDepth > 0 {
+		return nil
+	}
+
+	return s.flushDirty()
+}
+
+// flushDirty persists every database with pending changes
+// NOTE: This function should be called from within a locked context
+func (s *Storage) flushDirty() error {
+	for dbName, dbState := range s.dbStates {
+		if !dbState.dirty {
+			continue
+		}
+		if err := s.persistDB(dbName); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// startBackgroundFlush switches the storage to async mode: mutations only
+// update memory and a goroutine persists pending changes every interval
+func (s *Storage) startBackgroundFlush(interval time.Duration) {
+	s.async = true
+	s.stopFlush = make(chan struct{})
+	s.flushDone = make(chan struct{})
+
+	go func() {
+		defer close(s.flushDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				wait := time.Now()
+				s.mutex.Lock()
+				held := time.Now()
+				if s.batchDepth == 0 {
+					// Errors are retried on the next tick and surfaced by Close
+					if err := s.flushDirty(); err != nil {
+						s.config.Debugf("background flush failed, retrying: %v", err)
+					}
+				}
+				s.mutex.Unlock()
+				if waited := held.Sub(wait); waited > time.Millisecond {
+					s.config.Debugf("background flush waited %v for the storage lock", waited)
+				}
+			case <-s.stopFlush:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background flusher, if any, and guarantees that all
+// pending changes are written before it returns. Only the first call shuts
+// the storage down; later ones, such as that of a signal handler racing
+// the end of the process, wait for it and return its error.
+func (s *Storage) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.shutdown()
+	})
+	return s.closeErr
+}
+
+// shutdown implements Close
+func (s *Storage) shutdown() error {
+	s.mutex.RLock()
+	async := s.async
+	s.mutex.RUnlock()
+	if async {
+		close(s.stopFlush)
+		<-s.flushDone
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.async = false
+	s.batchDepth = 0
+	err := s.flushDirty()
+
+	for dbName, dbState := range s.dbStates {
+		for _, table := range dbState.records {
+			if closeErr := table.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+
+		// Reads do not save the database, so their counters are saved here;
+		// losing them is not worth failing the command for
+		if len(dbState.schemas) > 0 {
+			if metricsErr := s.saveMetrics(dbName); metricsErr != nil {
+				s.config.Debugf("cannot save statistics of database '%s': %v", dbName, metricsErr)
+			}
+		}
+	}
+
+	return err
+}
+
 // UseDB switches to a different database
 func (s *Storage) UseDB(dbName string) {
 	s.mutex.Lock()
@@ -158,9 +636,27 @@ func (s *Storage) UseDB(dbName string) {
 	s.loadFromPersistent()
 }
 
+// Reload reads the database in use again from its files, so changes saved
+// by another process become visible. Changes this process has not saved
+// are dropped.
+func (s *Storage) Reload() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.loadFromPersistent()
+}
+
+// CurrentDB returns the name of the database in use
+func (s *Storage) CurrentDB() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.currentDB
+}
+
 // ListDBs lists all available databases
 func (s *Storage) ListDBs() ([]string, error) {
-	files, err := ioutil.ReadDir("dbs")
+	files, err := ioutil.ReadDir(s.config.DataDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read dbs directory: %v", err)
 	}
@@ -175,16 +671,101 @@ func (s *Storage) ListDBs() ([]string, error) {
 	return dbsList, nil
 }
 
-// CreateSchema adds a new schema definition
+// CreateSchema adds a new schema definition. A schema that extended
+// another no longer does.
 func (s *Storage) CreateSchema(name string, fields string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	return s.defineSchema(name, fields, "", "")
+}
+
+// defineSchema creates or redefines a schema with a definition, the JSON
+// Schema document it was derived from, if any, and the parent and own
+// fields it was inherited from, if any. A schema redefined without a
+// document or parent no longer has one. The schemas extending it inherit
+// the new definition.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) defineSchema(name, fields, document, extends string) error {
+	if err := validateSchemaDef(fields, s.getDBState(s.currentDB).schemas); err != nil {
+		return err
+	}
+	if err := s.checkWritable(name); err != nil {
+		return err
+	}
+	if children := s.childSchemas(name); document != "" && len(children) > 0 {
+		return fmt.Errorf("schema '%s' is extended by '%s' and cannot be defined by a JSON Schema document", name, children[0])
+	}
+
 	dbState := s.getDBState(s.currentDB)
+	previous, existed := dbState.schemas[name]
+	previousDocument := dbState.documents[name]
+	previousExtends := dbState.extends[name]
 	dbState.schemas[name] = fields
+	if document != "" {
+		dbState.documents[name] = document
+	} else {
+		delete(dbState.documents, name)
+	}
+	if extends != "" {
+		dbState.extends[name] = extends
+	} else {
+		delete(dbState.extends, name)
+	}
+
+	s.table(name)
 
-	if _, exists := dbState.records[name]; !exists {
-		dbState.records[name] = make(map[string]interface{})
+	// Existing records of a schema made to ignore case take the declared
+	// field names, and must satisfy new unique, required, key and strict
+	// constraints, and the new document. So must the records of the
+	// schemas extending it.
+	folded, err := s.foldStoredFields(name)
+	if err == nil {
+		err = s.indexSchema(name)
+	}
+	if err == nil {
+		err = s.checkRequired(name)
+	}
+	if err == nil {
+		err = s.checkKeys(name)
+	}
+	if err == nil {
+		err = s.checkStrict(name)
+	}
+	if err == nil {
+		err = s.checkDocument(name)
+	}
+	if err == nil {
+		err = s.inheritChildren(name)
+	}
+	if err != nil {
+		for _, change := range folded {
+			s.putRecord(name, change.key, change.previous)
+		}
+		if existed {
+			dbState.schemas[name] = previous
+		} else {
+			delete(dbState.schemas, name)
+		}
+		if previousDocument != "" {
+			dbState.documents[name] = previousDocument
+		} else {
+			delete(dbState.documents, name)
+		}
+		if previousExtends != "" {
+			dbState.extends[name] = previousExtends
+		} else {
+			delete(dbState.extends, name)
+		}
+		s.indexSchema(name)
+		return err
+	}
+
+	// Records written from now on are stamped with the new version
+	if !existed {
+		dbState.versions[name] = 1
+	} else if fields != previous || document != previousDocument {
+		dbState.versions[name] = s.schemaVersion(name) + 1
 	}
 
 	return s.saveToPersistent()
@@ -198,7 +779,7 @@ func (s *Storage) GetSchema(name string) (string, error) {
 	dbState := s.getDBState(s.currentDB)
 	schema, exists := dbState.schemas[name]
 	if !exists {
-		return "", fmt.Errorf("schema '%s' does not exist", name)
+		return "", Errorf(ErrorNotFound, "schema '%s' does not exist", name)
 	}
 
 	return schema, nil
@@ -218,22 +799,55 @@ func (s *Storage) ListSchemas() []string {
 	return schemaNames
 }
 
-// AddRecord adds a record to a schema
-func (s *Storage) AddRecord(schemaName string, recordData string) error {
+// AddRecord adds a record to a schema. It returns the key generated for a
+// record that left out a key field declared uuid, or an empty string.
+func (s *Storage) AddRecord(schemaName string, recordData string) (string, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	generated, _, err := s.addRecord(schemaName, recordData, false, false)
+	if err != nil {
+		return "", err
+	}
+
+	return generated, s.saveToPersistent()
+}
+
+// addRecord stores a new record. With upsert set, a record whose key is
+// already taken is merged into the stored one instead of replacing it. It
+// returns the key generated for a record that left out a uuid key field,
+// empty when the record had a key, and reports whether a new record was
+// inserted. Force lets an upsert change immutable fields.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) addRecord(schemaName string, recordData string, upsert, force bool) (string, bool, error) {
 	dbState := s.getDBState(s.currentDB)
 
 	if _, exists := dbState.schemas[schemaName]; !exists {
-		return fmt.Errorf("schema '%s' does not exist", schemaName)
+		return "", false, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if err := s.checkWritable(schemaName); err != nil {
+		return "", false, err
+	}
+
+	if err := s.ensureLoaded(schemaName); err != nil {
+		return "", false, err
 	}
 
 	// Parse the incoming record
 	var parsedRecord map[string]interface{}
 	if err := json.Unmarshal([]byte(recordData), &parsedRecord); err != nil {
-		return fmt.Errorf("invalid JSON format: %v", err)
+		return "", false, Errorf(ErrorValidation, "invalid JSON format: %v", err)
+	}
+	if _, err := foldFieldNames(dbState.schemas[schemaName], parsedRecord); err != nil {
+		return "", false, err
+	}
+
+	generated, err := s.generateKey(schemaName, parsedRecord)
+	if err != nil {
+		return "", false, err
 	}
+	// The key is taken from the canonical form of its field
+	normalizeFields(dbState.schemas[schemaName], parsedRecord, dbState.schemas)
 
 	// Add timestamp fields
 	now := time.Now().Format(time.RFC3339)
@@ -243,20 +857,22 @@ func (s *Storage) AddRecord(schemaName string, recordData string) error {
 	// Marshal back to JSON string
 	updatedRecordData, err := json.Marshal(parsedRecord)
 	if err != nil {
-		return fmt.Errorf("failed to marshal updated record: %v", err)
+		return "", false, fmt.Errorf("failed to marshal updated record: %v", err)
 	}
 
-	// Validate the record with the new timestamp fields
-	if err := s.validateRecordAgainstSchema(schemaName, string(updatedRecordData)); err != nil {
-		return fmt.Errorf("record validation failed: %v", err)
+	var key string
+	if field := keyField(dbState.schemas[schemaName]); field != "" {
+		if key, err = recordKey(field, parsedRecord); err != nil {
+			return "", false, err
+		}
+	} else {
+		key = extractKeyFromRecord(string(updatedRecordData))
 	}
-
-	key := extractKeyFromRecord(string(updatedRecordData))
 	if key == "" || key == string(updatedRecordData) {
 		if err := json.Unmarshal(updatedRecordData, &parsedRecord); err == nil {
 			for _, field := range []string{"id", "name", "key"} {
 				if val, exists := parsedRecord[field]; exists {
-					key = fmt.Sprintf("%v", val)
+					key = formatKey(val)
 					break
 				}
 			}
@@ -264,17 +880,43 @@ func (s *Storage) AddRecord(schemaName string, recordData string) error {
 	}
 
 	if key == "" {
-		return fmt.Errorf("could not extract a valid key from record data: %s", string(updatedRecordData))
+		return "", false, fmt.Errorf("could not extract a valid key from record data: %s", string(updatedRecordData))
 	}
 
-	if _, exists := dbState.records[schemaName]; !exists {
-		dbState.records[schemaName] = make(map[string]interface{})
+	if upsert {
+		if _, err := s.lookupTable(schemaName).Get(key); err == nil {
+			return "", false, s.updateRecord(schemaName, key, parsedRecord, force)
+		}
 	}
 
-	dbState.records[schemaName][key] = string(updatedRecordData)
-	s.updatePartialKeyIndex(schemaName, key, true)
+	// Defaults only complete new records, never the fields an upsert leaves
+	// out of an existing one
+	filled, err := applyDefaults(dbState.schemas[schemaName], parsedRecord)
+	if err != nil {
+		return "", false, err
+	}
+	if filled {
+		normalizeFields(dbState.schemas[schemaName], parsedRecord, dbState.schemas)
+		if updatedRecordData, err = json.Marshal(parsedRecord); err != nil {
+			return "", false, fmt.Errorf("failed to marshal updated record: %v", err)
+		}
+	}
 
-	return s.saveToPersistent()
+	// Validate the record with the new timestamp fields
+	if err := s.validateRecordAgainstSchema(schemaName, string(updatedRecordData)); err != nil {
+		return "", false, Errorf(ErrorValidation, "record validation failed: %v", err)
+	}
+
+	if err := s.checkUnique(schemaName, key, parsedRecord); err != nil {
+		return "", false, Errorf(ErrorValidation, "record validation failed: %v", err)
+	}
+	if err := s.checkRefs(schemaName, parsedRecord); err != nil {
+		return "", false, Errorf(ErrorValidation, "record validation failed: %v", err)
+	}
+
+	s.writeRecord(schemaName, key, string(updatedRecordData))
+
+	return generated, true, nil
 }
 
 // validateRecordAgainstSchema checks if record matches schema types
@@ -282,9 +924,9 @@ func (s *Storage) AddRecord(schemaName string, recordData string) error {
 func (s *Storage) validateRecordAgainstSchema(schemaName string, recordData string) error {
 	dbState := s.getDBState(s.currentDB)
 	schemaDef, exists := dbState.schemas[schemaName]
-	
+
 	if !exists {
-		return fmt.Errorf("schema '%s' does not exist", schemaName)
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
 	}
 
 	var record map[string]interface{}
@@ -292,15 +934,55 @@ func (s *Storage) validateRecordAgainstSchema(schemaName string, recordData stri
 		return fmt.Errorf("invalid JSON format: %v", err)
 	}
 
-	fields := parseSchemaFields(schemaDef)
+	// In strict mode every schema rejects undeclared fields at the top
+	// level; nested objects follow their own schema
+	if s.config.Strict {
+		if field := unknownField(schemaDef, record); field != "" {
+			return fmt.Errorf("field '%s' is not declared in schema '%s'", field, schemaName)
+		}
+	}
 
-	for field, fieldType := range fields {
-		if _, exists := record[field]; !exists {
+	if err := validateObject(record, schemaDef, dbState.schemas); err != nil {
+		return err
+	}
+	if err := validateDocument(dbState.documents[schemaName], record); err != nil {
+		return err
+	}
+	s.config.Debugf("record validated against schema '%s': %d declared fields, strict %t, JSON Schema %t",
+		schemaName, len(DeclaredFields(schemaDef)), s.config.Strict || isStrict(schemaDef), dbState.documents[schemaName] != "")
+	return nil
+}
+
+// validateObject checks the fields of a record, or of an object nested in
+// one, against a schema definition
+func validateObject(object map[string]interface{}, schemaDef string, schemas map[string]string) error {
+	if field := missingRequired(schemaDef, object); field != "" {
+		return fmt.Errorf("required field '%s' is missing", field)
+	}
+	if isStrict(schemaDef) {
+		if field := unknownField(schemaDef, object); field != "" {
+			return fmt.Errorf("field '%s' is not declared in the strict schema", field)
+		}
+	}
+
+	for _, def := range parseFieldDefs(schemaDef) {
+		value, exists := object[def.name]
+		if !exists {
 			continue
 		}
 
-		if err := validateFieldType(record[field], fieldType); err != nil {
-			return fmt.Errorf("field '%s' type validation failed: %v", field, err)
+		if err := validateFieldType(value, def.fieldType, schemas); err != nil {
+			return fmt.Errorf("field '%s' type validation failed: %v", def.name, err)
+		}
+		if def.constraints != nil && value != nil {
+			if err := def.constraints.check(value); err != nil {
+				return fmt.Errorf("field '%s' %v", def.name, err)
+			}
+		}
+		if len(def.validators) > 0 && value != nil {
+			if err := runValidators(def.validators, value); err != nil {
+				return fmt.Errorf("field '%s' %v", def.name, err)
+			}
 		}
 	}
 
@@ -310,34 +992,21 @@ func (s *Storage) validateRecordAgainstSchema(schemaName string, recordData stri
 // parseSchemaFields parses the schema definition string and returns fields and their types
 func parseSchemaFields(schemaDef string) map[string]string {
 	fields := make(map[string]string)
-	parts := strings.Split(schemaDef, " ")
-
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
-		}
-
-		// Split by colon to separate field name and type (e.g., "name:string")
-		pair := strings.Split(part, ":")
-		if len(pair) == 2 {
-			fieldName := strings.TrimSpace(pair[0])
-			fieldType := strings.TrimSpace(pair[1])
-			fields[fieldName] = fieldType
-		}
+	for _, def := range parseFieldDefs(schemaDef) {
+		fields[def.name] = def.fieldType
 	}
-
 	return fields
 }
 
-// validateFieldType checks if value matches expected type
-func validateFieldType(value interface{}, expectedType string) error {
+// validateFieldType checks if value matches expected type. A type naming
+// one of the schemas expects an object whose fields match that schema.
+func validateFieldType(value interface{}, expectedType string, schemas map[string]string) error {
 	switch expectedType {
-	case "string":
+	case "string", "text":
 		if _, ok := value.(string); !ok {
 			return fmt.Errorf("expected string, got %T", value)
 		}
-	case "int", "integer":
+	case "int", "integer", "serial":
 		// JSON unmarshaling may represent numbers as float64
 		switch v := value.(type) {
 		case float64:
@@ -360,10 +1029,72 @@ func validateFieldType(value interface{}, expectedType string) error {
 		if !ok {
 			return fmt.Errorf("expected bool, got %T", value)
 		}
+	case "array", "list":
+		if _, ok := value.([]interface{}); !ok {
+			return fmt.Errorf("expected array, got %T", value)
+		}
+	case "uuid":
+		if _, ok := canonicalUUID(value); !ok {
+			return fmt.Errorf("expected uuid such as 123e4567-e89b-12d3-a456-426614174000, got %v", value)
+		}
+	case "bytes":
+		if _, ok := canonicalBytes(value); !ok {
+			return fmt.Errorf("expected base64 encoded bytes, got %v", value)
+		}
+	case "decimal":
+		if _, ok := decimalValue(value); !ok {
+			return fmt.Errorf("expected decimal such as \"19.99\", got %v", value)
+		}
+	case "date", "datetime", "timestamp":
+		text, _ := value.(string)
+		if _, ok := preprocessing.ParseTime(text); !ok {
+			if expectedType == "date" {
+				return fmt.Errorf("expected date such as 2006-01-02, got %v", value)
+			}
+			return fmt.Errorf("expected timestamp such as 2006-01-02T15:04:05Z, got %v", value)
+		}
+	case "geo":
+		if _, _, ok := geoPoint(value); !ok {
+			return fmt.Errorf("expected geo point {\"lat\": ..., \"lon\": ...} or [lat, lon], got %v", value)
+		}
 	case "object", "json":
 		// Accept any type for object/json type
 		return nil
 	default:
+		if elemType, ok := elementType(expectedType); ok {
+			elements, isArray := value.([]interface{})
+			if !isArray {
+				return fmt.Errorf("expected array, got %T", value)
+			}
+			for i, element := range elements {
+				if err := validateFieldType(element, elemType, schemas); err != nil {
+					return fmt.Errorf("element %d: %v", i, err)
+				}
+			}
+			return nil
+		}
+		if schemaDef, ok := schemas[expectedType]; ok {
+			object, isObject := value.(map[string]interface{})
+			if !isObject {
+				return fmt.Errorf("expected %s object, got %T", expectedType, value)
+			}
+			return validateObject(object, schemaDef, schemas)
+		}
+		if _, _, ok := parseRef(expectedType); ok {
+			if _, ok := refKey(value); !ok {
+				return fmt.Errorf("expected the key of a referenced record, got %v", value)
+			}
+			return nil
+		}
+		if values, ok := parseEnum(expectedType); ok {
+			text, isString := value.(string)
+			for _, allowed := range values {
+				if isString && text == allowed {
+					return nil
+				}
+			}
+			return fmt.Errorf("expected one of %s, got %v", strings.Join(values, ", "), value)
+		}
 		// For unknown types, accept any value for MVP
 		return nil
 	}
@@ -371,134 +1102,68 @@ func validateFieldType(value interface{}, expectedType string) error {
 	return nil
 }
 
-// getPartialKey returns the first 5 characters of the key as the partial key
-func getPartialKey(fullKey string) string {
-	if len(fullKey) <= 5 {
-		return fullKey
-	}
-	return fullKey[:5]
-}
-
-// updatePartialKeyIndex adds or removes a key from the partial key index
+// putRecord stores a record under its key and updates every structure
+// derived from it
 // NOTE: This function should be called from within a locked context
-func (s *Storage) updatePartialKeyIndex(schemaName, fullKey string, add bool) {
-	dbState := s.getDBState(s.currentDB)
-	
-	if _, exists := dbState.partialKeys[schemaName]; !exists {
-		dbState.partialKeys[schemaName] = make(map[string][]string)
+func (s *Storage) putRecord(schemaName, key, recordData string) {
+	s.unindexRecord(schemaName, key)
+	s.table(schemaName).Put(key, recordData)
+	s.updateFoldedKey(schemaName, key, true)
+	fields, err := decodeRecord(recordData)
+	if err == nil {
+		s.indexRecord(schemaName, key, fields, true)
 	}
+	s.updateChecksum(schemaName, key, recordData)
+	s.journalRecord(schemaName, key, false)
 
-	partialKey := getPartialKey(fullKey)
+	s.unarchive(schemaName)
 
-	if add {
-		// Add the full key to the partial key list if not already there
-		found := false
-		for _, key := range dbState.partialKeys[schemaName][partialKey] {
-			if key == fullKey {
-				found = true
-				break
-			}
-		}
-		if !found {
-			dbState.partialKeys[schemaName][partialKey] = append(dbState.partialKeys[schemaName][partialKey], fullKey)
-		}
-	} else {
-		// Remove the full key from the partial key list
-		newKeys := []string{}
-		for _, key := range dbState.partialKeys[schemaName][partialKey] {
-			if key != fullKey {
-				newKeys = append(newKeys, key)
-			}
-		}
-		dbState.partialKeys[schemaName][partialKey] = newKeys
-	}
+	s.syncViews(schemaName, key, recordData, fields)
 }
 
-// GetRecord retrieves a record from a schema
-func (s *Storage) GetRecord(schemaName string, key string) (interface{}, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
+// removeRecord deletes a record and its entries in derived structures
+// NOTE: This function should be called from within a locked context
+func (s *Storage) removeRecord(schemaName, key string) {
 	dbState := s.getDBState(s.currentDB)
 
-	// Check if schema exists
-	_, exists := dbState.schemas[schemaName]
-	if !exists {
-		return nil, fmt.Errorf("schema '%s' does not exist", schemaName)
-	}
-
-	// First, try exact key match
-	record, exists := dbState.records[schemaName][key]
-	if exists {
-		return record, nil
-	}
+	s.unindexRecord(schemaName, key)
+	s.table(schemaName).Delete(key)
+	s.updateFoldedKey(schemaName, key, false)
+	delete(dbState.checksums[schemaName], key)
+	delete(dbState.recordVersions[schemaName], key)
+	s.journalRecord(schemaName, key, true)
 
-	// If exact match not found, try partial key lookup
-	partialMatches := s.getRecordsByPartialKey(schemaName, key)
-	if len(partialMatches) == 1 {
-		// If there's exactly one match with the partial key, return it
-		fullKey := partialMatches[0]
-		record, exists := dbState.records[schemaName][fullKey]
-		if exists {
-			return record, nil
-		}
-	} else if len(partialMatches) > 1 {
-		// If multiple matches, return an error indicating ambiguity
-		return nil, fmt.Errorf("multiple records match partial key '%s' in schema '%s': %v", key, schemaName, partialMatches)
-	}
+	s.unarchive(schemaName)
 
-	// No matches found
-	return nil, fmt.Errorf("record with key '%s' does not exist in schema '%s'", key, schemaName)
+	s.syncViews(schemaName, key, "", nil)
 }
 
-// getRecordsByPartialKey returns the list of full keys that match the partial key
-func (s *Storage) getRecordsByPartialKey(schemaName string, partialKey string) []string {
+// GetRecord retrieves a record by its exact key, reduced to the given
+// fields when any are given
+func (s *Storage) GetRecord(schemaName string, key string, fields ...string) (interface{}, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	dbState := s.getDBState(s.currentDB)
-	
-	if partialKey == "" {
-		return []string{}
-	}
-
-	var matches []string
-
-	// If the partial key is at least 5 characters, look it up directly
-	if len(partialKey) >= 5 {
-		lookupKey := partialKey[:5]
-		if schemaIndex, exists := dbState.partialKeys[schemaName]; exists {
-			if keys, exists := schemaIndex[lookupKey]; exists {
-				// Filter keys that actually start with the partial key
-				for _, key := range keys {
-					if strings.HasPrefix(key, partialKey) {
-						matches = append(matches, key)
-					}
-				}
-			}
-		}
-	} else {
-		// If the partial key is less than 5 characters,
-		// we need to look for any partial key entries that start with this prefix
-		if schemaIndex, exists := dbState.partialKeys[schemaName]; exists {
-			for partial, keys := range schemaIndex {
-				if strings.HasPrefix(partial, partialKey) || strings.HasPrefix(partialKey, partial) {
-					// Check if any of the keys in this partial match start with the partialKey
-					for _, key := range keys {
-						if strings.HasPrefix(key, partialKey) {
-							matches = append(matches, key)
-						}
-					}
-				}
-			}
-		}
+	fullKey, err := s.resolveKey(schemaName, key, MatchExact)
+	if err != nil {
+		return nil, err
 	}
 
-	return matches
+	record, err := s.lookupTable(schemaName).Get(fullKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return projectRecord(record, fields)
 }
 
-// DeleteRecord removes a record from a schema
-func (s *Storage) DeleteRecord(schemaName string, key string) error {
+// DeleteRecord removes a record from a schema, matching the key as the
+// KeyMatch asks
+func (s *Storage) DeleteRecord(schemaName string, key string, match KeyMatch) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -507,42 +1172,44 @@ func (s *Storage) DeleteRecord(schemaName string, key string) error {
 	// Check if schema exists
 	_, exists := dbState.schemas[schemaName]
 	if !exists {
-		return fmt.Errorf("schema '%s' does not exist", schemaName)
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if err := s.checkWritable(schemaName); err != nil {
+		return err
 	}
 
-	// Check if record exists
-	_, exists = dbState.records[schemaName][key]
-	if !exists {
-		return fmt.Errorf("record with key '%s' does not exist in schema '%s'", key, schemaName)
+	if err := s.ensureLoaded(schemaName); err != nil {
+		return err
 	}
 
-	// Delete the record
-	delete(dbState.records[schemaName], key)
+	// Check if record exists
+	fullKey, err := s.resolveKey(schemaName, key, match)
+	if err != nil {
+		return err
+	}
 
-	// Update partial key index
-	s.updatePartialKeyIndex(schemaName, key, false)
+	// Delete the record along with its index entries and the records its
+	// cascading references remove
+	deletion := s.newRefDeletion()
+	if err := deletion.add(schemaName, fullKey); err != nil {
+		return err
+	}
+	deletion.apply()
 
 	return s.saveToPersistent()
 }
 
-// ListRecords returns all records of a schema
+// ListRecords returns all records of a schema in key order. Use Iterate
+// to visit large schemas without holding every record in memory.
 func (s *Storage) ListRecords(schemaName string) ([]interface{}, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	dbState := s.getDBState(s.currentDB)
-
-	// Check if schema exists
-	_, exists := dbState.schemas[schemaName]
-	if !exists {
-		return nil, fmt.Errorf("schema '%s' does not exist", schemaName)
-	}
-
 	records := make([]interface{}, 0)
-	for _, record := range dbState.records[schemaName] {
+	err := s.Iterate(schemaName, func(key string, record interface{}) error {
 		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return records, nil
 }
 
@@ -553,9 +1220,29 @@ func (s *Storage) WipeDatabase() error {
 
 	// Clear current database state
 	dbState := s.getDBState(s.currentDB)
-	dbState.records = make(map[string]map[string]interface{})
+	for _, table := range dbState.records {
+		if err := table.Drop(); err != nil {
+			return err
+		}
+	}
+	dbState.records = make(map[string]*preprocessing.LSMTree)
 	dbState.schemas = make(map[string]string)
-	dbState.partialKeys = make(map[string]map[string][]string)
+	dbState.checksums = make(map[string]map[string]string)
+	dbState.archived = make(map[string]bool)
+	dbState.indexes = make(map[string]map[string]fieldIndex)
+	dbState.indexDefs = make(map[string][]string)
+	dbState.compound = make(map[string]map[string]*compoundIndex)
+	dbState.textIndex = make(map[string]map[string]map[string]int)
+	dbState.folded = make(map[string]map[string]map[string]bool)
+	dbState.geo = make(map[string]map[string]*geoIndex)
+	dbState.arrays = make(map[string]map[string]fieldIndex)
+	dbState.views = make(map[string]*materializedView)
+	dbState.counters = make(map[string]int64)
+	dbState.versions = make(map[string]int64)
+	dbState.recordVersions = make(map[string]map[string]int64)
+	dbState.documents = make(map[string]string)
+	dbState.extends = make(map[string]string)
+	dbState.metrics = make(map[string]map[string]int64)
 
 	// Save the empty state to persistent storage
 	return s.saveToPersistent()
@@ -576,11 +1263,7 @@ func extractKeyFromRecord(recordData string) string {
 
 	for _, field := range keyFields {
 		if value, exists := record[field]; exists {
-			if strValue, ok := value.(string); ok {
-				return strValue
-			}
-			// Convert non-string values to string
-			return fmt.Sprintf("%v", value)
+			return formatKey(value)
 		}
 	}
 
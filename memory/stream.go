@@ -0,0 +1,138 @@
+package memory
+
+import "errors"
+
+// errStopIteration ends an iteration early without reporting an error
+var errStopIteration = errors.New("stop iteration")
+
+// Iterate calls fn with every record of a schema in key order, stopping at
+// the first error fn returns and returning it. Records are read from a
+// snapshot of the schema taken when the iteration starts, so fn may take
+// as long as it needs and writes made meanwhile are not seen.
+func (s *Storage) Iterate(schemaName string, fn func(key string, record interface{}) error) error {
+	return s.iterateFrom(schemaName, "", fn)
+}
+
+// iterateFrom iterates like Iterate, starting at the first key not before
+// start
+func (s *Storage) iterateFrom(schemaName, start string, fn func(key string, record interface{}) error) error {
+	if err := s.loadArchived(schemaName); err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	if _, exists := s.getDBState(s.currentDB).schemas[schemaName]; !exists {
+		s.mutex.RUnlock()
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	it := s.lookupTable(schemaName).Scan(start, "")
+	s.mutex.RUnlock()
+
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stream runs a query like Explain but hands each record to fn as soon as
+// it is produced instead of collecting them, and stops scanning at the
+// first match beyond Limit, which tells whether there is a next page. Only
+// a query sorted by a field or narrowed down by an index gathers its
+// matches first; the records of other queries are read one by one from a
+// snapshot of the schema, starting after the key of opts.After when it is
+// set.
+func (s *Storage) Stream(schemaName string, opts QueryOptions, fn func(record interface{}) error) (QueryPlan, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return QueryPlan{}, err
+	}
+
+	s.mutex.RLock()
+	schemaDef, exists := s.getDBState(s.currentDB).schemas[schemaName]
+	if !exists {
+		s.mutex.RUnlock()
+		return QueryPlan{}, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	opts = canonicalOptions(schemaDef, opts)
+	filter := schemaFilter(schemaDef, opts.Filter)
+	indexed := false
+	if filter != nil {
+		_, _, indexed = s.indexLookup(schemaName, filter)
+	}
+
+	if opts.SortField != "" || indexed {
+		matches, plan, err := s.planRecords(schemaName, opts.Filter)
+		var records []interface{}
+		if err == nil {
+			records, plan.Next, err = s.shapeMatches(schemaName, matches, opts)
+		}
+		s.mutex.RUnlock()
+		if err != nil {
+			return plan, err
+		}
+
+		plan.Returned = 0
+		for _, record := range records {
+			if err := fn(record); err != nil {
+				return plan, err
+			}
+			plan.Returned++
+		}
+		return plan, nil
+	}
+	s.mutex.RUnlock()
+
+	start := ""
+	if opts.After != nil {
+		if err := opts.After.check(schemaName, opts); err != nil {
+			return QueryPlan{}, err
+		}
+		start = opts.After.Key
+	}
+
+	s.mutex.RLock()
+	compareKeys := s.lookupTable(schemaName).Compare
+	s.mutex.RUnlock()
+
+	plan := QueryPlan{Path: PathFullScan}
+	skipped := 0
+	var last queryMatch
+	err := s.iterateFrom(schemaName, start, func(key string, record interface{}) error {
+		if opts.After != nil && compareKeys(key, opts.After.Key) <= 0 {
+			return nil
+		}
+
+		plan.Examined++
+		s.mutex.RLock()
+		version := s.recordVersion(schemaName, key)
+		s.mutex.RUnlock()
+		match, ok, err := matchRecord(key, record, filter, version)
+		if err != nil || !ok {
+			return err
+		}
+		if skipped < opts.Offset {
+			skipped++
+			return nil
+		}
+
+		// A match beyond the limit means there is another page
+		if opts.Limit > 0 && plan.Returned >= opts.Limit {
+			plan.Next = newCursor(schemaName, last, opts)
+			return errStopIteration
+		}
+		last = match
+
+		if len(opts.Fields) > 0 {
+			if record, err = projectFields(match.fields, opts.Fields); err != nil {
+				return err
+			}
+		}
+		plan.Returned++
+		return fn(record)
+	})
+	if err == errStopIteration {
+		err = nil
+	}
+	return plan, err
+}
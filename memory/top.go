@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"container/heap"
+	"sort"
+
+	"simplebson/preprocessing"
+)
+
+// Top returns the n records of a schema matching the filter with the
+// smallest values of a field, or the largest when descending is set, best
+// first. Records are compared like by sortMatches and records without the
+// field are skipped. Only the best n records are kept in a heap while the
+// schema is scanned, so the result set is never built in full.
+func (s *Storage) Top(schemaName, field string, n int, descending bool, filter preprocessing.Filter) ([]interface{}, error) {
+	if err := s.loadArchived(schemaName); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	schemaDef, exists := s.getDBState(s.currentDB).schemas[schemaName]
+	if !exists {
+		return nil, Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if n <= 0 {
+		return []interface{}{}, nil
+	}
+	types := fieldTypes(schemaDef)
+	filter = schemaFilter(schemaDef, filter)
+	field = canonicalField(schemaDef, field)
+
+	best := &topHeap{numeric: isNumericType(types[field]), field: field, descending: descending}
+	it := s.lookupTable(schemaName).Scan("", "")
+	for it.Next() {
+		match, ok, err := matchRecord(it.Key(), it.Value(), filter, s.recordVersion(schemaName, it.Key()))
+		if err != nil {
+			return nil, err
+		}
+		if value, exists := preprocessing.LookupField(match.fields, field); !ok || !exists || value == nil {
+			continue
+		}
+
+		if best.Len() < n {
+			heap.Push(best, match)
+		} else if best.better(match, best.matches[0]) {
+			best.matches[0] = match
+			heap.Fix(best, 0)
+		}
+	}
+
+	sort.Slice(best.matches, func(i, j int) bool {
+		return best.better(best.matches[i], best.matches[j])
+	})
+	records := make([]interface{}, len(best.matches))
+	for i, match := range best.matches {
+		records[i] = match.record
+	}
+	return records, nil
+}
+
+// topHeap holds the best matches found so far with the worst of them on
+// top, so it is the one replaced when a better match comes along
+type topHeap struct {
+	matches    []queryMatch
+	field      string
+	numeric    bool
+	descending bool
+}
+
+// better reports whether match a ranks before match b. Equal values rank
+// in key order.
+func (h *topHeap) better(a, b queryMatch) bool {
+	valueA, _ := preprocessing.LookupField(a.fields, h.field)
+	valueB, _ := preprocessing.LookupField(b.fields, h.field)
+	order := compareFieldValues(valueA, valueB, h.numeric)
+	if h.descending {
+		order = -order
+	}
+	if order != 0 {
+		return order < 0
+	}
+	return a.key < b.key
+}
+
+func (h *topHeap) Len() int           { return len(h.matches) }
+func (h *topHeap) Less(i, j int) bool { return h.better(h.matches[j], h.matches[i]) }
+func (h *topHeap) Swap(i, j int)      { h.matches[i], h.matches[j] = h.matches[j], h.matches[i] }
+func (h *topHeap) Push(x interface{}) { h.matches = append(h.matches, x.(queryMatch)) }
+
+func (h *topHeap) Pop() interface{} {
+	last := h.matches[len(h.matches)-1]
+	h.matches = h.matches[:len(h.matches)-1]
+	return last
+}
@@ -0,0 +1,203 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"simplebson/preprocessing"
+)
+
+// UpdateRecord merges the fields of a JSON object into an existing record.
+// Fields set to null are kept as null values. The merged record is
+// validated against the schema again, its updated_at timestamp is bumped
+// and it keeps its key and created_at timestamp. Immutable fields holding a
+// value cannot be changed unless force is set.
+func (s *Storage) UpdateRecord(schemaName string, key string, updateData string, force bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.schemas[schemaName]; !exists {
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", schemaName)
+	}
+	if err := s.checkWritable(schemaName); err != nil {
+		return err
+	}
+
+	if err := s.ensureLoaded(schemaName); err != nil {
+		return err
+	}
+
+	var changes map[string]interface{}
+	if err := json.Unmarshal([]byte(updateData), &changes); err != nil {
+		return Errorf(ErrorValidation, "invalid JSON format: %v", err)
+	}
+
+	if err := s.updateRecord(schemaName, key, changes, force); err != nil {
+		return err
+	}
+
+	return s.saveToPersistent()
+}
+
+// UpsertRecord adds a record to a schema when its key is not taken yet and
+// otherwise merges it into the existing record like UpdateRecord, keeping
+// created_at and refreshing updated_at. It returns the key generated like
+// AddRecord does and reports whether a new record was inserted. Immutable
+// fields of an existing record are kept as UpdateRecord keeps them.
+func (s *Storage) UpsertRecord(schemaName string, recordData string, force bool) (string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	generated, inserted, err := s.addRecord(schemaName, recordData, true, force)
+	if err != nil {
+		return "", false, err
+	}
+
+	return generated, inserted, s.saveToPersistent()
+}
+
+// UpdateWhere merges the fields of a JSON object into every record
+// matching the filter, like UpdateRecord, and saves them in one batch.
+// Every merged record is validated before any is changed. With dryRun set
+// nothing is changed. Immutable fields are kept as UpdateRecord keeps them.
+// It returns the number of matching records.
+func (s *Storage) UpdateWhere(schemaName string, filter preprocessing.Filter, updateData string, dryRun, force bool) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.checkWritable(schemaName); err != nil {
+		return 0, err
+	}
+	if err := s.ensureLoaded(schemaName); err != nil {
+		return 0, err
+	}
+
+	var changes map[string]interface{}
+	if err := json.Unmarshal([]byte(updateData), &changes); err != nil {
+		return 0, Errorf(ErrorValidation, "invalid JSON format: %v", err)
+	}
+	if _, err := foldFieldNames(s.getDBState(s.currentDB).schemas[schemaName], changes); err != nil {
+		return 0, err
+	}
+
+	matches, err := s.matchRecords(schemaName, filter)
+	if err != nil {
+		return 0, err
+	}
+	if dryRun || len(matches) == 0 {
+		return len(matches), nil
+	}
+
+	// A value can only be given to a unique field of a single record
+	if len(matches) > 1 {
+		for _, field := range uniqueFields(s.getDBState(s.currentDB).schemas[schemaName]) {
+			if value, exists := changes[field]; exists && value != nil {
+				return 0, fmt.Errorf("cannot set unique field '%s' on %d records", field, len(matches))
+			}
+		}
+	}
+
+	updated := make([]string, 0, len(matches))
+	for _, match := range matches {
+		recordData, err := s.mergeRecord(schemaName, match.key, match.fields, changes, force)
+		if err != nil {
+			return 0, Errorf(KindOf(err), "record '%s': %v", match.key, err)
+		}
+		updated = append(updated, recordData)
+	}
+
+	for i, match := range matches {
+		s.writeRecord(schemaName, match.key, updated[i])
+	}
+
+	return len(matches), s.saveToPersistent()
+}
+
+// updateRecord merges changes into the record stored under key
+// NOTE: This function should be called from within a locked context
+func (s *Storage) updateRecord(schemaName, key string, changes map[string]interface{}, force bool) error {
+	existing, err := s.lookupTable(schemaName).Get(key)
+	if err != nil {
+		return Errorf(ErrorNotFound, "record with key '%s' does not exist in schema '%s'", key, schemaName)
+	}
+
+	record, err := decodeRecord(existing)
+	if err != nil {
+		return fmt.Errorf("failed to decode record '%s': %v", key, err)
+	}
+
+	recordData, err := s.mergeRecord(schemaName, key, record, changes, force)
+	if err != nil {
+		return err
+	}
+
+	s.writeRecord(schemaName, key, recordData)
+
+	return nil
+}
+
+// mergeRecord applies changes to the decoded fields of a record, bumps its
+// updated_at timestamp and validates the result, which is returned
+// encoded. Without force, an immutable field holding a value must keep it.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) mergeRecord(schemaName, key string, record, changes map[string]interface{}, force bool) (string, error) {
+	schemaDef := s.getDBState(s.currentDB).schemas[schemaName]
+	if _, err := foldFieldNames(schemaDef, changes); err != nil {
+		return "", err
+	}
+	if field := keyField(schemaDef); field != "" {
+		if value, exists := changes[field]; exists {
+			if changed, err := recordKey(field, changes); err != nil || changed != key {
+				return "", Errorf(ErrorValidation, "key field '%s' cannot be changed, got %v", field, value)
+			}
+		}
+	}
+
+	// Immutable fields are compared once the changes are normalized, so
+	// writing the value a field already holds is not a change
+	kept := make(map[string]interface{})
+	if !force {
+		for _, field := range immutableFields(schemaDef) {
+			if value, exists := record[field]; exists && value != nil {
+				kept[field] = value
+			}
+		}
+	}
+
+	for field, value := range changes {
+		if field == "created_at" {
+			continue
+		}
+		record[field] = value
+	}
+	record["updated_at"] = time.Now().Format(time.RFC3339)
+	dbState := s.getDBState(s.currentDB)
+	normalizeFields(dbState.schemas[schemaName], record, dbState.schemas)
+
+	for field, value := range kept {
+		if !reflect.DeepEqual(record[field], value) {
+			return "", Errorf(ErrorValidation, "field '%s' is immutable, pass --force to change it", field)
+		}
+	}
+
+	updatedRecordData, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal updated record: %v", err)
+	}
+
+	if err := s.validateRecordAgainstSchema(schemaName, string(updatedRecordData)); err != nil {
+		return "", Errorf(ErrorValidation, "record validation failed: %v", err)
+	}
+	if err := s.checkUnique(schemaName, key, record); err != nil {
+		return "", Errorf(ErrorValidation, "record validation failed: %v", err)
+	}
+	if err := s.checkRefs(schemaName, record); err != nil {
+		return "", Errorf(ErrorValidation, "record validation failed: %v", err)
+	}
+
+	return string(updatedRecordData), nil
+}
@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// uuidPattern matches a UUID written as 32 hexadecimal digits in groups of
+// 8, 4, 4, 4 and 12
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate uuid: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// canonicalUUID returns the lower case form a uuid field stores for a value
+func canonicalUUID(value interface{}) (string, bool) {
+	text, ok := value.(string)
+	if !ok || !uuidPattern.MatchString(text) {
+		return "", false
+	}
+	return strings.ToLower(text), true
+}
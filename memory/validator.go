@@ -0,0 +1,172 @@
+package memory
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Validator checks a value of a field declared with the validator(name)
+// modifier, after its type and constraints were checked. It is not called
+// for null values.
+type Validator func(value interface{}) error
+
+// validators holds the validators fields can name, by name
+var validators = struct {
+	sync.RWMutex
+	funcs map[string]Validator
+}{funcs: map[string]Validator{
+	"luhn":  validateLuhn,
+	"email": validateEmail,
+	"url":   validateURL,
+	"ip":    validateIP,
+}}
+
+// RegisterValidator makes a validator available to schemas under a name,
+// so fields can declare it as in ssn:string:validator(name). Names are
+// registered once; the built-in luhn, email, url and ip validators cannot
+// be replaced.
+func RegisterValidator(name string, fn Validator) error {
+	if name == "" || strings.ContainsAny(name, ":=!,()[] \t\n") {
+		return fmt.Errorf("invalid validator name '%s'", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("validator '%s' has no function", name)
+	}
+
+	validators.Lock()
+	defer validators.Unlock()
+
+	if _, exists := validators.funcs[name]; exists {
+		return fmt.Errorf("validator '%s' is already registered", name)
+	}
+	validators.funcs[name] = fn
+	return nil
+}
+
+// ValidatorNames returns the names of the registered validators, sorted
+func ValidatorNames() []string {
+	validators.RLock()
+	defer validators.RUnlock()
+
+	names := make([]string, 0, len(validators.funcs))
+	for name := range validators.funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupValidator returns the validator registered under a name
+func lookupValidator(name string) (Validator, bool) {
+	validators.RLock()
+	defer validators.RUnlock()
+
+	fn, exists := validators.funcs[name]
+	return fn, exists
+}
+
+// parseValidatorModifier returns the name of the validator a modifier
+// such as validator(luhn) declares
+func parseValidatorModifier(modifier string) (string, bool) {
+	if !strings.HasPrefix(modifier, "validator(") || !strings.HasSuffix(modifier, ")") {
+		return "", false
+	}
+	return modifier[len("validator(") : len(modifier)-1], true
+}
+
+// runValidators checks a value against the named validators in order
+func runValidators(names []string, value interface{}) error {
+	for _, name := range names {
+		fn, exists := lookupValidator(name)
+		if !exists {
+			return fmt.Errorf("validator '%s' is not registered", name)
+		}
+		if err := fn(value); err != nil {
+			return fmt.Errorf("failed validator %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// validateLuhn accepts numbers whose digits pass the Luhn checksum, such
+// as card numbers, written as a string or a whole number. Spaces and
+// dashes between digits are ignored.
+func validateLuhn(value interface{}) error {
+	var digits string
+	switch v := value.(type) {
+	case string:
+		digits = strings.NewReplacer(" ", "", "-", "").Replace(v)
+	case float64:
+		if v < 0 || v != float64(int64(v)) {
+			return fmt.Errorf("expected digits, got %v", v)
+		}
+		digits = fmt.Sprintf("%d", int64(v))
+	default:
+		return fmt.Errorf("expected digits, got %T", value)
+	}
+	if len(digits) < 2 {
+		return fmt.Errorf("expected at least 2 digits, got %q", digits)
+	}
+
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		c := digits[len(digits)-1-i]
+		if c < '0' || c > '9' {
+			return fmt.Errorf("expected digits, got %q", digits)
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	if sum%10 != 0 {
+		return fmt.Errorf("checksum of %q does not match", digits)
+	}
+	return nil
+}
+
+// validateEmail accepts a bare email address such as alice@example.com
+func validateEmail(value interface{}) error {
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected a string, got %T", value)
+	}
+	addr, err := mail.ParseAddress(text)
+	if err != nil || addr.Address != text || !strings.Contains(text[strings.LastIndex(text, "@")+1:], ".") {
+		return fmt.Errorf("%q is not an email address", text)
+	}
+	return nil
+}
+
+// validateURL accepts an absolute URL with a scheme and a host
+func validateURL(value interface{}) error {
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected a string, got %T", value)
+	}
+	u, err := url.Parse(text)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", text)
+	}
+	return nil
+}
+
+// validateIP accepts an IPv4 or IPv6 address
+func validateIP(value interface{}) error {
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected a string, got %T", value)
+	}
+	if net.ParseIP(text) == nil {
+		return fmt.Errorf("%q is not an IP address", text)
+	}
+	return nil
+}
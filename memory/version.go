@@ -0,0 +1,86 @@
+package memory
+
+import "simplebson/preprocessing"
+
+// versionField is the name filters match the schema version a record was
+// written under by. The version is kept next to the record, like its
+// checksum, so a record holding a field of that name keeps its own value.
+const versionField = "schema_version"
+
+// SchemaVersion returns the version of a schema definition: 1 when it is
+// created, counted up every time it is redefined or altered
+func (s *Storage) SchemaVersion(name string) (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, exists := s.getDBState(s.currentDB).schemas[name]; !exists {
+		return 0, Errorf(ErrorNotFound, "schema '%s' does not exist", name)
+	}
+	return s.schemaVersion(name), nil
+}
+
+// schemaVersion returns the version of a schema definition. Schemas
+// defined before versions were kept are at version 1.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) schemaVersion(name string) int64 {
+	if version := s.getDBState(s.currentDB).versions[name]; version > 0 {
+		return version
+	}
+	return 1
+}
+
+// writeRecord stores a record like putRecord and notes that it was written
+// under the current version of its schema
+// NOTE: This function should be called from within a locked context
+func (s *Storage) writeRecord(schemaName, key, recordData string) {
+	dbState := s.getDBState(s.currentDB)
+
+	if _, exists := dbState.recordVersions[schemaName]; !exists {
+		dbState.recordVersions[schemaName] = make(map[string]int64)
+	}
+	dbState.recordVersions[schemaName][key] = s.schemaVersion(schemaName)
+	s.putRecord(schemaName, key, recordData)
+}
+
+// recordVersion returns the schema version a record was written under, or
+// 0 for a record written before versions were kept. The records of a view
+// are those of its source.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) recordVersion(schemaName, key string) int64 {
+	dbState := s.getDBState(s.currentDB)
+	if view, exists := dbState.views[schemaName]; exists {
+		return s.recordVersion(view.source, key)
+	}
+	return dbState.recordVersions[schemaName][key]
+}
+
+// saveRecordVersions returns a copy of the versions the records of a schema
+// were written under, and a function putting them back
+// NOTE: This function should be called from within a locked context
+func (s *Storage) saveRecordVersions(schemaName string) func() {
+	dbState := s.getDBState(s.currentDB)
+
+	saved, existed := dbState.recordVersions[schemaName]
+	versions := make(map[string]int64, len(saved))
+	for key, version := range saved {
+		versions[key] = version
+	}
+	return func() {
+		if existed {
+			dbState.recordVersions[schemaName] = versions
+		} else {
+			delete(dbState.recordVersions, schemaName)
+		}
+	}
+}
+
+// matchVersioned reports whether the fields of a record match a filter,
+// which sees the schema version the record was written under as the
+// versionField unless the record holds a field of that name
+func matchVersioned(filter preprocessing.Filter, fields map[string]interface{}, version int64) bool {
+	if _, own := fields[versionField]; !own && version > 0 {
+		fields[versionField] = float64(version)
+		defer delete(fields, versionField)
+	}
+	return filter.Match(fields)
+}
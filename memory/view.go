@@ -0,0 +1,176 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+
+	"simplebson/preprocessing"
+)
+
+// View describes a materialized view
+type View struct {
+	Name   string
+	Source string // Schema the view derives its records from
+	Query  string // Definition, FROM <schema> [WHERE <condition>]
+}
+
+// materializedView is a schema holding the records of another schema that
+// match a filter, kept up to date as that schema changes
+type materializedView struct {
+	source string
+	query  string
+	filter preprocessing.Filter // Records of the source to keep, all when nil
+}
+
+// newView parses the query defining a view
+func newView(query string) (*materializedView, error) {
+	q, err := preprocessing.ParseViewQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return &materializedView{source: q.Schema, query: query, filter: q.Filter}, nil
+}
+
+// loadViews rebuilds views from their stored queries. Queries that no
+// longer parse are dropped; the records of their views stay as a plain
+// schema.
+func loadViews(queries map[string]string) map[string]*materializedView {
+	views := make(map[string]*materializedView, len(queries))
+	for name, query := range queries {
+		if view, err := newView(query); err == nil {
+			views[name] = view
+		}
+	}
+	return views
+}
+
+// viewQueries returns the query of every view, keyed by view name, in the
+// form they are persisted
+func viewQueries(views map[string]*materializedView) map[string]string {
+	queries := make(map[string]string, len(views))
+	for name, view := range views {
+		queries[name] = view.query
+	}
+	return queries
+}
+
+// CreateView creates a schema named name that holds the records of another
+// schema matching the query, FROM <schema> [WHERE <condition>]. The view
+// takes the definition of its source schema, is filled from the records
+// the source holds now and then follows every change made to them. It can
+// be read like any schema but not written to directly.
+func (s *Storage) CreateView(name, query string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	view, err := newView(query)
+	if err != nil {
+		return fmt.Errorf("invalid view query: %v", err)
+	}
+
+	dbState := s.getDBState(s.currentDB)
+	if _, exists := dbState.schemas[name]; exists {
+		return fmt.Errorf("schema '%s' already exists", name)
+	}
+	schemaDef, exists := dbState.schemas[view.source]
+	if !exists {
+		return Errorf(ErrorNotFound, "schema '%s' does not exist", view.source)
+	}
+	if err := s.ensureLoaded(view.source); err != nil {
+		return err
+	}
+
+	matches, err := s.matchRecords(view.source, view.filter)
+	if err != nil {
+		return err
+	}
+
+	dbState.schemas[name] = schemaDef
+	dbState.versions[name] = dbState.versions[view.source]
+	dbState.views[name] = view
+	s.table(name)
+	s.indexSchema(name)
+	for _, match := range matches {
+		if data, ok := match.record.(string); ok {
+			s.putRecord(name, match.key, data)
+		}
+	}
+
+	return s.saveToPersistent()
+}
+
+// DropView removes a view and its records. The source schema is left as
+// it is.
+func (s *Storage) DropView(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dbState := s.getDBState(s.currentDB)
+	if _, exists := dbState.views[name]; !exists {
+		return Errorf(ErrorNotFound, "view '%s' does not exist", name)
+	}
+	for other, view := range dbState.views {
+		if view.source == name {
+			return fmt.Errorf("view '%s' is the source of view '%s', drop that first", name, other)
+		}
+	}
+
+	if err := s.forgetSchema(name); err != nil {
+		return err
+	}
+
+	return s.saveToPersistent()
+}
+
+// Views returns the materialized views of the current database, by name
+func (s *Storage) Views() []View {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dbState := s.getDBState(s.currentDB)
+	views := make([]View, 0, len(dbState.views))
+	for name, view := range dbState.views {
+		views = append(views, View{Name: name, Source: view.source, Query: view.query})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+
+	return views
+}
+
+// checkWritable rejects direct changes to the records or definition of a
+// view, which only follow its source schema
+// NOTE: This function should be called from within a locked context
+func (s *Storage) checkWritable(schemaName string) error {
+	if view, exists := s.getDBState(s.currentDB).views[schemaName]; exists {
+		return fmt.Errorf("'%s' is a view of '%s' and cannot be modified directly", schemaName, view.source)
+	}
+	return nil
+}
+
+// syncViews brings the views of a schema up to date with a change to one
+// of its records. Nil fields mean the record was removed.
+// NOTE: This function should be called from within a locked context
+func (s *Storage) syncViews(schemaName, key, recordData string, fields map[string]interface{}) {
+	dbState := s.getDBState(s.currentDB)
+
+	for name, view := range dbState.views {
+		if view.source != schemaName {
+			continue
+		}
+		if err := s.ensureLoaded(name); err != nil {
+			continue
+		}
+
+		matches := fields != nil
+		if matches && view.filter != nil {
+			filter := schemaFilter(dbState.schemas[schemaName], view.filter)
+			matches = matchVersioned(filter, fields, s.recordVersion(schemaName, key))
+		}
+
+		if matches {
+			s.putRecord(name, key, recordData)
+		} else if _, err := s.lookupTable(name).Get(key); err == nil {
+			s.removeRecord(name, key)
+		}
+	}
+}
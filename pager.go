@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+
+	"simplebson/preprocessing"
+)
+
+// defaultPager is the pager run when PAGER is not set; -R lets the colors
+// of the output through
+const defaultPager = "less -R"
+
+// pager holds back the output of a command until it fills the terminal,
+// then starts the pager and feeds it what was held back and the rest, as
+// git does. Output fitting on the terminal is printed as is when the
+// pager is closed.
+type pager struct {
+	out    io.Writer
+	height int // Lines that fit on the terminal, zero when not paging
+	lines  int
+	held   bytes.Buffer
+
+	cmd    *exec.Cmd
+	input  io.WriteCloser // Standard input of the running pager
+	closed bool           // Whether the pager was quit before the output ended
+}
+
+// newPager returns the writer list and find print to: a pager when stdout
+// is a terminal and neither --no-pager nor --json is passed, and stdout
+// itself otherwise
+func newPager(flags preprocessing.Flags) *pager {
+	p := &pager{out: os.Stdout}
+	if flags.Has("no-pager") || jsonOutput || !isTerminal(os.Stdout) {
+		return p
+	}
+	if _, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil && height > 0 {
+		p.height = height
+	}
+	return p
+}
+
+// Write holds output back until it is one line short of filling the
+// terminal, and pipes it to the pager after that
+func (p *pager) Write(data []byte) (int, error) {
+	if p.input != nil {
+		// Output after the pager is quit is dropped, as git does
+		if !p.closed {
+			if _, err := p.input.Write(data); err != nil {
+				p.closed = true
+			}
+		}
+		return len(data), nil
+	}
+	if p.height == 0 {
+		return p.out.Write(data)
+	}
+
+	p.held.Write(data)
+	p.lines += bytes.Count(data, []byte("\n"))
+	if p.lines < p.height-1 {
+		return len(data), nil
+	}
+
+	// Output the pager cannot run for is printed straight away
+	if err := p.start(); err != nil {
+		p.height = 0
+	}
+	if _, err := p.Write(p.held.Bytes()); err != nil {
+		return 0, err
+	}
+	p.held.Reset()
+	return len(data), nil
+}
+
+// start runs the pager set in PAGER, or less -R, on the terminal. An empty
+// PAGER or cat means no pager.
+func (p *pager) start() error {
+	command := defaultPager
+	if value, set := os.LookupEnv("PAGER"); set {
+		command = value
+	}
+	words := strings.Fields(command)
+	if len(words) == 0 || words[0] == "cat" {
+		return exec.ErrNotFound
+	}
+
+	cmd := exec.Command(words[0], words[1:]...)
+	cmd.Stdout, cmd.Stderr = p.out, os.Stderr
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.cmd, p.input = cmd, input
+	return nil
+}
+
+// Close prints the output held back, or waits for the pager to be quit
+func (p *pager) Close() error {
+	if p.input == nil {
+		_, err := p.out.Write(p.held.Bytes())
+		p.held.Reset()
+		return err
+	}
+	p.input.Close()
+	err := p.cmd.Wait()
+	p.cmd, p.input, p.closed = nil, nil, false
+	return err
+}
@@ -0,0 +1,325 @@
+package preprocessing
+
+import (
+	"time"
+)
+
+const (
+	// level0FileLimit is the number of freshly flushed SSTables allowed in
+	// L0 before they are merged into L1
+	level0FileLimit = 4
+
+	// levelSizeMultiplier is how many times more entries each level below
+	// L1 may hold than the one above it
+	levelSizeMultiplier = 10
+)
+
+// Compact performs a full compaction, merging every SSTable into a single
+// run at the bottom level. With nothing older left, every tombstone is
+// dropped.
+func (lsm *LSMTree) Compact() error {
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	if len(lsm.sortedFiles) <= 1 {
+		return nil
+	}
+
+	level := lsm.bottomLevel()
+	if level == 0 {
+		level = 1
+	}
+
+	return lsm.mergeTables(0, len(lsm.sortedFiles), level)
+}
+
+// StartCompactor starts a background worker that runs a full compaction
+// whenever the tree holds more than maxTables SSTables. The worker checks at
+// most once per interval, which bounds how often it competes with
+// foreground Put and Get calls, and it merges without holding the tree's
+// lock. Stop it with Close. No compactor is started without a positive
+// interval.
+func (lsm *LSMTree) StartCompactor(maxTables int, interval time.Duration) {
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	if lsm.stopCompactor != nil || interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	lsm.stopCompactor = stop
+	lsm.compactorDone = done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				lsm.mutex.RLock()
+				tables := len(lsm.sortedFiles)
+				lsm.mutex.RUnlock()
+
+				if tables > maxTables {
+					// A failed compaction leaves the tree unchanged and is
+					// retried on a later tick
+					lsm.compactInBackground()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background compactor, if one is running, waits for it
+// to exit and closes the commit log. The MemTable is left as is; its writes
+// are replayed from the log when the tree is opened again.
+func (lsm *LSMTree) Close() error {
+	lsm.mutex.Lock()
+	stop, done := lsm.stopCompactor, lsm.compactorDone
+	lsm.stopCompactor, lsm.compactorDone = nil, nil
+	lsm.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	return lsm.closeLog()
+}
+
+// compactInBackground fully compacts the SSTables present when it starts.
+// The merge runs without the lock; the result is only installed if those
+// SSTables are still in place, otherwise the work is discarded.
+func (lsm *LSMTree) compactInBackground() error {
+	lsm.mutex.RLock()
+	tables := append([]*sstable(nil), lsm.sortedFiles...)
+	lsm.mutex.RUnlock()
+
+	if len(tables) <= 1 {
+		return nil
+	}
+
+	// SSTables are immutable, so they can be merged while writes continue
+	entries := lsm.mergeRuns(tables, func(string) bool { return false })
+
+	level := 1
+	for _, table := range tables {
+		if table.level > level {
+			level = table.level
+		}
+	}
+
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	// Flushes only ever append, so an unchanged prefix means the merged
+	// tables are still current
+	if len(lsm.sortedFiles) < len(tables) {
+		return nil
+	}
+	for i, table := range tables {
+		if lsm.sortedFiles[i] != table {
+			return nil
+		}
+	}
+
+	return lsm.replaceTables(0, len(tables), entries, level)
+}
+
+// maybeCompact runs leveled compaction until every level is within its
+// limit: L0 by number of SSTables, deeper levels by number of entries
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) maybeCompact() error {
+	for level := 0; level <= lsm.bottomLevel(); level++ {
+		start, end := lsm.levelRange(level)
+		if start == end {
+			continue
+		}
+
+		if level == 0 {
+			if end-start <= level0FileLimit {
+				continue
+			}
+		} else if lsm.levelEntries(start, end) <= lsm.levelLimit(level) {
+			continue
+		}
+
+		if err := lsm.compactLevel(level); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compactLevel merges all SSTables of a level together with the next level
+// down into a single run on that next level
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) compactLevel(level int) error {
+	// The next level down immediately precedes this one in sortedFiles
+	start, _ := lsm.levelRange(level + 1)
+	_, end := lsm.levelRange(level)
+
+	return lsm.mergeTables(start, end, level+1)
+}
+
+// mergeTables replaces sortedFiles[start:end] with one merged SSTable on the
+// given level.
+//
+// Tombstones only exist to shadow older values of their key. A tombstone is
+// therefore dropped once it is older than every SSTable that could still
+// contain the key, i.e. when no SSTable below the merged range holds it;
+// otherwise it is carried into the output.
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) mergeTables(start, end, level int) error {
+	entries := lsm.mergeRuns(lsm.sortedFiles[start:end], func(key string) bool {
+		return lsm.olderTablesContain(start, key)
+	})
+
+	return lsm.replaceTables(start, end, entries, level)
+}
+
+// mergeRuns merges SSTables, given oldest first, into one sorted run with
+// the latest value of every key. keepTombstone decides whether a deleted
+// key still needs its tombstone.
+func (lsm *LSMTree) mergeRuns(tables []*sstable, keepTombstone func(key string) bool) []LSMNode {
+	// Tables are ordered oldest first, the iterator wants the newest first
+	runs := make([][]LSMNode, 0, len(tables))
+	for i := len(tables) - 1; i >= 0; i-- {
+		runs = append(runs, tables[i].entries)
+	}
+
+	entries := make([]LSMNode, 0)
+	it := NewMergeIterator(lsm.compare, runs...)
+	for it.advance() {
+		if it.current.Value == nil && !keepTombstone(it.current.Key) {
+			continue
+		}
+		entries = append(entries, it.current)
+	}
+
+	return entries
+}
+
+// replaceTables swaps sortedFiles[start:end] for a single SSTable holding
+// the given entries on the given level
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) replaceTables(start, end int, entries []LSMNode, level int) error {
+	tables := lsm.sortedFiles[start:end]
+
+	output, err := lsm.writeSSTable(entries, level)
+	if err != nil {
+		return err
+	}
+
+	lsm.counters.compactions.Add(1)
+	lsm.counters.compactionBytes.Add(runSize(entries))
+
+	if err := lsm.removeFiles(tables); err != nil {
+		return err
+	}
+
+	remaining := make([]*sstable, 0, len(lsm.sortedFiles)-len(tables)+1)
+	remaining = append(remaining, lsm.sortedFiles[:start]...)
+	remaining = append(remaining, output)
+	remaining = append(remaining, lsm.sortedFiles[end:]...)
+	lsm.sortedFiles = remaining
+
+	return nil
+}
+
+// olderTablesContain reports whether any SSTable in sortedFiles[:before]
+// holds an entry for the key
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) olderTablesContain(before int, key string) bool {
+	for _, table := range lsm.sortedFiles[:before] {
+		if _, found := table.find(key, lsm.compare); found {
+			return true
+		}
+	}
+	return false
+}
+
+// levelRange returns the bounds of the SSTables on a level within
+// sortedFiles. Tables of one level are always contiguous.
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) levelRange(level int) (int, int) {
+	start, end := -1, -1
+	for i, table := range lsm.sortedFiles {
+		if table.level == level {
+			if start < 0 {
+				start = i
+			}
+			end = i + 1
+		}
+	}
+	if start >= 0 {
+		return start, end
+	}
+
+	// No SSTable on this level: an empty range where it would start
+	for i, table := range lsm.sortedFiles {
+		if table.level < level {
+			return i, i
+		}
+	}
+	return len(lsm.sortedFiles), len(lsm.sortedFiles)
+}
+
+// levelEntries counts the entries of the SSTables in sortedFiles[start:end]
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) levelEntries(start, end int) int {
+	count := 0
+	for _, table := range lsm.sortedFiles[start:end] {
+		count += len(table.entries)
+	}
+	return count
+}
+
+// levelLimit returns the number of entries a level may hold before it is
+// compacted into the next one. A MemTable size below one counts as one, so
+// deeper levels still grow.
+func (lsm *LSMTree) levelLimit(level int) int {
+	limit := lsm.maxMemorySize
+	if limit < 1 {
+		limit = 1
+	}
+	limit *= level0FileLimit
+	for i := 1; i < level; i++ {
+		limit *= levelSizeMultiplier
+	}
+	return limit
+}
+
+// seedLevel returns the level a snapshot of the given size is loaded on:
+// below every existing SSTable and deep enough not to trigger compaction
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) seedLevel(entries int) int {
+	level := lsm.bottomLevel() + 1
+	for lsm.levelLimit(level) < entries {
+		level++
+	}
+	return level
+}
+
+// bottomLevel returns the deepest level holding an SSTable
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) bottomLevel() int {
+	bottom := 0
+	for _, table := range lsm.sortedFiles {
+		if table.level > bottom {
+			bottom = table.level
+		}
+	}
+	return bottom
+}
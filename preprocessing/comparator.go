@@ -0,0 +1,97 @@
+package preprocessing
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Comparator orders the keys of an LSM tree. Its function returns a
+// negative number when a sorts before b, zero when they sort together and a
+// positive number when a sorts after b. The zero Comparator orders keys
+// lexicographically.
+//
+// Keys a comparator considers equal, such as "Alice" and "alice" under
+// CaseInsensitiveComparator, are still distinct keys; the tree orders them
+// by their bytes. SSTable files record the name of the comparator they
+// were written with, and OpenLSMTree reopens them with it.
+type Comparator struct {
+	name    string
+	compare func(a, b string) int
+}
+
+// NewComparator returns a comparator ordering keys with the given function.
+// The name is recorded in the SSTables sorted by it, so it must be unique
+// and stay the same across runs.
+func NewComparator(name string, compare func(a, b string) int) Comparator {
+	return Comparator{name: name, compare: compare}
+}
+
+var (
+	// LexicographicComparator orders keys byte by byte, like Go strings
+	LexicographicComparator = NewComparator("lexicographic", strings.Compare)
+
+	// NumericComparator orders keys that parse as numbers by their value,
+	// so "9" sorts before "10". Non-numeric keys sort after all numbers.
+	NumericComparator = NewComparator("numeric", compareNumeric)
+
+	// CaseInsensitiveComparator orders keys ignoring letter case
+	CaseInsensitiveComparator = NewComparator("case-insensitive", compareCaseInsensitive)
+)
+
+// builtinComparators are the comparators of this package by name, which a
+// tree adopts when its SSTables were sorted by one of them
+var builtinComparators = map[string]Comparator{
+	LexicographicComparator.name:   LexicographicComparator,
+	NumericComparator.name:         NumericComparator,
+	CaseInsensitiveComparator.name: CaseInsensitiveComparator,
+}
+
+// Name returns the name the comparator is recorded under in SSTables
+func (c Comparator) Name() string {
+	if c.compare == nil {
+		return LexicographicComparator.name
+	}
+	return c.name
+}
+
+// Compare orders two keys by the comparator alone, without breaking ties
+func (c Comparator) Compare(a, b string) int {
+	if c.compare == nil {
+		return strings.Compare(a, b)
+	}
+	return c.compare(a, b)
+}
+
+// compareNumeric implements NumericComparator
+func compareNumeric(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareCaseInsensitive implements CaseInsensitiveComparator
+func compareCaseInsensitive(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// order compares two keys with the comparator and breaks ties by their
+// bytes, so only identical keys compare equal
+func (c Comparator) order(a, b string) int {
+	if order := c.Compare(a, b); order != 0 {
+		return order
+	}
+	return strings.Compare(a, b)
+}
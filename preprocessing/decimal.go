@@ -0,0 +1,28 @@
+package preprocessing
+
+import (
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// decimalPattern matches a decimal number written without an exponent,
+// such as 19.99, -0.5 or 100
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+
+// ParseDecimal parses an exact decimal number written without an exponent
+func ParseDecimal(text string) (*big.Rat, bool) {
+	if !decimalPattern.MatchString(text) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(text)
+}
+
+// DecimalScale returns the number of digits a decimal number is written
+// with after its decimal point
+func DecimalScale(text string) int {
+	if _, fraction, found := strings.Cut(text, "."); found {
+		return len(fraction)
+	}
+	return 0
+}
@@ -0,0 +1,261 @@
+package preprocessing
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// parseDocument parses a MongoDB-style query document such as
+// {"age": {"$gt": 30}, "name": {"$regex": "^Al"}}. Every field of the
+// document must match. A field maps either to a value it must equal or to
+// an object of operators: $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin,
+// $exists, $regex (with $options "i" for case-insensitive matching) and
+// $not. The top level may also hold $and, $or and $nor with arrays of
+// documents. An empty document yields a nil filter.
+func parseDocument(text string) (Filter, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &document); err != nil {
+		return nil, fmt.Errorf("invalid query document: %v", err)
+	}
+	return documentFilter(document)
+}
+
+// documentFilter builds the filter for a decoded query document
+func documentFilter(document map[string]interface{}) (Filter, error) {
+	var filters []Filter
+	for _, key := range sortedKeys(document) {
+		value := document[key]
+
+		var filter Filter
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			filter, err = logicalFilter(key, value)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return nil, fmt.Errorf("unknown operator '%s'", key)
+			}
+			filter, err = fieldFilter(key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return All(filters...), nil
+}
+
+// logicalFilter combines the documents of an $and, $or or $nor array
+func logicalFilter(op string, value interface{}) (Filter, error) {
+	documents, ok := value.([]interface{})
+	if !ok || len(documents) == 0 {
+		return nil, fmt.Errorf("%s expects a non-empty array of documents", op)
+	}
+
+	var combined Filter
+	for _, item := range documents {
+		document, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s expects a non-empty array of documents", op)
+		}
+		filter, err := documentFilter(document)
+		if err != nil {
+			return nil, err
+		}
+		if filter == nil {
+			return nil, fmt.Errorf("%s does not accept empty documents", op)
+		}
+
+		switch {
+		case combined == nil:
+			combined = filter
+		case op == "$and":
+			combined = &andFilter{left: combined, right: filter}
+		default:
+			combined = &orFilter{left: combined, right: filter}
+		}
+	}
+
+	if op == "$nor" {
+		return &notFilter{inner: combined}, nil
+	}
+	return combined, nil
+}
+
+// fieldFilter builds the filter for one field of a query document
+func fieldFilter(field string, value interface{}) (Filter, error) {
+	operators, ok := value.(map[string]interface{})
+	if !ok || !isOperatorObject(operators) {
+		return &comparison{field: field, op: "==", value: documentLiteral(value)}, nil
+	}
+
+	var filters []Filter
+	for _, op := range sortedKeys(operators) {
+		if op == "$options" {
+			if _, hasRegex := operators["$regex"]; !hasRegex {
+				return nil, fmt.Errorf("$options is only valid with $regex")
+			}
+			continue
+		}
+
+		operand := operators[op]
+		var filter Filter
+		switch op {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			if _, isArray := operand.([]interface{}); isArray {
+				return nil, fmt.Errorf("%s expects a single value", op)
+			}
+			filter = &comparison{field: field, op: documentOperators[op], value: documentLiteral(operand)}
+
+		case "$in", "$nin":
+			values, ok := operand.([]interface{})
+			if !ok || len(values) == 0 {
+				return nil, fmt.Errorf("%s expects a non-empty array", op)
+			}
+			for _, v := range values {
+				next := Filter(&comparison{field: field, op: "==", value: documentLiteral(v)})
+				if filter == nil {
+					filter = next
+				} else {
+					filter = &orFilter{left: filter, right: next}
+				}
+			}
+			if op == "$nin" {
+				filter = &notFilter{inner: filter}
+			}
+
+		case "$exists":
+			exists, ok := operand.(bool)
+			if !ok {
+				return nil, fmt.Errorf("$exists expects true or false")
+			}
+			filter = &existsFilter{field: field, exists: exists}
+
+		case "$regex":
+			pattern, ok := operand.(string)
+			if !ok {
+				return nil, fmt.Errorf("$regex expects a string")
+			}
+			if options, ok := operators["$options"].(string); ok && strings.Contains(options, "i") {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid $regex: %v", err)
+			}
+			filter = &regexFilter{field: field, re: re}
+
+		case "$not":
+			inner, ok := operand.(map[string]interface{})
+			if !ok || !isOperatorObject(inner) {
+				return nil, fmt.Errorf("$not expects an object of operators")
+			}
+			negated, err := fieldFilter(field, inner)
+			if err != nil {
+				return nil, err
+			}
+			filter = &notFilter{inner: negated}
+
+		default:
+			return nil, fmt.Errorf("unknown operator '%s'", op)
+		}
+		filters = append(filters, filter)
+	}
+	return All(filters...), nil
+}
+
+// documentOperators maps comparison operators to filter expression ones
+var documentOperators = map[string]string{
+	"$eq":  "==",
+	"$ne":  "!=",
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+// isOperatorObject reports whether every key of an object is an operator
+func isOperatorObject(object map[string]interface{}) bool {
+	if len(object) == 0 {
+		return false
+	}
+	for key := range object {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// documentLiteral turns a decoded JSON value into a literal
+func documentLiteral(value interface{}) literal {
+	lit := literal{text: FormatValue(value)}
+	switch v := value.(type) {
+	case nil:
+		lit.isNull = true
+	case float64:
+		lit.isNumber = true
+		lit.number = v
+	case bool:
+		lit.isBool = true
+		lit.boolean = v
+	}
+	return lit
+}
+
+// All combines filters that must all match. Nil filters are skipped, and
+// no filters yield nil.
+func All(filters ...Filter) Filter {
+	var combined Filter
+	for _, filter := range filters {
+		if filter == nil {
+			continue
+		}
+		if combined == nil {
+			combined = filter
+		} else {
+			combined = &andFilter{left: combined, right: filter}
+		}
+	}
+	return combined
+}
+
+// sortedKeys returns the keys of an object in name order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// existsFilter matches records that have, or lack, a field
+type existsFilter struct {
+	field  string
+	exists bool
+}
+
+func (f *existsFilter) Match(record map[string]interface{}) bool {
+	_, exists := LookupField(record, f.field)
+	return exists == f.exists
+}
+
+// regexFilter matches records whose field value matches a regular
+// expression. Values that are not strings are matched in the form
+// FormatValue gives them.
+type regexFilter struct {
+	field string
+	re    *regexp.Regexp
+}
+
+func (f *regexFilter) Match(record map[string]interface{}) bool {
+	value, exists := LookupField(record, f.field)
+	if !exists || value == nil {
+		return false
+	}
+	return f.re.MatchString(FormatValue(value))
+}
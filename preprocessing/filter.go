@@ -0,0 +1,733 @@
+package preprocessing
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter decides whether a decoded record matches a query
+type Filter interface {
+	Match(record map[string]interface{}) bool
+}
+
+// ParseFilter parses the filter arguments of a query. Each argument is an
+// expression such as "age > 30 && email != null"; several arguments must
+// all match. An argument of the form field=value that is not a valid
+// expression, like name=Bob Smith, compares the field with the raw value.
+// No arguments yield a nil filter, which matches every record.
+//
+// Expressions compare a field with a value using ==, =, !=, <, <=, > or >=
+// and combine comparisons with &&, || and !, grouped by parentheses. Values
+// are numbers, true, false, null, quoted strings or bare words. A missing
+// field compares equal to null. "tags contains golang" matches records
+// whose array field tags holds the value.
+//
+// "email IS NULL" and "email IS NOT NULL" match records that have the
+// field, set to null or to a value; "email EXISTS" and "email NOT EXISTS"
+// match on whether the record has the field at all.
+//
+// An argument starting with { is a MongoDB-style query document, see
+// parseDocument.
+func ParseFilter(args []string) (Filter, error) {
+	var filter Filter
+	for _, arg := range args {
+		next, err := parseArgument(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter '%s': %v", arg, err)
+		}
+		if next == nil {
+			continue
+		}
+
+		if filter == nil {
+			filter = next
+		} else {
+			filter = &andFilter{left: filter, right: next}
+		}
+	}
+	return filter, nil
+}
+
+// parseArgument parses a single filter argument
+func parseArgument(arg string) (Filter, error) {
+	if strings.HasPrefix(strings.TrimSpace(arg), "{") {
+		return parseDocument(arg)
+	}
+
+	filter, err := parseExpression(arg)
+	if err != nil {
+		if match := rawEquality.FindStringSubmatch(arg); match != nil {
+			return &comparison{field: match[1], op: "==", value: newLiteral(match[2], true)}, nil
+		}
+		return nil, err
+	}
+	return filter, nil
+}
+
+// rawEquality matches a field=value argument taken literally
+var rawEquality = regexp.MustCompile(`^([A-Za-z0-9_.$]+)=(.*)$`)
+
+// FormatValue renders a decoded JSON value the way it would be typed on the
+// command line
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+}
+
+// andFilter matches records both of its filters match
+type andFilter struct {
+	left, right Filter
+}
+
+func (f *andFilter) Match(record map[string]interface{}) bool {
+	return f.left.Match(record) && f.right.Match(record)
+}
+
+// orFilter matches records either of its filters matches
+type orFilter struct {
+	left, right Filter
+}
+
+func (f *orFilter) Match(record map[string]interface{}) bool {
+	return f.left.Match(record) || f.right.Match(record)
+}
+
+// notFilter matches records its inner filter rejects
+type notFilter struct {
+	inner Filter
+}
+
+func (f *notFilter) Match(record map[string]interface{}) bool {
+	return !f.inner.Match(record)
+}
+
+// nullFilter matches records that have a field set to null, or set to
+// any other value. Records without the field match neither.
+type nullFilter struct {
+	field string
+	null  bool
+}
+
+func (f *nullFilter) Match(record map[string]interface{}) bool {
+	value, exists := LookupField(record, f.field)
+	return exists && (value == nil) == f.null
+}
+
+// literal is a value written in a filter, kept with its text so it can be
+// compared with fields of any type
+type literal struct {
+	text     string
+	isNull   bool
+	isNumber bool
+	number   float64
+	isBool   bool
+	boolean  bool
+}
+
+// newLiteral classifies the text of a value. Quoted and raw values are
+// always strings.
+func newLiteral(text string, verbatim bool) literal {
+	lit := literal{text: text}
+	if verbatim {
+		return lit
+	}
+
+	switch text {
+	case "null":
+		lit.isNull = true
+	case "true", "false":
+		lit.isBool = true
+		lit.boolean = text == "true"
+	default:
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			lit.isNumber = true
+			lit.number = number
+		}
+	}
+	return lit
+}
+
+// comparison compares one field of a record with a literal
+type comparison struct {
+	field     string
+	op        string
+	value     literal
+	fieldType string // Schema type of the field, empty when unknown
+}
+
+func (c *comparison) Match(record map[string]interface{}) bool {
+	value, _ := LookupField(record, c.field)
+
+	if c.op == "contains" {
+		elements, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, element := range elements {
+			if c.equals(element) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if c.value.isNull || value == nil {
+		equal := c.value.isNull && value == nil
+		switch c.op {
+		case "==":
+			return equal
+		case "!=":
+			return !equal
+		}
+		return false
+	}
+
+	order, ok := c.compare(value)
+	switch c.op {
+	case "==", "!=":
+		// Values that cannot be ordered against the literal are equal
+		// only when they are written the same way
+		equal := order == 0
+		if !ok {
+			equal = FormatValue(value) == c.value.text
+		}
+		return equal == (c.op == "==")
+	}
+
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return false
+}
+
+// equals reports whether a single value equals the literal, the way ==
+// compares values of untyped fields
+func (c *comparison) equals(value interface{}) bool {
+	if c.value.isNull || value == nil {
+		return c.value.isNull && value == nil
+	}
+	if number, ok := value.(float64); ok && c.value.isNumber {
+		return number == c.value.number
+	}
+	return FormatValue(value) == c.value.text
+}
+
+// compare orders a field value against the literal according to the
+// field's schema type: numerically for int and float fields, exactly for
+// decimal fields, by time for date and datetime fields and as text for
+// string fields. Fields of
+// unknown type compare numerically when both sides are numbers and as
+// text when the field holds a string. Other combinations cannot be
+// ordered.
+func (c *comparison) compare(value interface{}) (int, bool) {
+	switch c.fieldType {
+	case "int", "integer", "float", "double":
+		number, ok := numberValue(value)
+		if !ok || !c.value.isNumber {
+			return 0, false
+		}
+		return compareNumbers(number, c.value.number), true
+
+	case "decimal":
+		number, ok := ParseDecimal(FormatValue(value))
+		if !ok {
+			return 0, false
+		}
+		bound, ok := ParseDecimal(c.value.text)
+		if !ok {
+			return 0, false
+		}
+		return number.Cmp(bound), true
+
+	case "date", "datetime", "timestamp":
+		t, ok := ParseTime(FormatValue(value))
+		if !ok {
+			return 0, false
+		}
+		bound, ok := ParseTime(c.value.text)
+		if !ok {
+			return 0, false
+		}
+		return t.Compare(bound), true
+
+	case "string", "text":
+		return strings.Compare(FormatValue(value), c.value.text), true
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if !c.value.isNumber {
+			return 0, false
+		}
+		return compareNumbers(v, c.value.number), true
+	case bool:
+		if !c.value.isBool {
+			return 0, false
+		}
+		if v == c.value.boolean {
+			return 0, true
+		}
+		return 1, true
+	case string:
+		return strings.Compare(v, c.value.text), true
+	}
+	return 0, false
+}
+
+// compareNumbers orders two numbers
+func compareNumbers(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// numberValue returns the numeric value of a number or numeric string
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		if number, err := strconv.ParseFloat(v, 64); err == nil {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+// timeLayouts are the formats ParseTime accepts, most specific first
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	"02 Jan 2006",
+	"Jan 2, 2006",
+}
+
+// ParseTime parses an RFC 3339 timestamp or a date written as 2006-01-02,
+// optionally followed by a time of day. RFC 1123 timestamps, dates written
+// with slashes as 2006/01/02 and dates such as 02 Jan 2006 or Jan 2, 2006
+// are accepted too. Times without a zone are UTC.
+func ParseTime(text string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// WithFieldTypes returns a copy of the filter that compares each field
+// according to its type in the given map of field names to schema types.
+// Fields missing from the map keep the type they were compared as.
+func WithFieldTypes(filter Filter, types map[string]string) Filter {
+	switch f := filter.(type) {
+	case *andFilter:
+		return &andFilter{left: WithFieldTypes(f.left, types), right: WithFieldTypes(f.right, types)}
+	case *orFilter:
+		return &orFilter{left: WithFieldTypes(f.left, types), right: WithFieldTypes(f.right, types)}
+	case *notFilter:
+		return &notFilter{inner: WithFieldTypes(f.inner, types)}
+	case *comparison:
+		typed := *f
+		if fieldType, declared := types[f.field]; declared {
+			typed.fieldType = fieldType
+		}
+		return &typed
+	}
+	return filter
+}
+
+// WithFieldNames returns a copy of the filter that looks up every field
+// under the name rename gives its path, so fields written in another form
+// than the records hold them can still be compared
+func WithFieldNames(filter Filter, rename func(path string) string) Filter {
+	switch f := filter.(type) {
+	case *andFilter:
+		return &andFilter{left: WithFieldNames(f.left, rename), right: WithFieldNames(f.right, rename)}
+	case *orFilter:
+		return &orFilter{left: WithFieldNames(f.left, rename), right: WithFieldNames(f.right, rename)}
+	case *notFilter:
+		return &notFilter{inner: WithFieldNames(f.inner, rename)}
+	case *nullFilter:
+		renamed := *f
+		renamed.field = rename(f.field)
+		return &renamed
+	case *comparison:
+		renamed := *f
+		renamed.field = rename(f.field)
+		return &renamed
+	case *existsFilter:
+		renamed := *f
+		renamed.field = rename(f.field)
+		return &renamed
+	case *regexFilter:
+		renamed := *f
+		renamed.field = rename(f.field)
+		return &renamed
+	}
+	return filter
+}
+
+// Equalities returns the field == value comparisons every record matching
+// the filter must satisfy, so an index can narrow down the records to
+// check. Each field maps to the forms its value may take when formatted
+// with FormatValue. Comparisons against null or on date, datetime and
+// decimal fields, whose values can be written in several ways, are left
+// out.
+func Equalities(filter Filter) map[string][]string {
+	equalities := make(map[string][]string)
+	collectComparisons(filter, "==", equalities)
+	return equalities
+}
+
+// Memberships returns the field contains value comparisons every record
+// matching the filter must satisfy, in the same form as Equalities
+func Memberships(filter Filter) map[string][]string {
+	memberships := make(map[string][]string)
+	collectComparisons(filter, "contains", memberships)
+	return memberships
+}
+
+// collectComparisons adds the comparisons with the operator required by
+// the filter
+func collectComparisons(filter Filter, op string, equalities map[string][]string) {
+	switch f := filter.(type) {
+	case *andFilter:
+		collectComparisons(f.left, op, equalities)
+		collectComparisons(f.right, op, equalities)
+	case *comparison:
+		if f.op != op || f.value.isNull {
+			return
+		}
+		switch f.fieldType {
+		case "date", "datetime", "timestamp", "decimal":
+			return
+		}
+		if _, exists := equalities[f.field]; exists {
+			return
+		}
+
+		forms := []string{f.value.text}
+		if f.value.isNumber {
+			if number := FormatValue(f.value.number); number != f.value.text {
+				forms = append(forms, number)
+			}
+		}
+		equalities[f.field] = forms
+	}
+}
+
+// FilterUses reports whether a filter looks at a field, directly or
+// through a dotted path into it
+func FilterUses(filter Filter, field string) bool {
+	var path string
+	switch f := filter.(type) {
+	case *andFilter:
+		return FilterUses(f.left, field) || FilterUses(f.right, field)
+	case *orFilter:
+		return FilterUses(f.left, field) || FilterUses(f.right, field)
+	case *notFilter:
+		return FilterUses(f.inner, field)
+	case *nullFilter:
+		path = f.field
+	case *comparison:
+		path = f.field
+	case *existsFilter:
+		path = f.field
+	case *regexFilter:
+		path = f.field
+	}
+	return path == field || strings.HasPrefix(path, field+".")
+}
+
+// filterToken is one lexical element of a filter expression
+type filterToken struct {
+	kind string // "word", "string", "op", "&&", "||", "!", "(" or ")"
+	text string
+}
+
+// tokenizeFilter splits a filter expression into tokens
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		rest := string(runes[i:])
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.HasPrefix(rest, "&&"), strings.HasPrefix(rest, "||"):
+			tokens = append(tokens, filterToken{kind: rest[:2], text: rest[:2]})
+			i += 2
+		case strings.HasPrefix(rest, "=="), strings.HasPrefix(rest, "!="),
+			strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="):
+			tokens = append(tokens, filterToken{kind: "op", text: rest[:2]})
+			i += 2
+		case r == '=', r == '<', r == '>':
+			tokens = append(tokens, filterToken{kind: "op", text: string(r)})
+			i++
+		case r == '!', r == '(', r == ')':
+			tokens = append(tokens, filterToken{kind: string(r), text: string(r)})
+			i++
+		case r == '"', r == '\'':
+			end := i + 1
+			var text strings.Builder
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' && end+1 < len(runes) {
+					end++
+				}
+				text.WriteRune(runes[end])
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, filterToken{kind: "string", text: text.String()})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("=!<>&|()\"'", runes[end]) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("unexpected character '%c'", r)
+			}
+			tokens = append(tokens, filterToken{kind: "word", text: string(runes[i:end])})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser over filter tokens
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseExpression parses a single filter expression
+func parseExpression(expr string) (Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	return parseTokens(tokens)
+}
+
+// parseTokens parses the tokens of a single filter expression
+func parseTokens(tokens []filterToken) (Filter, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", p.tokens[p.pos].text)
+	}
+	return filter, nil
+}
+
+// peek returns the kind of the next token, or an empty string at the end
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].kind
+}
+
+// parseOr parses: and ("||" and)*
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orFilter{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary ("&&" unary)*
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andFilter{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: "!" unary | "(" or ")" | comparison
+func (p *filterParser) parseUnary() (Filter, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notFilter{inner: inner}, nil
+
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: field op value | field "contains" value |
+// field predicate
+func (p *filterParser) parseComparison() (Filter, error) {
+	if p.peek() != "word" {
+		return nil, p.unexpected("field name")
+	}
+	field := p.tokens[p.pos].text
+	p.pos++
+
+	if filter, ok, err := p.parsePredicate(field); ok || err != nil {
+		return filter, err
+	}
+
+	var op string
+	switch {
+	case p.peek() == "op":
+		op = p.tokens[p.pos].text
+		if op == "=" {
+			op = "=="
+		}
+	case p.peek() == "word" && strings.EqualFold(p.tokens[p.pos].text, "contains"):
+		op = "contains"
+	default:
+		return nil, p.unexpected("comparison operator")
+	}
+	p.pos++
+
+	switch p.peek() {
+	case "word":
+		value := newLiteral(p.tokens[p.pos].text, false)
+		p.pos++
+		return &comparison{field: field, op: op, value: value}, nil
+	case "string":
+		value := newLiteral(p.tokens[p.pos].text, true)
+		p.pos++
+		return &comparison{field: field, op: op, value: value}, nil
+	}
+	return nil, p.unexpected("value")
+}
+
+// parsePredicate parses the predicates that test a field without a value:
+// "IS NULL", "IS NOT NULL", "EXISTS" and "NOT EXISTS". It reports false,
+// consuming nothing, when the next token does not start a predicate.
+func (p *filterParser) parsePredicate(field string) (Filter, bool, error) {
+	if p.peek() != "word" {
+		return nil, false, nil
+	}
+
+	switch strings.ToUpper(p.tokens[p.pos].text) {
+	case "IS":
+		p.pos++
+		null := true
+		if p.peekWord("NOT") {
+			null = false
+			p.pos++
+		}
+		if !p.peekWord("NULL") {
+			return nil, true, p.unexpected("NULL")
+		}
+		p.pos++
+		return &nullFilter{field: field, null: null}, true, nil
+
+	case "EXISTS":
+		p.pos++
+		return &existsFilter{field: field, exists: true}, true, nil
+
+	case "NOT":
+		p.pos++
+		if !p.peekWord("EXISTS") {
+			return nil, true, p.unexpected("EXISTS")
+		}
+		p.pos++
+		return &existsFilter{field: field, exists: false}, true, nil
+	}
+	return nil, false, nil
+}
+
+// peekWord reports whether the next token is the given word, in any case
+func (p *filterParser) peekWord(word string) bool {
+	return p.peek() == "word" && strings.EqualFold(p.tokens[p.pos].text, word)
+}
+
+// unexpected describes what was found where something else was expected
+func (p *filterParser) unexpected(expected string) error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("expected %s at end of expression", expected)
+	}
+	return fmt.Errorf("expected %s, found '%s'", expected, p.tokens[p.pos].text)
+}
@@ -0,0 +1,123 @@
+package preprocessing
+
+import (
+	"sort"
+)
+
+// MergeIterator merges several sorted runs into one stream of live
+// key-value pairs in key order. When a key appears in more than one run only
+// the newest version is yielded, and keys whose newest version is a
+// tombstone are skipped. It is the building block for range scans, listing
+// and compaction.
+//
+//	it := tree.Scan("a", "n")
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+type MergeIterator struct {
+	sources [][]LSMNode // Sorted runs, newest first
+	compare Comparator  // Order the runs are sorted by
+	current LSMNode
+}
+
+// NewMergeIterator returns an iterator over the given runs. Each run must be
+// sorted by key with the given comparator (nil for lexicographic order), and
+// runs must be ordered newest first so that later runs only fill in keys the
+// earlier ones do not have.
+func NewMergeIterator(compare Comparator, runs ...[]LSMNode) *MergeIterator {
+	sources := make([][]LSMNode, len(runs))
+	copy(sources, runs)
+	return &MergeIterator{sources: sources, compare: compare}
+}
+
+// Scan returns an iterator over the live keys in [startKey, endKey) in key
+// order, merging the MemTable with all SSTables. An empty startKey starts at
+// the first key and an empty endKey scans to the end. The iterator works on
+// a snapshot taken when Scan is called.
+func (lsm *LSMTree) Scan(startKey, endKey string) *MergeIterator {
+	return lsm.Snapshot().Scan(startKey, endKey)
+}
+
+// Next advances to the next live pair and reports whether there is one
+func (it *MergeIterator) Next() bool {
+	for it.advance() {
+		if it.current.Value != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// advance moves to the newest version of the next key, including
+// tombstones, and reports whether there is one
+func (it *MergeIterator) advance() bool {
+	// Find the smallest key at the head of any source
+	smallest := -1
+	for i, source := range it.sources {
+		if len(source) == 0 {
+			continue
+		}
+		if smallest < 0 || it.compare.order(source[0].Key, it.sources[smallest][0].Key) < 0 {
+			smallest = i
+		}
+	}
+	if smallest < 0 {
+		return false
+	}
+
+	// Sources are ordered newest first, so the first one holding the key
+	// has its latest value; older versions are skipped
+	node := it.sources[smallest][0]
+	for i, source := range it.sources {
+		if len(source) > 0 && source[0].Key == node.Key {
+			it.sources[i] = source[1:]
+		}
+	}
+
+	it.current = node
+	return true
+}
+
+// Key returns the key of the current pair
+func (it *MergeIterator) Key() string {
+	return it.current.Key
+}
+
+// Value returns the value of the current pair
+func (it *MergeIterator) Value() interface{} {
+	return it.current.Value
+}
+
+// sortedMemoryTable returns the MemTable as a run sorted by key
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) sortedMemoryTable() []LSMNode {
+	nodes := make([]LSMNode, 0, len(lsm.memoryTable))
+	for k, v := range lsm.memoryTable {
+		nodes = append(nodes, LSMNode{Key: k, Value: v})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return lsm.compare.order(nodes[i].Key, nodes[j].Key) < 0
+	})
+	return nodes
+}
+
+// clipRange returns the part of a sorted run with keys in [startKey, endKey)
+// under the given comparator. An empty bound leaves that side open.
+func clipRange(entries []LSMNode, startKey, endKey string, compare Comparator) []LSMNode {
+	start := 0
+	if startKey != "" {
+		start = sort.Search(len(entries), func(i int) bool {
+			return compare.order(entries[i].Key, startKey) >= 0
+		})
+	}
+	end := len(entries)
+	if endKey != "" {
+		end = sort.Search(len(entries), func(i int) bool {
+			return compare.order(entries[i].Key, endKey) >= 0
+		})
+	}
+	if end < start {
+		end = start
+	}
+	return entries[start:end]
+}
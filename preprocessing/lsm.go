@@ -1,49 +1,253 @@
 package preprocessing
 
 import (
-	"container/list"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // LSMNode represents a node in the LSM tree
 type LSMNode struct {
-	Key   string
-	Value interface{}
+	Key   string      `bson:"k"`
+	Value interface{} `bson:"v"`
+}
+
+// sstable is an immutable run of nodes sorted by key
+type sstable struct {
+	entries []LSMNode
+	level   int    // Compaction level, 0 for freshly flushed MemTables
+	path    string // File backing the SSTable, empty when held only in memory
+	order   string // Name of the comparator the file is sorted by
+}
+
+// sstableFile is the on-disk BSON layout of an SSTable
+type sstableFile struct {
+	Entries    []LSMNode `bson:"entries"`
+	Comparator string    `bson:"comparator,omitempty"` // Files without one are in byte order
 }
 
+// sstableSuffix is the file extension of SSTables written to disk
+const sstableSuffix = ".sst"
+
 // LSMTree implements a Log-Structured Merge Tree
 type LSMTree struct {
 	memoryTable   map[string]interface{} // MemTable
-	sortedFiles   []*list.List           // SSTables
+	sortedFiles   []*sstable             // SSTables, oldest first; deeper levels always precede shallower ones
+	compare       Comparator             // Key order of the MemTable and SSTables
 	maxMemorySize int
 	currentSize   int
+	maxBytes      int    // Approximate MemTable size in bytes that triggers a flush, 0 for no limit
+	currentBytes  int    // Approximate size of the keys and values in the MemTable
+	dir           string // Directory SSTables are written to, empty for a memory-only tree
+	nextFileID    int
+	commitLog     *os.File // Open commit log of MemTable writes, nil until first write
+	counters      lsmCounters
+	stopCompactor chan struct{} // Closed to stop the background compactor
+	compactorDone chan struct{} // Closed once the background compactor has exited
 	mutex         sync.RWMutex
 }
 
-// NewLSMTree creates a new LSM tree with specified memory size limit
-func NewLSMTree(maxMemorySize int) *LSMTree {
+// NewLSMTree creates a new LSM tree with specified memory size limit whose
+// keys are ordered by the given comparator. The zero Comparator orders
+// keys lexicographically.
+func NewLSMTree(maxMemorySize int, compare Comparator) *LSMTree {
 	return &LSMTree{
 		memoryTable:   make(map[string]interface{}),
-		sortedFiles:   make([]*list.List, 0),
+		sortedFiles:   make([]*sstable, 0),
+		compare:       compare,
 		maxMemorySize: maxMemorySize,
 		currentSize:   0,
 	}
 }
 
+// OpenLSMTree creates an LSM tree whose SSTables are written to dir and
+// loads the SSTables a previous process left there. When there are some,
+// the tree keeps the comparator they were written with: one of this
+// package, or the given one when it has the same name. SSTables sorted by
+// any other comparator cannot be read, and a comparator without a name
+// cannot be recorded in SSTables.
+func OpenLSMTree(dir string, maxMemorySize int, compare Comparator) (*LSMTree, error) {
+	if compare.Name() == "" {
+		return nil, fmt.Errorf("comparator of the SSTables in %s has no name", dir)
+	}
+	lsm := NewLSMTree(maxMemorySize, compare)
+	lsm.dir = dir
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return lsm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSTable directory: %v", err)
+	}
+
+	type sstableName struct {
+		name  string
+		level int
+		id    int
+	}
+
+	var names []sstableName
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), sstableSuffix) {
+			continue
+		}
+		level, id, ok := parseSSTableName(file.Name())
+		if !ok {
+			continue
+		}
+		names = append(names, sstableName{name: file.Name(), level: level, id: id})
+	}
+
+	// Oldest first: deeper levels, then lower sequence numbers within a level
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].level != names[j].level {
+			return names[i].level > names[j].level
+		}
+		return names[i].id < names[j].id
+	})
+
+	for _, name := range names {
+		table, err := readSSTable(filepath.Join(dir, name.name))
+		if err != nil {
+			return nil, err
+		}
+		table.level = name.level
+		lsm.sortedFiles = append(lsm.sortedFiles, table)
+
+		if name.id >= lsm.nextFileID {
+			lsm.nextFileID = name.id + 1
+		}
+	}
+
+	// SSTables keep the order they were written in, even when the tree is
+	// now opened with another comparator
+	if len(lsm.sortedFiles) > 0 {
+		order := lsm.sortedFiles[0].order
+		if written, known := builtinComparators[order]; known {
+			lsm.compare = written
+		} else if order != compare.Name() {
+			return nil, fmt.Errorf("SSTables in %s are sorted by unknown comparator '%s'", dir, order)
+		}
+	}
+
+	// Writes that never made it into an SSTable are replayed into the MemTable
+	if err := lsm.replayLog(); err != nil {
+		return nil, err
+	}
+
+	return lsm, nil
+}
+
+// parseSSTableName extracts the level and sequence number from an SSTable
+// file name of the form L<level>-<id>.sst. Files named only by sequence
+// number predate leveled compaction and belong to L0.
+func parseSSTableName(name string) (int, int, bool) {
+	name = strings.TrimSuffix(name, sstableSuffix)
+
+	level := 0
+	if strings.HasPrefix(name, "L") {
+		dash := strings.Index(name, "-")
+		if dash < 0 {
+			return 0, 0, false
+		}
+		var err error
+		if level, err = strconv.Atoi(name[1:dash]); err != nil {
+			return 0, 0, false
+		}
+		name = name[dash+1:]
+	}
+
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, 0, false
+	}
+	return level, id, true
+}
+
 // Put adds or updates a key-value pair in the LSM tree
 func (lsm *LSMTree) Put(key string, value interface{}) error {
 	lsm.mutex.Lock()
 	defer lsm.mutex.Unlock()
 
+	if err := lsm.write(key, value); err != nil {
+		return err
+	}
+
+	if lsm.memoryTableFull() {
+		return lsm.flushMemoryTable()
+	}
+
+	return nil
+}
+
+// SetMaxBytes sets the approximate MemTable size in bytes at which it is
+// flushed, in addition to the write count limit. Large values would
+// otherwise use a lot of memory long before the write count is reached.
+// A limit of 0 disables the byte threshold.
+func (lsm *LSMTree) SetMaxBytes(maxBytes int) {
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	lsm.maxBytes = maxBytes
+}
+
+// write records a value, or a nil tombstone, in the commit log and then
+// stores it in the MemTable
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) write(key string, value interface{}) error {
+	if err := lsm.appendLog(LSMNode{Key: key, Value: value}); err != nil {
+		return err
+	}
+
+	lsm.apply(key, value)
+
+	lsm.counters.writes.Add(1)
+	lsm.counters.bytesWritten.Add(int64(len(key) + approximateSize(value)))
+	return nil
+}
+
+// apply stores a value, or a nil tombstone, in the MemTable and updates
+// its size accounting
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) apply(key string, value interface{}) {
+	if old, exists := lsm.memoryTable[key]; exists {
+		lsm.currentBytes -= len(key) + approximateSize(old)
+	}
+
 	lsm.memoryTable[key] = value
 	lsm.currentSize++
+	lsm.currentBytes += len(key) + approximateSize(value)
+}
 
+// memoryTableFull reports whether the MemTable reached either flush threshold
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) memoryTableFull() bool {
 	if lsm.currentSize >= lsm.maxMemorySize {
-		lsm.flushMemoryTable()
+		return true
 	}
+	return lsm.maxBytes > 0 && lsm.currentBytes >= lsm.maxBytes
+}
 
-	return nil
+// approximateSize estimates the memory used by a stored value
+func approximateSize(value interface{}) int {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	default:
+		return len(fmt.Sprintf("%v", v))
+	}
 }
 
 // Get retrieves a value by key from the LSM tree
@@ -51,17 +255,24 @@ func (lsm *LSMTree) Get(key string) (interface{}, error) {
 	lsm.mutex.RLock()
 	defer lsm.mutex.RUnlock()
 
+	lsm.counters.reads.Add(1)
+
 	if value, exists := lsm.memoryTable[key]; exists {
+		lsm.counters.memTableHits.Add(1)
+		if value == nil {
+			return nil, fmt.Errorf("key '%s' not found", key)
+		}
 		return value, nil
 	}
 
 	for i := len(lsm.sortedFiles) - 1; i >= 0; i-- {
-		file := lsm.sortedFiles[i]
-		for e := file.Front(); e != nil; e = e.Next() {
-			node := e.Value.(LSMNode)
-			if node.Key == key {
-				return node.Value, nil
+		lsm.counters.sstableProbes.Add(1)
+		if node, found := lsm.sortedFiles[i].find(key, lsm.compare); found {
+			// A nil value is a tombstone left by Delete
+			if node.Value == nil {
+				return nil, fmt.Errorf("key '%s' not found", key)
 			}
+			return node.Value, nil
 		}
 	}
 
@@ -73,100 +284,272 @@ func (lsm *LSMTree) Delete(key string) error {
 	lsm.mutex.Lock()
 	defer lsm.mutex.Unlock()
 
-	lsm.memoryTable[key] = nil
-	lsm.currentSize++
+	if err := lsm.write(key, nil); err != nil {
+		return err
+	}
 
-	if lsm.currentSize >= lsm.maxMemorySize {
-		lsm.flushMemoryTable()
+	if lsm.memoryTableFull() {
+		return lsm.flushMemoryTable()
 	}
 
 	return nil
 }
 
 // flushMemoryTable moves the in-memory table to a sorted file
-func (lsm *LSMTree) flushMemoryTable() {
-	sortedFile := list.New()
+func (lsm *LSMTree) flushMemoryTable() error {
+	sortedFile := lsm.sortedMemoryTable()
 
-	for k, v := range lsm.memoryTable {
-		sortedFile.PushBack(LSMNode{Key: k, Value: v})
+	table, err := lsm.writeSSTable(sortedFile, 0)
+	if err != nil {
+		return err
 	}
 
-	lsm.sortedFiles = append(lsm.sortedFiles, sortedFile)
+	lsm.counters.flushes.Add(1)
+	lsm.counters.flushBytes.Add(runSize(sortedFile))
+
+	lsm.sortedFiles = append(lsm.sortedFiles, table)
 
 	lsm.memoryTable = make(map[string]interface{})
 	lsm.currentSize = 0
+	lsm.currentBytes = 0
+
+	// The flushed writes are durable in the SSTable now
+	if err := lsm.truncateLog(); err != nil {
+		return err
+	}
+
+	return lsm.maybeCompact()
 }
 
-// Compact merges sorted files to optimize storage
-func (lsm *LSMTree) Compact() {
+// Seed loads pairs as the oldest layer of the tree, beneath every SSTable.
+// It is used to restore a snapshot the tree's own files are newer than.
+// The layer is held in memory until Persist writes it to a file.
+func (lsm *LSMTree) Seed(pairs map[string]interface{}) {
 	lsm.mutex.Lock()
 	defer lsm.mutex.Unlock()
 
-	if len(lsm.sortedFiles) > 1 {
-		consolidated := make(map[string]interface{})
-
-		for _, file := range lsm.sortedFiles {
-			for e := file.Front(); e != nil; e = e.Next() {
-				node := e.Value.(LSMNode)
-				if node.Value != nil {
-					consolidated[node.Key] = node.Value
-				} else {
-					if _, exists := lsm.memoryTable[node.Key]; !exists {
-						delete(consolidated, node.Key)
-					}
-				}
-			}
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lsm.compare.order(keys[i], keys[j]) < 0
+	})
+
+	base := make([]LSMNode, 0, len(keys))
+	for _, k := range keys {
+		base = append(base, LSMNode{Key: k, Value: pairs[k]})
+	}
+
+	lsm.sortedFiles = append([]*sstable{{entries: base, level: lsm.seedLevel(len(base))}}, lsm.sortedFiles...)
+}
+
+// Flush writes the MemTable out as a new SSTable, if it holds anything
+func (lsm *LSMTree) Flush() error {
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	if len(lsm.memoryTable) == 0 {
+		return nil
+	}
+	return lsm.flushMemoryTable()
+}
+
+// Persist writes the SSTables held only in memory, such as a snapshot
+// loaded with Seed, to files, so the tree's directory alone holds all of
+// its contents
+func (lsm *LSMTree) Persist() error {
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	if lsm.dir == "" {
+		return nil
+	}
+	for i, table := range lsm.sortedFiles {
+		if table.path != "" {
+			continue
+		}
+		written, err := lsm.writeSSTable(table.entries, table.level)
+		if err != nil {
+			return err
 		}
+		lsm.sortedFiles[i] = written
+	}
+
+	return nil
+}
+
+// Drop stops the background compactor, empties the tree and removes its
+// SSTable directory
+func (lsm *LSMTree) Drop() error {
+	if err := lsm.Close(); err != nil {
+		return err
+	}
+
+	lsm.mutex.Lock()
+	defer lsm.mutex.Unlock()
+
+	lsm.memoryTable = make(map[string]interface{})
+	lsm.sortedFiles = make([]*sstable, 0)
+	lsm.currentSize = 0
+	lsm.currentBytes = 0
+
+	if lsm.dir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(lsm.dir); err != nil {
+		return fmt.Errorf("failed to remove SSTable directory: %v", err)
+	}
+
+	return nil
+}
+
+// writeSSTable wraps a sorted run in an SSTable, writing it to a new file
+// when the tree is backed by a directory
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) writeSSTable(entries []LSMNode, level int) (*sstable, error) {
+	table := &sstable{entries: entries, level: level, order: lsm.compare.Name()}
+	if lsm.dir == "" {
+		return table, nil
+	}
 
-		compactedFile := list.New()
-		for k, v := range consolidated {
-			compactedFile.PushBack(LSMNode{Key: k, Value: v})
+	data, err := bson.Marshal(sstableFile{Entries: entries, Comparator: lsm.compare.Name()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal SSTable: %v", err)
+	}
+
+	if err := os.MkdirAll(lsm.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	path := filepath.Join(lsm.dir, fmt.Sprintf("L%d-%06d%s", level, lsm.nextFileID, sstableSuffix))
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write SSTable: %v", err)
+	}
+	lsm.nextFileID++
+
+	table.path = path
+	return table, nil
+}
+
+// removeFiles deletes the files backing the given SSTables
+func (lsm *LSMTree) removeFiles(tables []*sstable) error {
+	for _, table := range tables {
+		if table.path == "" {
+			continue
 		}
+		if err := os.Remove(table.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove SSTable: %v", err)
+		}
+	}
+	return nil
+}
+
+// readSSTable loads an SSTable file written by writeSSTable
+func readSSTable(path string) (*sstable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSTable: %v", err)
+	}
+
+	var doc sstableFile
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SSTable %s: %v", filepath.Base(path), err)
+	}
 
-		lsm.sortedFiles = []*list.List{compactedFile}
+	// Files from before comparators were recorded are in byte order
+	order := doc.Comparator
+	if order == "" {
+		order = LexicographicComparator.name
 	}
+	return &sstable{entries: doc.Entries, path: path, order: order}, nil
+}
+
+// find looks a key up in the SSTable with a binary search, using the
+// comparator the SSTable is sorted by
+func (table *sstable) find(key string, compare Comparator) (LSMNode, bool) {
+	i := sort.Search(len(table.entries), func(i int) bool {
+		return compare.order(table.entries[i].Key, key) >= 0
+	})
+	if i < len(table.entries) && table.entries[i].Key == key {
+		return table.entries[i], true
+	}
+	return LSMNode{}, false
+}
+
+// Compare orders two keys as the tree does, returning a negative number
+// when a comes before b
+func (lsm *LSMTree) Compare(a, b string) int {
+	lsm.mutex.RLock()
+	defer lsm.mutex.RUnlock()
+
+	return lsm.compare.order(a, b)
+}
+
+// Lexicographic reports whether the keys of the tree are in byte order, in
+// which keys sharing a prefix follow each other
+func (lsm *LSMTree) Lexicographic() bool {
+	lsm.mutex.RLock()
+	defer lsm.mutex.RUnlock()
+
+	return lsm.compare.Name() == LexicographicComparator.name
 }
 
-// Size returns the number of key-value pairs in the LSM tree
-func (lsm *LSMTree) Size() int {
+// Size returns the number of live key-value pairs in the LSM tree along
+// with the number of dead entries still stored: tombstones and values
+// shadowed by newer writes, which compaction reclaims
+func (lsm *LSMTree) Size() (int, int) {
 	lsm.mutex.RLock()
 	defer lsm.mutex.RUnlock()
 
-	count := len(lsm.memoryTable)
+	stored := len(lsm.memoryTable)
 	for _, file := range lsm.sortedFiles {
-		count += file.Len()
+		stored += len(file.entries)
 	}
-	return count
+
+	live := len(lsm.items())
+	return live, stored - live
 }
 
-// Keys returns all keys in the LSM tree
+// Keys returns all live keys in the LSM tree
 func (lsm *LSMTree) Keys() []string {
 	lsm.mutex.RLock()
 	defer lsm.mutex.RUnlock()
 
-	keys := make([]string, 0)
-
-	for k := range lsm.memoryTable {
+	items := lsm.items()
+	keys := make([]string, 0, len(items))
+	for k := range items {
 		keys = append(keys, k)
 	}
 
-	for _, file := range lsm.sortedFiles {
-		for e := file.Front(); e != nil; e = e.Next() {
-			node := e.Value.(LSMNode)
-			found := false
-			for _, existingKey := range keys {
-				if existingKey == node.Key {
-					found = true
-					break
-				}
-			}
-			if !found {
-				keys = append(keys, node.Key)
-			}
-		}
+	return keys
+}
+
+// Items returns every live key-value pair, with newer writes shadowing
+// older ones and deleted keys left out
+func (lsm *LSMTree) Items() map[string]interface{} {
+	lsm.mutex.RLock()
+	defer lsm.mutex.RUnlock()
+
+	return lsm.items()
+}
+
+// items merges the MemTable and all SSTables into a single map
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) items() map[string]interface{} {
+	runs := make([][]LSMNode, 0, len(lsm.sortedFiles)+1)
+	runs = append(runs, lsm.sortedMemoryTable())
+	for i := len(lsm.sortedFiles) - 1; i >= 0; i-- {
+		runs = append(runs, lsm.sortedFiles[i].entries)
 	}
 
-	return keys
+	merged := make(map[string]interface{})
+	it := NewMergeIterator(lsm.compare, runs...)
+	for it.Next() {
+		merged[it.Key()] = it.Value()
+	}
+
+	return merged
 }
 
 // BatchPut adds multiple key-value pairs efficiently
@@ -175,13 +558,14 @@ func (lsm *LSMTree) BatchPut(pairs map[string]interface{}) error {
 	defer lsm.mutex.Unlock()
 
 	for key, value := range pairs {
-		lsm.memoryTable[key] = value
-		lsm.currentSize++
+		if err := lsm.write(key, value); err != nil {
+			return err
+		}
 	}
 
-	if lsm.currentSize >= lsm.maxMemorySize {
-		lsm.flushMemoryTable()
+	if lsm.memoryTableFull() {
+		return lsm.flushMemoryTable()
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,125 @@
+package preprocessing
+
+import (
+	"sync/atomic"
+)
+
+// lsmCounters are updated atomically since reads only hold the read lock
+type lsmCounters struct {
+	reads           atomic.Int64
+	memTableHits    atomic.Int64
+	sstableProbes   atomic.Int64
+	writes          atomic.Int64
+	bytesWritten    atomic.Int64
+	flushes         atomic.Int64
+	flushBytes      atomic.Int64
+	compactions     atomic.Int64
+	compactionBytes atomic.Int64
+}
+
+// LSMMetrics is a snapshot of the activity of an LSM tree, since it was
+// opened plus any counters added with AddCounters, used to measure read and
+// write amplification
+type LSMMetrics struct {
+	Reads           int64 // Get calls
+	MemTableHits    int64 // Gets answered by the MemTable
+	SSTableProbes   int64 // SSTables searched by Gets
+	Writes          int64 // Puts and Deletes
+	BytesWritten    int64 // Approximate size of the keys and values written
+	Flushes         int64 // MemTables flushed to SSTables
+	FlushBytes      int64 // Approximate size of the flushed SSTables
+	Compactions     int64 // Merges of SSTables
+	CompactionBytes int64 // Approximate size of the SSTables rewritten by compaction
+	SSTables        int   // SSTables currently held
+	Levels          int   // Deepest level holding an SSTable
+}
+
+// ProbesPerRead returns the average number of SSTables searched per Get,
+// the tree's read amplification
+func (m LSMMetrics) ProbesPerRead() float64 {
+	if m.Reads == 0 {
+		return 0
+	}
+	return float64(m.SSTableProbes) / float64(m.Reads)
+}
+
+// WriteAmplification returns how many bytes were written to SSTables, by
+// flushes and compactions, for every byte written to the tree
+func (m LSMMetrics) WriteAmplification() float64 {
+	if m.BytesWritten == 0 {
+		return 0
+	}
+	return float64(m.FlushBytes+m.CompactionBytes) / float64(m.BytesWritten)
+}
+
+// Metrics returns a snapshot of the tree's activity counters
+func (lsm *LSMTree) Metrics() LSMMetrics {
+	lsm.mutex.RLock()
+	defer lsm.mutex.RUnlock()
+
+	return LSMMetrics{
+		Reads:           lsm.counters.reads.Load(),
+		MemTableHits:    lsm.counters.memTableHits.Load(),
+		SSTableProbes:   lsm.counters.sstableProbes.Load(),
+		Writes:          lsm.counters.writes.Load(),
+		BytesWritten:    lsm.counters.bytesWritten.Load(),
+		Flushes:         lsm.counters.flushes.Load(),
+		FlushBytes:      lsm.counters.flushBytes.Load(),
+		Compactions:     lsm.counters.compactions.Load(),
+		CompactionBytes: lsm.counters.compactionBytes.Load(),
+		SSTables:        len(lsm.sortedFiles),
+		Levels:          lsm.bottomLevel(),
+	}
+}
+
+// Counters returns the activity counters of the snapshot by name, the form
+// in which they are saved
+func (m LSMMetrics) Counters() map[string]int64 {
+	return map[string]int64{
+		"reads":            m.Reads,
+		"memtable_hits":    m.MemTableHits,
+		"sstable_probes":   m.SSTableProbes,
+		"writes":           m.Writes,
+		"bytes_written":    m.BytesWritten,
+		"flushes":          m.Flushes,
+		"flush_bytes":      m.FlushBytes,
+		"compactions":      m.Compactions,
+		"compaction_bytes": m.CompactionBytes,
+	}
+}
+
+// AddCounters adds counters returned by Counters, saved by an earlier run,
+// to the activity of the tree. Unknown names are ignored.
+func (lsm *LSMTree) AddCounters(counters map[string]int64) {
+	for name, value := range counters {
+		switch name {
+		case "reads":
+			lsm.counters.reads.Add(value)
+		case "memtable_hits":
+			lsm.counters.memTableHits.Add(value)
+		case "sstable_probes":
+			lsm.counters.sstableProbes.Add(value)
+		case "writes":
+			lsm.counters.writes.Add(value)
+		case "bytes_written":
+			lsm.counters.bytesWritten.Add(value)
+		case "flushes":
+			lsm.counters.flushes.Add(value)
+		case "flush_bytes":
+			lsm.counters.flushBytes.Add(value)
+		case "compactions":
+			lsm.counters.compactions.Add(value)
+		case "compaction_bytes":
+			lsm.counters.compactionBytes.Add(value)
+		}
+	}
+}
+
+// runSize estimates the size in bytes of a sorted run
+func runSize(entries []LSMNode) int64 {
+	var size int64
+	for _, node := range entries {
+		size += int64(len(node.Key) + approximateSize(node.Value))
+	}
+	return size
+}
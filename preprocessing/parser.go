@@ -2,31 +2,166 @@ package preprocessing
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
-// Preprocessor handles command preprocessing with LSM tree optimization
-type Preprocessor struct {
-	// We can add an LSM tree instance here if needed for future optimization
-	// lsmTree *LSMTree 
+// Flags holds the --flags given on the command line, keyed by name without
+// the leading dashes. Boolean flags are stored with the value "true".
+type Flags map[string]string
+
+// Has reports whether the flag was given
+func (f Flags) Has(name string) bool {
+	_, exists := f[name]
+	return exists
+}
+
+// Get returns the value of the flag, or an empty string if it was not given
+func (f Flags) Get(name string) string {
+	return f[name]
+}
+
+// Count returns the flag as a non-negative integer, or 0 if it was not
+// given
+func (f Flags) Count(name string) (int, error) {
+	value, exists := f[name]
+	if !exists {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("--%s expects a non-negative number, got '%s'", name, value)
+	}
+	return n, nil
+}
+
+// valueFlags lists the flags that take the following argument as their value
+// when not written as --name=value
+var valueFlags = map[string]bool{
+	"fields": true,
+	"sort":   true,
+	"limit":  true,
+	"offset": true,
+	"cursor": true,
+
+	"group-by": true,
+	"field":    true,
+	"on":       true,
+	"n":        true,
+	"radius":   true,
+
+	"since":      true,
+	"until":      true,
+	"time-field": true,
+
+	"to":     true,
+	"format": true,
+	"file":   true,
+	"db":     true,
+
+	"interval": true,
+}
+
+// ParseFlags separates --flags from positional arguments. Anything after a
+// bare "--" is treated as positional.
+func ParseFlags(args []string) ([]string, Flags) {
+	positional := make([]string, 0, len(args))
+	flags := make(Flags)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name := arg[2:]
+		if eq := strings.Index(name, "="); eq >= 0 {
+			flags[name[:eq]] = name[eq+1:]
+			continue
+		}
+
+		if valueFlags[name] && i+1 < len(args) {
+			flags[name] = args[i+1]
+			i++
+			continue
+		}
+
+		flags[name] = "true"
+	}
+
+	return positional, flags
 }
 
 // ParseCommand parses command-line arguments for different commands
 func ParseCommand(command string, args []string) ([]string, error) {
 	switch command {
-	case "add":
-		// Format: add <schema> <record_data>
+	case "add", "upsert":
+		// Format: add/upsert <schema> <record_data> [record_data...]
 		if len(args) < 2 {
-			return nil, fmt.Errorf("not enough arguments for 'add' command")
+			return nil, fmt.Errorf("not enough arguments for '%s' command", command)
 		}
 		return args, nil
 
-	case "get", "view", "delete":
-		// Format: get/view/delete <schema> <key>
+	case "get", "delete", "exists":
+		// Format: get/delete/exists <schema> <key> [--prefix|--fuzzy] [--ignore-case]
 		if len(args) < 2 {
 			return nil, fmt.Errorf("not enough arguments for '%s' command", command)
 		}
 		return args, nil
 
+	case "view":
+		// Format: view <schema> <key> like get, or view create <name> <query>,
+		// view drop <name> and view list
+		needed := 2
+		if len(args) > 0 {
+			switch strings.ToLower(args[0]) {
+			case "create":
+				needed = 3
+			case "list":
+				needed = 1
+			}
+		}
+		if len(args) < needed {
+			return nil, fmt.Errorf("not enough arguments for 'view' command")
+		}
+		return args, nil
+
+	case "update":
+		// Format: update <schema> <key> <update_data>
+		if len(args) < 3 {
+			return nil, fmt.Errorf("not enough arguments for 'update' command")
+		}
+		return args, nil
+
+	case "update-where":
+		// Format: update-where <schema> <filter> <update_data>
+		if len(args) < 3 {
+			return nil, fmt.Errorf("not enough arguments for 'update-where' command")
+		}
+		return args, nil
+
+	case "delete-where":
+		// Format: delete-where <schema> <filter>
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'delete-where' command")
+		}
+		return args, nil
+
+	case "checksum":
+		// Format: checksum <schema>
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'checksum' command")
+		}
+		return args, nil
+
 	case "list":
 		// Format: list <schema>
 		if len(args) < 1 {
@@ -34,9 +169,167 @@ func ParseCommand(command string, args []string) ([]string, error) {
 		}
 		return args, nil
 
+	case "find":
+		// Format: find <schema> [filter...]
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'find' command")
+		}
+		return args, nil
+
+	case "agg":
+		// Format: agg <schema> <func> <field> [filter...]
+		if len(args) < 3 {
+			return nil, fmt.Errorf("not enough arguments for 'agg' command")
+		}
+		return args, nil
+
+	case "pipeline":
+		// Format: pipeline <schema> <stages>
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'pipeline' command")
+		}
+		return args, nil
+
+	case "distinct":
+		// Format: distinct <schema> <field> [filter...]
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'distinct' command")
+		}
+		return args, nil
+
+	case "top":
+		// Format: top <schema> <field> [filter...] [--n 10] [--desc]
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'top' command")
+		}
+		return args, nil
+
+	case "near":
+		// Format: near <schema> <lat> <lon> --radius <distance> [--field f]
+		if len(args) < 3 {
+			return nil, fmt.Errorf("not enough arguments for 'near' command")
+		}
+		return args, nil
+
+	case "keys":
+		// Format: keys <schema> [prefix]
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'keys' command")
+		}
+		return args, nil
+
+	case "sql":
+		// Format: sql <query>
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'sql' command")
+		}
+		return args, nil
+
+	case "join":
+		// Format: join <left_schema> <right_schema> --on left.field=right.field [--left]
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'join' command")
+		}
+		return args, nil
+
+	case "search":
+		// Format: search <schema> <regexp> [--field f]
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'search' command")
+		}
+		return args, nil
+
+	case "search-text":
+		// Format: search-text <schema> <words>
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'search-text' command")
+		}
+		return args, nil
+
+	case "index":
+		// Format: index <create|drop> <schema> <field,...> or index list <schema>
+		if len(args) < 2 {
+			return nil, fmt.Errorf("not enough arguments for 'index' command")
+		}
+		switch strings.ToLower(args[0]) {
+		case "create", "drop":
+			if len(args) < 3 {
+				return nil, fmt.Errorf("not enough arguments for 'index %s' command", args[0])
+			}
+		case "list":
+		default:
+			return nil, fmt.Errorf("unknown index action '%s', expected create, drop or list", args[0])
+		}
+		return args, nil
+
 	case "schema":
-		// Format: schema <schema_name> [field_definitions...]
+		// Format: schema <schema_name> [field_definitions...],
+		// schema alter <schema_name> <add-field|rename-field|drop-field> ...,
+		// schema drop <schema_name>, schema rename <old_name> <new_name>,
+		// schema export [schema_name...], schema import <file>,
+		// schema json <schema_name> <file>, schema infer <schema_name> [file]
+		// schema diff <schema_name> <database|file> or
+		// schema copy <schema_name> --to <database>
 		// If no args provided, this is to list all schemas
+		if len(args) == 0 {
+			return args, nil
+		}
+		switch strings.ToLower(args[0]) {
+		case "drop":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("not enough arguments for 'schema drop' command")
+			}
+		case "rename":
+			if len(args) < 3 {
+				return nil, fmt.Errorf("not enough arguments for 'schema rename' command")
+			}
+		case "import":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("not enough arguments for 'schema import' command")
+			}
+		case "json":
+			if len(args) < 3 {
+				return nil, fmt.Errorf("not enough arguments for 'schema json' command")
+			}
+		case "infer":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("not enough arguments for 'schema infer' command")
+			}
+		case "diff":
+			if len(args) < 3 {
+				return nil, fmt.Errorf("not enough arguments for 'schema diff' command")
+			}
+		case "copy":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("not enough arguments for 'schema copy' command")
+			}
+		case "alter":
+			if len(args) < 3 {
+				return nil, fmt.Errorf("not enough arguments for 'schema alter' command")
+			}
+			needed := 4
+			switch strings.ToLower(args[2]) {
+			case "add-field", "drop-field":
+			case "rename-field":
+				needed = 5
+			default:
+				return nil, fmt.Errorf("unknown schema alter action '%s', expected add-field, rename-field or drop-field", args[2])
+			}
+			if len(args) < needed {
+				return nil, fmt.Errorf("not enough arguments for 'schema alter %s' command", args[2])
+			}
+		}
+		return args, nil
+
+	case "archive":
+		// Format: archive <schema>
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'archive' command")
+		}
+		return args, nil
+
+	case "stats":
+		// Format: stats [schema...]
 		return args, nil
 
 	case "use":
@@ -50,8 +343,45 @@ func ParseCommand(command string, args []string) ([]string, error) {
 		// Format: dbs (no args needed)
 		return args, nil
 
+	case "shell":
+		// Format: shell (no args needed)
+		return args, nil
+
+	case "run":
+		// Format: run <script|-> [--keep-going]
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'run' command")
+		}
+		return args, nil
+
+	case "flush":
+		// Format: flush (no args needed)
+		return args, nil
+
+	case "reindex", "compact":
+		// Format: reindex/compact [schema...]
+		return args, nil
+
 	case "wipe", "drop":
-		// Format: wipe/drop (no args needed)
+		// Format: wipe/drop [database] [--force]
+		return args, nil
+
+	case "help":
+		// Format: help [command] [subcommand]
+		return args, nil
+
+	case "watch":
+		// Format: watch <schema> [--interval 500ms]
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'watch' command")
+		}
+		return args, nil
+
+	case "completion":
+		// Format: completion <bash|zsh|fish>
+		if len(args) < 1 {
+			return nil, fmt.Errorf("not enough arguments for 'completion' command")
+		}
 		return args, nil
 
 	default:
@@ -59,19 +389,141 @@ func ParseCommand(command string, args []string) ([]string, error) {
 	}
 }
 
+// IsViewAction reports whether the first argument of the view command
+// manages views rather than naming the schema of a record to view
+func IsViewAction(arg string) bool {
+	switch strings.ToLower(arg) {
+	case "create", "drop", "list":
+		return true
+	}
+	return false
+}
+
+// ParseFieldList splits a comma-separated list of field names, such as the
+// value of --fields
+func ParseFieldList(value string) []string {
+	fields := make([]string, 0)
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// ParseSortSpec parses the value of --sort, field[:asc|desc], into the
+// field name and whether the order is descending
+func ParseSortSpec(value string) (string, bool, error) {
+	field, direction := value, "asc"
+	if colon := strings.LastIndex(value, ":"); colon >= 0 {
+		field, direction = value[:colon], strings.ToLower(value[colon+1:])
+	}
+
+	if field == "" {
+		return "", false, fmt.Errorf("missing sort field")
+	}
+	switch direction {
+	case "asc":
+		return field, false, nil
+	case "desc":
+		return field, true, nil
+	}
+	return "", false, fmt.Errorf("invalid sort direction '%s', expected asc or desc", direction)
+}
+
+// ParseDBName checks the name of a database given to use or --db, which
+// names a directory of its own. Names starting with a dot are kept for
+// the files beside the databases.
+func ParseDBName(value string) (string, error) {
+	name := strings.TrimSpace(value)
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid database name '%s'", value)
+	}
+	return name, nil
+}
+
+// Formats lists the values --format accepts
+var Formats = []string{"json", "table", "csv", "yaml"}
+
+// ParseFormat checks the value of --format, case-insensitively, and
+// returns it in lower case. An empty value stands for the raw records.
+func ParseFormat(value string) (string, error) {
+	format := strings.ToLower(value)
+	if format == "" {
+		return "", nil
+	}
+	for _, known := range Formats {
+		if format == known {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format '%s', expected %s", value, strings.Join(Formats, ", "))
+}
+
+// ParseDistance parses a distance such as 5km, 300m or 2mi and returns it
+// in kilometres. A number without a unit is taken as kilometres.
+func ParseDistance(value string) (float64, error) {
+	units := []struct {
+		suffix string
+		km     float64
+	}{{"km", 1}, {"mi", 1.609344}, {"m", 0.001}}
+
+	number, factor := strings.TrimSpace(value), 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(strings.ToLower(number), unit.suffix) {
+			number, factor = strings.TrimSpace(number[:len(number)-len(unit.suffix)]), unit.km
+			break
+		}
+	}
+
+	distance, err := strconv.ParseFloat(number, 64)
+	if err != nil || distance < 0 {
+		return 0, fmt.Errorf("invalid distance '%s', expected a number with an optional km, m or mi unit", value)
+	}
+	return distance * factor, nil
+}
+
+// ParseJoinOn parses a join condition of the form left.field=right.field,
+// where left and right are the names of the joined schemas, and returns the
+// field of each side. The two sides may be written in either order. Since
+// the sides are told apart by schema name, a schema cannot be joined with
+// itself.
+func ParseJoinOn(value, leftSchema, rightSchema string) (string, string, error) {
+	if leftSchema == rightSchema {
+		return "", "", fmt.Errorf("cannot join schema '%s' with itself, self-joins are not supported", leftSchema)
+	}
+
+	sides := strings.SplitN(value, "=", 2)
+	if len(sides) != 2 {
+		return "", "", fmt.Errorf("invalid join condition '%s', expected left.field=right.field", value)
+	}
+
+	fields := make(map[string]string, 2)
+	for _, side := range sides {
+		side = strings.TrimSpace(side)
+		dot := strings.Index(side, ".")
+		if dot <= 0 || dot == len(side)-1 {
+			return "", "", fmt.Errorf("invalid join field '%s', expected schema.field", side)
+		}
+		schema, field := side[:dot], side[dot+1:]
+		if schema != leftSchema && schema != rightSchema {
+			return "", "", fmt.Errorf("join field '%s' does not belong to schema '%s' or '%s'", side, leftSchema, rightSchema)
+		}
+		if _, seen := fields[schema]; seen {
+			return "", "", fmt.Errorf("join condition '%s' must name a field of each schema", value)
+		}
+		fields[schema] = field
+	}
+
+	return fields[leftSchema], fields[rightSchema], nil
+}
+
 // ExtractSchemaName extracts the schema name from a record string
-// This is a simplified implementation - in a real implementation, 
+// This is a simplified implementation - in a real implementation,
 // this would parse the JSON-like format properly
 func ExtractSchemaName(recordData string) (string, error) {
-	// This is a simplified version - in the real implementation, 
+	// This is a simplified version - in the real implementation,
 	// we would parse the record data to extract the primary key
 	// For now, we'll just return the first value found in the record
 	return recordData, nil
 }
-
-// NewLSMPreprocessor creates a new preprocessor with LSM tree capabilities
-func NewLSMPreprocessor(maxMemorySize int) *Preprocessor {
-	// In a more advanced implementation, this would set up the LSM tree
-	// and potentially use it for preprocessing operations
-	return &Preprocessor{}
-}
\ No newline at end of file
@@ -0,0 +1,53 @@
+package preprocessing
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LookupField returns the value a field path addresses in a decoded record.
+// A path is a field name, or names joined by dots, such as address.city,
+// that walk into nested objects; a numeric segment indexes into an array.
+// A top-level field whose name itself contains a dot takes precedence.
+func LookupField(record map[string]interface{}, path string) (interface{}, bool) {
+	if value, exists := record[path]; exists || !strings.Contains(path, ".") {
+		return value, exists
+	}
+
+	var current interface{} = record
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, exists := node[segment]
+			if !exists {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			current = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// SetField stores a value at a field path in a record, creating the
+// nested objects the path walks through. It is the inverse of LookupField
+// for building projections.
+func SetField(record map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		child, ok := record[segment].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			record[segment] = child
+		}
+		record = child
+	}
+	record[segments[len(segments)-1]] = value
+}
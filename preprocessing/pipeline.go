@@ -0,0 +1,242 @@
+package preprocessing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PipelineStage is one stage of an aggregation pipeline. Kind tells which
+// of the other fields apply.
+type PipelineStage struct {
+	Kind         string        // "match", "project", "group", "sort", "skip" or "limit"
+	Filter       Filter        // Documents a match stage keeps
+	Fields       []string      // Fields a project stage keeps
+	GroupBy      string        // Field whose values form the groups, every document in one group when empty
+	Accumulators []Accumulator // Values a group stage computes for each group
+	Sort         []SortKey     // Fields a sort stage orders by, most significant first
+	Count        int           // Number of documents a skip stage skips or a limit stage keeps
+}
+
+// Accumulator computes one field of the documents a group stage produces
+type Accumulator struct {
+	Name     string // Field of the group document holding the result
+	Function string // "sum", "avg", "min", "max" or "count"
+	Field    string // Field the function reads, empty when counting documents
+}
+
+// SortKey is one field of a sort stage
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// ParsePipeline parses an aggregation pipeline written as a JSON array of
+// MongoDB-style stage documents, each holding a single stage:
+//
+//	{"$match": <query document or filter expression>}
+//	{"$project": {"field": 1, ...}} or {"$project": ["field", ...]}
+//	{"$group": {"_id": "$field" or null, "name": {"$sum": "$field"}, ...}}
+//	{"$sort": {"field": 1 or -1, ...}}
+//	{"$skip": n} and {"$limit": n}
+//
+// Group accumulators are $sum, $avg, $min, $max and $count; {"$sum": 1}
+// counts documents like {"$count": {}}.
+func ParsePipeline(text string) ([]PipelineStage, error) {
+	if !strings.HasPrefix(strings.TrimSpace(text), "[") {
+		return nil, fmt.Errorf("pipeline must be a JSON array of stages")
+	}
+	var documents []json.RawMessage
+	if err := json.Unmarshal([]byte(text), &documents); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %v", err)
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("pipeline has no stages")
+	}
+
+	stages := make([]PipelineStage, 0, len(documents))
+	for i, document := range documents {
+		stage, err := parseStage(document)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %v", i+1, err)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// parseStage parses a single stage document
+func parseStage(document json.RawMessage) (PipelineStage, error) {
+	var stage map[string]json.RawMessage
+	if err := json.Unmarshal(document, &stage); err != nil || len(stage) != 1 {
+		return PipelineStage{}, fmt.Errorf("a stage must be an object with a single $stage key")
+	}
+
+	for name, body := range stage {
+		switch name {
+		case "$match":
+			return parseMatchStage(body)
+		case "$project":
+			return parseProjectStage(body)
+		case "$group":
+			return parseGroupStage(body)
+		case "$sort":
+			return parseSortStage(body)
+		case "$skip", "$limit":
+			var n int
+			if err := json.Unmarshal(body, &n); err != nil || n < 0 {
+				return PipelineStage{}, fmt.Errorf("%s expects a non-negative integer", name)
+			}
+			return PipelineStage{Kind: name[1:], Count: n}, nil
+		default:
+			return PipelineStage{}, fmt.Errorf("unknown stage '%s'", name)
+		}
+	}
+	return PipelineStage{}, nil
+}
+
+// parseMatchStage parses a $match stage, which takes a query document or a
+// filter expression string
+func parseMatchStage(body json.RawMessage) (PipelineStage, error) {
+	var expr string
+	if err := json.Unmarshal(body, &expr); err == nil {
+		filter, err := parseArgument(expr)
+		if err != nil {
+			return PipelineStage{}, fmt.Errorf("invalid $match filter: %v", err)
+		}
+		return PipelineStage{Kind: "match", Filter: filter}, nil
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return PipelineStage{}, fmt.Errorf("$match expects a query document or a filter expression")
+	}
+	filter, err := documentFilter(document)
+	if err != nil {
+		return PipelineStage{}, fmt.Errorf("invalid $match filter: %v", err)
+	}
+	return PipelineStage{Kind: "match", Filter: filter}, nil
+}
+
+// parseProjectStage parses a $project stage, which takes an array of field
+// names or an object marking the fields to keep with 1 or true
+func parseProjectStage(body json.RawMessage) (PipelineStage, error) {
+	var fields []string
+	if err := json.Unmarshal(body, &fields); err != nil {
+		var document map[string]interface{}
+		if err := json.Unmarshal(body, &document); err != nil {
+			return PipelineStage{}, fmt.Errorf("$project expects an object or an array of field names")
+		}
+		for _, field := range sortedKeys(document) {
+			switch document[field] {
+			case 1.0, true:
+				fields = append(fields, field)
+			default:
+				return PipelineStage{}, fmt.Errorf("$project only includes fields, expected 1 or true for '%s'", field)
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return PipelineStage{}, fmt.Errorf("$project expects at least one field")
+	}
+	return PipelineStage{Kind: "project", Fields: fields}, nil
+}
+
+// parseGroupStage parses a $group stage
+func parseGroupStage(body json.RawMessage) (PipelineStage, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return PipelineStage{}, fmt.Errorf("$group expects an object")
+	}
+
+	id, exists := document["_id"]
+	if !exists {
+		return PipelineStage{}, fmt.Errorf("$group requires an _id, a $field reference or null")
+	}
+	stage := PipelineStage{Kind: "group"}
+	if id != nil {
+		field, ok := fieldReference(id)
+		if !ok {
+			return PipelineStage{}, fmt.Errorf("$group _id must be a $field reference or null")
+		}
+		stage.GroupBy = field
+	}
+
+	for _, name := range sortedKeys(document) {
+		if name == "_id" {
+			continue
+		}
+		accumulator, err := parseAccumulator(name, document[name])
+		if err != nil {
+			return PipelineStage{}, err
+		}
+		stage.Accumulators = append(stage.Accumulators, accumulator)
+	}
+	return stage, nil
+}
+
+// parseAccumulator parses an accumulator of a $group stage, such as
+// {"$sum": "$amount"}
+func parseAccumulator(name string, value interface{}) (Accumulator, error) {
+	operator, ok := value.(map[string]interface{})
+	if !ok || len(operator) != 1 {
+		return Accumulator{}, fmt.Errorf("group field '%s' expects an accumulator such as {\"$sum\": \"$field\"}", name)
+	}
+
+	for op, argument := range operator {
+		switch op {
+		case "$count":
+			return Accumulator{Name: name, Function: "count"}, nil
+		case "$sum", "$avg", "$min", "$max":
+			if op == "$sum" && argument == 1.0 {
+				return Accumulator{Name: name, Function: "count"}, nil
+			}
+			field, ok := fieldReference(argument)
+			if !ok {
+				return Accumulator{}, fmt.Errorf("%s of '%s' expects a $field reference", op, name)
+			}
+			return Accumulator{Name: name, Function: op[1:], Field: field}, nil
+		default:
+			return Accumulator{}, fmt.Errorf("unknown accumulator '%s' for '%s'", op, name)
+		}
+	}
+	return Accumulator{}, nil
+}
+
+// fieldReference returns the field named by a "$field" reference
+func fieldReference(value interface{}) (string, bool) {
+	text, ok := value.(string)
+	if !ok || !strings.HasPrefix(text, "$") || len(text) == 1 {
+		return "", false
+	}
+	return text[1:], true
+}
+
+// parseSortStage parses a $sort stage. Its fields are read in the order
+// they are written, which a decoded JSON object does not keep.
+func parseSortStage(body json.RawMessage) (PipelineStage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return PipelineStage{}, fmt.Errorf("$sort expects an object of fields and directions")
+	}
+
+	stage := PipelineStage{Kind: "sort"}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return PipelineStage{}, fmt.Errorf("invalid $sort: %v", err)
+		}
+		field := token.(string)
+
+		var direction float64
+		if err := decoder.Decode(&direction); err != nil || (direction != 1 && direction != -1) {
+			return PipelineStage{}, fmt.Errorf("$sort direction of '%s' must be 1 or -1", field)
+		}
+		stage.Sort = append(stage.Sort, SortKey{Field: field, Descending: direction < 0})
+	}
+	if len(stage.Sort) == 0 {
+		return PipelineStage{}, fmt.Errorf("$sort expects at least one field")
+	}
+	return stage, nil
+}
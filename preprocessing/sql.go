@@ -0,0 +1,295 @@
+package preprocessing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SQLQuery is a query written in the SQL subset understood by the sql
+// command:
+//
+//	SELECT <* | field, ...> FROM <schema> [WHERE <condition>]
+//	    [ORDER BY <field> [ASC|DESC]] [LIMIT n] [OFFSET n]
+//
+// Conditions use the filter expression syntax, including IS [NOT] NULL
+// and [NOT] EXISTS, with AND, OR, NOT and <> accepted as well.
+type SQLQuery struct {
+	Schema         string
+	Fields         []string // Selected fields, all when empty
+	Filter         Filter   // Records to return, all when nil
+	SortField      string
+	SortDescending bool
+	Limit          int
+	Offset         int
+}
+
+// sqlClauses lists the clauses of a query in the order they must appear
+var sqlClauses = []string{"SELECT", "FROM", "WHERE", "ORDER BY", "LIMIT", "OFFSET"}
+
+// ParseSQL parses a query in the SQL subset
+func ParseSQL(query string) (*SQLQuery, error) {
+	clauses, err := splitClauses(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	if err != nil {
+		return nil, err
+	}
+
+	selectList, ok := clauses["SELECT"]
+	if !ok {
+		return nil, fmt.Errorf("query must start with SELECT")
+	}
+	from, ok := clauses["FROM"]
+	if !ok {
+		return nil, fmt.Errorf("missing FROM clause")
+	}
+
+	q := &SQLQuery{Schema: from}
+	if q.Schema == "" || strings.ContainsAny(q.Schema, " \t\n,") {
+		return nil, fmt.Errorf("FROM expects a single schema name, found '%s'", from)
+	}
+
+	if selectList == "" {
+		return nil, fmt.Errorf("SELECT expects * or a list of fields")
+	}
+	if selectList != "*" {
+		for _, field := range strings.Split(selectList, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" || strings.ContainsAny(field, " \t\n*") {
+				return nil, fmt.Errorf("invalid field '%s' in SELECT", field)
+			}
+			q.Fields = append(q.Fields, field)
+		}
+	}
+
+	if where, ok := clauses["WHERE"]; ok {
+		if q.Filter, err = parseSQLCondition(where); err != nil {
+			return nil, fmt.Errorf("invalid WHERE clause: %v", err)
+		}
+	}
+
+	if orderBy, ok := clauses["ORDER BY"]; ok {
+		parts := strings.Fields(orderBy)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("ORDER BY expects a field and an optional ASC or DESC")
+		}
+		q.SortField = parts[0]
+		if len(parts) == 2 {
+			switch strings.ToUpper(parts[1]) {
+			case "ASC":
+			case "DESC":
+				q.SortDescending = true
+			default:
+				return nil, fmt.Errorf("invalid sort direction '%s', expected ASC or DESC", parts[1])
+			}
+		}
+	}
+
+	for _, clause := range []string{"LIMIT", "OFFSET"} {
+		value, ok := clauses[clause]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s expects a non-negative integer, found '%s'", clause, value)
+		}
+		if clause == "LIMIT" {
+			q.Limit = n
+		} else {
+			q.Offset = n
+		}
+	}
+
+	return q, nil
+}
+
+// ParseViewQuery parses the query defining a materialized view, of the
+// form FROM <schema> [WHERE <condition>]. A leading SELECT * is accepted;
+// views keep whole records in key order, so field lists, ORDER BY, LIMIT
+// and OFFSET are not.
+func ParseViewQuery(query string) (*SQLQuery, error) {
+	query = strings.TrimSpace(query)
+	if len(query) >= 4 && strings.EqualFold(query[:4], "FROM") {
+		query = "SELECT * " + query
+	}
+
+	q, err := ParseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(q.Fields) > 0 {
+		return nil, fmt.Errorf("a view selects whole records, expected SELECT * or no SELECT")
+	}
+	if q.SortField != "" || q.Limit > 0 || q.Offset > 0 {
+		return nil, fmt.Errorf("a view cannot have ORDER BY, LIMIT or OFFSET")
+	}
+	return q, nil
+}
+
+// RetargetViewQuery returns a view query reading from another schema with
+// the same WHERE clause, written as FROM <schema> [WHERE <condition>]
+func RetargetViewQuery(query, schema string) (string, error) {
+	if _, err := ParseViewQuery(query); err != nil {
+		return "", err
+	}
+	query = strings.TrimSpace(query)
+	if len(query) >= 4 && strings.EqualFold(query[:4], "FROM") {
+		query = "SELECT * " + query
+	}
+	clauses, err := splitClauses(strings.TrimSuffix(query, ";"))
+	if err != nil {
+		return "", err
+	}
+
+	retargeted := "FROM " + schema
+	if where, ok := clauses["WHERE"]; ok {
+		retargeted += " WHERE " + where
+	}
+	return retargeted, nil
+}
+
+// splitClauses cuts a query at its clause keywords, which must appear at
+// most once and in order, and returns the text following each keyword.
+// Keywords inside quoted strings are ignored.
+func splitClauses(query string) (map[string]string, error) {
+	type keyword struct {
+		clause     string
+		start, end int
+	}
+
+	words := sqlWords(query)
+	var found []keyword
+	for i, word := range words {
+		text := strings.ToUpper(word.text)
+		if text == "ORDER" && i+1 < len(words) && strings.ToUpper(words[i+1].text) == "BY" {
+			found = append(found, keyword{"ORDER BY", word.start, words[i+1].end})
+			continue
+		}
+		for _, clause := range sqlClauses {
+			if text == clause {
+				found = append(found, keyword{clause, word.start, word.end})
+			}
+		}
+	}
+
+	if len(found) == 0 || found[0].start != 0 {
+		return nil, fmt.Errorf("query must start with SELECT")
+	}
+
+	clauses := make(map[string]string, len(found))
+	last := -1
+	for i, k := range found {
+		position := indexOf(sqlClauses, k.clause)
+		if position <= last {
+			return nil, fmt.Errorf("unexpected %s", k.clause)
+		}
+		last = position
+
+		end := len(query)
+		if i+1 < len(found) {
+			end = found[i+1].start
+		}
+		clauses[k.clause] = strings.TrimSpace(query[k.end:end])
+	}
+	return clauses, nil
+}
+
+// sqlWord is a whitespace separated word of a query with its byte offsets
+type sqlWord struct {
+	text       string
+	start, end int
+}
+
+// sqlWords splits a query into words, keeping quoted strings whole
+func sqlWords(query string) []sqlWord {
+	var words []sqlWord
+	start := -1
+	var quote rune
+	for i, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			if start < 0 {
+				start = i
+			}
+		case unicode.IsSpace(r):
+			if start >= 0 {
+				words = append(words, sqlWord{query[start:i], start, i})
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		words = append(words, sqlWord{query[start:], start, len(query)})
+	}
+	return words
+}
+
+// parseSQLCondition parses a WHERE condition by translating its SQL
+// keywords and operators into filter expression tokens
+func parseSQLCondition(condition string) (Filter, error) {
+	tokens, err := tokenizeFilter(condition)
+	if err != nil {
+		return nil, err
+	}
+
+	translated := make([]filterToken, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.kind == "op" && token.text == "<" && i+1 < len(tokens) && tokens[i+1].kind == "op" && tokens[i+1].text == ">" {
+			translated = append(translated, filterToken{kind: "op", text: "!="})
+			i++
+			continue
+		}
+		if token.kind != "word" {
+			translated = append(translated, token)
+			continue
+		}
+
+		switch strings.ToUpper(token.text) {
+		case "AND":
+			translated = append(translated, filterToken{kind: "&&", text: "&&"})
+		case "OR":
+			translated = append(translated, filterToken{kind: "||", text: "||"})
+		case "NOT":
+			// NOT EXISTS is a predicate of the filter syntax, any other NOT
+			// negates what follows
+			if i+1 < len(tokens) && strings.ToUpper(tokens[i+1].text) == "EXISTS" {
+				translated = append(translated, token)
+				continue
+			}
+			translated = append(translated, filterToken{kind: "!", text: "!"})
+		case "IS":
+			// IS [NOT] NULL is a predicate of the filter syntax, so keep the
+			// NOT from being read as a negation
+			translated = append(translated, token)
+			if i+1 < len(tokens) && strings.ToUpper(tokens[i+1].text) == "NOT" {
+				translated = append(translated, tokens[i+1])
+				i++
+			}
+		default:
+			translated = append(translated, token)
+		}
+	}
+
+	return parseTokens(translated)
+}
+
+// indexOf returns the position of a value in a slice, or -1
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
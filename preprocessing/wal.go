@@ -0,0 +1,121 @@
+package preprocessing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// commitLogName is the file MemTable writes are logged to until they are
+// flushed into an SSTable
+const commitLogName = "memtable.log"
+
+// appendLog records a MemTable write in the commit log. Memory-only trees
+// keep no log.
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) appendLog(node LSMNode) error {
+	if lsm.dir == "" {
+		return nil
+	}
+
+	if lsm.commitLog == nil {
+		if err := os.MkdirAll(lsm.dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %v", err)
+		}
+
+		file, err := os.OpenFile(filepath.Join(lsm.dir, commitLogName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open commit log: %v", err)
+		}
+		lsm.commitLog = file
+	}
+
+	// Each entry is a BSON document, which carries its own length prefix
+	data, err := bson.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("failed to marshal commit log entry: %v", err)
+	}
+
+	if _, err := lsm.commitLog.Write(data); err != nil {
+		return fmt.Errorf("failed to write commit log: %v", err)
+	}
+
+	return nil
+}
+
+// replayLog applies the writes recorded in the commit log to the MemTable.
+// A torn entry at the end, left by a crash mid-write, is cut off so new
+// entries are appended after the last complete one.
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) replayLog() error {
+	path := filepath.Join(lsm.dir, commitLogName)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read commit log: %v", err)
+	}
+
+	offset := 0
+	for len(data)-offset >= 4 {
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		if length < 5 || length > len(data)-offset {
+			break
+		}
+
+		var node LSMNode
+		if err := bson.Unmarshal(data[offset:offset+length], &node); err != nil {
+			break
+		}
+		lsm.apply(node.Key, node.Value)
+
+		offset += length
+	}
+
+	if offset < len(data) {
+		if err := os.Truncate(path, int64(offset)); err != nil {
+			return fmt.Errorf("failed to repair commit log: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// truncateLog empties the commit log once its writes are in an SSTable
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) truncateLog() error {
+	if lsm.dir == "" {
+		return nil
+	}
+
+	if err := lsm.closeLog(); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(lsm.dir, commitLogName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to truncate commit log: %v", err)
+	}
+
+	return nil
+}
+
+// closeLog closes the commit log file if it is open
+// NOTE: This function should be called from within a locked context
+func (lsm *LSMTree) closeLog() error {
+	if lsm.commitLog == nil {
+		return nil
+	}
+
+	err := lsm.commitLog.Close()
+	lsm.commitLog = nil
+	if err != nil {
+		return fmt.Errorf("failed to close commit log: %v", err)
+	}
+
+	return nil
+}
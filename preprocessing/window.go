@@ -0,0 +1,54 @@
+package preprocessing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeWindow returns a filter matching records whose timestamp field lies
+// between since and until, both inclusive. Either bound may be empty to
+// leave that side open; with both empty the filter is nil. A bound is a
+// time ParseTime accepts or a duration such as 24h, 90m or 7d, taken as
+// that long before now.
+func TimeWindow(field, since, until string, now time.Time) (Filter, error) {
+	var filters []Filter
+	for _, bound := range []struct {
+		value string
+		op    string
+	}{{since, ">="}, {until, "<="}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := parseTimeBound(bound.value, now)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, &comparison{
+			field:     field,
+			op:        bound.op,
+			value:     newLiteral(t.Format(time.RFC3339Nano), true),
+			fieldType: "datetime",
+		})
+	}
+	return All(filters...), nil
+}
+
+// parseTimeBound parses a point in time or a duration before now
+func parseTimeBound(value string, now time.Time) (time.Time, error) {
+	if t, ok := ParseTime(value); ok {
+		return t, nil
+	}
+
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if n, err := strconv.ParseFloat(days, 64); err == nil && n >= 0 {
+			return now.Add(-time.Duration(n * float64(24*time.Hour))), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time '%s', expected a date, an RFC 3339 time or a duration such as 24h or 7d", value)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"simplebson/config"
+)
+
+const (
+	// progressDelay is how long an operation runs before its progress is
+	// shown, so quick ones print nothing
+	progressDelay = time.Second
+
+	// progressBarWidth is the number of characters of the bar drawn on a
+	// terminal
+	progressBarWidth = 30
+)
+
+// progressReporter shows how far a long operation got on stderr: a bar
+// redrawn in place on a terminal, and a line every few seconds otherwise,
+// with an estimate of the time left when the total is known
+type progressReporter struct {
+	cfg      *config.Config
+	out      io.Writer
+	terminal bool
+	interval time.Duration // Time between two updates
+
+	label   string
+	done    int
+	start   time.Time
+	printed time.Time // When progress was last shown, zero before
+}
+
+// newProgressReporter returns the reporter printing progress to stderr
+func newProgressReporter(cfg *config.Config) *progressReporter {
+	p := &progressReporter{cfg: cfg, out: os.Stderr, terminal: isTerminal(os.Stderr), interval: 5 * time.Second}
+	if p.terminal {
+		p.interval = 100 * time.Millisecond
+	}
+	return p
+}
+
+// Report takes the number of items of an operation done so far, and the
+// total when known. A new label, or a count going back, starts another
+// operation; reaching the total ends it.
+func (p *progressReporter) Report(label string, done, total int) {
+	now := time.Now()
+	if label != p.label || done < p.done {
+		p.finish()
+		p.label, p.start = label, now
+	}
+	p.done = done
+
+	complete := total > 0 && done >= total
+	if p.cfg.Verbosity != config.Quiet && now.Sub(p.start) >= progressDelay &&
+		(complete || now.Sub(p.printed) >= p.interval) {
+		p.printed = now
+		line := p.line(done, total, now.Sub(p.start))
+		if p.terminal {
+			fmt.Fprintf(p.out, "\r\x1b[K%s", line)
+		} else {
+			fmt.Fprintln(p.out, line)
+		}
+	}
+	if complete {
+		p.finish()
+	}
+}
+
+// finish ends the line of a bar drawn on a terminal, and forgets the
+// operation
+func (p *progressReporter) finish() {
+	if p.terminal && !p.printed.IsZero() {
+		fmt.Fprintln(p.out)
+	}
+	p.label, p.done, p.printed = "", 0, time.Time{}
+}
+
+// line describes the progress of the operation after elapsed time
+func (p *progressReporter) line(done, total int, elapsed time.Duration) string {
+	if total <= 0 {
+		return fmt.Sprintf("%s: %d done, %s elapsed", p.label, done, elapsed.Round(time.Second))
+	}
+
+	fraction := float64(done) / float64(total)
+	if fraction > 1 {
+		fraction = 1
+	}
+	eta := "done"
+	if done < total && done > 0 {
+		left := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		eta = "ETA " + left.Round(time.Second).String()
+	}
+	if !p.terminal {
+		return fmt.Sprintf("%s: %d/%d (%.0f%%), %s", p.label, done, total, fraction*100, eta)
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	return fmt.Sprintf("%s [%s] %d/%d %3.0f%% %s", p.label, bar, done, total, fraction*100, eta)
+}
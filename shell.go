@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/peterh/liner"
+
+	"simplebson/config"
+	"simplebson/memory"
+	"simplebson/preprocessing"
+)
+
+// runShell reads commands from in, one per line, and runs each like the
+// command line would against the storage loaded once for the session.
+// Arguments are quoted as in a shell. Blank lines and lines starting with
+// # are skipped, and exit, quit or the end of the input end the session.
+// When interactive is set, lines are read from the terminal with a prompt
+// and line editing instead, Tab completes the word under the cursor, and
+// the lines typed are kept in the history file across sessions.
+func runShell(cfg *config.Config, storage *memory.Storage, in io.Reader, interactive bool) int {
+	var readLine func() (string, error)
+	if interactive {
+		line := liner.NewLiner()
+		defer line.Close()
+		line.SetCtrlCAborts(true)
+		line.SetTabCompletionStyle(liner.TabPrints)
+		line.SetWordCompleter(shellCompleter(storage))
+		if err := readHistory(line, cfg.HistoryPath); err != nil {
+			cfg.Warnf("history not loaded: %v", err)
+		}
+		readLine = func() (string, error) {
+			text, err := line.Prompt(fmt.Sprintf("simplebson:%s> ", storage.CurrentDB()))
+			// Ctrl-C abandons the line being typed, as in other shells
+			if err == liner.ErrPromptAborted {
+				return "", nil
+			}
+			if err == io.EOF {
+				fmt.Println()
+			}
+			// Like shells ignoring a leading space, a line starting with a
+			// space is left out of the history, for records holding secrets
+			if err == nil && strings.TrimSpace(text) != "" && !strings.HasPrefix(text, " ") {
+				line.AppendHistory(text)
+				if err := writeHistory(line, cfg.HistoryPath); err != nil {
+					cfg.Warnf("history not saved: %v", err)
+				}
+			}
+			return text, err
+		}
+	} else {
+		readLine = lineReader(in)
+	}
+
+	if _, err := runLines(cfg, storage, readLine, false); err != nil {
+		printError("Error reading commands: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readHistory loads the history of earlier sessions from a file, which
+// may not exist yet
+func readHistory(line *liner.State, path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = line.ReadHistory(file)
+	return err
+}
+
+// writeHistory saves the history to a file after every line, so it
+// survives a session that is killed. The file is only readable by its
+// owner, as commands may hold record data.
+func writeHistory(line *liner.State, path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := line.WriteHistory(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runScript runs the commands in a script file, or on standard input when
+// the file is -, like the shell does, and saves their changes in a single
+// write once the script ends. It stops at the first command that fails
+// unless keepGoing is set, and fails when any command did.
+func runScript(cfg *config.Config, storage *memory.Storage, path string, keepGoing bool) int {
+	in := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			printError("Error reading script: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+
+	storage.Begin()
+	failed, err := runLines(cfg, storage, lineReader(in), !keepGoing)
+	if flushErr := storage.Flush(); flushErr != nil {
+		printError("Error saving database: %v\n", flushErr)
+		return 1
+	}
+	if err != nil {
+		printError("Error reading script: %v\n", err)
+		return 1
+	}
+	if failed > 0 {
+		if keepGoing {
+			fmt.Printf("%d commands failed\n", failed)
+		}
+		return 1
+	}
+	return 0
+}
+
+// lineReader returns a function reading the lines of in one at a time,
+// which returns io.EOF after the last one
+func lineReader(in io.Reader) func() (string, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	return func() (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	}
+}
+
+// runLines runs the commands readLine returns until the input ends or a
+// line says exit or quit, and returns how many failed. Blank lines and
+// lines starting with # are skipped. With stop set it stops at the first
+// command that fails, naming its line.
+func runLines(cfg *config.Config, storage *memory.Storage, readLine func() (string, error), stop bool) (int, error) {
+	failed := 0
+	for lineNo := 1; ; lineNo++ {
+		text, err := readLine()
+		if err == io.EOF {
+			return failed, nil
+		}
+		if err != nil {
+			return failed, err
+		}
+
+		code := 0
+		words, err := preprocessing.SplitWords(text)
+		if err != nil {
+			printError("Error parsing command: %v\n", err)
+			code = 1
+		} else if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		} else {
+			switch command := strings.ToLower(words[0]); command {
+			case "exit", "quit":
+				return failed, nil
+			case "shell", "run", "watch":
+				printError("Error: %s cannot be used inside the shell or a script\n", command)
+				code = 1
+			default:
+				code = run(cfg, storage, command, words[1:])
+			}
+		}
+
+		if code != 0 {
+			failed++
+			if stop {
+				fmt.Printf("Stopped at line %d\n", lineNo)
+				return failed, nil
+			}
+		}
+	}
+}
+
+// isTerminal reports whether a file is an interactive terminal rather than
+// a pipe or a regular file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
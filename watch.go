@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"simplebson/config"
+	"simplebson/memory"
+	"simplebson/preprocessing"
+)
+
+// defaultWatchInterval is how often watch checks the database files when
+// --interval is not given
+const defaultWatchInterval = 500 * time.Millisecond
+
+// runWatch prints the changes made to the records of a schema as they are
+// saved, by this or another process, until the process is interrupted. The
+// files of the database are checked every interval, and when they changed
+// the database is read again and compared with what was read before.
+func runWatch(cfg *config.Config, storage *memory.Storage, schema string, flags preprocessing.Flags) int {
+	interval := defaultWatchInterval
+	if flags.Has("interval") {
+		var err error
+		if interval, err = time.ParseDuration(flags.Get("interval")); err != nil || interval <= 0 {
+			printError("Error parsing --interval: %v\n", memory.Errorf(memory.ErrorValidation, "expected a duration such as 500ms or 2s, got '%s'", flags.Get("interval")))
+			return 1
+		}
+	}
+
+	schemaDef, err := storage.GetSchema(schema)
+	if err != nil {
+		printError("Error watching schema: %v\n", err)
+		return 1
+	}
+	var redact []string
+	if !flags.Has("show-binary") {
+		redact = memory.BinaryFields(schemaDef)
+	}
+
+	dir := filepath.Join(cfg.DataDir, storage.CurrentDB())
+	records, err := schemaRecords(storage, schema)
+	if err != nil {
+		printError("Error watching schema: %v\n", err)
+		return 1
+	}
+	fingerprint := dirFingerprint(dir)
+	fmt.Fprintf(os.Stderr, "Watching schema '%s' in database '%s', press Ctrl-C to stop\n", schema, storage.CurrentDB())
+
+	for {
+		time.Sleep(interval)
+		if current := dirFingerprint(dir); current == fingerprint {
+			continue
+		}
+
+		storage.Reload()
+		// Reading the database may touch its files, so they are compared
+		// with how they were left afterwards
+		fingerprint = dirFingerprint(dir)
+		current, err := schemaRecords(storage, schema)
+		if err != nil {
+			printError("Error watching schema: %v\n", err)
+			return 1
+		}
+		printChanges(records, current, redact)
+		records = current
+	}
+}
+
+// schemaRecords returns the JSON text of every record of a schema by key
+func schemaRecords(storage *memory.Storage, schema string) (map[string]string, error) {
+	records := make(map[string]string)
+	err := storage.Iterate(schema, func(key string, record interface{}) error {
+		records[key] = recordText(record)
+		return nil
+	})
+	return records, err
+}
+
+// printChanges prints a line per record added, updated or deleted between
+// two readings of a schema, in key order
+func printChanges(before, after map[string]string, redact []string) {
+	keys := make([]string, 0, len(after))
+	for key := range after {
+		keys = append(keys, key)
+	}
+	for key := range before {
+		if _, exists := after[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	now := time.Now().Format("15:04:05")
+	for _, key := range keys {
+		old, existed := before[key]
+		record, exists := after[key]
+		switch {
+		case !existed:
+			fmt.Printf("%s %s %s: %s\n", now, paint(colorCyan, "added"), paint(colorBlue, key), paintJSON(recordText(memory.RedactBinary(record, redact))))
+		case !exists:
+			fmt.Printf("%s %s %s\n", now, paint(colorRed, "deleted"), paint(colorBlue, key))
+		case old != record:
+			fmt.Printf("%s %s %s: %s\n", now, paint(colorYellow, "updated"), paint(colorBlue, key), paintJSON(recordText(memory.RedactBinary(record, redact))))
+		}
+	}
+}
+
+// dirFingerprint returns a hash of the names, sizes and modification times
+// of the files in a directory and below it, which changes whenever one of
+// them is written
+func dirFingerprint(dir string) uint64 {
+	hash := fnv.New64a()
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		fmt.Fprintf(hash, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return hash.Sum64()
+}